@@ -0,0 +1,75 @@
+package bounces
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// readARF reads the spam complaint of a feedback report (RFC 5965), whose
+// recipient is the one of the VERP address the report was sent to, else
+// the one of the VERP Return-Path of the message reported. The providers
+// often redact the recipient of the message reported: its VERP Return-Path
+// is then left. Without VERP, the recipient is the Original-Rcpt-To of the
+// report, else the To of the message reported, which anyone sending the
+// mailbox a report could forge. The reports of other feedback than abuse,
+// e.g. not-spam, are not complaints.
+func readARF(parts *multipart.Reader, report *Report, verp, secret string) (*Report, error) {
+	var feedbackType, originalTo, returnPathTo, headerTo string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch mediaType {
+		case "message/feedback-report":
+			fields, err := textproto.NewReader(bufio.NewReader(io.LimitReader(part, maxBody))).ReadMIMEHeader()
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			feedbackType = strings.ToLower(strings.TrimSpace(fields.Get("Feedback-Type")))
+			originalTo = strings.Trim(strings.TrimSpace(fields.Get("Original-Rcpt-To")), "<>")
+		case "message/rfc822", "text/rfc822-headers":
+			reported, err := mail.ReadMessage(io.LimitReader(part, maxBody))
+			if err != nil {
+				continue
+			}
+			if verp != "" {
+				if addr, err := mail.ParseAddress(reported.Header.Get("Return-Path")); err == nil {
+					returnPathTo, _ = FromVERP(verp, secret, addr.Address)
+				}
+			}
+			if addr, err := mail.ParseAddress(reported.Header.Get("To")); err == nil {
+				headerTo = addr.Address
+			}
+		}
+	}
+
+	if feedbackType != "abuse" {
+		return nil, nil
+	}
+	candidates := []string{report.Recipient, returnPathTo}
+	if verp == "" {
+		candidates = []string{originalTo, headerTo}
+	}
+	for _, recipient := range candidates {
+		if recipient != "" {
+			report.Recipient = recipient
+			break
+		}
+	}
+	if report.Recipient == "" {
+		return nil, nil
+	}
+	report.Complaint = true
+	return report, nil
+}
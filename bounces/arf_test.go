@@ -0,0 +1,109 @@
+package bounces
+
+import (
+	"strings"
+	"testing"
+)
+
+// arf returns a feedback report of the type to the address, with the
+// fields of the report and the headers of the message reported.
+func arf(to, feedbackType, fields, reported string) string {
+	return strings.ReplaceAll(`From: fbl@isp.example.net
+To: `+to+`
+Subject: Abuse report
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=feedback-report; boundary="b"
+
+--b
+Content-Type: text/plain
+
+This is an abuse report.
+
+--b
+Content-Type: message/feedback-report
+
+Feedback-Type: `+feedbackType+`
+User-Agent: fbl/1.0
+Version: 1
+`+fields+`
+
+--b
+Content-Type: text/rfc822-headers
+
+`+reported+`
+
+--b--
+`, "\n", "\r\n")
+}
+
+func TestParseARF(t *testing.T) {
+	janeVERP := VERP(verp, "jane@example.com", verpSecret)
+	tests := []struct {
+		name      string
+		raw       string
+		verp      string
+		recipient string
+	}{
+		{
+			name:      "VERP address of the report",
+			raw:       arf(janeVERP, "abuse", "Original-Rcpt-To: <john@example.com>", "To: john@example.com"),
+			verp:      verp,
+			recipient: "jane@example.com",
+		},
+		{
+			name: "VERP return path of the message reported",
+			raw: arf("bounces@lists.example.com", "abuse", "Original-Rcpt-To: <john@example.com>",
+				"Return-Path: <"+janeVERP+">\nTo: redacted@isp.example.net"),
+			verp:      verp,
+			recipient: "jane@example.com",
+		},
+		{
+			name: "VERP return path forged",
+			raw: arf("bounces@lists.example.com", "abuse", "",
+				"Return-Path: <bounces+jane=example.com+0123456789abcdef@lists.example.com>"),
+			verp: verp,
+		},
+		{
+			name: "original recipient with VERP",
+			raw:  arf("bounces@lists.example.com", "abuse", "Original-Rcpt-To: <jane@example.com>", "To: jane@example.com"),
+			verp: verp,
+		},
+		{
+			name:      "original recipient",
+			raw:       arf("bounces@lists.example.com", "abuse", "Original-Rcpt-To: <jane@example.com>", "To: john@example.com"),
+			recipient: "jane@example.com",
+		},
+		{
+			name:      "recipient of the message reported",
+			raw:       arf("bounces@lists.example.com", "abuse", "", "To: jane@example.com"),
+			recipient: "jane@example.com",
+		},
+		{
+			name: "not spam",
+			raw:  arf("bounces@lists.example.com", "not-spam", "Original-Rcpt-To: <jane@example.com>", "To: jane@example.com"),
+		},
+		{
+			name: "redacted",
+			raw:  arf("bounces@lists.example.com", "abuse", "", "Subject: Hello"),
+			verp: verp,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report, err := Parse([]byte(test.raw), test.verp, verpSecret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.recipient == "" {
+				if report != nil {
+					t.Errorf("Parse = %+v, want none", report)
+				}
+				return
+			}
+			if report == nil || !report.Complaint || report.Recipient != test.recipient || report.Hard {
+				t.Errorf("Parse = %+v, want the complaint of %v", report, test.recipient)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+// Package bounces ingests the bounces of the messages sent: those the
+// providers report through their webhooks, and the delivery status
+// notifications returned to a mailbox, polled over IMAP, whose recipient is
+// read from the signed VERP envelope sender of the message, else from the
+// report.
+// The hard bounces suppress the address, the soft ones are only counted.
+// The spam complaints, reported by the webhooks or by the feedback loops
+// of the mailbox providers to the same mailbox, suppress the address for
+// good.
+package bounces
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"strings"
+)
+
+// Record counts a bounce of the email, suppressing it when hard. The
+// bounces of the emails unknown, or deleted since, are skipped.
+func Record(ctx context.Context, db *sql.DB, email string, hard bool, source string) error {
+	logger := logging.FromContext(ctx)
+	suppressed, err := mdb.RecordBounce(ctx, db, email, hard)
+	if errors.Is(err, mdb.ErrEmailNotFound) {
+		logger.Info("bounce of an unknown email", "email", email, "source", source)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("bounce", "email", email, "hard", hard, "source", source, "suppressed", suppressed)
+	return nil
+}
+
+// RecordComplaint suppresses the email whose recipient reported a message
+// as spam. The complaints of the emails unknown, or deleted since, are
+// skipped.
+func RecordComplaint(ctx context.Context, db *sql.DB, email string, source string) error {
+	logger := logging.FromContext(ctx)
+	recorded, err := mdb.RecordComplaint(ctx, db, email)
+	if errors.Is(err, mdb.ErrEmailNotFound) {
+		logger.Info("complaint of an unknown email", "email", email, "source", source)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("complaint", "email", email, "source", source, "recorded", recorded)
+	return nil
+}
+
+// VERP returns the envelope sender of the messages to the recipient for
+// the bounces mailbox, signed with the secret, e.g.
+// bounces+jane=example.com+{signature}@lists.example.com for
+// bounces@lists.example.com and jane@example.com. The signature keeps the
+// messages sent to the mailbox by anyone else from suppressing the
+// addresses of their choice.
+func VERP(mailbox, recipient, secret string) string {
+	local, domain, ok := strings.Cut(mailbox, "@")
+	if !ok {
+		return mailbox
+	}
+	return local + "+" + strings.Replace(recipient, "@", "=", 1) + "+" + signVERP(recipient, secret) + "@" + domain
+}
+
+// FromVERP returns the recipient of an envelope sender made by VERP for
+// the mailbox and signed with the secret, false for the other addresses.
+func FromVERP(mailbox, secret, address string) (string, bool) {
+	local, domain, ok := strings.Cut(mailbox, "@")
+	if !ok {
+		return "", false
+	}
+	at := strings.LastIndex(address, "@")
+	if at < 0 || !strings.EqualFold(address[at+1:], domain) {
+		return "", false
+	}
+	prefix := local + "+"
+	if len(address[:at]) <= len(prefix) || !strings.EqualFold(address[:len(prefix)], prefix) {
+		return "", false
+	}
+	encoded, signature, ok := cutLast(address[len(prefix):at], "+")
+	if !ok {
+		return "", false
+	}
+	user, host, ok := cutLast(encoded, "=")
+	if !ok || user == "" || host == "" {
+		return "", false
+	}
+	recipient := user + "@" + host
+	if !hmac.Equal([]byte(signVERP(recipient, secret)), []byte(strings.ToLower(signature))) {
+		return "", false
+	}
+	return recipient, true
+}
+
+// signVERP returns the truncated HMAC-SHA256 of the recipient of a VERP
+// address, lowercased as the mail servers may change its case.
+func signVERP(recipient, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("verp " + strings.ToLower(recipient)))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,35 @@
+package bounces
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVERP(t *testing.T) {
+	addr := VERP(verp, "jane+news@example.com", verpSecret)
+	local, ok := strings.CutSuffix(addr, "@lists.example.com")
+	if !ok || !strings.HasPrefix(local, "bounces+jane+news=example.com+") {
+		t.Fatalf("VERP = %v", addr)
+	}
+	signature := local[len("bounces+jane+news=example.com+"):]
+	for _, addr := range []string{addr, strings.ToUpper(addr)} {
+		if recipient, ok := FromVERP(verp, verpSecret, addr); !ok || !strings.EqualFold(recipient, "jane+news@example.com") {
+			t.Errorf("FromVERP(%v) = %v, %v", addr, recipient, ok)
+		}
+	}
+
+	for _, addr := range []string{
+		"bounces@lists.example.com",
+		"bounces+jane+news=example.com@lists.example.com",
+		"bounces+john=example.com+" + signature + "@lists.example.com",
+		"bounces+jane+news=example.com+" + signature + "@other.example.com",
+		"other+jane+news=example.com+" + signature + "@lists.example.com",
+		"bounces+jane+" + signature + "@lists.example.com",
+		"bounces+=example.com+" + signature + "@lists.example.com",
+		"bounces+jane=+" + signature + "@lists.example.com",
+	} {
+		if recipient, ok := FromVERP(verp, verpSecret, addr); ok {
+			t.Errorf("FromVERP(%v) = %v, want none", addr, recipient)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package bounces
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// Report is the bounce of a message returned to the mailbox, or the spam
+// complaint of its recipient.
+type Report struct {
+	Recipient string
+	Hard      bool
+	// Status is the enhanced status code of RFC 3463, e.g. 5.1.1, empty
+	// when the bounce gave none.
+	Status string
+	// Complaint is set for the spam complaints, which have no status.
+	Complaint bool
+}
+
+// maxBody bounds the text of the bounces searched for a status code.
+const maxBody = 64 << 10
+
+var statusCode = regexp.MustCompile(`\b([245])\.(\d{1,3})\.(\d{1,3})\b`)
+
+// Parse reads the bounce of a message returned to the mailbox of verp,
+// empty without VERP, whose addresses are signed with secret. The
+// recipient is the one of the signed VERP address the bounce was sent to.
+// Without VERP, it is the Final-Recipient of its delivery status
+// notification (RFC 3464), which anyone sending the mailbox a bounce could
+// forge. The bounces without one, e.g. in plain text, are only read with
+// VERP, from the first status code of their text.
+// The complaints of the feedback loops (RFC 5965) are read with readARF.
+// Parse returns nil for the messages which are not bounces, e.g. the
+// automatic replies, and the delays.
+func Parse(raw []byte, verp, secret string) (*Report, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{Recipient: verpRecipient(msg.Header, verp, secret)}
+
+	mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if mediaType == "multipart/report" && params["boundary"] != "" &&
+		strings.EqualFold(params["report-type"], "feedback-report") {
+		return readARF(multipart.NewReader(msg.Body, params["boundary"]), report, verp, secret)
+	}
+	if mediaType == "multipart/report" && params["boundary"] != "" {
+		found, err := readDSN(multipart.NewReader(msg.Body, params["boundary"]), report, verp)
+		if err != nil || found {
+			if err != nil || report.Recipient == "" || report.Status == "" {
+				return nil, err
+			}
+			report.Hard = isHard(report.Status)
+			return report, nil
+		}
+	}
+
+	if report.Recipient == "" {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(msg.Body, maxBody))
+	if err != nil {
+		return nil, err
+	}
+	match := statusCode.FindSubmatch(body)
+	if match == nil || string(match[1]) == "2" {
+		return nil, nil
+	}
+	report.Status = string(match[0])
+	report.Hard = isHard(report.Status)
+	return report, nil
+}
+
+// verpRecipient returns the recipient of the VERP address the bounce was
+// delivered to.
+func verpRecipient(header mail.Header, verp, secret string) string {
+	if verp == "" {
+		return ""
+	}
+	for _, name := range []string{"Delivered-To", "X-Original-To", "Envelope-To", "To"} {
+		addrs, err := header.AddressList(name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if recipient, ok := FromVERP(verp, secret, addr.Address); ok {
+				return recipient
+			}
+		}
+	}
+	return ""
+}
+
+// readDSN fills the report from the message/delivery-status part of the
+// first recipient failed, reporting whether the message had that part.
+// The delays leave the status empty. The Final-Recipient is only taken
+// without VERP.
+func readDSN(parts *multipart.Reader, report *Report, verp string) (bool, error) {
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if mediaType != "message/delivery-status" {
+			continue
+		}
+
+		fields := textproto.NewReader(bufio.NewReader(io.LimitReader(part, maxBody)))
+		// The fields of the message, then those of each recipient.
+		if _, err := fields.ReadMIMEHeader(); err != nil && !errors.Is(err, io.EOF) {
+			return true, err
+		}
+		for {
+			recipient, err := fields.ReadMIMEHeader()
+			if len(recipient) > 0 && strings.EqualFold(recipient.Get("Action"), "failed") {
+				if verp == "" {
+					_, addr, _ := strings.Cut(recipient.Get("Final-Recipient"), ";")
+					report.Recipient = strings.TrimSpace(addr)
+				}
+				report.Status = statusCode.FindString(recipient.Get("Status"))
+				return true, nil
+			}
+			if err != nil {
+				return true, nil
+			}
+		}
+	}
+}
+
+// isHard tells the status codes of the addresses failing for good: the
+// unknown mailboxes and domains (5.1.x) and the disabled mailboxes (5.2.1).
+// The other permanent failures, e.g. a message refused as spam (5.7.x) or
+// a full mailbox (5.2.2), may not happen again and are soft.
+func isHard(status string) bool {
+	return strings.HasPrefix(status, "5.1.") || status == "5.2.1"
+}
@@ -0,0 +1,150 @@
+package bounces
+
+import (
+	"strings"
+	"testing"
+)
+
+// dsn returns a delivery status notification to the address with the
+// fields of the recipient.
+func dsn(to, recipient string) string {
+	return strings.ReplaceAll(`From: MAILER-DAEMON@mx.example.net
+To: `+to+`
+Subject: Undelivered Mail Returned to Sender
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="b"
+
+--b
+Content-Type: text/plain
+
+The message could not be delivered.
+
+--b
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.net
+
+`+recipient+`
+
+--b
+Content-Type: message/rfc822
+
+Subject: Hello
+
+--b--
+`, "\n", "\r\n")
+}
+
+// The VERP mailbox of the tests and the secret signing its addresses.
+const (
+	verp       = "bounces@lists.example.com"
+	verpSecret = "secret"
+)
+
+func TestParse(t *testing.T) {
+	janeVERP := VERP(verp, "jane@example.com", verpSecret)
+	tests := []struct {
+		name   string
+		raw    string
+		verp   string
+		report *Report
+	}{
+		{
+			name: "hard bounce",
+			raw: dsn("bounces@lists.example.com", `Final-Recipient: rfc822; jane@example.com
+Action: failed
+Status: 5.1.1`),
+			report: &Report{Recipient: "jane@example.com", Hard: true, Status: "5.1.1"},
+		},
+		{
+			name: "soft bounce",
+			raw: dsn("bounces@lists.example.com", `Final-Recipient: rfc822; jane@example.com
+Action: failed
+Status: 5.2.2`),
+			report: &Report{Recipient: "jane@example.com", Status: "5.2.2"},
+		},
+		{
+			name: "delay",
+			raw: dsn("bounces@lists.example.com", `Final-Recipient: rfc822; jane@example.com
+Action: delayed
+Status: 4.4.1`),
+		},
+		{
+			name: "first recipient failed",
+			raw: dsn("bounces@lists.example.com", `Final-Recipient: rfc822; john@example.com
+Action: delivered
+Status: 2.0.0
+
+Final-Recipient: rfc822; jane@example.com
+Action: failed
+Status: 5.1.2`),
+			report: &Report{Recipient: "jane@example.com", Hard: true, Status: "5.1.2"},
+		},
+		{
+			name: "VERP recipient",
+			raw: dsn(janeVERP, `Final-Recipient: rfc822; forwarded@example.org
+Action: failed
+Status: 5.1.1`),
+			verp:   verp,
+			report: &Report{Recipient: "jane@example.com", Hard: true, Status: "5.1.1"},
+		},
+		{
+			name: "VERP recipient forged",
+			raw: dsn("bounces+john=example.com+0123456789abcdef@lists.example.com", `Final-Recipient: rfc822; jane@example.com
+Action: failed
+Status: 5.1.1`),
+			verp: verp,
+		},
+		{
+			name: "final recipient with VERP",
+			raw: dsn(verp, `Final-Recipient: rfc822; jane@example.com
+Action: failed
+Status: 5.1.1`),
+			verp: verp,
+		},
+		{
+			name: "plain text with VERP",
+			raw: "From: postmaster@example.com\r\nTo: " + janeVERP + "\r\n" +
+				"Subject: failure\r\n\r\n550 5.1.1 The mailbox does not exist.\r\n",
+			verp:   verp,
+			report: &Report{Recipient: "jane@example.com", Hard: true, Status: "5.1.1"},
+		},
+		{
+			name: "plain text to a VERP address forged",
+			raw: "From: postmaster@example.com\r\nTo: bounces+jane=example.com@lists.example.com\r\n" +
+				"Subject: failure\r\n\r\n550 5.1.1 The mailbox does not exist.\r\n",
+			verp: verp,
+		},
+		{
+			name: "plain text without VERP",
+			raw: "From: postmaster@example.com\r\nTo: bounces@lists.example.com\r\n" +
+				"Subject: failure\r\n\r\n550 5.1.1 The mailbox does not exist.\r\n",
+		},
+		{
+			name: "automatic reply",
+			raw: "From: jane@example.com\r\nTo: " + janeVERP + "\r\n" +
+				"Subject: Out of office\r\n\r\nBack on Monday.\r\n",
+			verp: verp,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report, err := Parse([]byte(test.raw), test.verp, verpSecret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (report == nil) != (test.report == nil) || (report != nil && *report != *test.report) {
+				t.Errorf("Parse = %+v, want %+v", report, test.report)
+			}
+		})
+	}
+}
+
+func TestIsHard(t *testing.T) {
+	for status, hard := range map[string]bool{"5.1.1": true, "5.1.10": true, "5.2.1": true, "5.2.2": false, "5.7.1": false, "4.2.2": false} {
+		if isHard(status) != hard {
+			t.Errorf("isHard(%v) = %v", status, !hard)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package bounces
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// imapTimeout bounds each command, within the deadline of the poll.
+	imapTimeout = time.Minute
+	// maxMessage bounds the messages fetched, the bounces quoting the
+	// message returned.
+	maxMessage = 10 << 20
+)
+
+// imapClient speaks the few commands of IMAP4rev1 (RFC 3501) reading the
+// bounces: the mailbox is searched for the unseen messages, which are
+// fetched then flagged.
+type imapClient struct {
+	ctx  context.Context
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// imapResponse is an untagged response, with the literal it carries, e.g.
+// the message of a FETCH.
+type imapResponse struct {
+	line    string
+	literal []byte
+}
+
+// errPlaintext refuses to log in to the servers offering no TLS, which
+// would get the password in clear.
+var errPlaintext = errors.New("imap server offers no STARTTLS, the password would be sent in clear")
+
+// dialIMAP connects to the server at addr, over TLS on port 993, else with
+// STARTTLS. It fails with errPlaintext for a server not offering it,
+// unless plaintext allows the connection in clear.
+func dialIMAP(ctx context.Context, addr string, plaintext bool) (*imapClient, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	if port == "993" {
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: host}}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &imapClient{ctx: ctx, conn: conn, r: bufio.NewReader(conn)}
+	c.setDeadline()
+	greeting, err := c.r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting %q", strings.TrimSpace(greeting))
+	}
+	if port == "993" {
+		return c, nil
+	}
+
+	responses, err := c.cmd("CAPABILITY")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for _, res := range responses {
+		if strings.HasPrefix(res.line, "CAPABILITY ") && strings.Contains(res.line, " STARTTLS") {
+			if _, err := c.cmd("STARTTLS"); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+			c.conn, c.r = tlsConn, bufio.NewReader(tlsConn)
+			return c, nil
+		}
+	}
+	if !plaintext {
+		conn.Close()
+		return nil, errPlaintext
+	}
+	return c, nil
+}
+
+// setDeadline bounds the next command by imapTimeout, within the deadline
+// of ctx.
+func (c *imapClient) setDeadline() {
+	deadline := time.Now().Add(imapTimeout)
+	if d, ok := c.ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.conn.SetDeadline(deadline)
+}
+
+func (c *imapClient) Close() error {
+	c.cmd("LOGOUT")
+	return c.conn.Close()
+}
+
+// cmd sends the command and returns its untagged responses, failing
+// unless the server answers OK.
+func (c *imapClient) cmd(command string) ([]imapResponse, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	c.setDeadline()
+	if _, err := fmt.Fprintf(c.conn, "%v %v\r\n", tag, command); err != nil {
+		return nil, err
+	}
+
+	var responses []imapResponse
+	for {
+		res, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		if rest, ok := strings.CutPrefix(res.line, tag+" "); ok {
+			if !strings.HasPrefix(rest, "OK") {
+				verb, _, _ := strings.Cut(command, " ")
+				return nil, fmt.Errorf("imap %v: %v", verb, rest)
+			}
+			return responses, nil
+		}
+		if rest, ok := strings.CutPrefix(res.line, "* "); ok {
+			res.line = rest
+			responses = append(responses, res)
+		}
+	}
+}
+
+// read reads a response, with its literal.
+func (c *imapClient) read() (imapResponse, error) {
+	var res imapResponse
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return res, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		res.line += line
+		// A literal, {size} then size bytes, goes on with the line.
+		open := strings.LastIndexByte(line, '{')
+		if open < 0 || !strings.HasSuffix(line, "}") {
+			return res, nil
+		}
+		size, err := strconv.Atoi(line[open+1 : len(line)-1])
+		if err != nil {
+			return res, nil
+		}
+		if size > maxMessage {
+			return res, fmt.Errorf("imap literal of %v bytes", size)
+		}
+		res.literal = make([]byte, size)
+		if _, err := io.ReadFull(c.r, res.literal); err != nil {
+			return res, err
+		}
+	}
+}
+
+// quote quotes the string of a command.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// search returns the UIDs of the unseen messages of the mailbox selected,
+// but those flagged deleted, already read by a poll which failed before
+// expunging them.
+func (c *imapClient) search() ([]string, error) {
+	responses, err := c.cmd("UID SEARCH UNSEEN UNDELETED")
+	if err != nil {
+		return nil, err
+	}
+	var uids []string
+	for _, res := range responses {
+		if rest, ok := strings.CutPrefix(res.line, "SEARCH"); ok {
+			uids = append(uids, strings.Fields(rest)...)
+		}
+	}
+	return uids, nil
+}
+
+// fetch returns the message of the UID, without flagging it seen.
+func (c *imapClient) fetch(uid string) ([]byte, error) {
+	responses, err := c.cmd("UID FETCH " + uid + " BODY.PEEK[]")
+	if err != nil {
+		return nil, err
+	}
+	for _, res := range responses {
+		if res.literal != nil {
+			return res.literal, nil
+		}
+	}
+	return nil, errors.New("imap FETCH: no message")
+}
+
+// flag adds the flag to the message of the UID, e.g. \Deleted.
+func (c *imapClient) flag(uid, flag string) error {
+	_, err := c.cmd("UID STORE " + uid + " +FLAGS.SILENT (" + flag + ")")
+	return err
+}
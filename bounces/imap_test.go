@@ -0,0 +1,151 @@
+package bounces
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeIMAP answers each command read from the client with the response
+// of the command, else of its verb, the tag of the command replacing the
+// "tag" of the response.
+func fakeIMAP(t *testing.T, responses map[string]string) *imapClient {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			tag, verb := fields[0], fields[1]
+			if verb == "UID" {
+				verb += " " + fields[2]
+			}
+			res, ok := responses[strings.Join(fields[1:], " ")]
+			if !ok {
+				res, ok = responses[verb]
+			}
+			if !ok {
+				res = "tag BAD unknown command\r\n"
+			}
+			if _, err := server.Write([]byte(strings.ReplaceAll(res, "tag ", tag+" "))); err != nil {
+				return
+			}
+		}
+	}()
+	return &imapClient{ctx: context.Background(), conn: client, r: bufio.NewReader(client)}
+}
+
+func TestIMAPSearch(t *testing.T) {
+	c := fakeIMAP(t, map[string]string{
+		// The messages flagged deleted were read by a poll which failed
+		// before expunging them.
+		"UID SEARCH UNSEEN UNDELETED": "* SEARCH 4 8 15\r\ntag OK SEARCH completed\r\n",
+	})
+	uids, err := c.search()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(uids, []string{"4", "8", "15"}) {
+		t.Errorf("search = %v, want 4 8 15", uids)
+	}
+}
+
+func TestIMAPFetch(t *testing.T) {
+	const message = "Subject: Hello\r\n\r\nHi {3}\r\n"
+	c := fakeIMAP(t, map[string]string{
+		"UID FETCH": "* 1 FETCH (UID 4 BODY[] {" + strconv.Itoa(len(message)) + "}\r\n" + message + ")\r\ntag OK FETCH completed\r\n",
+	})
+	raw, err := c.fetch("4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != message {
+		t.Errorf("fetch = %q, want %q", raw, message)
+	}
+}
+
+func TestIMAPRefused(t *testing.T) {
+	c := fakeIMAP(t, map[string]string{
+		"LOGIN": "* OK [ALERT] nope\r\ntag NO [AUTHENTICATIONFAILED] invalid credentials\r\n",
+	})
+	_, err := c.cmd("LOGIN " + quote("jane") + " " + quote(`pa"ss`))
+	if err == nil || !strings.Contains(err.Error(), "imap LOGIN: NO [AUTHENTICATIONFAILED]") {
+		t.Errorf("cmd = %v, want the refusal of LOGIN", err)
+	}
+}
+
+// The password is not sent in clear to a server offering no STARTTLS,
+// unless allowed.
+func TestDialIMAPPlaintext(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("* OK IMAP4rev1 ready\r\n"))
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					tag, _, _ := strings.Cut(line, " ")
+					conn.Write([]byte("* CAPABILITY IMAP4rev1 AUTH=PLAIN\r\n" + tag + " OK done\r\n"))
+				}
+			}()
+		}
+	}()
+
+	ctx := context.Background()
+	if _, err := dialIMAP(ctx, l.Addr().String(), false); !errors.Is(err, errPlaintext) {
+		t.Errorf("dialIMAP = %v, want %v", err, errPlaintext)
+	}
+	c, err := dialIMAP(ctx, l.Addr().String(), true)
+	if err != nil {
+		t.Fatalf("dialIMAP allowing plaintext = %v", err)
+	}
+	c.Close()
+}
+
+// A server not answering fails the command once its deadline passed.
+func TestIMAPDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	go io.Copy(io.Discard, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c := &imapClient{ctx: ctx, conn: client, r: bufio.NewReader(client)}
+	if _, err := c.cmd("NOOP"); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("cmd = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got := quote(`pa"ss\word`); got != `"pa\"ss\\word"` {
+		t.Errorf("quote = %v", got)
+	}
+}
@@ -0,0 +1,113 @@
+package bounces
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+)
+
+// maxPoll bounds the messages read by a poll, the others being read by
+// the next ones.
+const maxPoll = 500
+
+// IMAPOptions sets the mailbox of the bounces.
+type IMAPOptions struct {
+	// Addr is the host:port of the IMAP server, over TLS on port 993, else
+	// with STARTTLS.
+	Addr string
+	// Plaintext allows logging in to a server offering no STARTTLS, the
+	// password being sent in clear.
+	Plaintext bool
+	Username  string
+	Password  string
+	// Mailbox defaults to INBOX.
+	Mailbox string
+	// VERP is the address of the bounces the messages were sent with, whose
+	// VERP addresses give the recipients, e.g. bounces@example.com, signed
+	// with VERPSecret.
+	VERP       string
+	VERPSecret string
+}
+
+// Poller reads the bounces of a mailbox over IMAP.
+type Poller struct {
+	db   *sql.DB
+	opts IMAPOptions
+}
+
+func NewPoller(db *sql.DB, opts IMAPOptions) *Poller {
+	if opts.Mailbox == "" {
+		opts.Mailbox = "INBOX"
+	}
+	return &Poller{db: db, opts: opts}
+}
+
+// Poll reads the unseen messages of the mailbox, records the bounces and
+// the complaints, and deletes them. The other messages are flagged seen,
+// and left for a human to read. The messages flagged deleted are not read
+// again, when a poll failed before expunging them.
+func (p *Poller) Poll(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+	c, err := dialIMAP(ctx, p.opts.Addr, p.opts.Plaintext)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if _, err := c.cmd("LOGIN " + quote(p.opts.Username) + " " + quote(p.opts.Password)); err != nil {
+		return err
+	}
+	if _, err := c.cmd("SELECT " + quote(p.opts.Mailbox)); err != nil {
+		return err
+	}
+	uids, err := c.search()
+	if err != nil {
+		return err
+	}
+	if len(uids) > maxPoll {
+		uids = uids[:maxPoll]
+	}
+
+	read := 0
+	for _, uid := range uids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		raw, err := c.fetch(uid)
+		if err != nil {
+			return err
+		}
+		report, err := Parse(raw, p.opts.VERP, p.opts.VERPSecret)
+		if err != nil {
+			logger.Warn("unreadable message in the bounces mailbox", "uid", uid, "err", err)
+		}
+		if report == nil {
+			if err := c.flag(uid, `\Seen`); err != nil {
+				return err
+			}
+			continue
+		}
+		if report.Complaint {
+			logger.Debug("complaint read", "uid", uid, "email", report.Recipient)
+			err = RecordComplaint(ctx, p.db, report.Recipient, "imap")
+		} else {
+			logger.Debug("bounce read", "uid", uid, "email", report.Recipient, "status", report.Status)
+			err = Record(ctx, p.db, report.Recipient, report.Hard, "imap")
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.flag(uid, `\Deleted`); err != nil {
+			return err
+		}
+		read++
+	}
+	// Also deletes the messages flagged by a poll which failed before.
+	if _, err := c.cmd("EXPUNGE"); err != nil {
+		return err
+	}
+	if read > 0 {
+		logger.Info("reports read from the mailbox", "count", read)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// Package campaigns sends the campaigns: the scheduled ones are started
+// once due, their segment being expanded into recipients, whose messages
+// are then rendered for each of them into the send queue in batches. A
+// campaign is sent once the queue has settled the messages of all its
+// recipients.
+package campaigns
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"mailinglist/personalize"
+	"mailinglist/templates"
+	"time"
+)
+
+// batchSize is the number of recipients queued at once.
+const batchSize = 100
+
+// Run starts the due campaigns, then queues the messages of the campaigns
+// being sent for their pending recipients, and finishes the campaigns
+// whose messages were all settled. With confirmedOnly, the campaigns leave
+// out the emails not confirmed, as when confirmations are sent.
+func Run(ctx context.Context, db *sql.DB, p *personalize.Personalizer, confirmedOnly bool) error {
+	logger := logging.FromContext(ctx)
+	started, err := mdb.StartDueCampaigns(ctx, db, time.Now(), confirmedOnly)
+	for _, id := range started {
+		logger.Info("campaign started", "id", id)
+	}
+	if err != nil {
+		return err
+	}
+
+	sending, err := mdb.GetCampaigns(ctx, db, mdb.CampaignSending)
+	if err != nil {
+		return err
+	}
+	for _, campaign := range sending {
+		if err := queue(ctx, db, p, campaign); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queue queues the messages of the campaign for its pending recipients, a
+// message failing to render, or to a recipient deleted since the start of
+// the campaign, failing its recipient.
+func queue(ctx context.Context, db *sql.DB, p *personalize.Personalizer, campaign *mdb.Campaign) error {
+	logger := logging.FromContext(ctx).With("campaign", campaign.Id)
+	// Checked when the campaign was saved.
+	parsed, err := templates.Parse(campaign.Template())
+	if err != nil {
+		return fmt.Errorf("campaign %v : %w", campaign.Id, err)
+	}
+
+	queued := 0
+	for ctx.Err() == nil {
+		emails, err := mdb.PendingRecipients(ctx, db, campaign.Id, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(emails) == 0 {
+			break
+		}
+		messages := make([]*mdb.QueuedMessage, 0, len(emails))
+		for _, email := range emails {
+			rendered, headers, err := render(ctx, p, parsed, campaign.Id, email)
+			if err != nil {
+				if !errors.Is(err, errRecipient) {
+					return err
+				}
+				logger.Warn("rendering the campaign failed", "email", email, "err", err)
+				if err := mdb.FailRecipient(ctx, db, campaign.Id, email, err); err != nil {
+					return err
+				}
+				continue
+			}
+			messages = append(messages, &mdb.QueuedMessage{
+				From:    campaign.From,
+				To:      email,
+				Subject: rendered.Subject,
+				Text:    rendered.Text,
+				HTML:    rendered.HTML,
+				Headers: headers,
+				Hash:    rendered.Hash(),
+			})
+		}
+		if err := mdb.QueueRecipients(ctx, db, campaign.Id, messages); err != nil {
+			return err
+		}
+		queued += len(messages)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if queued > 0 {
+		logger.Info("campaign queued", "messages", queued)
+	}
+
+	finished, err := mdb.FinishCampaign(ctx, db, campaign.Id)
+	if err != nil || !finished {
+		return err
+	}
+	sent, err := mdb.GetCampaign(ctx, db, campaign.Id)
+	if err != nil {
+		return err
+	}
+	logger.Info("campaign sent", "recipients", sent.Progress.Total, "failed", sent.Progress.Failed)
+	return nil
+}
+
+// errRecipient wraps the failures of a single recipient, the others
+// stopping the run.
+var errRecipient = errors.New("recipient")
+
+// render renders the message of the campaign for the recipient, its links
+// going through the click redirect and its HTML body carrying the tracking
+// pixel, and returns it with its headers.
+func render(ctx context.Context, p *personalize.Personalizer, parsed *templates.Parsed, campaignId int64, email string) (*templates.Rendered, map[string]string, error) {
+	recipient, err := p.Recipient(ctx, email)
+	if errors.Is(err, mdb.ErrEmailNotFound) {
+		return nil, nil, fmt.Errorf("%w deleted: %w", errRecipient, err)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	rendered, err := parsed.Render(recipient.Data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w not rendered: %w", errRecipient, err)
+	}
+	rendered.HTML, err = p.TrackClicks(ctx, rendered.HTML, campaignId, email)
+	if err == nil {
+		rendered.HTML, err = p.TrackOpens(ctx, rendered.HTML, campaignId, email)
+	}
+	if errors.Is(err, mdb.ErrEmailNotFound) {
+		return nil, nil, fmt.Errorf("%w deleted: %w", errRecipient, err)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return rendered, recipient.Headers, nil
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// BulkOptions sets how the calls of a bulk operation are spread, for the
+// servers where the streaming calls are not available, e.g. over HTTP.
+type BulkOptions struct {
+	// Workers is how many calls run at once, it defaults to 8.
+	Workers int
+	// Rate caps the calls started per second, unlimited when 0.
+	Rate float64
+	// OnDone, when set, is called by the workers after each call.
+	OnDone func()
+}
+
+// BulkError is the failure of the call for one email.
+type BulkError struct {
+	// Index is the position of the email in the input.
+	Index int
+	Email string
+	Err   error
+}
+
+type BulkResult struct {
+	// Emails are those returned by the calls that succeeded, in the order
+	// of the input.
+	Emails []*Email
+	// Errors are sorted by Index.
+	Errors []BulkError
+}
+
+// bulk calls fn with each of the emails over the workers of opts. It only
+// fails when ctx is done, the failed calls being listed in the result.
+func (c *MailingListClient) bulk(ctx context.Context, addrs []string, opts BulkOptions, fn func(ctx context.Context, addr string) (*Email, error)) (*BulkResult, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 8
+	}
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	if opts.Rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.Rate), 1)
+	}
+
+	emails := make([]*Email, len(addrs))
+	var mu sync.Mutex
+	result := &BulkResult{}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				email, err := fn(ctx, addrs[i])
+				mu.Lock()
+				if err != nil {
+					result.Errors = append(result.Errors, BulkError{Index: i, Email: addrs[i], Err: err})
+				}
+				emails[i] = email
+				mu.Unlock()
+				if opts.OnDone != nil {
+					opts.OnDone()
+				}
+			}
+		}()
+	}
+
+	var err error
+	for i := range addrs {
+		if err = limiter.Wait(ctx); err != nil {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, email := range emails {
+		if email != nil {
+			result.Emails = append(result.Emails, email)
+		}
+	}
+	sort.Slice(result.Errors, func(i, j int) bool {
+		return result.Errors[i].Index < result.Errors[j].Index
+	})
+	return result, nil
+}
+
+// GetEmails gets each of the emails with its own call, spread as set by
+// opts. The missing emails fail with ErrNotFound.
+func (c *MailingListClient) GetEmails(ctx context.Context, addrs []string, opts BulkOptions) (*BulkResult, error) {
+	return c.bulk(ctx, addrs, opts, c.GetEmail)
+}
+
+// CreateEmails creates each of the emails with its own call, spread as
+// set by opts. The emails that already exist fail with ErrAlreadyExists.
+func (c *MailingListClient) CreateEmails(ctx context.Context, addrs []string, opts BulkOptions) (*BulkResult, error) {
+	return c.bulk(ctx, addrs, opts, c.CreateEmail)
+}
+
+// DeleteEmails deletes each of the emails with its own call, spread as set
+// by opts.
+func (c *MailingListClient) DeleteEmails(ctx context.Context, addrs []string, opts BulkOptions) (*BulkResult, error) {
+	return c.bulk(ctx, addrs, opts, c.DeleteEmail)
+}
+
+// UpdateEmails updates each of the emails with its own call, spread as set
+// by opts. Like UpdateEmail, the missing emails are created.
+func (c *MailingListClient) UpdateEmails(ctx context.Context, emails []*Email, opts BulkOptions) (*BulkResult, error) {
+	addrs := make([]string, len(emails))
+	byAddr := make(map[string]*Email, len(emails))
+	for i, email := range emails {
+		addrs[i] = email.Email
+		byAddr[email.Email] = email
+	}
+	return c.bulk(ctx, addrs, opts, func(ctx context.Context, addr string) (*Email, error) {
+		return c.UpdateEmail(ctx, byAddr[addr])
+	})
+}
@@ -1,125 +1,370 @@
-package main
+// Package client is a Go client of the mailing list gRPC API.
+package client
 
 import (
 	"context"
-	"fmt"
-	"log"
-	"mailinglist/proto"
+	"crypto/tls"
+	pb "mailinglist/proto/mailinglist/v1"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type Email struct {
+	Id           int64      `json:"id"`
+	Email        string     `json:"email"`
+	ConfirmedAt  *time.Time `json:"confirmed_at,omitempty"`
+	OptOut       bool       `json:"opt_out"`
+	OptOutReason string     `json:"opt_out_reason,omitempty"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+}
+
+// Sort orders the listed emails.
+type Sort string
 
-	"github.com/alexflint/go-arg"
+const (
+	SortId            Sort = "id"
+	SortIdDesc        Sort = "-id"
+	SortEmail         Sort = "email"
+	SortEmailDesc     Sort = "-email"
+	SortCreatedAt     Sort = "created_at"
+	SortCreatedAtDesc Sort = "-created_at"
 )
 
-func logResponse(res *proto.EmailResponse, err error) {
-	if err != nil {
-		log.Fatalf("	error: %v\n", err)
-	}
+var pbSorts = map[Sort]pb.EmailSort{
+	"":                pb.EmailSort_EMAIL_SORT_ID,
+	SortId:            pb.EmailSort_EMAIL_SORT_ID,
+	SortIdDesc:        pb.EmailSort_EMAIL_SORT_ID_DESC,
+	SortEmail:         pb.EmailSort_EMAIL_SORT_EMAIL,
+	SortEmailDesc:     pb.EmailSort_EMAIL_SORT_EMAIL_DESC,
+	SortCreatedAt:     pb.EmailSort_EMAIL_SORT_CREATED_AT,
+	SortCreatedAtDesc: pb.EmailSort_EMAIL_SORT_CREATED_AT_DESC,
+}
 
-	if res.EmailEntry == nil {
-		log.Println("	email not found")
-	} else {
-		log.Printf("	response: %v\n", res.EmailEntry)
-	}
+// ListOptions selects the emails returned by ListEmails. The zero value
+// lists the first page with the server's default page size.
+type ListOptions struct {
+	Page, Count int32
+	// PageToken continues from the NextPageToken of a previous page.
+	PageToken     string
+	ConfirmedOnly bool
+	IncludeOptOut bool
+	// IncludeComplained also lists, with IncludeOptOut, the emails which
+	// complained.
+	IncludeComplained bool
+	Tag               string
+	// Query only lists the emails containing it.
+	Query string
+	Sort  Sort
 }
 
-func createEmail(pb proto.MailingListServiceClient, addr string) *proto.EmailEntry {
-	log.Printf("gRPC Client -> create email : %v\n", addr)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
-	defer cancel()
+type EmailPage struct {
+	Emails     []*Email
+	TotalCount int64
+	Page       int32
+	PageCount  int32
+	// NextPageToken is empty on the last page.
+	NextPageToken string
+}
 
-	res, err := pb.CreateEmail(ctx, &proto.CreateEmailRequest{EmailAddr: addr})
-	logResponse(res, err)
-	return res.EmailEntry
+type Config struct {
+	// Addr is the host:port of the gRPC server, or unix:///path for a
+	// unix socket.
+	Addr string
+	// HTTPAddr, when set, makes the client call the REST API of the JSON
+	// server at this host:port or URL instead of the gRPC server.
+	HTTPAddr string
+	// TLS enables TLS when set.
+	TLS *tls.Config
+	// ApiKey, when set, is sent with every call.
+	ApiKey string
+	// Timeout is the deadline of each call made with a context without
+	// one, retries included. 0 leaves the calls without deadline.
+	Timeout time.Duration
+	// Retry is how the idempotent calls are retried when the server is
+	// unavailable or busy, WithRetryPolicy overrides it per call.
+	Retry RetryPolicy
+	// DialOptions are appended to the options dialing the server. The
+	// retries of a service config are disabled, the calls being retried by
+	// the client alone.
+	DialOptions []grpc.DialOption
 }
 
-func updateEmail(pb proto.MailingListServiceClient, emailEntry proto.EmailEntry) *proto.EmailEntry {
-	log.Printf("gRPC Client -> update email : %v\n", emailEntry.Email)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
-	defer cancel()
+// transport is the part of the service the client calls, implemented by
+// the generated gRPC client and by httpTransport.
+type transport interface {
+	CreateEmail(ctx context.Context, in *pb.CreateEmailRequest, opts ...grpc.CallOption) (*pb.EmailResponse, error)
+	GetEmail(ctx context.Context, in *pb.GetEmailRequest, opts ...grpc.CallOption) (*pb.EmailResponse, error)
+	UpdateEmail(ctx context.Context, in *pb.UpdateEmailRequest, opts ...grpc.CallOption) (*pb.EmailResponse, error)
+	DeleteEmail(ctx context.Context, in *pb.DeleteEmailRequest, opts ...grpc.CallOption) (*pb.EmailResponse, error)
+	GetEmailBatch(ctx context.Context, in *pb.GetEmailBatchRequest, opts ...grpc.CallOption) (*pb.GetEmailBatchResponse, error)
+	ConfirmEmail(ctx context.Context, in *pb.ConfirmEmailRequest, opts ...grpc.CallOption) (*pb.EmailResponse, error)
+	UnsubscribeEmail(ctx context.Context, in *pb.UnsubscribeEmailRequest, opts ...grpc.CallOption) (*pb.EmailResponse, error)
+	BulkUnsubscribe(ctx context.Context, in *pb.BulkUnsubscribeRequest, opts ...grpc.CallOption) (*pb.BulkUnsubscribeResponse, error)
+	GetStats(ctx context.Context, in *pb.StatsRequest, opts ...grpc.CallOption) (*pb.StatsResponse, error)
+}
 
-	res, err := pb.UpdateEmail(ctx, &proto.UpdateEmailRequest{EmailEntry: &emailEntry})
-	logResponse(res, err)
-	return res.EmailEntry
+// MailingListClient calls the mailing list service. It is safe for
+// concurrent use.
+type MailingListClient struct {
+	// conn and grpc are nil when calling the REST API, which does not
+	// serve the streaming calls.
+	conn   *grpc.ClientConn
+	grpc   pb.MailingListServiceClient
+	rpc    transport
+	config Config
 }
 
-func deleteEmail(pb proto.MailingListServiceClient, addr string) *proto.EmailEntry {
-	log.Printf("gRPC Client -> delete email : %v\n", addr)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
-	defer cancel()
+// New connects to the server of the config. The connection is established
+// lazily, so New does not fail when the server is down.
+func New(config Config) (*MailingListClient, error) {
+	config.Retry = config.Retry.withDefaults(defaultRetryPolicy)
+
+	if config.HTTPAddr != "" {
+		return &MailingListClient{rpc: newHTTPTransport(config), config: config}, nil
+	}
+
+	creds := insecure.NewCredentials()
+	if config.TLS != nil {
+		creds = credentials.NewTLS(config.TLS)
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if config.ApiKey != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(apiKeyCredentials(config.ApiKey)))
+	}
+	opts = append(opts, config.DialOptions...)
+	// Retried by call, with Retry, not again by gRPC within each attempt.
+	opts = append(opts, grpc.WithDisableRetry())
 
-	res, err := pb.DeleteEmail(ctx, &proto.DeleteEmailRequest{EmailAddr: addr})
-	logResponse(res, err)
-	return res.EmailEntry
+	conn, err := grpc.Dial(config.Addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	rpc := pb.NewMailingListServiceClient(conn)
+	return &MailingListClient{conn: conn, grpc: rpc, rpc: rpc, config: config}, nil
+}
+
+func (c *MailingListClient) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
 }
 
-func getEmail(pb proto.MailingListServiceClient, addr string) *proto.EmailEntry {
-	log.Printf("gRPC Client -> get email : %v\n", addr)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
-	defer cancel()
+// apiKeyCredentials sends the API key in the metadata of the calls. The
+// server may be reached without TLS, e.g. over a unix socket.
+type apiKeyCredentials string
 
-	res, err := pb.GetEmail(ctx, &proto.GetEmailRequest{EmailAddr: addr})
-	logResponse(res, err)
-	return res.EmailEntry
+func (k apiKeyCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(k)}, nil
 }
 
-func getEmailBatch(pb proto.MailingListServiceClient, page int32, count int32) []*proto.EmailEntry {
-	log.Printf("gRPC Client -> get email batch : Page[%v] Count[%v]\n", page, count)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
-	defer cancel()
+func (k apiKeyCredentials) RequireTransportSecurity() bool {
+	return false
+}
 
-	res, err := pb.GetEmailBatch(ctx, &proto.GetEmailBatchRequest{Page: page, Count: count})
+func emailFromPb(entry *pb.EmailEntry) *Email {
+	if entry == nil {
+		return nil
+	}
+	email := &Email{
+		Id:           entry.Id,
+		Email:        entry.Email,
+		OptOut:       entry.OptOut,
+		OptOutReason: entry.OptOutReason,
+	}
+	if entry.ConfirmedAt != nil {
+		t := entry.ConfirmedAt.AsTime()
+		email.ConfirmedAt = &t
+	}
+	if entry.CreatedAt != nil {
+		t := entry.CreatedAt.AsTime()
+		email.CreatedAt = &t
+	}
+	return email
+}
+
+func emailToPb(email *Email) *pb.EmailEntry {
+	entry := &pb.EmailEntry{
+		Id:           email.Id,
+		Email:        email.Email,
+		OptOut:       email.OptOut,
+		OptOutReason: email.OptOutReason,
+	}
+	if email.ConfirmedAt != nil {
+		entry.ConfirmedAt = timestamppb.New(*email.ConfirmedAt)
+	}
+	if email.CreatedAt != nil {
+		entry.CreatedAt = timestamppb.New(*email.CreatedAt)
+	}
+	return entry
+}
+
+// CreateEmail adds an email, normalized with NormalizeEmail. It is not
+// retried since it is not idempotent.
+func (c *MailingListClient) CreateEmail(ctx context.Context, addr string) (*Email, error) {
+	addr, err := NormalizeEmail(addr)
 	if err != nil {
-		log.Fatalf("	error: %v\n", err)
+		return nil, err
 	}
-	if len(res.EmailEntries) == 0 {
-		log.Println("	no email entries found")
-	} else {
-		log.Printf("\tEMailEntries : [\n")
-		for _, entry := range res.EmailEntries {
-			log.Printf("\t\t%v\n", entry)
-		}
-		log.Printf("\t]\n")
+
+	var res *pb.EmailResponse
+	err = c.call(ctx, false, func(ctx context.Context) (err error) {
+		res, err = c.rpc.CreateEmail(ctx, &pb.CreateEmailRequest{EmailAddr: addr})
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return res.EmailEntries
+	return emailFromPb(res.EmailEntry), nil
 }
 
-var args struct {
-	GrpcAddr string `arg:"env:MAILING_LIST_GRPC_ADDR"`
+func (c *MailingListClient) GetEmail(ctx context.Context, addr string) (*Email, error) {
+	var res *pb.EmailResponse
+	err := c.call(ctx, true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.GetEmail(ctx, &pb.GetEmailRequest{EmailAddr: addr})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return emailFromPb(res.EmailEntry), nil
 }
 
-func main() {
-	arg.MustParse(&args)
+// UpdateEmail stores the confirmation and opt out of the email, creating
+// it if needed. The address is validated but not normalized, so that it
+// still matches the stored one.
+func (c *MailingListClient) UpdateEmail(ctx context.Context, email *Email) (*Email, error) {
+	if err := ValidateEmail(email.Email); err != nil {
+		return nil, err
+	}
 
-	if args.GrpcAddr == "" {
-		args.GrpcAddr = ":9092"
+	var res *pb.EmailResponse
+	err := c.call(ctx, true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.UpdateEmail(ctx, &pb.UpdateEmailRequest{EmailEntry: emailToPb(email)})
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return emailFromPb(res.EmailEntry), nil
+}
 
-	conn, err := grpc.Dial(args.GrpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// DeleteEmail moves the email to the trash and returns it.
+func (c *MailingListClient) DeleteEmail(ctx context.Context, addr string) (*Email, error) {
+	var res *pb.EmailResponse
+	err := c.call(ctx, true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.DeleteEmail(ctx, &pb.DeleteEmailRequest{EmailAddr: addr})
+		return err
+	})
 	if err != nil {
-		log.Fatalf("error connecting to gRPC client at %v : %v\n", args.GrpcAddr, err)
+		return nil, err
 	}
-	defer conn.Close()
+	return emailFromPb(res.EmailEntry), nil
+}
+
+// ConfirmEmail confirms the email holding the token of its confirmation
+// link. The token can only be used once, so the call is not retried and an
+// unknown or used token fails with ErrNotFound.
+func (c *MailingListClient) ConfirmEmail(ctx context.Context, token string) (*Email, error) {
+	var res *pb.EmailResponse
+	err := c.call(ctx, false, func(ctx context.Context) (err error) {
+		res, err = c.rpc.ConfirmEmail(ctx, &pb.ConfirmEmailRequest{Token: token})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return emailFromPb(res.EmailEntry), nil
+}
+
+// UnsubscribeEmail opts out the email with the unsubscribe reason.
+func (c *MailingListClient) UnsubscribeEmail(ctx context.Context, addr string) (*Email, error) {
+	return c.unsubscribe(ctx, &pb.UnsubscribeEmailRequest{
+		Target: &pb.UnsubscribeEmailRequest_EmailAddr{EmailAddr: addr},
+	})
+}
 
-	client := proto.NewMailingListServiceClient(conn)
+// UnsubscribeByToken opts out the email holding the token of its
+// unsubscribe link. An unknown token fails with ErrNotFound.
+func (c *MailingListClient) UnsubscribeByToken(ctx context.Context, token string) (*Email, error) {
+	return c.unsubscribe(ctx, &pb.UnsubscribeEmailRequest{
+		Target: &pb.UnsubscribeEmailRequest_Token{Token: token},
+	})
+}
 
-	emailAddr := fmt.Sprintf("dimodrangov%d@gmail.com", time.Now().Nanosecond())
+func (c *MailingListClient) unsubscribe(ctx context.Context, req *pb.UnsubscribeEmailRequest) (*Email, error) {
+	var res *pb.EmailResponse
+	err := c.call(ctx, true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.UnsubscribeEmail(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return emailFromPb(res.EmailEntry), nil
+}
 
-	// Create email
-	newEmail := createEmail(client, emailAddr)
+// UnsubscribeEmails opts out the emails in a single transaction, or moves
+// them to the trash with del. It returns how many emails changed, those
+// missing or already opted out being skipped.
+func (c *MailingListClient) UnsubscribeEmails(ctx context.Context, addrs []string, del bool) (int64, error) {
+	var res *pb.BulkUnsubscribeResponse
+	err := c.call(ctx, true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.BulkUnsubscribe(ctx, &pb.BulkUnsubscribeRequest{EmailAddrs: addrs, Delete: del})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.Affected, nil
+}
 
-	// Update email
-	newEmail.ConfirmedAt = 10000
-	updateEmail(client, *newEmail)
+func (c *MailingListClient) ListEmails(ctx context.Context, opts ListOptions) (*EmailPage, error) {
+	sort, ok := pbSorts[opts.Sort]
+	if !ok {
+		return nil, &Error{Code: codes.InvalidArgument, Message: "unknown sort " + string(opts.Sort)}
+	}
 
-	// Get Email
-	getEmail(client, newEmail.Email)
+	req := &pb.GetEmailBatchRequest{
+		PageToken:         opts.PageToken,
+		ConfirmedOnly:     opts.ConfirmedOnly,
+		IncludeOptOut:     opts.IncludeOptOut,
+		IncludeComplained: opts.IncludeComplained,
+		Tag:               opts.Tag,
+		Query:             opts.Query,
+		Sort:              sort,
+	}
+	if opts.Page != 0 {
+		req.Page = &opts.Page
+	}
+	if opts.Count != 0 {
+		req.Count = &opts.Count
+	}
 
-	// Delete email
-	deleteEmail(client, newEmail.Email)
+	var res *pb.GetEmailBatchResponse
+	err := c.call(ctx, true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.GetEmailBatch(ctx, req, grpc.UseCompressor(gzip.Name))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	// Get email batch
-	getEmailBatch(client, 1, 5)
+	page := &EmailPage{
+		Emails:        make([]*Email, 0, len(res.EmailEntries)),
+		TotalCount:    res.TotalCount,
+		Page:          res.Page,
+		PageCount:     res.PageCount,
+		NextPageToken: res.NextPageToken,
+	}
+	for _, entry := range res.EmailEntries {
+		page.Emails = append(page.Emails, emailFromPb(entry))
+	}
+	return page, nil
 }
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The errors returned by the client match these with errors.Is.
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrAlreadyExists    = errors.New("already exists")
+	ErrInvalidArgument  = errors.New("invalid argument")
+	ErrUnauthenticated  = errors.New("unauthenticated")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrRateLimited      = errors.New("rate limited")
+	ErrUnavailable      = errors.New("server unavailable")
+	// ErrUnsupported is returned by the streaming calls over HTTP.
+	ErrUnsupported = errors.New("not supported by the HTTP transport")
+)
+
+var codeErrors = map[codes.Code]error{
+	codes.NotFound:          ErrNotFound,
+	codes.AlreadyExists:     ErrAlreadyExists,
+	codes.InvalidArgument:   ErrInvalidArgument,
+	codes.Unauthenticated:   ErrUnauthenticated,
+	codes.PermissionDenied:  ErrPermissionDenied,
+	codes.ResourceExhausted: ErrRateLimited,
+	codes.Unavailable:       ErrUnavailable,
+	codes.Aborted:           ErrUnavailable,
+}
+
+// FieldViolation is a request field rejected by the server.
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// Error is an error returned by the server.
+type Error struct {
+	Code       codes.Code
+	Message    string
+	Violations []FieldViolation
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	return codeErrors[e.Code] == target
+}
+
+// convertError turns the status of a failed call into an *Error, leaving
+// context errors untouched.
+func convertError(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch st.Code() {
+	case codes.Canceled:
+		return context.Canceled
+	case codes.DeadlineExceeded:
+		return context.DeadlineExceeded
+	}
+
+	e := &Error{Code: st.Code(), Message: st.Message()}
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.FieldViolations {
+				e.Violations = append(e.Violations, FieldViolation{
+					Field:       violation.Field,
+					Description: violation.Description,
+				})
+			}
+		}
+	}
+	return e
+}
+
+// retryable reports whether a call failing with err may succeed if retried.
+func retryable(err error) bool {
+	return errors.Is(err, ErrUnavailable)
+}
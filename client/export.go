@@ -0,0 +1,153 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	pb "mailinglist/proto/mailinglist/v1"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+type ExportFormat string
+
+const (
+	ExportCsv ExportFormat = "csv"
+	// ExportNdjson writes one JSON encoded email per line.
+	ExportNdjson ExportFormat = "ndjson"
+)
+
+var pbExportFormats = map[ExportFormat]pb.ExportFormat{
+	"":           pb.ExportFormat_EXPORT_FORMAT_CSV,
+	ExportCsv:    pb.ExportFormat_EXPORT_FORMAT_CSV,
+	ExportNdjson: pb.ExportFormat_EXPORT_FORMAT_NDJSON,
+}
+
+type ExportOptions struct {
+	Format        ExportFormat
+	ConfirmedOnly bool
+	IncludeOptOut bool
+	// IncludeComplained also exports, with IncludeOptOut, the emails which
+	// complained.
+	IncludeComplained bool
+	Tag               string
+	// AfterId continues an export after the email with this id, as
+	// returned by a previous ExportEmails. The CSV header is left out.
+	AfterId int64
+}
+
+// ExportEmails writes the export of the selected emails to w. When the
+// stream breaks because the server is unavailable, the export resumes
+// after the last email written, so w receives every row exactly once.
+// It returns the id of the last email written, also on error, from which
+// a later call may resume. Requires the gRPC transport.
+func (c *MailingListClient) ExportEmails(ctx context.Context, w io.Writer, opts ExportOptions) (int64, error) {
+	if c.grpc == nil {
+		return 0, ErrUnsupported
+	}
+	format, ok := pbExportFormats[opts.Format]
+	if !ok {
+		return 0, &Error{Code: codes.InvalidArgument, Message: "unknown format " + string(opts.Format)}
+	}
+
+	policy := c.retryPolicy(ctx)
+	lastId := opts.AfterId
+	failures := 0
+	for {
+		progressed, err := c.exportFrom(ctx, w, &pb.ExportRequest{
+			Format:            format,
+			ConfirmedOnly:     opts.ConfirmedOnly,
+			IncludeOptOut:     opts.IncludeOptOut,
+			IncludeComplained: opts.IncludeComplained,
+			Tag:               opts.Tag,
+			AfterId:           lastId,
+		}, &lastId)
+		// Only the last chunk of an export without emails has no last_id,
+		// so the export is complete once it is written.
+		if err == nil || (progressed && lastId == 0) {
+			return lastId, nil
+		}
+
+		// Only consecutive failures without progress count as retries.
+		if progressed {
+			failures = 0
+		}
+		failures++
+		if !retryable(err) || failures > policy.Retries {
+			return lastId, err
+		}
+		if err := wait(ctx, policy.backoff(failures-1)); err != nil {
+			return lastId, err
+		}
+	}
+}
+
+// exportFrom copies one export stream to w, updating lastId after each
+// chunk written. progressed reports whether any chunk was written.
+func (c *MailingListClient) exportFrom(ctx context.Context, w io.Writer, req *pb.ExportRequest, lastId *int64) (progressed bool, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.grpc.ExportEmails(ctx, req)
+	if err != nil {
+		return false, convertError(err)
+	}
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return progressed, nil
+		}
+		if err != nil {
+			return progressed, convertError(err)
+		}
+
+		if _, err := w.Write(chunk.Data); err != nil {
+			return progressed, err
+		}
+		progressed = true
+		if chunk.LastId != 0 {
+			*lastId = chunk.LastId
+		}
+	}
+}
+
+// entryWriter decodes the lines of an NDJSON export, calling fn with each
+// email. The chunks may end in the middle of a line, which is kept until
+// the next one.
+type entryWriter struct {
+	partial []byte
+	fn      func(*Email) error
+}
+
+func (w *entryWriter) Write(data []byte) (int, error) {
+	w.partial = append(w.partial, data...)
+	for {
+		end := bytes.IndexByte(w.partial, '\n')
+		if end < 0 {
+			return len(data), nil
+		}
+		line := w.partial[:end]
+		w.partial = w.partial[end+1:]
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		entry := &pb.EmailEntry{}
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(line, entry); err != nil {
+			return 0, &Error{Code: codes.Internal, Message: "invalid export line: " + err.Error()}
+		}
+		if err := w.fn(emailFromPb(entry)); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// ExportEntries calls fn with each of the selected emails, in the order of
+// their ids, streaming them with ExportEmails, whose Format is ignored. It
+// stops at the first error of fn. Requires the gRPC transport.
+func (c *MailingListClient) ExportEntries(ctx context.Context, opts ExportOptions, fn func(*Email) error) (int64, error) {
+	opts.Format = ExportNdjson
+	return c.ExportEmails(ctx, &entryWriter{fn: fn}, opts)
+}
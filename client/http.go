@@ -0,0 +1,204 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	pb "mailinglist/proto/mailinglist/v1"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// httpCodes maps the HTTP status of responses without a gRPC status body,
+// e.g. those rejected by the middlewares of the JSON server.
+var httpCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+	http.StatusBadGateway:          codes.Unavailable,
+	http.StatusGatewayTimeout:      codes.DeadlineExceeded,
+	http.StatusInternalServerError: codes.Internal,
+}
+
+// httpTransport calls the REST API the JSON server generates from the
+// google.api.http annotations, under /v1/.
+type httpTransport struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func newHTTPTransport(config Config) *httpTransport {
+	addr := config.HTTPAddr
+	if !strings.Contains(addr, "://") {
+		scheme := "http://"
+		if config.TLS != nil {
+			scheme = "https://"
+		}
+		addr = scheme + addr
+	}
+
+	client := http.DefaultClient
+	if config.TLS != nil {
+		client = &http.Client{Transport: &http.Transport{TLSClientConfig: config.TLS}}
+	}
+	return &httpTransport{baseURL: strings.TrimSuffix(addr, "/"), apiKey: config.ApiKey, client: client}
+}
+
+// send sends the request with the API key.
+func (t *httpTransport) send(req *http.Request) (*http.Response, error) {
+	if t.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	}
+	return t.client.Do(req)
+}
+
+// do sends the body, if any, and decodes the response into res. Errors are
+// returned as gRPC statuses, so they are handled like those of gRPC calls.
+func (t *httpTransport) do(ctx context.Context, method, path string, query url.Values, body proto.Message, res proto.Message) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := protojson.Marshal(body)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	u := t.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := t.send(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return httpError(resp.StatusCode, data)
+	}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, res); err != nil {
+		return status.Errorf(codes.Internal, "invalid response: %v", err)
+	}
+	return nil
+}
+
+// httpError decodes the gRPC status written by the gateway, falling back
+// to the HTTP status for the other errors.
+func httpError(statusCode int, data []byte) error {
+	var st spb.Status
+	err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, &st)
+	if err == nil && st.Code != 0 {
+		return status.ErrorProto(&st)
+	}
+
+	code, ok := httpCodes[statusCode]
+	if !ok {
+		code = codes.Unknown
+	}
+	message := strings.TrimSpace(string(data))
+	if message == "" {
+		message = http.StatusText(statusCode)
+	}
+	return status.Error(code, message)
+}
+
+func (t *httpTransport) CreateEmail(ctx context.Context, in *pb.CreateEmailRequest, _ ...grpc.CallOption) (*pb.EmailResponse, error) {
+	res := &pb.EmailResponse{}
+	return res, t.do(ctx, http.MethodPost, "/v1/emails", nil, in, res)
+}
+
+func (t *httpTransport) GetEmail(ctx context.Context, in *pb.GetEmailRequest, _ ...grpc.CallOption) (*pb.EmailResponse, error) {
+	res := &pb.EmailResponse{}
+	return res, t.do(ctx, http.MethodGet, "/v1/emails/"+url.PathEscape(in.EmailAddr), nil, nil, res)
+}
+
+func (t *httpTransport) UpdateEmail(ctx context.Context, in *pb.UpdateEmailRequest, _ ...grpc.CallOption) (*pb.EmailResponse, error) {
+	res := &pb.EmailResponse{}
+	path := "/v1/emails/" + url.PathEscape(in.EmailEntry.GetEmail())
+	return res, t.do(ctx, http.MethodPut, path, nil, in.EmailEntry, res)
+}
+
+func (t *httpTransport) DeleteEmail(ctx context.Context, in *pb.DeleteEmailRequest, _ ...grpc.CallOption) (*pb.EmailResponse, error) {
+	res := &pb.EmailResponse{}
+	return res, t.do(ctx, http.MethodDelete, "/v1/emails/"+url.PathEscape(in.EmailAddr), nil, nil, res)
+}
+
+func (t *httpTransport) ConfirmEmail(ctx context.Context, in *pb.ConfirmEmailRequest, _ ...grpc.CallOption) (*pb.EmailResponse, error) {
+	res := &pb.EmailResponse{}
+	return res, t.do(ctx, http.MethodPost, "/v1/emails:confirm", nil, in, res)
+}
+
+func (t *httpTransport) UnsubscribeEmail(ctx context.Context, in *pb.UnsubscribeEmailRequest, _ ...grpc.CallOption) (*pb.EmailResponse, error) {
+	res := &pb.EmailResponse{}
+	return res, t.do(ctx, http.MethodPost, "/v1/emails:unsubscribe", nil, in, res)
+}
+
+func (t *httpTransport) GetEmailBatch(ctx context.Context, in *pb.GetEmailBatchRequest, _ ...grpc.CallOption) (*pb.GetEmailBatchResponse, error) {
+	query := url.Values{}
+	if in.Page != nil {
+		query.Set("page", strconv.Itoa(int(*in.Page)))
+	}
+	if in.Count != nil {
+		query.Set("count", strconv.Itoa(int(*in.Count)))
+	}
+	if in.PageToken != "" {
+		query.Set("page_token", in.PageToken)
+	}
+	if in.ConfirmedOnly {
+		query.Set("confirmed_only", "true")
+	}
+	if in.IncludeOptOut {
+		query.Set("include_opt_out", "true")
+	}
+	if in.IncludeComplained {
+		query.Set("include_complained", "true")
+	}
+	if in.Tag != "" {
+		query.Set("tag", in.Tag)
+	}
+	if in.Query != "" {
+		query.Set("query", in.Query)
+	}
+	if in.Sort != pb.EmailSort_EMAIL_SORT_ID {
+		query.Set("sort", in.Sort.String())
+	}
+
+	res := &pb.GetEmailBatchResponse{}
+	return res, t.do(ctx, http.MethodGet, "/v1/emails", query, nil, res)
+}
+
+func (t *httpTransport) BulkUnsubscribe(ctx context.Context, in *pb.BulkUnsubscribeRequest, _ ...grpc.CallOption) (*pb.BulkUnsubscribeResponse, error) {
+	res := &pb.BulkUnsubscribeResponse{}
+	return res, t.do(ctx, http.MethodPost, "/v1/emails:bulkUnsubscribe", nil, in, res)
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"io"
+	pb "mailinglist/proto/mailinglist/v1"
+)
+
+// importChunkSize is how much of the file is sent in each chunk.
+const importChunkSize = 64 << 10
+
+type ImportRowError struct {
+	// Row is the line of the row in the file, starting at 1.
+	Row   int64
+	Email string
+	Error string
+}
+
+type ImportSummary struct {
+	Rows, Created, Duplicates, Invalid int64
+	// Failed counts the rows whose creation failed for another reason,
+	// e.g. the server being unavailable. Only imports made with
+	// CreateEmails have such rows.
+	Failed int64
+	// Errors lists the first invalid rows.
+	Errors []ImportRowError
+}
+
+// ImportEmails streams the CSV file read from r to the server, which
+// creates its emails. The email is read from the "email" column when the
+// file has a header, from the first column otherwise. The import is not
+// retried, and requires the gRPC transport.
+func (c *MailingListClient) ImportEmails(ctx context.Context, r io.Reader) (*ImportSummary, error) {
+	if c.grpc == nil {
+		return nil, ErrUnsupported
+	}
+
+	// Canceling the call on a read error keeps the server from committing
+	// the rest of a partially read file.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.grpc.ImportEmails(ctx)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	buf := make([]byte, importChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := stream.Send(&pb.ImportChunk{Data: buf[:n]}); err != nil {
+				// The server ended the call, its status is returned by
+				// CloseAndRecv.
+				if err == io.EOF {
+					break
+				}
+				return nil, convertError(err)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	summary := &ImportSummary{
+		Rows:       res.Rows,
+		Created:    res.Created,
+		Duplicates: res.Duplicates,
+		Invalid:    res.Invalid,
+		Errors:     make([]ImportRowError, 0, len(res.Errors)),
+	}
+	for _, rowErr := range res.Errors {
+		summary.Errors = append(summary.Errors, ImportRowError{Row: rowErr.Row, Email: rowErr.EmailAddr, Error: rowErr.Error})
+	}
+	return summary, nil
+}
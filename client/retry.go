@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy sets how the calls are retried while the server is
+// unavailable or busy. The wait doubles after each retry, up to MaxDelay,
+// and is randomized by up to half so that clients do not retry in step.
+type RetryPolicy struct {
+	// Retries is how many times a call is retried. It defaults to 3, -1
+	// disables the retries.
+	Retries int
+	// Delay is the wait before the first retry, it defaults to 200ms.
+	Delay time.Duration
+	// MaxDelay caps the wait between retries, it defaults to 5s.
+	MaxDelay time.Duration
+}
+
+// withDefaults returns the policy with its unset fields taken from
+// defaults.
+func (p RetryPolicy) withDefaults(defaults RetryPolicy) RetryPolicy {
+	if p.Retries == 0 {
+		p.Retries = defaults.Retries
+	}
+	if p.Delay == 0 {
+		p.Delay = defaults.Delay
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = defaults.MaxDelay
+	}
+	return p
+}
+
+// backoff returns the wait before the retry following attempt, the first
+// being attempt 0.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.Delay
+	for i := 0; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	Retries:  3,
+	Delay:    200 * time.Millisecond,
+	MaxDelay: 5 * time.Second,
+}
+
+type retryPolicyKey struct{}
+
+// WithRetryPolicy returns a context overriding the retry policy of the
+// client for the calls made with it. Its unset fields keep the values of
+// the client's policy.
+func WithRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+func (c *MailingListClient) retryPolicy(ctx context.Context) RetryPolicy {
+	policy, _ := ctx.Value(retryPolicyKey{}).(RetryPolicy)
+	return policy.withDefaults(c.config.Retry)
+}
+
+// call runs fn, retrying it if idempotent while the server is unavailable.
+// fn is given ctx with the timeout of the config.
+func (c *MailingListClient) call(ctx context.Context, idempotent bool, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Deadline(); !ok && c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+	}
+
+	policy := c.retryPolicy(ctx)
+	for attempt := 0; ; attempt++ {
+		err := convertError(fn(ctx))
+		if err == nil || !idempotent || !retryable(err) || attempt >= policy.Retries {
+			return err
+		}
+
+		if wait(ctx, policy.backoff(attempt)) != nil {
+			return err
+		}
+	}
+}
+
+// wait sleeps between retries, returning early with the error of ctx.
+func wait(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
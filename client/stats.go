@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	pb "mailinglist/proto/mailinglist/v1"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"google.golang.org/grpc"
+)
+
+// Stats are the subscriber totals of the list. Total splits into
+// Subscribed, itself split into Confirmed and Unconfirmed, and OptedOut.
+type Stats struct {
+	Total       int64 `json:"total"`
+	Subscribed  int64 `json:"subscribed"`
+	Confirmed   int64 `json:"confirmed"`
+	Unconfirmed int64 `json:"unconfirmed"`
+	OptedOut    int64 `json:"opted_out"`
+	// Deleted counts the emails in the trash, not part of Total.
+	Deleted       int64            `json:"deleted"`
+	OptOutReasons map[string]int64 `json:"opt_out_reasons,omitempty"`
+	// Signups are the emails created per UTC day, oldest first.
+	Signups []DailyCount `json:"signups"`
+}
+
+type DailyCount struct {
+	// Date is formatted as YYYY-MM-DD.
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+// GetStats returns the totals of the list and the signups of the last
+// days, including today. The server defaults to 30 days when days is 0.
+func (c *MailingListClient) GetStats(ctx context.Context, days int32) (*Stats, error) {
+	req := &pb.StatsRequest{}
+	if days != 0 {
+		req.Days = &days
+	}
+
+	var res *pb.StatsResponse
+	err := c.call(ctx, true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.GetStats(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{
+		Total:         res.Total,
+		Subscribed:    res.Subscribed,
+		Confirmed:     res.Confirmed,
+		Unconfirmed:   res.Unconfirmed,
+		OptedOut:      res.OptedOut,
+		Deleted:       res.Deleted,
+		OptOutReasons: make(map[string]int64, len(res.OptOutReasons)),
+	}
+	for _, reason := range res.OptOutReasons {
+		stats.OptOutReasons[reason.Reason] = reason.Count
+	}
+	for _, signups := range res.Signups {
+		stats.Signups = append(stats.Signups, DailyCount{Date: signups.Date, Count: signups.Count})
+	}
+	return stats, nil
+}
+
+func (t *httpTransport) GetStats(ctx context.Context, in *pb.StatsRequest, _ ...grpc.CallOption) (*pb.StatsResponse, error) {
+	query := url.Values{}
+	if in.Days != nil {
+		query.Set("days", strconv.Itoa(int(*in.Days)))
+	}
+
+	res := &pb.StatsResponse{}
+	return res, t.do(ctx, http.MethodGet, "/v1/stats", query, nil, res)
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"mailinglist/sanitize"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+)
+
+// NormalizeEmail trims the spaces around an address and lowercases its
+// domain, which is case insensitive, then checks it like the server does.
+// The error is an *Error matching ErrInvalidArgument.
+func NormalizeEmail(addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if at := strings.LastIndexByte(addr, '@'); at >= 0 {
+		addr = addr[:at+1] + strings.ToLower(addr[at+1:])
+	}
+	if err := ValidateEmail(addr); err != nil {
+		return "", err
+	}
+	return addr, nil
+}
+
+// ValidateEmail checks an address like the server does, without changing
+// it. The error is an *Error matching ErrInvalidArgument.
+func ValidateEmail(addr string) error {
+	if err := sanitize.Email("email", addr); err != nil {
+		return &Error{
+			Code:       codes.InvalidArgument,
+			Message:    err.Error(),
+			Violations: []FieldViolation{{Field: "email", Description: err.Error()}},
+		}
+	}
+	return nil
+}
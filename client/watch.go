@@ -0,0 +1,227 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	pb "mailinglist/proto/mailinglist/v1"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// EventKind is what happened to a subscriber.
+type EventKind string
+
+const (
+	EventCreated      EventKind = "created"
+	EventConfirmed    EventKind = "confirmed"
+	EventUnsubscribed EventKind = "unsubscribed"
+	EventBounced      EventKind = "bounced"
+	EventComplained   EventKind = "complained"
+	EventResubscribed EventKind = "resubscribed"
+	EventDeleted      EventKind = "deleted"
+	EventRestored     EventKind = "restored"
+	EventUpdated      EventKind = "updated"
+	EventPurged       EventKind = "purged"
+)
+
+const eventKindPrefix = "SUBSCRIBER_EVENT_KIND_"
+
+func eventKindFromPb(kind pb.SubscriberEventKind) EventKind {
+	return EventKind(strings.ToLower(strings.TrimPrefix(kind.String(), eventKindPrefix)))
+}
+
+func eventKindToPb(kind EventKind) (pb.SubscriberEventKind, bool) {
+	value, ok := pb.SubscriberEventKind_value[eventKindPrefix+strings.ToUpper(string(kind))]
+	return pb.SubscriberEventKind(value), ok && value != 0
+}
+
+type Event struct {
+	// Seq orders the events, a watch can resume after it.
+	Seq         int64      `json:"seq"`
+	Email       string     `json:"email"`
+	Kind        EventKind  `json:"kind"`
+	OccurredAt  time.Time  `json:"occurred_at"`
+	OptOut      bool       `json:"opt_out"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+}
+
+func eventFromPb(event *pb.SubscriberEvent) *Event {
+	e := &Event{
+		Seq:        event.Seq,
+		Email:      event.Email,
+		Kind:       eventKindFromPb(event.Kind),
+		OccurredAt: event.OccurredAt.AsTime(),
+		OptOut:     event.OptOut,
+	}
+	if event.ConfirmedAt != nil {
+		t := event.ConfirmedAt.AsTime()
+		e.ConfirmedAt = &t
+	}
+	return e
+}
+
+type WatchOptions struct {
+	// SinceSeq, when set, replays the events recorded after it before
+	// the new ones.
+	SinceSeq *int64
+	// Kinds only watches events of these kinds, all kinds when empty.
+	Kinds []EventKind
+	// Domain only watches the emails of this domain.
+	Domain string
+	// OnReconnect, when set, is called with the error breaking the stream
+	// before each reconnection.
+	OnReconnect func(err error)
+}
+
+// Watch calls fn with the subscriber events as they happen, until ctx is
+// done or fn returns an error, which Watch returns. When the stream breaks
+// it reconnects and resumes after the last event received, for as long as
+// the server is unavailable, waiting longer after each failed reconnection
+// as set by the retry policy, whose number of retries is ignored.
+func (c *MailingListClient) Watch(ctx context.Context, opts WatchOptions, fn func(*Event) error) error {
+	req := &pb.WatchRequest{SinceSeq: opts.SinceSeq, Domain: opts.Domain}
+	for _, kind := range opts.Kinds {
+		pbKind, ok := eventKindToPb(kind)
+		if !ok {
+			return &Error{Code: codes.InvalidArgument, Message: "unknown event kind " + string(kind)}
+		}
+		req.Kinds = append(req.Kinds, pbKind)
+	}
+
+	policy := c.retryPolicy(ctx)
+	failures := 0
+	for {
+		err := c.watchFrom(ctx, req, func(event *pb.SubscriberEvent) error {
+			failures = 0
+			seq := event.Seq
+			req.SinceSeq = &seq
+			return fn(eventFromPb(event))
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// The server ends the watch of clients falling behind, which can
+		// catch up from their last event.
+		if !retryable(err) && !errors.Is(err, ErrRateLimited) {
+			return err
+		}
+		if opts.OnReconnect != nil {
+			opts.OnReconnect(err)
+		}
+		if err := wait(ctx, policy.backoff(failures)); err != nil {
+			return nil
+		}
+		failures++
+	}
+}
+
+// watchFrom calls fn with the events of one watch stream, until it breaks.
+func (c *MailingListClient) watchFrom(ctx context.Context, req *pb.WatchRequest, fn func(*pb.SubscriberEvent) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var recv func() (*pb.SubscriberEvent, error)
+	if c.grpc != nil {
+		stream, err := c.grpc.Watch(ctx, req)
+		if err != nil {
+			return convertError(err)
+		}
+		recv = stream.Recv
+	} else {
+		var err error
+		recv, err = c.rpc.(*httpTransport).watch(ctx, req)
+		if err != nil {
+			return convertError(err)
+		}
+	}
+
+	for {
+		event, err := recv()
+		if err == io.EOF {
+			return convertError(status.Error(codes.Unavailable, "watch ended by the server"))
+		}
+		if err != nil {
+			return convertError(err)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+}
+
+// watch reads the stream of the gateway, which writes each event as a
+// {"result": event} line, and the error ending it as {"error": status}.
+func (t *httpTransport) watch(ctx context.Context, in *pb.WatchRequest) (func() (*pb.SubscriberEvent, error), error) {
+	query := url.Values{}
+	if in.SinceSeq != nil {
+		query.Set("since_seq", strconv.FormatInt(*in.SinceSeq, 10))
+	}
+	for _, kind := range in.Kinds {
+		query.Add("kinds", kind.String())
+	}
+	if in.Domain != "" {
+		query.Set("domain", in.Domain)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.baseURL+"/v1/events:watch?"+query.Encode(), nil)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	resp, err := t.send(req)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return nil, httpError(resp.StatusCode, data)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	unmarshal := protojson.UnmarshalOptions{DiscardUnknown: true}
+	return func() (*pb.SubscriberEvent, error) {
+		for scanner.Scan() {
+			var line struct {
+				Result json.RawMessage `json:"result"`
+				Error  json.RawMessage `json:"error"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				return nil, status.Errorf(codes.Internal, "invalid response: %v", err)
+			}
+			if line.Error != nil {
+				var st spb.Status
+				if err := unmarshal.Unmarshal(line.Error, &st); err != nil {
+					return nil, status.Errorf(codes.Internal, "invalid response: %v", err)
+				}
+				return nil, status.ErrorProto(&st)
+			}
+			if line.Result == nil {
+				continue
+			}
+
+			event := &pb.SubscriberEvent{}
+			if err := unmarshal.Unmarshal(line.Result, event); err != nil {
+				return nil, status.Errorf(codes.Internal, "invalid response: %v", err)
+			}
+			return event, nil
+		}
+		resp.Body.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return nil, io.EOF
+	}, nil
+}
@@ -0,0 +1,731 @@
+// Package config holds the settings of the server, read from a YAML or
+// TOML file, the environment and the flags, in increasing priority.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mailinglist/crash"
+	"mailinglist/flags"
+	"mailinglist/logging"
+	"mailinglist/mailer"
+	"mailinglist/ratelimit"
+	"mailinglist/s3backup"
+	"mailinglist/scheduler"
+	"mailinglist/sendqueue"
+	"maps"
+	"math"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/alexflint/go-arg"
+	"gopkg.in/yaml.v3"
+)
+
+// Database sets the SQLite database.
+type Database struct {
+	DbPath         string        `arg:"env:MAILING_LIST_DB" yaml:"path" toml:"path" help:"SQLite database file, defaults to list.db"`
+	TrashRetention time.Duration `arg:"env:MAILING_LIST_TRASH_RETENTION" yaml:"trash_retention" toml:"trash_retention" help:"how long deleted emails can be restored, defaults to 30 days"`
+	// ManualMigrations leaves the migrations to the migrate command.
+	ManualMigrations bool `arg:"env:MAILING_LIST_MANUAL_MIGRATIONS" yaml:"manual_migrations" toml:"manual_migrations" help:"do not migrate the schema on start, refusing a database not migrated with the migrate command"`
+}
+
+// Bind sets the addresses the servers listen on.
+type Bind struct {
+	BindJson    string `arg:"env:MAILING_LIST_BIND_PORT" yaml:"json" toml:"json" help:"address of the JSON server, defaults to :9091"`
+	BindGrpc    string `arg:"env:MAILING_LIST_GRPC_BIND_PORT" yaml:"grpc" toml:"grpc" help:"address of the gRPC server, defaults to :9092"`
+	GrpcUnix    string `arg:"env:MAILING_LIST_GRPC_UNIX_SOCKET" yaml:"grpc_unix" toml:"grpc_unix" help:"path of a unix socket the gRPC server also listens on"`
+	DisableJson bool   `arg:"env:MAILING_LIST_DISABLE_JSON" yaml:"disable_json" toml:"disable_json" help:"do not serve the JSON API, the webhooks and the admin endpoints"`
+	DisableGrpc bool   `arg:"env:MAILING_LIST_DISABLE_GRPC" yaml:"disable_grpc" toml:"disable_grpc" help:"do not serve the gRPC API, nor the REST API under /v1/ proxied to it"`
+	BindDebug   string `arg:"env:MAILING_LIST_DEBUG_BIND" yaml:"debug" toml:"debug" help:"loopback address serving pprof and the runtime stats, e.g. localhost:6060, which can be bind.admin"`
+	BindAdmin   string `arg:"env:MAILING_LIST_ADMIN_BIND" yaml:"admin" toml:"admin" help:"address serving /admin and /metrics instead of bind.json, e.g. localhost:9093"`
+}
+
+// Auth sets who may call the servers.
+type Auth struct {
+	RequireApiKey bool   `arg:"env:MAILING_LIST_REQUIRE_API_KEY" yaml:"require_api_key" toml:"require_api_key"`
+	AdminToken    string `arg:"env:MAILING_LIST_ADMIN_TOKEN" yaml:"admin_token" toml:"admin_token" secret:"true"`
+}
+
+// Limits bounds the requests of the clients.
+type Limits struct {
+	RateLimit                float64           `arg:"env:MAILING_LIST_RATE_LIMIT" yaml:"rate" toml:"rate" help:"requests per second allowed for each API key or address, 0 disables it"`
+	RateLimitBurst           int               `arg:"env:MAILING_LIST_RATE_LIMIT_BURST" yaml:"burst" toml:"burst" help:"requests allowed at once, defaults to the rate limit"`
+	RateLimitRules           map[string]string `arg:"env:MAILING_LIST_RATE_LIMIT_RULES" yaml:"rules" toml:"rules" help:"more rate limits by name, as scope[:class] rate[/burst], e.g. exports=tenant:bulk 1/5"`
+	TrustedProxies           []string          `arg:"env:MAILING_LIST_TRUSTED_PROXIES" yaml:"trusted_proxies" toml:"trusted_proxies" help:"addresses or CIDRs of the proxies whose X-Forwarded-For tells the address of the callers for the rate limits, e.g. 10.0.0.0/8"`
+	GrpcMaxRecvMsgSize       int               `arg:"env:MAILING_LIST_GRPC_MAX_RECV_MSG_SIZE" yaml:"grpc_max_recv_msg_size" toml:"grpc_max_recv_msg_size" help:"in bytes, defaults to 16MiB"`
+	GrpcMaxSendMsgSize       int               `arg:"env:MAILING_LIST_GRPC_MAX_SEND_MSG_SIZE" yaml:"grpc_max_send_msg_size" toml:"grpc_max_send_msg_size" help:"in bytes, defaults to 16MiB"`
+	GrpcMaxConcurrentStreams uint32            `arg:"env:MAILING_LIST_GRPC_MAX_CONCURRENT_STREAMS" yaml:"grpc_max_concurrent_streams" toml:"grpc_max_concurrent_streams"`
+}
+
+// Timeouts sets the keepalives of the gRPC server and the shutdown.
+type Timeouts struct {
+	GrpcKeepaliveTime    time.Duration `arg:"env:MAILING_LIST_GRPC_KEEPALIVE_TIME" yaml:"grpc_keepalive_time" toml:"grpc_keepalive_time" help:"defaults to 1m"`
+	GrpcKeepaliveTimeout time.Duration `arg:"env:MAILING_LIST_GRPC_KEEPALIVE_TIMEOUT" yaml:"grpc_keepalive_timeout" toml:"grpc_keepalive_timeout" help:"defaults to 20s"`
+	GrpcKeepaliveMinTime time.Duration `arg:"env:MAILING_LIST_GRPC_KEEPALIVE_MIN_TIME" yaml:"grpc_keepalive_min_time" toml:"grpc_keepalive_min_time" help:"minimum interval between client pings, defaults to 10s"`
+	GrpcShutdownTimeout  time.Duration `arg:"env:MAILING_LIST_GRPC_SHUTDOWN_TIMEOUT" yaml:"grpc_shutdown_timeout" toml:"grpc_shutdown_timeout" help:"how long to wait for RPCs in flight on shutdown, defaults to 30s"`
+	LameDuckPeriod       time.Duration `arg:"env:MAILING_LIST_LAME_DUCK_PERIOD" yaml:"lame_duck_period" toml:"lame_duck_period" help:"how long the server reports itself as not ready, still serving, on SIGTERM before shutting down, e.g. 15s, 0 shuts down at once"`
+}
+
+// TLS sets the certificate of the gRPC server.
+type TLS struct {
+	GrpcTLSCert     string `arg:"env:MAILING_LIST_GRPC_TLS_CERT" yaml:"cert" toml:"cert"`
+	GrpcTLSKey      string `arg:"env:MAILING_LIST_GRPC_TLS_KEY" yaml:"key" toml:"key"`
+	GrpcTLSClientCA string `arg:"env:MAILING_LIST_GRPC_TLS_CLIENT_CA" yaml:"client_ca" toml:"client_ca" help:"require client certificates signed by this CA"`
+}
+
+// Sync sets the instance kept in sync with this one.
+type Sync struct {
+	SyncPeer       string `arg:"env:MAILING_LIST_SYNC_PEER" yaml:"peer" toml:"peer" help:"gRPC address of an instance to keep in sync with"`
+	SyncPeerApiKey string `arg:"env:MAILING_LIST_SYNC_PEER_API_KEY" yaml:"peer_api_key" toml:"peer_api_key" secret:"true"`
+	SyncPeerCaCert string `arg:"env:MAILING_LIST_SYNC_PEER_CA_CERT" yaml:"peer_ca_cert" toml:"peer_ca_cert" help:"CA verifying the sync peer, enables TLS to the peer"`
+}
+
+// Tracing sets where the traces are sent.
+type Tracing struct {
+	OtlpEndpoint string `arg:"env:MAILING_LIST_OTLP_ENDPOINT" yaml:"otlp_endpoint" toml:"otlp_endpoint" help:"host:port of an OTLP gRPC collector receiving the traces"`
+	OtlpInsecure bool   `arg:"env:MAILING_LIST_OTLP_INSECURE" yaml:"otlp_insecure" toml:"otlp_insecure" help:"connect to the OTLP collector without TLS"`
+}
+
+// Logging sets the logs of the server.
+type Logging struct {
+	LogLevel  string `arg:"env:MAILING_LIST_LOG_LEVEL" yaml:"level" toml:"level" help:"debug, info, warn or error, defaults to info"`
+	LogFormat string `arg:"env:MAILING_LIST_LOG_FORMAT" yaml:"format" toml:"format" help:"text or json, defaults to text"`
+
+	LogFile           string            `arg:"env:MAILING_LIST_LOG_FILE" yaml:"file" toml:"file" help:"file the logs are written to, rotated, as well as stderr"`
+	LogFiles          map[string]string `arg:"env:MAILING_LIST_LOG_FILES" yaml:"files" toml:"files" help:"files of the logs of components, e.g. json=/var/log/mailinglist/access.log, - being stderr alone; the components are json, grpc, admin, diagnostics, scheduler, outbox, webhook-delivery and email-cache"`
+	LogMaxSize        int               `arg:"env:MAILING_LIST_LOG_MAX_SIZE" yaml:"max_size" toml:"max_size" help:"size in megabytes past which a log file is rotated, defaults to 100"`
+	LogRotateInterval time.Duration     `arg:"env:MAILING_LIST_LOG_ROTATE_INTERVAL" yaml:"rotate_interval" toml:"rotate_interval" help:"interval at which the log files are rotated too, e.g. 24h, never when 0"`
+	LogMaxAge         time.Duration     `arg:"env:MAILING_LIST_LOG_MAX_AGE" yaml:"max_age" toml:"max_age" help:"how long the rotated log files are kept, in whole days, forever when 0"`
+	LogMaxBackups     int               `arg:"env:MAILING_LIST_LOG_MAX_BACKUPS" yaml:"max_backups" toml:"max_backups" help:"how many rotated files are kept for each log file, all when 0"`
+	LogCompress       bool              `arg:"env:MAILING_LIST_LOG_COMPRESS" yaml:"compress" toml:"compress" help:"gzip the rotated log files"`
+}
+
+// Jobs sets the recurring jobs of the server.
+type Jobs struct {
+	PendingRetention  time.Duration            `arg:"env:MAILING_LIST_PENDING_RETENTION" yaml:"pending_retention" toml:"pending_retention" help:"how long unconfirmed emails are kept before moving to the trash, 0 keeps them"`
+	OutboxRetention   time.Duration            `arg:"env:MAILING_LIST_OUTBOX_RETENTION" yaml:"outbox_retention" toml:"outbox_retention" help:"how long the events no server published are kept in the outbox, defaults to 168h"`
+	BackupDir         string                   `arg:"env:MAILING_LIST_BACKUP_DIR" yaml:"backup_dir" toml:"backup_dir" help:"directory of the database snapshots, none are taken when empty"`
+	BackupKeep        int                      `arg:"env:MAILING_LIST_BACKUP_KEEP" yaml:"backup_keep" toml:"backup_keep" help:"how many snapshots are kept, defaults to 7"`
+	MaintenanceWindow string                   `arg:"env:MAILING_LIST_MAINTENANCE_WINDOW" yaml:"maintenance_window" toml:"maintenance_window" help:"daily HH:MM-HH:MM, in the time zone of the server, when the maintenance job analyzes and vacuums the database, at any time when empty"`
+	JobIntervals      map[string]time.Duration `arg:"env:MAILING_LIST_JOB_INTERVALS" yaml:"intervals" toml:"intervals" help:"intervals of the jobs, e.g. backup=12h"`
+	DisabledJobs      []string                 `arg:"env:MAILING_LIST_DISABLED_JOBS" yaml:"disabled" toml:"disabled" help:"jobs not run on schedule, which can be enabled at /admin/jobs"`
+	LeaderElection    bool                     `arg:"env:MAILING_LIST_LEADER_ELECTION" yaml:"leader_election" toml:"leader_election" help:"run the jobs on schedule on a single one of the servers sharing the database"`
+	LeaseTTL          time.Duration            `arg:"env:MAILING_LIST_LEASE_TTL" yaml:"lease_ttl" toml:"lease_ttl" help:"how long the leader keeps running the jobs without renewing its lease, defaults to 30s"`
+}
+
+// Redis sets the store shared by the servers behind a load balancer.
+type Redis struct {
+	RedisAddr      string        `arg:"env:MAILING_LIST_REDIS_ADDR" yaml:"addr" toml:"addr" help:"host:port of a Redis caching the emails and sharing the idempotency keys and the rate limits"`
+	RedisPassword  string        `arg:"env:MAILING_LIST_REDIS_PASSWORD" yaml:"password" toml:"password" secret:"true"`
+	RedisDB        int           `arg:"env:MAILING_LIST_REDIS_DB" yaml:"db" toml:"db"`
+	RedisPrefix    string        `arg:"env:MAILING_LIST_REDIS_PREFIX" yaml:"prefix" toml:"prefix" help:"prefix of the keys, defaults to mailinglist:"`
+	EmailCacheTTL  time.Duration `arg:"env:MAILING_LIST_EMAIL_CACHE_TTL" yaml:"email_cache_ttl" toml:"email_cache_ttl" help:"how long an email stays in the cache at most, defaults to 5m"`
+	IdempotencyTTL time.Duration `arg:"env:MAILING_LIST_IDEMPOTENCY_TTL" yaml:"idempotency_ttl" toml:"idempotency_ttl" help:"how long the responses to the idempotency keys are kept, defaults to 24h"`
+}
+
+// S3 sets the bucket the backup job uploads the database snapshots to, on S3
+// or a compatible object storage.
+type S3 struct {
+	S3Bucket     string        `arg:"env:MAILING_LIST_S3_BUCKET" yaml:"bucket" toml:"bucket" help:"bucket the database snapshots are uploaded to, none are when empty"`
+	S3Prefix     string        `arg:"env:MAILING_LIST_S3_PREFIX" yaml:"prefix" toml:"prefix" help:"prefix of the uploads, e.g. backups/"`
+	S3Endpoint   string        `arg:"env:MAILING_LIST_S3_ENDPOINT" yaml:"endpoint" toml:"endpoint" help:"host[:port] of the object storage, defaults to s3.amazonaws.com"`
+	S3Region     string        `arg:"env:MAILING_LIST_S3_REGION" yaml:"region" toml:"region"`
+	S3Insecure   bool          `arg:"env:MAILING_LIST_S3_INSECURE" yaml:"insecure" toml:"insecure" help:"use http rather than https"`
+	S3AccessKey  string        `arg:"env:MAILING_LIST_S3_ACCESS_KEY" yaml:"access_key" toml:"access_key" help:"taken from the AWS environment, credentials file or instance role when empty"`
+	S3SecretKey  string        `arg:"env:MAILING_LIST_S3_SECRET_KEY" yaml:"secret_key" toml:"secret_key" secret:"true"`
+	S3Recipients []string      `arg:"env:MAILING_LIST_S3_RECIPIENTS" yaml:"recipients" toml:"recipients" help:"age public keys the uploads are encrypted to"`
+	S3Keep       int           `arg:"env:MAILING_LIST_S3_KEEP" yaml:"keep" toml:"keep" help:"how many of the newest uploads are never removed, defaults to 7"`
+	S3Retention  time.Duration `arg:"env:MAILING_LIST_S3_RETENTION" yaml:"retention" toml:"retention" help:"how long the older uploads are kept, defaults to 720h"`
+}
+
+// Kafka sets the topic the email events are published to.
+type Kafka struct {
+	KafkaBrokers []string `arg:"env:MAILING_LIST_KAFKA_BROKERS" yaml:"brokers" toml:"brokers" help:"host:port of the Kafka brokers the email events are published to, none are when empty"`
+	KafkaTopic   string   `arg:"env:MAILING_LIST_KAFKA_TOPIC" yaml:"topic" toml:"topic" help:"defaults to mailinglist.email-events"`
+}
+
+// Nats sets the subjects the email events are published to, instead of
+// Kafka.
+type Nats struct {
+	NatsURL     string `arg:"env:MAILING_LIST_NATS_URL" yaml:"url" toml:"url" help:"URL of the NATS server the email events are published to, e.g. nats://localhost:4222"`
+	NatsSubject string `arg:"env:MAILING_LIST_NATS_SUBJECT" yaml:"subject" toml:"subject" help:"prefix of the subjects, followed by the kind of the event, defaults to mailinglist.email-events"`
+	NatsStream  string `arg:"env:MAILING_LIST_NATS_STREAM" yaml:"stream" toml:"stream" help:"JetStream stream keeping the events, created when missing, none when empty"`
+}
+
+// Webhooks sets the email providers whose delivery events are received, and
+// the endpoints the email events are delivered to.
+type Webhooks struct {
+	SesTopicArn         string `arg:"env:MAILING_LIST_SES_TOPIC_ARN" yaml:"ses_topic_arn" toml:"ses_topic_arn"`
+	SendGridWebhookKey  string `arg:"env:MAILING_LIST_SENDGRID_WEBHOOK_KEY" yaml:"sendgrid_key" toml:"sendgrid_key"`
+	MailgunWebhookKey   string `arg:"env:MAILING_LIST_MAILGUN_WEBHOOK_KEY" yaml:"mailgun_key" toml:"mailgun_key" secret:"true"`
+	PostmarkWebhookAuth string `arg:"env:MAILING_LIST_POSTMARK_WEBHOOK_AUTH" yaml:"postmark_auth" toml:"postmark_auth" secret:"true" help:"user:password expected in the basic auth of Postmark webhooks"`
+
+	WebhookEndpoints   map[string]string `arg:"env:MAILING_LIST_WEBHOOK_ENDPOINTS" yaml:"endpoints" toml:"endpoints" help:"URLs the email events are posted to, by name, e.g. crm=https://crm.example.com/hooks"`
+	WebhookSecret      string            `arg:"env:MAILING_LIST_WEBHOOK_SECRET" yaml:"signing_secret" toml:"signing_secret" secret:"true" help:"secret of the X-Mailinglist-Signature of the deliveries, unsigned when empty"`
+	WebhookWorkers     int               `arg:"env:MAILING_LIST_WEBHOOK_WORKERS" yaml:"workers" toml:"workers" help:"deliveries sent at once, defaults to 4"`
+	WebhookMaxAttempts int               `arg:"env:MAILING_LIST_WEBHOOK_MAX_ATTEMPTS" yaml:"max_attempts" toml:"max_attempts" help:"attempts before a delivery is dead, defaults to 10"`
+}
+
+// Mail sets the provider the emails are sent through.
+type Mail struct {
+	MailProvider string `arg:"env:MAILING_LIST_MAIL_PROVIDER" yaml:"provider" toml:"provider" help:"smtp, ses, sendgrid or mailgun, no emails are sent when empty"`
+	MailFrom     string `arg:"env:MAILING_LIST_MAIL_FROM" yaml:"from" toml:"from" help:"sender of the emails, e.g. News <news@example.com>"`
+	MailEndpoint string `arg:"env:MAILING_LIST_MAIL_ENDPOINT" yaml:"endpoint" toml:"endpoint" help:"host:port of the SMTP server, or base URL replacing the one of the API, e.g. https://api.eu.mailgun.net"`
+	MailUsername string `arg:"env:MAILING_LIST_MAIL_USERNAME" yaml:"username" toml:"username" help:"SMTP username or SES access key id"`
+	MailPassword string `arg:"env:MAILING_LIST_MAIL_PASSWORD" yaml:"password" toml:"password" secret:"true" help:"SMTP password, SES secret access key, or SendGrid or Mailgun API key"`
+	MailRegion   string `arg:"env:MAILING_LIST_MAIL_REGION" yaml:"region" toml:"region" help:"SES region, e.g. eu-west-1"`
+	MailDomain   string `arg:"env:MAILING_LIST_MAIL_DOMAIN" yaml:"domain" toml:"domain" help:"Mailgun sending domain"`
+	// MailPublicURL is the base of the links sent to the subscribers.
+	MailPublicURL string `arg:"env:MAILING_LIST_MAIL_PUBLIC_URL" yaml:"public_url" toml:"public_url" help:"URL of the JSON server for the subscribers, e.g. https://lists.example.com, the base of the unsubscribe and confirmation links"`
+
+	// MailConfirm sends the emails created unconfirmed their confirmation
+	// link, signed with MailLinkSecret.
+	MailConfirm         bool          `arg:"env:MAILING_LIST_MAIL_CONFIRM" yaml:"confirm" toml:"confirm" help:"send a confirmation email to the new emails, needs public_url and link_secret"`
+	MailConfirmTemplate string        `arg:"env:MAILING_LIST_MAIL_CONFIRM_TEMPLATE" yaml:"confirm_template" toml:"confirm_template" help:"name of the stored template of the confirmation emails, a built-in one when empty"`
+	MailConfirmExpiry   time.Duration `arg:"env:MAILING_LIST_MAIL_CONFIRM_EXPIRY" yaml:"confirm_expiry" toml:"confirm_expiry" help:"how long the signed confirmation links are valid, defaults to 72h"`
+	MailConfirmCooldown time.Duration `arg:"env:MAILING_LIST_MAIL_CONFIRM_COOLDOWN" yaml:"confirm_cooldown" toml:"confirm_cooldown" help:"how long after a confirmation another one can be sent to the same email, defaults to 5m"`
+	MailLinkSecret      string        `arg:"env:MAILING_LIST_MAIL_LINK_SECRET" yaml:"link_secret" toml:"link_secret" secret:"true" help:"secret signing the confirmation and unsubscribe links, the confirmation ones then expiring, unsigned when empty"`
+
+	// MailUnsubscribeMailto is the mailto: of the List-Unsubscribe header,
+	// next to the link of MailPublicURL.
+	MailUnsubscribeMailto string `arg:"env:MAILING_LIST_MAIL_UNSUBSCRIBE_MAILTO" yaml:"unsubscribe_mailto" toml:"unsubscribe_mailto" help:"address of the mailto: List-Unsubscribe, e.g. unsubscribe@example.com, whose emails are read apart, none when empty"`
+
+	// MailTrackClicks sends the links of the campaigns through the click
+	// redirect of MailPublicURL, signed with MailLinkSecret.
+	MailTrackClicks bool `arg:"env:MAILING_LIST_MAIL_TRACK_CLICKS" yaml:"track_clicks" toml:"track_clicks" help:"record the clicks on the links of the campaigns, going through a redirect, needs public_url and link_secret"`
+
+	// MailTrackOpens adds to the HTML bodies of the campaigns a tracking
+	// pixel of MailPublicURL, signed with MailLinkSecret.
+	MailTrackOpens bool `arg:"env:MAILING_LIST_MAIL_TRACK_OPENS" yaml:"track_opens" toml:"track_opens" help:"record the opens of the campaigns, loading a tracking pixel, needs public_url and link_secret"`
+
+	MailWorkers     int `arg:"env:MAILING_LIST_MAIL_WORKERS" yaml:"workers" toml:"workers" help:"emails sent at once, defaults to 4"`
+	MailMaxAttempts int `arg:"env:MAILING_LIST_MAIL_MAX_ATTEMPTS" yaml:"max_attempts" toml:"max_attempts" help:"attempts before an email fails, defaults to 8"`
+
+	// MailDomainLimits throttles the emails to each recipient domain.
+	MailDomainLimits map[string]string `arg:"env:MAILING_LIST_MAIL_DOMAIN_LIMITS" yaml:"domain_limits" toml:"domain_limits" help:"limits of the emails to each recipient domain, as concurrency/per_minute, 0 for no limit, * for the other domains, e.g. gmail.com=4/600"`
+}
+
+// Bounces sets the mailbox the bounces of the SMTP messages are returned
+// to, read by the bounces job.
+type Bounces struct {
+	BounceVERP          string `arg:"env:MAILING_LIST_BOUNCE_VERP" yaml:"verp" toml:"verp" help:"address of the bounces mailbox, e.g. bounces@example.com, the envelope sender of the SMTP emails being bounces+jane=example.com+{signature}@example.com signed with mail.link_secret, none when empty"`
+	BounceIMAP          string `arg:"env:MAILING_LIST_BOUNCE_IMAP" yaml:"imap" toml:"imap" help:"host:port of the IMAP server of the bounces mailbox, over TLS on port 993, else with STARTTLS, not read when empty"`
+	BounceIMAPPlaintext bool   `arg:"env:MAILING_LIST_BOUNCE_IMAP_PLAINTEXT" yaml:"imap_plaintext" toml:"imap_plaintext" help:"log in to an IMAP server offering no STARTTLS, the password being sent in clear"`
+	BounceIMAPUsername  string `arg:"env:MAILING_LIST_BOUNCE_IMAP_USERNAME" yaml:"imap_username" toml:"imap_username"`
+	BounceIMAPPassword  string `arg:"env:MAILING_LIST_BOUNCE_IMAP_PASSWORD" yaml:"imap_password" toml:"imap_password" secret:"true"`
+	BounceIMAPMailbox   string `arg:"env:MAILING_LIST_BOUNCE_IMAP_MAILBOX" yaml:"imap_mailbox" toml:"imap_mailbox" help:"defaults to INBOX"`
+}
+
+// Crash sets the reporting of the panics.
+type Crash struct {
+	CrashDSN         string `arg:"env:MAILING_LIST_CRASH_DSN" yaml:"dsn" toml:"dsn" secret:"true" help:"Sentry DSN the panics are reported to, https://<key>@<host>/<project>"`
+	CrashEnvironment string `arg:"env:MAILING_LIST_CRASH_ENVIRONMENT" yaml:"environment" toml:"environment" help:"environment of the crash reports, e.g. production"`
+	CrashFile        string `arg:"env:MAILING_LIST_CRASH_FILE" yaml:"file" toml:"file" help:"file the fatal errors are written to, reported on the next start"`
+}
+
+// MigrateCommand migrates the schema of the database, showing its status
+// without a subcommand.
+type MigrateCommand struct {
+	Status *struct{}         `arg:"subcommand:status" help:"show the version of the schema and the migrations"`
+	Up     *struct{}         `arg:"subcommand:up" help:"apply the pending migrations"`
+	Down   *struct{}         `arg:"subcommand:down" help:"revert the last migration applied"`
+	To     *MigrateToCommand `arg:"subcommand:to" help:"migrate up or down to a version"`
+}
+
+type MigrateToCommand struct {
+	Version int `arg:"positional,required"`
+}
+
+// AnonymizeCommand copies the database for the test environments.
+type AnonymizeCommand struct {
+	Out string `arg:"--out,required" help:"file of the copy, which must not exist"`
+	Key string `arg:"--key,env:MAILING_LIST_ANONYMIZE_KEY" help:"secret the fake addresses are derived from, the same across copies, random when empty"`
+}
+
+// Config are the settings of the server. The sections are embedded so that
+// their fields are flat flags, and nested in the files.
+type Config struct {
+	File string `arg:"--config,env:MAILING_LIST_CONFIG" yaml:"-" toml:"-" help:"YAML or TOML file of the settings, overridden by the environment and the flags"`
+	// SystemdUnit is not a setting but a command.
+	SystemdUnit string `arg:"--systemd-unit" yaml:"-" toml:"-" help:"print the systemd service or socket unit running the server with these settings, and exit"`
+	// Migrate is not a setting but a command either.
+	Migrate   *MigrateCommand   `arg:"subcommand:migrate" yaml:"-" toml:"-" help:"show or change the version of the schema of the database, rather than serving"`
+	Anonymize *AnonymizeCommand `arg:"subcommand:anonymize" yaml:"-" toml:"-" help:"copy the database with fake addresses, for the test environments"`
+
+	Database `yaml:"database" toml:"database"`
+	Bind     `yaml:"bind" toml:"bind"`
+	Auth     `yaml:"auth" toml:"auth"`
+	Limits   `yaml:"limits" toml:"limits"`
+	Timeouts `yaml:"timeouts" toml:"timeouts"`
+	TLS      `yaml:"tls" toml:"tls"`
+	Sync     `yaml:"sync" toml:"sync"`
+	Tracing  `yaml:"tracing" toml:"tracing"`
+	Logging  `yaml:"logging" toml:"logging"`
+	Jobs     `yaml:"jobs" toml:"jobs"`
+	S3       `yaml:"s3" toml:"s3"`
+	Redis    `yaml:"redis" toml:"redis"`
+	Kafka    `yaml:"kafka" toml:"kafka"`
+	Nats     `yaml:"nats" toml:"nats"`
+	Webhooks `yaml:"webhooks" toml:"webhooks"`
+	Mail     `yaml:"mail" toml:"mail"`
+	Bounces  `yaml:"bounces" toml:"bounces"`
+	Crash    `yaml:"crash" toml:"crash"`
+
+	ReadOnly    bool            `arg:"env:MAILING_LIST_READ_ONLY" yaml:"read_only" toml:"read_only"`
+	Flags       map[string]bool `arg:"env:MAILING_LIST_FLAGS" yaml:"flags" toml:"flags" help:"optional behaviors turned on or off, among signup, imports, webhooks and read_only, e.g. signup=false"`
+	DebugBodies bool            `arg:"env:MAILING_LIST_DEBUG_BODIES" yaml:"debug_bodies" toml:"debug_bodies" help:"log redacted request and response bodies"`
+}
+
+// MustLoad reads the settings and fills in the defaults. It exits on
+// invalid flags and on --help like arg.MustParse. The file is given by
+// --config or MAILING_LIST_CONFIG, so the flags are parsed first, then
+// again over the settings of the file to override them.
+func MustLoad() (*Config, error) {
+	return load(func(c *Config) error {
+		arg.MustParse(c)
+		return nil
+	})
+}
+
+// Reload reads the settings again, from the file, the environment and the
+// flags of the process, and validates them.
+func Reload() (*Config, error) {
+	c, err := load(func(c *Config) error {
+		parser, err := arg.NewParser(arg.Config{}, c)
+		if err != nil {
+			return err
+		}
+		return parser.Parse(os.Args[1:])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, c.Validate()
+}
+
+func load(parse func(c *Config) error) (*Config, error) {
+	c := &Config{}
+	if err := parse(c); err != nil {
+		return nil, err
+	}
+	if c.File != "" {
+		if err := c.readFile(c.File); err != nil {
+			return nil, err
+		}
+		if err := parse(c); err != nil {
+			return nil, err
+		}
+	}
+	c.setDefaults()
+	return c, nil
+}
+
+// readFile reads the settings of a TOML file, or of a YAML file for the
+// other extensions. The unknown settings are rejected, so that typos are
+// not silently ignored.
+func (c *Config) readFile(path string) error {
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		meta, err := toml.DecodeFile(path, c)
+		if err != nil {
+			return fmt.Errorf("reading %v : %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("reading %v : unknown setting %v", path, undecoded[0])
+		}
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading %v : %w", path, err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("reading %v : %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) setDefaults() {
+	if c.DbPath == "" {
+		c.DbPath = "list.db"
+	}
+	if c.BindJson == "" {
+		c.BindJson = ":9091"
+	}
+	if c.BindGrpc == "" {
+		c.BindGrpc = ":9092"
+	}
+	if c.GrpcMaxRecvMsgSize == 0 {
+		c.GrpcMaxRecvMsgSize = 16 << 20
+	}
+	if c.GrpcMaxSendMsgSize == 0 {
+		c.GrpcMaxSendMsgSize = 16 << 20
+	}
+	if c.GrpcKeepaliveTime == 0 {
+		c.GrpcKeepaliveTime = time.Minute
+	}
+	if c.GrpcKeepaliveTimeout == 0 {
+		c.GrpcKeepaliveTimeout = 20 * time.Second
+	}
+	if c.GrpcKeepaliveMinTime == 0 {
+		c.GrpcKeepaliveMinTime = 10 * time.Second
+	}
+	if c.GrpcShutdownTimeout == 0 {
+		c.GrpcShutdownTimeout = 30 * time.Second
+	}
+	if c.RateLimitBurst == 0 {
+		c.RateLimitBurst = int(math.Ceil(c.RateLimit))
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = "info"
+	}
+	if c.LogFormat == "" {
+		c.LogFormat = logging.FormatText
+	}
+	if c.LogMaxSize == 0 {
+		c.LogMaxSize = 100
+	}
+	if c.LeaseTTL == 0 {
+		c.LeaseTTL = 30 * time.Second
+	}
+	if c.BackupKeep == 0 {
+		c.BackupKeep = 7
+	}
+	if c.S3Endpoint == "" {
+		c.S3Endpoint = "s3.amazonaws.com"
+	}
+	if c.S3Keep == 0 {
+		c.S3Keep = 7
+	}
+	if c.S3Retention == 0 {
+		c.S3Retention = 30 * 24 * time.Hour
+	}
+	if c.RedisPrefix == "" {
+		c.RedisPrefix = "mailinglist:"
+	}
+	if c.EmailCacheTTL == 0 {
+		c.EmailCacheTTL = 5 * time.Minute
+	}
+	if c.IdempotencyTTL == 0 {
+		c.IdempotencyTTL = 24 * time.Hour
+	}
+	if c.KafkaTopic == "" {
+		c.KafkaTopic = "mailinglist.email-events"
+	}
+	if c.NatsSubject == "" {
+		c.NatsSubject = "mailinglist.email-events"
+	}
+	if c.WebhookWorkers == 0 {
+		c.WebhookWorkers = 4
+	}
+	if c.WebhookMaxAttempts == 0 {
+		c.WebhookMaxAttempts = 10
+	}
+	if c.MailWorkers == 0 {
+		c.MailWorkers = 4
+	}
+	if c.MailMaxAttempts == 0 {
+		c.MailMaxAttempts = 8
+	}
+	if c.MailConfirmExpiry == 0 {
+		c.MailConfirmExpiry = 72 * time.Hour
+	}
+	if c.MailConfirmCooldown == 0 {
+		c.MailConfirmCooldown = 5 * time.Minute
+	}
+	if c.TrashRetention == 0 {
+		c.TrashRetention = 30 * 24 * time.Hour
+	}
+	if c.OutboxRetention == 0 {
+		c.OutboxRetention = 7 * 24 * time.Hour
+	}
+}
+
+// Validate checks the settings once the defaults are set, returning all
+// the problems found at once.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, a ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, a...))
+		}
+	}
+	checkAddr := func(name, addr string) {
+		_, _, err := net.SplitHostPort(addr)
+		check(err == nil, "%v %q is not a host:port address", name, addr)
+	}
+	checkFile := func(name, path string) {
+		if path != "" {
+			_, err := os.Stat(path)
+			check(err == nil, "%v %q cannot be read: %v", name, path, err)
+		}
+	}
+
+	check(c.SystemdUnit == "" || c.SystemdUnit == "service" || c.SystemdUnit == "socket", "--systemd-unit must be service or socket")
+	check(!c.DisableJson || !c.DisableGrpc, "bind.disable_json and bind.disable_grpc leave nothing to serve")
+	if !c.DisableJson {
+		checkAddr("bind.json", c.BindJson)
+	}
+	if !c.DisableGrpc {
+		checkAddr("bind.grpc", c.BindGrpc)
+	}
+	check(c.DisableJson || c.DisableGrpc || c.BindJson != c.BindGrpc, "bind.json and bind.grpc are both %q", c.BindJson)
+	if c.BindAdmin != "" {
+		checkAddr("bind.admin", c.BindAdmin)
+		check(!c.DisableJson, "bind.admin needs the JSON server, bind.disable_json is set")
+		check(c.BindAdmin != c.BindJson && c.BindAdmin != c.BindGrpc, "bind.admin %q is also bind.json or bind.grpc", c.BindAdmin)
+	}
+	if c.BindDebug != "" {
+		// The diagnostics have no authentication.
+		host, _, err := net.SplitHostPort(c.BindDebug)
+		ip := net.ParseIP(host)
+		check(err == nil && (host == "localhost" || ip != nil && ip.IsLoopback()),
+			"bind.debug %q must be a loopback host:port address", c.BindDebug)
+	}
+
+	check(c.TrashRetention > 0, "database.trash_retention must be positive")
+	check(c.RateLimit >= 0, "limits.rate must not be negative")
+	check(c.RateLimitBurst >= 0, "limits.burst must not be negative")
+	check(c.RateLimit <= 0 || c.RateLimitBurst > 0, "limits.burst must be positive with a rate limit")
+	_, err := c.RateLimitPolicy()
+	check(err == nil, "limits.rules: %v", err)
+	_, err = ratelimit.ParseProxies(c.TrustedProxies)
+	check(err == nil, "limits.trusted_proxies: %v", err)
+	check(c.GrpcMaxRecvMsgSize > 0, "limits.grpc_max_recv_msg_size must be positive")
+	check(c.GrpcMaxSendMsgSize > 0, "limits.grpc_max_send_msg_size must be positive")
+
+	check(c.GrpcKeepaliveTime > 0, "timeouts.grpc_keepalive_time must be positive")
+	check(c.GrpcKeepaliveTimeout > 0, "timeouts.grpc_keepalive_timeout must be positive")
+	check(c.GrpcKeepaliveMinTime > 0, "timeouts.grpc_keepalive_min_time must be positive")
+	check(c.GrpcShutdownTimeout > 0, "timeouts.grpc_shutdown_timeout must be positive")
+	check(c.LameDuckPeriod >= 0, "timeouts.lame_duck_period must not be negative")
+
+	check((c.GrpcTLSCert == "") == (c.GrpcTLSKey == ""), "tls.cert and tls.key go together")
+	check(c.GrpcTLSClientCA == "" || c.GrpcTLSCert != "", "tls.client_ca needs tls.cert")
+	checkFile("tls.cert", c.GrpcTLSCert)
+	checkFile("tls.key", c.GrpcTLSKey)
+	checkFile("tls.client_ca", c.GrpcTLSClientCA)
+
+	if c.SyncPeer != "" {
+		checkAddr("sync.peer", c.SyncPeer)
+	}
+	check(c.SyncPeer != "" || (c.SyncPeerApiKey == "" && c.SyncPeerCaCert == ""), "sync.peer_api_key and sync.peer_ca_cert need sync.peer")
+	checkFile("sync.peer_ca_cert", c.SyncPeerCaCert)
+
+	level, err := logging.ParseLevel(c.LogLevel)
+	check(err == nil, "logging.level: %v", err)
+	_, err = logging.New(io.Discard, level, c.LogFormat)
+	check(err == nil, "logging.format: %v", err)
+	check(c.LogMaxSize > 0, "logging.max_size must be positive")
+	check(c.LogRotateInterval == 0 || c.LogRotateInterval >= time.Minute, "logging.rotate_interval must be at least 1m")
+	check(c.LogMaxAge >= 0, "logging.max_age must not be negative")
+	check(c.LogMaxBackups >= 0, "logging.max_backups must not be negative")
+	for component, path := range c.LogFiles {
+		check(component != "" && path != "", "logging.files: %q=%q needs a component and a file", component, path)
+	}
+
+	check(c.PendingRetention >= 0, "jobs.pending_retention must not be negative")
+	check(c.OutboxRetention > 0, "jobs.outbox_retention must be positive")
+	check(c.BackupKeep > 0, "jobs.backup_keep must be positive")
+	check(c.LeaseTTL >= 3*time.Second, "jobs.lease_ttl must be at least 3s")
+	if c.BackupDir != "" {
+		info, err := os.Stat(c.BackupDir)
+		check(err == nil && info.IsDir(), "jobs.backup_dir %q is not a directory", c.BackupDir)
+	}
+	_, err = scheduler.ParseWindow(c.MaintenanceWindow)
+	check(err == nil, "jobs.maintenance_window: %v", err)
+	for name, interval := range c.JobIntervals {
+		check(interval > 0, "jobs.intervals of %v must be positive", name)
+	}
+
+	check(!strings.Contains(c.S3Endpoint, "/"), "s3.endpoint %q must be a host[:port], without scheme", c.S3Endpoint)
+	check(c.S3Bucket == "" || len(c.S3Recipients) > 0, "s3.bucket needs s3.recipients to encrypt the uploads to")
+	check(c.S3Bucket != "" || c.S3Prefix == "" && len(c.S3Recipients) == 0, "s3.prefix and s3.recipients need s3.bucket")
+	_, err = s3backup.ParseRecipients(c.S3Recipients)
+	check(err == nil, "s3.recipients: %v", err)
+	check(c.S3Keep > 0, "s3.keep must be positive")
+	check(c.S3Retention > 0, "s3.retention must be positive")
+
+	if c.CrashDSN != "" {
+		_, _, err := crash.ParseDSN(c.CrashDSN)
+		check(err == nil, "crash.dsn: %v", err)
+	}
+	check(c.CrashEnvironment == "" || c.CrashDSN != "", "crash.environment needs crash.dsn")
+
+	if c.RedisAddr != "" {
+		checkAddr("redis.addr", c.RedisAddr)
+	}
+	check(c.RedisDB >= 0, "redis.db must not be negative")
+	check(c.EmailCacheTTL > 0, "redis.email_cache_ttl must be positive")
+	check(c.IdempotencyTTL > 0, "redis.idempotency_ttl must be positive")
+
+	for _, broker := range c.KafkaBrokers {
+		checkAddr("kafka.brokers", broker)
+	}
+	check(len(c.KafkaBrokers) == 0 || c.NatsURL == "", "kafka.brokers and nats.url both set, the events go to a single one")
+	check(c.NatsURL != "" || c.NatsStream == "", "nats.stream needs nats.url")
+	check(!strings.ContainsAny(c.NatsSubject, " *>") && !strings.HasSuffix(c.NatsSubject, "."), "nats.subject %q is not a subject prefix", c.NatsSubject)
+
+	check(c.PostmarkWebhookAuth == "" || strings.Contains(c.PostmarkWebhookAuth, ":"), "webhooks.postmark_auth must be user:password")
+	for name, endpoint := range c.WebhookEndpoints {
+		u, err := url.Parse(endpoint)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"webhooks.endpoints %v %q is not an http or https URL", name, endpoint)
+	}
+	check(c.WebhookWorkers > 0, "webhooks.workers must be positive")
+	check(c.WebhookMaxAttempts > 0, "webhooks.max_attempts must be positive")
+
+	if c.MailProvider != "" {
+		_, err := mailer.New(c.MailOptions())
+		check(err == nil, "mail: %v", err)
+	}
+	check(c.MailProvider != "" || c.MailFrom == "" && c.MailEndpoint == "", "mail.from and mail.endpoint need mail.provider")
+	if c.MailPublicURL != "" {
+		u, err := url.Parse(c.MailPublicURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.RawQuery == "",
+			"mail.public_url %q is not an http or https URL", c.MailPublicURL)
+	}
+	check(!c.MailConfirm || c.MailProvider != "" && c.MailPublicURL != "" && c.MailLinkSecret != "",
+		"mail.confirm needs mail.provider, mail.public_url and mail.link_secret")
+	check(c.MailConfirm || c.MailConfirmTemplate == "", "mail.confirm_template needs mail.confirm")
+	check(!c.MailTrackClicks || c.MailPublicURL != "" && c.MailLinkSecret != "",
+		"mail.track_clicks needs mail.public_url and mail.link_secret")
+	check(!c.MailTrackOpens || c.MailPublicURL != "" && c.MailLinkSecret != "",
+		"mail.track_opens needs mail.public_url and mail.link_secret")
+	if c.MailUnsubscribeMailto != "" {
+		addr, err := mail.ParseAddress(c.MailUnsubscribeMailto)
+		check(err == nil && addr.Name == "" && addr.Address == c.MailUnsubscribeMailto,
+			"mail.unsubscribe_mailto %q is not a bare address", c.MailUnsubscribeMailto)
+	}
+	check(c.MailConfirmExpiry > 0, "mail.confirm_expiry must be positive")
+	check(c.MailConfirmCooldown > 0, "mail.confirm_cooldown must be positive")
+	if c.BounceVERP != "" {
+		addr, err := mail.ParseAddress(c.BounceVERP)
+		check(err == nil && addr.Name == "" && addr.Address == c.BounceVERP && !strings.Contains(c.BounceVERP, "+"),
+			"bounces.verp %q is not a bare address without +", c.BounceVERP)
+		check(c.MailProvider == "smtp", "bounces.verp needs the smtp mail.provider")
+		check(c.MailLinkSecret != "", "bounces.verp needs mail.link_secret, signing the VERP addresses")
+	}
+	if c.BounceIMAP != "" {
+		_, _, err := net.SplitHostPort(c.BounceIMAP)
+		check(err == nil, "bounces.imap: %v", err)
+		check(c.BounceIMAPUsername != "" && c.BounceIMAPPassword != "", "bounces.imap needs bounces.imap_username and bounces.imap_password")
+	}
+	check(c.MailWorkers > 0, "mail.workers must be positive")
+	check(c.MailMaxAttempts > 0, "mail.max_attempts must be positive")
+	_, err = c.DomainLimits()
+	check(err == nil, "mail.domain_limits: %v", err)
+
+	err = flags.Validate(c.Flags)
+	check(err == nil, "flags: %v", err)
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// defaultRateLimit names the rule of limits.rate.
+const defaultRateLimit = "default"
+
+// RateLimitPolicy returns the rate limiting rules, limits.rate for each
+// caller on all the endpoints, then limits.rules by name.
+func (c *Config) RateLimitPolicy() ([]ratelimit.Rule, error) {
+	var rules []ratelimit.Rule
+	if c.RateLimit > 0 {
+		rules = append(rules, ratelimit.Rule{Name: defaultRateLimit, Scope: ratelimit.ScopeCaller, Rate: c.RateLimit, Burst: c.RateLimitBurst})
+	}
+	names := make([]string, 0, len(c.RateLimitRules))
+	for name := range c.RateLimitRules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rule, err := ratelimit.ParseRule(name, c.RateLimitRules[name])
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, ratelimit.ValidateRules(rules)
+}
+
+// DomainLimits returns the limits of the emails to the recipient domains,
+// by lowercased domain.
+func (c *Config) DomainLimits() (map[string]sendqueue.DomainLimit, error) {
+	limits := make(map[string]sendqueue.DomainLimit, len(c.MailDomainLimits))
+	for domain, spec := range c.MailDomainLimits {
+		limit, err := sendqueue.ParseDomainLimit(domain, spec)
+		if err != nil {
+			return nil, err
+		}
+		limits[strings.ToLower(domain)] = limit
+	}
+	return limits, nil
+}
+
+// MailOptions returns the settings of the mail provider.
+func (c *Config) MailOptions() mailer.Options {
+	return mailer.Options{
+		Provider: c.MailProvider,
+		From:     c.MailFrom,
+		Endpoint: c.MailEndpoint,
+		Username: c.MailUsername,
+		Password: c.MailPassword,
+		Region:   c.MailRegion,
+		Domain:   c.MailDomain,
+	}
+}
+
+// FlagValues returns the values of all the flags, flags over their
+// defaults, read_only turning the read only flag on.
+func (c *Config) FlagValues() map[string]bool {
+	values := maps.Clone(flags.Defaults)
+	maps.Copy(values, c.Flags)
+	if c.ReadOnly {
+		values[flags.ReadOnly] = true
+	}
+	return values
+}
+
+// Lines returns the settings as "section.name: value" lines, in the order
+// of the fields, with the secrets redacted, e.g. to log them at startup.
+func (c *Config) Lines() []string {
+	var lines []string
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name := field.Tag.Get("yaml")
+			if name == "-" {
+				continue
+			}
+			if field.Anonymous {
+				walk(prefix+name+".", v.Field(i))
+				continue
+			}
+
+			value := fmt.Sprint(v.Field(i).Interface())
+			if field.Tag.Get("secret") == "true" && value != "" {
+				value = "<redacted>"
+			}
+			lines = append(lines, fmt.Sprintf("%v%v: %v", prefix, name, value))
+		}
+	}
+	walk("", reflect.ValueOf(c).Elem())
+	return lines
+}
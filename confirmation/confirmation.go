@@ -0,0 +1,121 @@
+// Package confirmation sends the double opt-in emails: the emails created
+// unconfirmed are sent their confirmation link through the send queue,
+// rendered from a stored template or from the built-in one.
+package confirmation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"mailinglist/personalize"
+	"mailinglist/templates"
+	"time"
+)
+
+// ErrNotPending is returned for the emails which are confirmed, or
+// unsubscribed, and so are not sent a confirmation.
+var ErrNotPending = errors.New("email is not waiting for its confirmation")
+
+// ErrTooSoon is returned for the emails sent a confirmation less than the
+// cooldown ago.
+var ErrTooSoon = errors.New("a confirmation was sent to the email recently, try again later")
+
+// Default is the confirmation email when no template is set.
+var Default = templates.Template{
+	Subject: "Please confirm your subscription",
+	Text: `Hello {{.FirstName | default "there"}},
+
+Please confirm your subscription to our mailing list by opening this link:
+
+{{.ConfirmURL}}
+
+If you did not sign up, ignore this email and you will not hear from us again.
+`,
+	HTML: `<p>Hello {{.FirstName | default "there"}},</p>
+<p>Please confirm your subscription to our mailing list:</p>
+<p><a href="{{.ConfirmURL}}">Confirm my subscription</a></p>
+<p>If you did not sign up, ignore this email and you will not hear from us again.</p>
+`,
+}
+
+// Sender queues the confirmation emails.
+type Sender struct {
+	db *sql.DB
+	p  *personalize.Personalizer
+	// template is the name of the stored template, Default when empty.
+	template string
+	// cooldown is the least time between two confirmations of an email.
+	cooldown time.Duration
+}
+
+// New returns the sender of the confirmations rendered from the stored
+// template of that name, or from Default when empty, sending one at most
+// per cooldown to each email. The personalizer must link to the public URL.
+func New(db *sql.DB, p *personalize.Personalizer, template string, cooldown time.Duration) *Sender {
+	return &Sender{db: db, p: p, template: template, cooldown: cooldown}
+}
+
+// Send queues the confirmation email of the email, with a new link. It
+// fails with mdb.ErrEmailNotFound for an unknown email, ErrNotPending, and
+// ErrTooSoon within the cooldown of the last one.
+func (s *Sender) Send(ctx context.Context, email string) (err error) {
+	entry, err := mdb.GetEmail(ctx, s.db, email)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return mdb.ErrEmailNotFound
+	}
+	if entry.OptOut || entry.ConfirmedAt.Unix() != 0 {
+		return ErrNotPending
+	}
+	claimed, err := mdb.ClaimConfirmation(ctx, s.db, email, s.cooldown)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrTooSoon
+	}
+	defer func() {
+		if err != nil {
+			mdb.ReleaseConfirmation(ctx, s.db, email)
+		}
+	}()
+
+	t := Default
+	if s.template != "" {
+		stored, err := mdb.GetEmailTemplateByName(ctx, s.db, s.template)
+		if err != nil {
+			return fmt.Errorf("confirmation template %q: %w", s.template, err)
+		}
+		t = stored.Template()
+	}
+	parsed, err := templates.Parse(t)
+	if err != nil {
+		return fmt.Errorf("confirmation template %q: %w", s.template, err)
+	}
+	recipient, err := s.p.Recipient(ctx, email)
+	if err != nil {
+		return err
+	}
+	rendered, err := parsed.Render(recipient.Data)
+	if err != nil {
+		return err
+	}
+	id, err := mdb.EnqueueMessage(ctx, s.db, &mdb.QueuedMessage{
+		To:      email,
+		Subject: rendered.Subject,
+		Text:    rendered.Text,
+		HTML:    rendered.HTML,
+		Headers: recipient.Headers,
+		Hash:    rendered.Hash(),
+	})
+	if err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Info("confirmation queued", "email", email, "message", id)
+	return nil
+}
@@ -0,0 +1,313 @@
+// Package crash recovers the panics of the handlers, the interceptors and
+// the background workers, logs them with their stack and reports them to
+// Sentry, or to a compatible service such as GlitchTip, with the id of the
+// request. The fatal errors, which cannot be recovered, are written to a
+// file and reported on the next start.
+package crash
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mailinglist/logging"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	sendTimeout = 5 * time.Second
+	// maxOutput bounds the crash output sent with a report.
+	maxOutput = 64 << 10
+)
+
+// Options sets where the reports go.
+type Options struct {
+	// DSN is the client key of the Sentry project,
+	// https://<key>@<host>/<project>.
+	DSN string
+	// Environment tells the deployments apart, e.g. production.
+	Environment string
+}
+
+// Reporter sends the panics to Sentry.
+type Reporter struct {
+	storeURL    string
+	auth        string
+	environment string
+	release     string
+	serverName  string
+	client      *http.Client
+}
+
+// ParseDSN returns the store endpoint and the auth header of the DSN.
+func ParseDSN(dsn string) (storeURL, auth string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.User == nil || u.User.Username() == "" {
+		return "", "", errors.New("the DSN must be http(s)://<key>@<host>/<project>")
+	}
+	path, project, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if project == "" {
+		path, project = "", path
+	}
+	if project == "" {
+		return "", "", errors.New("the DSN has no project")
+	}
+	if path != "" {
+		path = "/" + path
+	}
+
+	auth = "Sentry sentry_version=7, sentry_client=mailinglist/1.0, sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	return fmt.Sprintf("%v://%v%v/api/%v/store/", u.Scheme, u.Host, path, project), auth, nil
+}
+
+func New(opts Options) (*Reporter, error) {
+	storeURL, auth, err := ParseDSN(opts.DSN)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reporter{
+		storeURL:    storeURL,
+		auth:        auth,
+		environment: opts.Environment,
+		client:      &http.Client{Timeout: sendTimeout},
+	}
+	r.serverName, _ = os.Hostname()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		r.release = info.Main.Version
+	}
+	return r, nil
+}
+
+var reporter atomic.Pointer[Reporter]
+
+// SetDefault sets the reporter of the panics, which are only logged
+// without one.
+func SetDefault(r *Reporter) {
+	reporter.Store(r)
+}
+
+// Recover recovers a panic of the goroutine, logging and reporting it
+// with the tags, in key/value pairs. It must be deferred itself:
+//
+//	defer crash.Recover(ctx, "worker", name)
+func Recover(ctx context.Context, tags ...string) {
+	if value := recover(); value != nil {
+		Handle(ctx, value, tags...)
+	}
+}
+
+// Handle logs and reports a panic recovered by the caller, with the tags,
+// in key/value pairs. The report is sent in the background.
+func Handle(ctx context.Context, value any, tags ...string) {
+	logging.FromContext(ctx).Error("panic", "panic", value, "stack", string(debug.Stack()))
+
+	r := reporter.Load()
+	if r == nil {
+		return
+	}
+	e := r.event("error", fmt.Sprint(value), true, tagMap(ctx, tags))
+	e.Exception.Values[0].Stacktrace = &stacktrace{Frames: callers()}
+	go func() {
+		if err := r.send(context.WithoutCancel(ctx), e); err != nil {
+			logging.FromContext(ctx).Warn("reporting the panic failed", "err", err)
+		}
+	}()
+}
+
+func tagMap(ctx context.Context, tags []string) map[string]string {
+	m := make(map[string]string)
+	if id := logging.RequestId(ctx); id != "" {
+		m["request_id"] = id
+	}
+	for i := 0; i+1 < len(tags); i += 2 {
+		m[tags[i]] = tags[i+1]
+	}
+	return m
+}
+
+// CaptureFatal writes the fatal errors of the process, and the panics not
+// recovered, which the runtime prints before exiting, to the file at path.
+// The ones of the previous run found there are logged and reported first.
+func CaptureFatal(ctx context.Context, path string) error {
+	output, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if output = bytes.TrimSpace(output); len(output) > 0 {
+		reportFatal(ctx, output)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return debug.SetCrashOutput(file, debug.CrashOptions{})
+}
+
+// reportFatal logs and reports the crash output of the previous run.
+func reportFatal(ctx context.Context, output []byte) {
+	first, _, _ := bytes.Cut(output, []byte("\n"))
+	logger := logging.FromContext(ctx)
+	logger.Error("the previous run crashed", "crash", string(first))
+
+	r := reporter.Load()
+	if r == nil {
+		return
+	}
+	if len(output) > maxOutput {
+		output = output[:maxOutput]
+	}
+	e := r.event("fatal", string(first), false, map[string]string{})
+	e.Extra = map[string]string{"output": string(output)}
+	if err := r.send(ctx, e); err != nil {
+		logger.Warn("reporting the crash failed", "err", err)
+	}
+}
+
+type event struct {
+	EventId     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+	Exception   struct {
+		Values []exception `json:"values"`
+	} `json:"exception"`
+}
+
+type exception struct {
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	Mechanism struct {
+		Type    string `json:"type"`
+		Handled bool   `json:"handled"`
+	} `json:"mechanism"`
+	Stacktrace *stacktrace `json:"stacktrace,omitempty"`
+}
+
+type stacktrace struct {
+	Frames []frame `json:"frames"`
+}
+
+type frame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+func (r *Reporter) event(level, value string, handled bool, tags map[string]string) *event {
+	id := make([]byte, 16)
+	rand.Read(id)
+	e := &event{
+		EventId:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       level,
+		Platform:    "go",
+		Logger:      "mailinglist",
+		ServerName:  r.serverName,
+		Release:     r.release,
+		Environment: r.environment,
+		Tags:        tags,
+	}
+	ex := exception{Type: "panic", Value: value}
+	ex.Mechanism.Type, ex.Mechanism.Handled = "recover", handled
+	if !handled {
+		ex.Type, ex.Mechanism.Type = "fatal error", "crash"
+	}
+	e.Exception.Values = []exception{ex}
+	return e
+}
+
+// callers returns the frames of the panicking goroutine, from the panic
+// down, the outermost first as Sentry expects, without the ones of the
+// runtime.
+func callers() []frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var stack []frame
+	for {
+		f, more := frames.Next()
+		if f.Function == "runtime.gopanic" {
+			// The frames above are the recovery.
+			stack = nil
+		} else if !strings.HasPrefix(f.Function, "runtime.") && !strings.HasPrefix(f.Function, "mailinglist/crash.") {
+			module := f.Function
+			if slash := strings.LastIndex(module, "/"); slash >= 0 {
+				if dot := strings.Index(module[slash:], "."); dot >= 0 {
+					module = module[:slash+dot]
+				}
+			} else if dot := strings.Index(module, "."); dot >= 0 {
+				module = module[:dot]
+			}
+			stack = append(stack, frame{
+				Function: strings.TrimPrefix(f.Function, module+"."),
+				Module:   module,
+				Filename: f.File[strings.LastIndex(f.File, "/")+1:],
+				AbsPath:  f.File,
+				Lineno:   f.Line,
+				InApp:    module == "main" || strings.HasPrefix(module, "mailinglist/"),
+			})
+		}
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	return stack
+}
+
+func (r *Reporter) send(ctx context.Context, e *event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Sentry-Auth", r.auth)
+
+	response, err := r.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v answered %v", r.storeURL, response.Status)
+	}
+	return nil
+}
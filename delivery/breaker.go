@@ -0,0 +1,96 @@
+package delivery
+
+import (
+	"sync"
+	"time"
+)
+
+// The states of a circuit breaker.
+const (
+	// StateClosed lets the deliveries through.
+	StateClosed = "closed"
+	// StateOpen holds the deliveries back after too many failures in a row.
+	StateOpen = "open"
+	// StateHalfOpen lets a single delivery through to probe the endpoint.
+	StateHalfOpen = "half-open"
+)
+
+// breaker stops sending to an endpoint failing threshold times in a row for
+// the cooldown, then probes it with one delivery at a time until one
+// succeeds.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *breaker) stateLocked(now time.Time) string {
+	switch {
+	case b.failures < b.threshold:
+		return StateClosed
+	case now.Before(b.openUntil):
+		return StateOpen
+	}
+	return StateHalfOpen
+}
+
+// available reports whether a delivery may be claimed for the endpoint.
+func (b *breaker) available(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.stateLocked(now) {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		return !b.probing
+	}
+	return true
+}
+
+// acquire reserves the sending of a delivery, the probe when half-open.
+func (b *breaker) acquire(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.stateLocked(now) {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// record counts the outcome of a delivery sent after acquire.
+func (b *breaker) record(now time.Time, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = now.Add(b.cooldown)
+	}
+}
+
+// status returns the state of the breaker, with the failures in a row and
+// when an open breaker lets a probe through.
+func (b *breaker) status(now time.Time) (string, int, *time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.stateLocked(now)
+	if state != StateOpen {
+		return state, b.failures, nil
+	}
+	openUntil := b.openUntil
+	return state, b.failures, &openUntil
+}
@@ -0,0 +1,288 @@
+// Package delivery sends the email events to the webhook endpoints. The
+// events are queued in the database in the transactions of the changes,
+// and a pool of workers, on every server sharing the database, posts them
+// to the endpoints, retrying the failures with an exponential backoff. The
+// deliveries failing for good are kept as dead letters to be requeued.
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"mailinglist/crash"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// pollInterval is how often the idle workers look for due deliveries.
+	pollInterval = time.Second
+	// visibility is how long a claimed delivery is hidden from the other
+	// workers, after which it is sent again if the server sending it died.
+	visibility  = time.Minute
+	sendTimeout = 10 * time.Second
+
+	baseBackoff = 10 * time.Second
+	maxBackoff  = time.Hour
+
+	breakerThreshold = 5
+	breakerCooldown  = time.Minute
+)
+
+// Options sets the endpoints and how hard the deliveries are tried.
+type Options struct {
+	// Endpoints are the URLs of the endpoints, by name.
+	Endpoints map[string]string
+	// Secret signs the deliveries, which are not signed when empty.
+	Secret string
+	// Workers is the number of deliveries sent at once.
+	Workers int
+	// MaxAttempts is how many times a delivery is tried before it is dead.
+	MaxAttempts int
+	// Paused reports whether the deliveries wait, e.g. while the webhooks
+	// flag is off. They are sent once it returns false.
+	Paused func() bool
+}
+
+// EndpointStatus is the state of the circuit breaker of an endpoint, and
+// the deliveries waiting for it.
+type EndpointStatus struct {
+	Name     string
+	URL      string
+	State    string
+	Failures int
+	// OpenUntil is when an open breaker probes the endpoint again.
+	OpenUntil *time.Time `json:",omitempty"`
+	Pending   int64
+	Dead      int64
+}
+
+// Dispatcher runs the workers sending the deliveries.
+type Dispatcher struct {
+	db       *sql.DB
+	opts     Options
+	client   *http.Client
+	logger   *slog.Logger
+	breakers map[string]*breaker
+}
+
+func New(db *sql.DB, opts Options, logger *slog.Logger) *Dispatcher {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	breakers := make(map[string]*breaker)
+	for name := range opts.Endpoints {
+		breakers[name] = &breaker{threshold: breakerThreshold, cooldown: breakerCooldown}
+	}
+	return &Dispatcher{
+		db:       db,
+		opts:     opts,
+		client:   &http.Client{Timeout: sendTimeout},
+		logger:   logger.With("component", "webhook-delivery"),
+		breakers: breakers,
+	}
+}
+
+// Run sends the deliveries until ctx is done, then waits for the ones in
+// flight.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ctx = logging.NewContext(ctx, d.logger)
+	var wg sync.WaitGroup
+	for i := 0; i < d.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (d *Dispatcher) work(ctx context.Context) {
+	for {
+		if d.opts.Paused == nil || !d.opts.Paused() {
+			delivered, err := d.safeNext(ctx)
+			if err != nil && ctx.Err() == nil {
+				d.logger.Error("webhook delivery failed", "err", err)
+			}
+			if delivered && err == nil {
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// safeNext is next, a panic failing the delivery rather than the worker.
+// The delivery is sent again once its claim expires.
+func (d *Dispatcher) safeNext(ctx context.Context) (delivered bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			crash.Handle(ctx, r, "component", "webhook-delivery")
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return d.next(ctx)
+}
+
+// next sends the next due delivery, reporting whether there was one.
+func (d *Dispatcher) next(ctx context.Context) (bool, error) {
+	now := time.Now()
+	var available []string
+	for name, b := range d.breakers {
+		if b.available(now) {
+			available = append(available, name)
+		}
+	}
+
+	delivery, err := mdb.ClaimWebhookDelivery(ctx, d.db, available, visibility)
+	if err != nil || delivery == nil {
+		return false, err
+	}
+
+	// The settling outlives a canceled ctx, the delivery being sent.
+	settle := context.WithoutCancel(ctx)
+	b, ok := d.breakers[delivery.Endpoint]
+	if !ok {
+		// An endpoint of another server, left to it.
+		d.logger.Warn("webhook delivery of an unknown endpoint released", "endpoint", delivery.Endpoint, "delivery", delivery.Id)
+		return false, mdb.RetryWebhookDelivery(settle, d.db, delivery.Id, delivery.Attempts, time.Now(), delivery.LastError)
+	}
+	if !b.acquire(time.Now()) {
+		// Another worker is probing the endpoint.
+		return true, mdb.RetryWebhookDelivery(settle, d.db, delivery.Id, delivery.Attempts, time.Now().Add(pollInterval), delivery.LastError)
+	}
+
+	logger := d.logger.With("endpoint", delivery.Endpoint, "delivery", delivery.Id)
+	err = d.send(ctx, delivery)
+	// An endpoint rejecting a delivery is still up.
+	_, permanent := err.(permanentError)
+	b.record(time.Now(), err == nil || permanent)
+	if err == nil {
+		logger.Debug("webhook delivered", "kind", delivery.Kind)
+		return true, mdb.DeleteWebhookDelivery(settle, d.db, delivery.Id)
+	}
+
+	attempts := delivery.Attempts + 1
+	if permanent || attempts >= d.opts.MaxAttempts {
+		logger.Warn("webhook delivery dead", "attempts", attempts, "err", err)
+		return true, mdb.KillWebhookDelivery(settle, d.db, delivery.Id, attempts, err.Error())
+	}
+	retryAt := time.Now().Add(backoff(attempts))
+	logger.Info("webhook delivery failed, retrying", "attempts", attempts, "retry_at", retryAt, "err", err)
+	return true, mdb.RetryWebhookDelivery(settle, d.db, delivery.Id, attempts, retryAt, err.Error())
+}
+
+// permanentError is a failure that retrying will not fix.
+type permanentError struct {
+	error
+}
+
+// backoff is the wait before the next attempt, doubling with each attempt
+// up to maxBackoff, with some jitter so that the deliveries held back by
+// an outage are not all retried at once.
+func backoff(attempts int) time.Duration {
+	wait := maxBackoff
+	if attempts < 20 {
+		wait = baseBackoff << (attempts - 1)
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait - time.Duration(rand.Int63n(int64(wait/5)))
+}
+
+// Sign returns the signature of the body sent at t, the hex HMAC-SHA256
+// with the secret of "t.body".
+func Sign(secret string, t time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", t.Unix())
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery *mdb.WebhookDelivery) error {
+	url, ok := d.opts.Endpoints[delivery.Endpoint]
+	if !ok {
+		return permanentError{fmt.Errorf("unknown endpoint %v", delivery.Endpoint)}
+	}
+
+	body := []byte(delivery.Payload)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "mailinglist-webhooks")
+	request.Header.Set("X-Mailinglist-Event", delivery.Kind)
+	request.Header.Set("X-Mailinglist-Delivery", strconv.FormatInt(delivery.Id, 10))
+	if d.opts.Secret != "" {
+		now := time.Now()
+		request.Header.Set("X-Mailinglist-Signature", fmt.Sprintf("t=%d,v1=%v", now.Unix(), Sign(d.opts.Secret, now, body)))
+	}
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	switch {
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+		return nil
+	case response.StatusCode >= 400 && response.StatusCode < 500 &&
+		response.StatusCode != http.StatusRequestTimeout && response.StatusCode != http.StatusTooManyRequests:
+		// The endpoint rejects the delivery itself.
+		return permanentError{fmt.Errorf("endpoint answered %v", response.Status)}
+	}
+	return fmt.Errorf("endpoint answered %v", response.Status)
+}
+
+// Endpoints returns the status of the endpoints, sorted by name.
+func (d *Dispatcher) Endpoints(ctx context.Context) ([]EndpointStatus, error) {
+	counts, err := mdb.CountWebhookDeliveries(ctx, d.db)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	endpoints := make([]EndpointStatus, 0, len(d.opts.Endpoints))
+	for name, url := range d.opts.Endpoints {
+		status := EndpointStatus{Name: name, URL: url}
+		status.State, status.Failures, status.OpenUntil = d.breakers[name].status(now)
+		for _, count := range counts {
+			if count.Endpoint != name {
+				continue
+			}
+			switch count.Status {
+			case mdb.DeliveryPending:
+				status.Pending = count.Count
+			case mdb.DeliveryDead:
+				status.Dead = count.Count
+			}
+		}
+		endpoints = append(endpoints, status)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Name < endpoints[j].Name
+	})
+	return endpoints, nil
+}
@@ -0,0 +1,129 @@
+// Package diagnostics serves the pprof profiles and the runtime stats of
+// the server, for debugging it in production. It has no authentication and
+// must only listen on a loopback address.
+package diagnostics
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"mailinglist/handoff"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"time"
+)
+
+// RuntimeStats is a snapshot of the process and of the database pool.
+type RuntimeStats struct {
+	Uptime     string
+	GoVersion  string
+	Goroutines int
+	CPUs       int
+
+	// The memory, in bytes.
+	HeapAlloc    uint64
+	HeapInuse    uint64
+	HeapObjects  uint64
+	StackInuse   uint64
+	Sys          uint64
+	TotalAlloc   uint64
+	NumGC        uint32
+	LastGC       *time.Time
+	PauseTotalNs uint64
+
+	DB sql.DBStats
+}
+
+var started = time.Now()
+
+func runtimeStats(db *sql.DB) RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := RuntimeStats{
+		Uptime:     time.Since(started).Round(time.Second).String(),
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+		CPUs:       runtime.NumCPU(),
+
+		HeapAlloc:    mem.HeapAlloc,
+		HeapInuse:    mem.HeapInuse,
+		HeapObjects:  mem.HeapObjects,
+		StackInuse:   mem.StackInuse,
+		Sys:          mem.Sys,
+		TotalAlloc:   mem.TotalAlloc,
+		NumGC:        mem.NumGC,
+		PauseTotalNs: mem.PauseTotalNs,
+
+		DB: db.Stats(),
+	}
+	if mem.LastGC != 0 {
+		lastGC := time.Unix(0, int64(mem.LastGC))
+		stats.LastGC = &lastGC
+	}
+	return stats
+}
+
+// Runtime serves the RuntimeStats as JSON.
+func Runtime(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(runtimeStats(db))
+	})
+}
+
+// Handler serves the profiles under /debug/pprof/ and the runtime stats at
+// /debug/runtime.
+func Handler(db *sql.DB) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/runtime", Runtime(db))
+	return mux
+}
+
+// Serve starts the diagnostics server on bind, serving the profiles under
+// /debug/pprof/ and the runtime stats at /debug/runtime.
+func Serve(db *sql.DB, bind string, logger *slog.Logger) *http.Server {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("server", "diagnostics")
+
+	// No write timeout, the CPU profiles and traces last for seconds.
+	serv := &http.Server{
+		Addr:              bind,
+		Handler:           Handler(db),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	// Bound before returning, so that the socket is handed over on upgrades.
+	listener, err := handoff.Listen("tcp", bind)
+	if err != nil {
+		logger.Error("error starting the server", "err", err)
+		os.Exit(1)
+	}
+	go func() {
+		logger.Info("starting server", "addr", serv.Addr)
+		if err := serv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("error starting the server", "err", err)
+			os.Exit(1)
+		}
+	}()
+
+	return serv
+}
+
+func Shutdown(serv *http.Server) {
+	tc, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	serv.Shutdown(tc)
+}
@@ -0,0 +1,94 @@
+// Package flags are the optional behaviors of the server, which can be
+// turned on and off while it runs, from the configuration reloaded on
+// SIGHUP or from the admin API.
+package flags
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Signup lets the callers subscribe emails one by one, with CreateEmail.
+	Signup = "signup"
+	// Imports lets the callers subscribe emails in bulk, with
+	// BulkCreateEmails and ImportEmails.
+	Imports = "imports"
+	// Webhooks receives the events of the email providers and delivers the
+	// email events to the webhook endpoints, which are kept for later while
+	// it is off.
+	Webhooks = "webhooks"
+	// ReadOnly rejects the writes, e.g. during migrations or backups.
+	ReadOnly = "read_only"
+)
+
+// Defaults are the flags, with their values when not set.
+var Defaults = map[string]bool{Signup: true, Imports: true, Webhooks: true, ReadOnly: false}
+
+// Validate checks that the flags are known.
+func Validate(values map[string]bool) error {
+	for name := range values {
+		if _, ok := Defaults[name]; !ok {
+			return fmt.Errorf("unknown flag %q, expected one of %v", name, strings.Join(names(), ", "))
+		}
+	}
+	return nil
+}
+
+func names() []string {
+	names := make([]string, 0, len(Defaults))
+	for name := range Defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set holds the values of the flags, shared by the servers and the workers.
+type Set struct {
+	mu     sync.RWMutex
+	values map[string]bool
+}
+
+// New returns the flags with the values, the others having their default.
+func New(values map[string]bool) (*Set, error) {
+	s := &Set{values: maps.Clone(Defaults)}
+	return s, s.Set(values)
+}
+
+// Enabled reports whether the flag is on.
+func (s *Set) Enabled(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[name]
+}
+
+// Check returns an error telling that the flag is off, nil when it is on.
+func (s *Set) Check(name string) error {
+	if s.Enabled(name) {
+		return nil
+	}
+	return fmt.Errorf("%v is turned off on this server", name)
+}
+
+// Set changes the flags of values, leaving the others. Nothing is changed
+// when one of them is unknown.
+func (s *Set) Set(values map[string]bool) error {
+	if err := Validate(values); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	maps.Copy(s.values, values)
+	return nil
+}
+
+// All returns the values of all the flags.
+func (s *Set) All() map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.values)
+}
@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"context"
+	"mailinglist/grpcapi"
+	pb "mailinglist/proto/mailinglist/v1"
+	"mailinglist/tracing"
+	"net"
+	"net/http"
+	"net/textproto"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+// headerMatcher forwards the X-API-Key, X-Org-Id and X-Request-Id headers
+// to the gRPC server in addition to the headers forwarded by default (such
+// as Authorization).
+func headerMatcher(key string) (string, bool) {
+	switch textproto.CanonicalMIMEHeaderKey(key) {
+	case "X-Api-Key":
+		return "x-api-key", true
+	case "X-Org-Id":
+		return "x-org-id", true
+	case "X-Request-Id":
+		return "x-request-id", true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// loopbackAddr turns a bind address such as ":9092" into an address the
+// gateway can dial.
+func loopbackAddr(bind string) string {
+	host, port, err := net.SplitHostPort(bind)
+	if err != nil {
+		return bind
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		return net.JoinHostPort("localhost", port)
+	}
+	return bind
+}
+
+// New returns the REST handler generated from the google.api.http
+// annotations of the proto. Requests are proxied to the gRPC server
+// listening on grpcBind, so they go through the same interceptors, with
+// the token the server knows the gateway by, for it to believe the
+// address of the callers in x-forwarded-for.
+func New(ctx context.Context, grpcBind string, creds credentials.TransportCredentials, token string) (http.Handler, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(headerMatcher),
+		runtime.WithMetadata(func(context.Context, *http.Request) metadata.MD {
+			return metadata.Pairs(grpcapi.GatewayTokenHeader, token)
+		}),
+	)
+
+	opts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultServiceConfig(grpcapi.ServiceConfig),
+	}, tracing.DialOptions()...)
+	err := pb.RegisterMailingListServiceHandlerFromEndpoint(ctx, mux, loopbackAddr(grpcBind), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return traceContextMiddleware(mux), nil
+}
+
+// traceContextMiddleware continues the trace of the HTTP caller, sent in
+// the traceparent header, in the call to the gRPC server.
+func traceContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
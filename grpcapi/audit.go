@@ -0,0 +1,106 @@
+package grpcapi
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// auditTargetFields are the request fields naming the target of a call,
+// by order of preference.
+var auditTargetFields = []protoreflect.Name{"email_addr", "email_entry", "domain", "name", "list"}
+
+// principal names the caller: its API key, else its client certificate.
+func principal(ctx context.Context) string {
+	if apiKey := apiKeyFromContext(ctx); apiKey != nil {
+		return "key:" + apiKey.Name
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+			return "cert:" + tlsInfo.State.PeerCertificates[0].Subject.CommonName
+		}
+	}
+	return "anonymous"
+}
+
+// auditTarget returns the email, or else the domain or list, a request is about.
+func auditTarget(req interface{}) string {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return ""
+	}
+	msg := m.ProtoReflect()
+	fields := msg.Descriptor().Fields()
+
+	for _, name := range auditTargetFields {
+		fd := fields.ByName(name)
+		if fd == nil || !msg.Has(fd) {
+			continue
+		}
+		if fd.Kind() == protoreflect.MessageKind {
+			return auditTarget(msg.Get(fd).Message().Interface())
+		}
+		if fd.Kind() == protoreflect.StringKind {
+			return msg.Get(fd).String()
+		}
+	}
+	if fd := fields.ByName("email"); fd != nil && fd.Kind() == protoreflect.StringKind {
+		return msg.Get(fd).String()
+	}
+	return ""
+}
+
+type auditor struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+func (a *auditor) record(ctx context.Context, method, target string, err error) {
+	entry := mdb.AuditEntry{
+		At:        time.Now(),
+		Principal: principal(ctx),
+		Source:    mdb.AuditSourceGrpc,
+		Action:    method,
+		Target:    target,
+		Outcome:   status.Code(err).String(),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		entry.RemoteAddr = p.Addr.String()
+	}
+
+	// The entry is recorded even when the call was canceled.
+	if err := mdb.RecordAudit(logging.NewContext(context.Background(), a.logger), a.db, entry); err != nil {
+		a.logger.Error("failed to audit", "method", method, "err", err)
+	}
+}
+
+// unaryInterceptor records every mutating call in the audit log.
+func (a *auditor) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !writeMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+
+	res, err := handler(ctx, req)
+	a.record(ctx, info.FullMethod, auditTarget(req), err)
+	return res, err
+}
+
+func (a *auditor) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !writeMethods[info.FullMethod] {
+		return handler(srv, ss)
+	}
+
+	err := handler(srv, ss)
+	a.record(ss.Context(), info.FullMethod, "", err)
+	return err
+}
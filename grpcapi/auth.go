@@ -0,0 +1,135 @@
+package grpcapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mailinglist/mdb"
+	"mailinglist/state"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const servicePrefix = "/mailinglist.v1.MailingListService/"
+
+// campaignMethods are the RPCs of the campaigns, which span every email:
+// as the /campaigns endpoints of the JSON API, they need a key with the
+// write scope and no organization, reads included.
+var campaignMethods = map[string]bool{
+	"/mailinglist.v1.MailingListService/CreateCampaign":     true,
+	"/mailinglist.v1.MailingListService/GetCampaign":        true,
+	"/mailinglist.v1.MailingListService/ListCampaigns":      true,
+	"/mailinglist.v1.MailingListService/UpdateCampaign":     true,
+	"/mailinglist.v1.MailingListService/DeleteCampaign":     true,
+	"/mailinglist.v1.MailingListService/ScheduleCampaign":   true,
+	"/mailinglist.v1.MailingListService/UnscheduleCampaign": true,
+	"/mailinglist.v1.MailingListService/GetCampaignStats":   true,
+}
+
+type apiKeyContextKey struct{}
+
+func apiKeyFromContext(ctx context.Context) *mdb.ApiKey {
+	apiKey, _ := ctx.Value(apiKeyContextKey{}).(*mdb.ApiKey)
+	return apiKey
+}
+
+// credentialsFromMetadata returns the API key sent either as
+// "authorization: Bearer <key>" or as "x-api-key: <key>".
+func credentialsFromMetadata(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		if strings.HasPrefix(values[0], "Bearer ") {
+			return strings.TrimPrefix(values[0], "Bearer ")
+		}
+	}
+	if values := md.Get("x-api-key"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+type authenticator struct {
+	db          *sql.DB
+	st          *state.State
+	requireAuth bool
+}
+
+// authenticate resolves the API key of the call, checks that it may call
+// the method and counts the request against its daily quota.
+func (a *authenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if !strings.HasPrefix(method, servicePrefix) {
+		return ctx, nil
+	}
+
+	key := credentialsFromMetadata(ctx)
+	if key == "" {
+		if orgFromMetadata(ctx) != "" {
+			return nil, status.Error(codes.Unauthenticated, "an organization requires an API key")
+		}
+		// The writes and the campaigns always need a key, the reads only
+		// when required.
+		if a.requireAuth || writeMethods[method] || campaignMethods[method] {
+			return nil, status.Error(codes.Unauthenticated, "missing API key")
+		}
+		return ctx, nil
+	}
+
+	apiKey, err := mdb.GetApiKey(ctx, a.db, key)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	if apiKey == nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	}
+
+	if (writeMethods[method] || campaignMethods[method]) && !apiKey.CanWrite() {
+		return nil, status.Errorf(codes.PermissionDenied, "API key %v is not allowed to call %v", apiKey.Name, method)
+	}
+
+	ctx, err = orgContext(ctx, apiKey, method)
+	if err != nil {
+		return nil, err
+	}
+
+	if !a.st.ReadOnly() {
+		err := mdb.CountRequest(ctx, a.db, *apiKey)
+		if errors.Is(err, mdb.ErrQuotaExceeded) {
+			return nil, status.Error(codes.ResourceExhausted, "daily request quota exceeded")
+		}
+		if err != nil {
+			return nil, storageError(err)
+		}
+	}
+
+	return context.WithValue(ctx, apiKeyContextKey{}, apiKey), nil
+}
+
+func (a *authenticator) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// contextStream overrides the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func (a *authenticator) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
@@ -0,0 +1,180 @@
+package grpcapi
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/flags"
+	"mailinglist/mdb"
+	"mailinglist/state"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", t.TempDir()+"/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := mdb.Migrate(context.Background(), db, mdb.SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newAuthenticator(t *testing.T, db *sql.DB, requireAuth bool) *authenticator {
+	t.Helper()
+	featureFlags, err := flags.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{db: db, st: state.New(featureFlags, false), requireAuth: requireAuth}
+}
+
+// keyContext returns the context of a call made with the API key and the
+// metadata pairs.
+func keyContext(key string, pairs ...string) context.Context {
+	if key != "" {
+		pairs = append(pairs, "x-api-key", key)
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	readKey, err := mdb.CreateApiKey(ctx, db, "reader", mdb.ScopeRead, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey, err := mdb.CreateApiKey(ctx, db, "writer", mdb.ScopeWrite, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orgKey, err := mdb.CreateApiKey(ctx, db, "acme", mdb.ScopeWrite, "acme", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		requireAuth bool
+		ctx         context.Context
+		method      string
+		code        codes.Code
+		// key is the name of the API key expected in the context.
+		key string
+	}{
+		{name: "read without key", ctx: keyContext(""), method: "GetEmail", code: codes.OK},
+		{name: "read without key required", requireAuth: true, ctx: keyContext(""), method: "GetEmail", code: codes.Unauthenticated},
+		{name: "write without key", ctx: keyContext(""), method: "CreateEmail", code: codes.Unauthenticated},
+		{name: "invalid key", ctx: keyContext("nope"), method: "GetEmail", code: codes.Unauthenticated},
+		{name: "read with read key", ctx: keyContext(readKey.Key), method: "GetEmail", code: codes.OK, key: "reader"},
+		{name: "write with read key", ctx: keyContext(readKey.Key), method: "CreateEmail", code: codes.PermissionDenied},
+		{name: "write with write key", ctx: keyContext(writeKey.Key), method: "CreateEmail", code: codes.OK, key: "writer"},
+		{
+			name:   "bearer key",
+			ctx:    metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+writeKey.Key)),
+			method: "CreateEmail",
+			code:   codes.OK,
+			key:    "writer",
+		},
+		// The campaigns need an unscoped write key, reads included.
+		{name: "campaign read without key", ctx: keyContext(""), method: "GetCampaignStats", code: codes.Unauthenticated},
+		{name: "campaign read with read key", ctx: keyContext(readKey.Key), method: "ListCampaigns", code: codes.PermissionDenied},
+		{name: "campaign read with organization key", ctx: keyContext(orgKey.Key), method: "GetCampaign", code: codes.PermissionDenied},
+		{name: "campaign read with write key", ctx: keyContext(writeKey.Key), method: "GetCampaign", code: codes.OK, key: "writer"},
+		{name: "other service", requireAuth: true, ctx: keyContext(""), method: "/grpc.health.v1.Health/Check", code: codes.OK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := newAuthenticator(t, db, test.requireAuth)
+			method := test.method
+			if !strings.HasPrefix(method, "/") {
+				method = servicePrefix + method
+			}
+			ctx, err := a.authenticate(test.ctx, method)
+			if code := status.Code(err); code != test.code {
+				t.Fatalf("authenticate = %v, want %v", err, test.code)
+			}
+			if err != nil {
+				return
+			}
+			if apiKey := apiKeyFromContext(ctx); (apiKey == nil && test.key != "") || (apiKey != nil && apiKey.Name != test.key) {
+				t.Errorf("API key %+v, want %q", apiKey, test.key)
+			}
+		})
+	}
+}
+
+func TestAuthenticateQuota(t *testing.T) {
+	db := openDB(t)
+	a := newAuthenticator(t, db, true)
+	apiKey, err := mdb.CreateApiKey(context.Background(), db, "test", mdb.ScopeWrite, "", 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		readOnly bool
+		code     codes.Code
+	}{
+		{name: "first request", code: codes.OK},
+		{name: "last request", code: codes.OK},
+		{name: "over quota", code: codes.ResourceExhausted},
+		// The requests are not counted while the database is not written.
+		{name: "over quota read only", readOnly: true, code: codes.OK},
+		{name: "over quota again", code: codes.ResourceExhausted},
+	}
+
+	for _, test := range tests {
+		a.st.SetReadOnly(test.readOnly)
+		_, err := a.authenticate(keyContext(apiKey.Key), servicePrefix+"GetEmail")
+		if code := status.Code(err); code != test.code {
+			t.Errorf("%v: authenticate = %v, want %v", test.name, err, test.code)
+		}
+	}
+}
+
+// The handler runs with the API key of the call, and not at all when it
+// is refused.
+func TestUnaryInterceptor(t *testing.T) {
+	db := openDB(t)
+	a := newAuthenticator(t, db, false)
+	readKey, err := mdb.CreateApiKey(context.Background(), db, "reader", mdb.ScopeRead, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		called bool
+	}{
+		{method: "GetEmail", called: true},
+		{method: "DeleteEmail"},
+	}
+
+	for _, test := range tests {
+		var called bool
+		info := &grpc.UnaryServerInfo{FullMethod: servicePrefix + test.method}
+		a.unaryInterceptor(keyContext(readKey.Key), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			called = true
+			if apiKey := apiKeyFromContext(ctx); apiKey == nil || apiKey.Name != "reader" {
+				t.Errorf("%v: API key %+v, want reader", test.method, apiKey)
+			}
+			return nil, nil
+		})
+		if called != test.called {
+			t.Errorf("%v: handler called %v, want %v", test.method, called, test.called)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"mailinglist/mdb"
+	pb "mailinglist/proto/mailinglist/v1"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *MailService) GetEmailBounces(ctx context.Context, r *pb.GetEmailBouncesRequest) (*pb.EmailBounces, error) {
+	if err := s.checkOrg(ctx, r.EmailAddr); err != nil {
+		return nil, err
+	}
+	b, err := mdb.GetBounces(ctx, s.db, r.EmailAddr)
+	if errors.Is(err, mdb.ErrEmailNotFound) {
+		return nil, notFound(r.EmailAddr)
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	res := &pb.EmailBounces{Hard: b.Hard, Soft: b.Soft}
+	if b.LastBouncedAt != nil {
+		res.LastBouncedAt = timestamppb.New(*b.LastBouncedAt)
+	}
+	return res, nil
+}
@@ -0,0 +1,206 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mailinglist/mdb"
+	pb "mailinglist/proto/mailinglist/v1"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var campaignStates = map[mdb.CampaignState]pb.CampaignState{
+	mdb.CampaignDraft:     pb.CampaignState_CAMPAIGN_STATE_DRAFT,
+	mdb.CampaignScheduled: pb.CampaignState_CAMPAIGN_STATE_SCHEDULED,
+	mdb.CampaignSending:   pb.CampaignState_CAMPAIGN_STATE_SENDING,
+	mdb.CampaignSent:      pb.CampaignState_CAMPAIGN_STATE_SENT,
+}
+
+func optionalTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func mdbCampaignToPb(c *mdb.Campaign) *pb.Campaign {
+	return &pb.Campaign{
+		Id:          c.Id,
+		Name:        c.Name,
+		From:        c.From,
+		Subject:     c.Subject,
+		TextBody:    c.TextBody,
+		HtmlBody:    c.HTMLBody,
+		Segment:     &pb.Segment{List: c.Segment.List, Tag: c.Segment.Tag},
+		State:       campaignStates[c.State],
+		ScheduledAt: optionalTimestamp(c.ScheduledAt),
+		CreatedAt:   timestamppb.New(c.CreatedAt),
+		UpdatedAt:   timestamppb.New(c.UpdatedAt),
+		SentAt:      optionalTimestamp(c.SentAt),
+		Progress: &pb.CampaignProgress{
+			Total:   c.Progress.Total,
+			Pending: c.Progress.Pending,
+			Queued:  c.Progress.Queued,
+			Sent:    c.Progress.Sent,
+			Failed:  c.Progress.Failed,
+		},
+	}
+}
+
+var statsIntervals = map[pb.StatsInterval]mdb.StatsInterval{
+	pb.StatsInterval_STATS_INTERVAL_HOUR: mdb.StatsHour,
+	pb.StatsInterval_STATS_INTERVAL_DAY:  mdb.StatsDay,
+}
+
+// pbCampaignToMdb returns the content of the campaign, validated.
+func pbCampaignToMdb(c *pb.Campaign) (mdb.Campaign, error) {
+	campaign := mdb.Campaign{
+		Name:     c.Name,
+		From:     c.From,
+		Subject:  c.Subject,
+		TextBody: c.TextBody,
+		HTMLBody: c.HtmlBody,
+		Segment:  mdb.Segment{List: c.Segment.GetList(), Tag: c.Segment.GetTag()},
+	}
+	if err := campaign.Validate(); err != nil {
+		return campaign, invalidArgument("campaign", err)
+	}
+	return campaign, nil
+}
+
+// campaignError maps the errors of the campaign operations.
+func campaignError(err error, id int64, list string) error {
+	switch {
+	case errors.Is(err, mdb.ErrCampaignNotFound):
+		return resourceNotFound("campaign", strconv.FormatInt(id, 10))
+	case errors.Is(err, mdb.ErrListNotFound):
+		return resourceNotFound("list", list)
+	case errors.Is(err, mdb.ErrCampaignState):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return storageError(err)
+}
+
+func (s *MailService) CreateCampaign(ctx context.Context, r *pb.CreateCampaignRequest) (*pb.Campaign, error) {
+	campaign, err := pbCampaignToMdb(r.Campaign)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := mdb.CreateCampaign(ctx, s.db, campaign)
+	if err != nil {
+		return nil, campaignError(err, 0, campaign.Segment.List)
+	}
+	return mdbCampaignToPb(created), nil
+}
+
+func (s *MailService) GetCampaign(ctx context.Context, r *pb.GetCampaignRequest) (*pb.Campaign, error) {
+	campaign, err := mdb.GetCampaign(ctx, s.db, r.Id)
+	if err != nil {
+		return nil, campaignError(err, r.Id, "")
+	}
+	return mdbCampaignToPb(campaign), nil
+}
+
+func (s *MailService) ListCampaigns(ctx context.Context, r *pb.ListCampaignsRequest) (*pb.ListCampaignsResponse, error) {
+	var state mdb.CampaignState
+	for mdbState, pbState := range campaignStates {
+		if pbState == r.State {
+			state = mdbState
+		}
+	}
+
+	campaigns, err := mdb.GetCampaigns(ctx, s.db, state)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	res := &pb.ListCampaignsResponse{Campaigns: make([]*pb.Campaign, 0, len(campaigns))}
+	for _, campaign := range campaigns {
+		res.Campaigns = append(res.Campaigns, mdbCampaignToPb(campaign))
+	}
+	return res, nil
+}
+
+func (s *MailService) UpdateCampaign(ctx context.Context, r *pb.UpdateCampaignRequest) (*pb.Campaign, error) {
+	campaign, err := pbCampaignToMdb(r.Campaign)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := mdb.UpdateCampaign(ctx, s.db, r.Id, campaign)
+	if err != nil {
+		return nil, campaignError(err, r.Id, campaign.Segment.List)
+	}
+	return mdbCampaignToPb(updated), nil
+}
+
+func (s *MailService) DeleteCampaign(ctx context.Context, r *pb.DeleteCampaignRequest) (*emptypb.Empty, error) {
+	if err := mdb.DeleteCampaign(ctx, s.db, r.Id); err != nil {
+		return nil, campaignError(err, r.Id, "")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *MailService) ScheduleCampaign(ctx context.Context, r *pb.ScheduleCampaignRequest) (*pb.Campaign, error) {
+	campaign, err := mdb.ScheduleCampaign(ctx, s.db, r.Id, r.At.AsTime())
+	if err != nil {
+		return nil, campaignError(err, r.Id, "")
+	}
+	return mdbCampaignToPb(campaign), nil
+}
+
+func (s *MailService) UnscheduleCampaign(ctx context.Context, r *pb.UnscheduleCampaignRequest) (*pb.Campaign, error) {
+	campaign, err := mdb.UnscheduleCampaign(ctx, s.db, r.Id)
+	if err != nil {
+		return nil, campaignError(err, r.Id, "")
+	}
+	return mdbCampaignToPb(campaign), nil
+}
+
+func (s *MailService) GetCampaignStats(ctx context.Context, r *pb.GetCampaignStatsRequest) (*pb.CampaignStats, error) {
+	interval, ok := statsIntervals[r.Interval]
+	if !ok {
+		return nil, invalidArgument("interval", fmt.Errorf("unknown stats interval %v", r.Interval))
+	}
+
+	stats, err := mdb.GetCampaignStats(ctx, s.db, r.Id, interval)
+	if errors.Is(err, mdb.ErrTooManyBuckets) {
+		return nil, invalidArgument("interval", err)
+	}
+	if err != nil {
+		return nil, campaignError(err, r.Id, "")
+	}
+	res := &pb.CampaignStats{
+		CampaignId:   stats.CampaignId,
+		Interval:     r.Interval,
+		Sent:         stats.Sent,
+		Delivered:    stats.Delivered,
+		Failed:       stats.Failed,
+		Bounced:      stats.Bounced,
+		Opened:       stats.Opened,
+		Clicked:      stats.Clicked,
+		Clicks:       stats.Clicks,
+		Unsubscribed: stats.Unsubscribed,
+		Complained:   stats.Complained,
+		Series:       make([]*pb.CampaignStatsBucket, 0, len(stats.Series)),
+	}
+	for _, b := range stats.Series {
+		res.Series = append(res.Series, &pb.CampaignStatsBucket{
+			Start:        timestamppb.New(b.Start),
+			Sent:         b.Sent,
+			Bounced:      b.Bounced,
+			Opened:       b.Opened,
+			Clicked:      b.Clicked,
+			Clicks:       b.Clicks,
+			Unsubscribed: b.Unsubscribed,
+			Complained:   b.Complained,
+		})
+	}
+	return res, nil
+}
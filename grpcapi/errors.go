@@ -0,0 +1,62 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"mailinglist/mdb"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const errorDomain = "mailinglist"
+
+// invalidArgument reports a request field that failed validation.
+func invalidArgument(field string, err error) error {
+	st, _ := status.New(codes.InvalidArgument, err.Error()).WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: field, Description: err.Error()},
+		},
+	})
+	return st.Err()
+}
+
+func notFound(email string) error {
+	return resourceNotFound("email", email)
+}
+
+func resourceNotFound(resourceType, name string) error {
+	st, _ := status.New(codes.NotFound, resourceType+" not found").WithDetails(&errdetails.ResourceInfo{
+		ResourceType: resourceType,
+		ResourceName: name,
+	})
+	return st.Err()
+}
+
+// storageError maps an error returned by mdb to a gRPC status.
+func storageError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, mdb.ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case mdb.IsUniqueViolation(err):
+		return status.Error(codes.AlreadyExists, "email already exists")
+	case mdb.IsBusy(err):
+		return status.Error(codes.Aborted, "database is busy, retry the request")
+	}
+
+	st, _ := status.New(codes.Internal, "storage error").WithDetails(&errdetails.ErrorInfo{
+		Reason:   "STORAGE_ERROR",
+		Domain:   errorDomain,
+		Metadata: map[string]string{"error": err.Error()},
+	})
+	return st.Err()
+}
@@ -0,0 +1,107 @@
+package grpcapi
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"mailinglist/mdb"
+	pb "mailinglist/proto/mailinglist/v1"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// exportBatchSize is how many emails are read from the database at once.
+	exportBatchSize = 500
+	// exportChunkSize is the size above which the buffered rows are sent.
+	exportChunkSize = 64 << 10
+)
+
+var exportHeader = []string{"id", "email", "confirmed_at", "opt_out", "opt_out_reason", "created_at"}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.Unix() == 0 {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func csvRecord(entry *mdb.EmailEntry) []string {
+	return []string{
+		strconv.FormatInt(entry.Id, 10),
+		entry.Email,
+		formatTime(entry.ConfirmedAt),
+		strconv.FormatBool(entry.OptOut),
+		entry.OptOutReason,
+		formatTime(entry.CreatedAt),
+	}
+}
+
+func (s *MailService) ExportEmails(r *pb.ExportRequest, stream pb.MailingListService_ExportEmailsServer) error {
+	if _, ok := pb.ExportFormat_name[int32(r.Format)]; !ok {
+		return invalidArgument("format", fmt.Errorf("unknown format %v", r.Format))
+	}
+
+	buf := new(bytes.Buffer)
+	csvWriter := csv.NewWriter(buf)
+	var lastId int64
+
+	flush := func(force bool) error {
+		csvWriter.Flush()
+		if buf.Len() == 0 || (!force && buf.Len() < exportChunkSize) {
+			return nil
+		}
+		// Send marshals the chunk before returning, so the buffer can be reused.
+		if err := stream.Send(&pb.ExportChunk{Data: buf.Bytes(), LastId: lastId}); err != nil {
+			return err
+		}
+		buf.Reset()
+		return nil
+	}
+
+	if r.Format == pb.ExportFormat_EXPORT_FORMAT_CSV && r.AfterId == 0 {
+		csvWriter.Write(exportHeader)
+	}
+
+	params := mdb.GetBatchEmailQueryParams{
+		Page:              1,
+		Count:             exportBatchSize,
+		AfterId:           r.AfterId,
+		ConfirmedOnly:     r.ConfirmedOnly,
+		IncludeOptOut:     r.IncludeOptOut,
+		IncludeComplained: r.IncludeComplained,
+		Tag:               r.Tag,
+		Org:               orgFromContext(stream.Context()),
+	}
+	for {
+		entries, err := mdb.GetEmailBatch(stream.Context(), s.db, params)
+		if err != nil {
+			return storageError(err)
+		}
+
+		for _, entry := range entries {
+			if r.Format == pb.ExportFormat_EXPORT_FORMAT_NDJSON {
+				line, err := protojson.Marshal(mdbEntryToPb(entry))
+				if err != nil {
+					return err
+				}
+				buf.Write(line)
+				buf.WriteByte('\n')
+			} else {
+				csvWriter.Write(csvRecord(entry))
+			}
+			lastId = entry.Id
+
+			if err := flush(false); err != nil {
+				return err
+			}
+			params.AfterId = entry.Id
+		}
+
+		if len(entries) < exportBatchSize {
+			return flush(true)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package grpcapi
+
+import (
+	"context"
+	"mailinglist/flags"
+	"mailinglist/state"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flagMethods are the RPCs rejected while their flag is off.
+var flagMethods = map[string]string{
+	"/mailinglist.v1.MailingListService/CreateEmail":        flags.Signup,
+	"/mailinglist.v1.MailingListService/ResendConfirmation": flags.Signup,
+
+	"/mailinglist.v1.MailingListService/BulkCreateEmails": flags.Imports,
+	"/mailinglist.v1.MailingListService/ImportEmails":     flags.Imports,
+}
+
+func checkFlag(st *state.State, method string) error {
+	name, ok := flagMethods[method]
+	if !ok {
+		return nil
+	}
+	if err := st.Flags().Check(name); err != nil {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return nil
+}
+
+func flagInterceptor(st *state.State) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := checkFlag(st, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func flagStreamInterceptor(st *state.State) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkFlag(st, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
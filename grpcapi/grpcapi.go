@@ -3,132 +3,616 @@ package grpcapi
 import (
 	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mailinglist/confirmation"
+	"mailinglist/handoff"
+	"mailinglist/logging"
 	"mailinglist/mdb"
-	"mailinglist/proto"
+	"mailinglist/personalize"
+	pb "mailinglist/proto/mailinglist/v1"
+	"mailinglist/ratelimit"
+	"mailinglist/redisstore"
+	"mailinglist/sanitize"
+	"mailinglist/state"
+	"mailinglist/tlsutil"
 	"net"
 	"os"
 	"time"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	// Registers gzip, so that responses are compressed for clients sending
+	// compressed requests.
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type MailService struct {
-	proto.UnimplementedMailingListServiceServer
+	pb.UnimplementedMailingListServiceServer
 	db     *sql.DB
-	logger *log.Logger
+	logger *slog.Logger
+	events *eventHub
+	cache  *redisstore.Store
+	links  *personalize.Personalizer
+	// confirmations is nil when no confirmations are sent.
+	confirmations *confirmation.Sender
 }
 
-func Serve(db *sql.DB, bind string) *grpc.Server {
-	logger := log.New(os.Stdout, "gRPC mail service -> ", log.Ldate|log.Ltime)
+// Options controls optional behavior of the gRPC server.
+type Options struct {
+	// State is shared with the JSON API server.
+	State *state.State
+	// RequireApiKey rejects calls without an API key in their metadata.
+	RequireApiKey bool
+	// TLSCertFile and TLSKeyFile enable TLS. With TLSClientCAFile set,
+	// clients must also present a certificate signed by that CA.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
 
-	listener, err := net.Listen("tcp", bind)
+	// MaxRecvMsgSize and MaxSendMsgSize limit message sizes in bytes, and
+	// MaxConcurrentStreams the streams per connection. Zero keeps the
+	// gRPC defaults.
+	MaxRecvMsgSize       int
+	MaxSendMsgSize       int
+	MaxConcurrentStreams uint32
+	// KeepaliveTime is how long a connection may be idle before the server
+	// pings the client, which must answer within KeepaliveTimeout. Clients
+	// pinging more often than KeepaliveMinTime are disconnected.
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+	KeepaliveMinTime time.Duration
+	// RateLimiter throttles callers by API key, organization or address.
+	// It is shared with the JSON API server.
+	RateLimiter *ratelimit.Limiter
+	// TrustedProxies are believed to tell the address of their callers in
+	// x-forwarded-for for the rate limits, as is the REST gateway sending
+	// GatewayToken.
+	TrustedProxies ratelimit.Proxies
+	GatewayToken   string
+	// UnixSocket, when set, is the path of a unix socket the server also
+	// listens on, next to the TCP bind address.
+	UnixSocket string
+	// Redis, when set, caches the emails of GetEmail, and keeps the
+	// idempotency keys of the unary writes for all the servers.
+	Redis *redisstore.Store
+	// Links checks the signed tokens of ConfirmEmail and UnsubscribeEmail.
+	Links *personalize.Personalizer
+	// Confirmations, when set, sends the emails of CreateEmail their
+	// confirmation, again with ResendConfirmation.
+	Confirmations *confirmation.Sender
+	// Logger logs the calls, the default logger when nil.
+	Logger *slog.Logger
+}
+
+// listenUnix listens on the unix socket at path, replacing the socket left
+// behind by a previous run, unless handed over by the previous process.
+// Only the owner and its group may connect.
+func listenUnix(path string) (net.Listener, error) {
+	if listener, ok, err := handoff.Inherited("unix", path); ok || err != nil {
+		return listener, err
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return handoff.Register("unix", path, listener), nil
+}
+
+// limitOptions turns the size, concurrency and keepalive options into
+// server options.
+func limitOptions(opts Options) []grpc.ServerOption {
+	var serverOpts []grpc.ServerOption
+
+	if opts.MaxRecvMsgSize > 0 {
+		serverOpts = append(serverOpts, grpc.MaxRecvMsgSize(opts.MaxRecvMsgSize))
+	}
+	if opts.MaxSendMsgSize > 0 {
+		serverOpts = append(serverOpts, grpc.MaxSendMsgSize(opts.MaxSendMsgSize))
+	}
+	if opts.MaxConcurrentStreams > 0 {
+		serverOpts = append(serverOpts, grpc.MaxConcurrentStreams(opts.MaxConcurrentStreams))
+	}
+
+	serverOpts = append(serverOpts,
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    opts.KeepaliveTime,
+			Timeout: opts.KeepaliveTimeout,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             opts.KeepaliveMinTime,
+			PermitWithoutStream: true,
+		}),
+	)
+	return serverOpts
+}
+
+func Serve(db *sql.DB, bind string, opts Options) *Server {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("server", "grpc")
+
+	listener, err := handoff.Listen("tcp", bind)
 	if err != nil {
-		logger.Fatalf("gRPC error, failed to start : %v\n", err)
+		logger.Error("gRPC error, failed to start", "err", err)
+		os.Exit(1)
+	}
+
+	auth := &authenticator{db: db, st: opts.State, requireAuth: opts.RequireApiKey}
+	calls := newCallTracker()
+	audit := &auditor{db: db, logger: logger}
+	forwarders := &forwarders{gatewayToken: opts.GatewayToken, proxies: opts.TrustedProxies}
+
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			otelgrpc.UnaryServerInterceptor(),
+			calls.unaryInterceptor,
+			loggingInterceptor(logger),
+			recoveryInterceptor,
+			readOnlyInterceptor(opts.State),
+			flagInterceptor(opts.State),
+			auth.unaryInterceptor,
+			rateLimitInterceptor(opts.RateLimiter, forwarders),
+			idempotencyInterceptor(opts.Redis),
+			audit.unaryInterceptor,
+			validationInterceptor,
+		),
+		grpc.ChainStreamInterceptor(
+			otelgrpc.StreamServerInterceptor(),
+			calls.streamInterceptor,
+			loggingStreamInterceptor(logger),
+			recoveryStreamInterceptor,
+			readOnlyStreamInterceptor(opts.State),
+			flagStreamInterceptor(opts.State),
+			auth.streamInterceptor,
+			rateLimitStreamInterceptor(opts.RateLimiter, forwarders),
+			audit.streamInterceptor,
+			validationStreamInterceptor,
+		),
+	}
+
+	serverOpts = append(serverOpts, limitOptions(opts)...)
+
+	if opts.TLSCertFile != "" {
+		tlsConfig, err := tlsutil.ServerConfig(opts.TLSCertFile, opts.TLSKeyFile, opts.TLSClientCAFile)
+		if err != nil {
+			logger.Error("gRPC error, failed to load TLS configuration", "err", err)
+			os.Exit(1)
+		}
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
 	}
 
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(serverOpts...)
 
 	mailService := MailService{
 		db:     db,
 		logger: logger,
+		events: newEventHub(db, logger),
+		cache:  opts.Redis,
+		links:  opts.Links,
+
+		confirmations: opts.Confirmations,
 	}
+	go mailService.events.run()
 
-	proto.RegisterMailingListServiceServer(grpcServer, &mailService)
+	pb.RegisterMailingListServiceServer(grpcServer, &mailService)
+	reflection.Register(grpcServer)
 
-	logger.Printf("gRPC API service starting on %v\n", bind)
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	healthCtx, stopHealth := context.WithCancel(context.Background())
+	go watchHealth(healthCtx, db, opts.State, healthServer, logger)
 
 	go func() {
-		log.Printf("Starting gRPC server on port %v...\n ", bind)
+		logger.Info("starting server", "addr", bind)
 		if err = grpcServer.Serve(listener); err != nil {
-			logger.Fatalf("gRPC error: %v\n", err)
+			logger.Error("gRPC error", "err", err)
+			os.Exit(1)
 		}
 	}()
 
-	return grpcServer
+	if opts.UnixSocket != "" {
+		unixListener, err := listenUnix(opts.UnixSocket)
+		if err != nil {
+			logger.Error("gRPC error, failed to listen", "socket", opts.UnixSocket, "err", err)
+			os.Exit(1)
+		}
+
+		go func() {
+			logger.Info("starting server", "socket", opts.UnixSocket)
+			if err := grpcServer.Serve(unixListener); err != nil {
+				logger.Error("gRPC error", "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	return &Server{Server: grpcServer, logger: logger, health: healthServer, stopHealth: stopHealth, calls: calls}
 }
 
-func pbEntryToMdb(pb *proto.EmailEntry) *mdb.EmailEntry {
-	t := time.Unix(pb.ConfirmedAt, 0)
+func pbEntryToMdb(pbEntry *pb.EmailEntry) *mdb.EmailEntry {
+	t := time.Unix(0, 0)
+	if pbEntry.ConfirmedAt != nil {
+		t = pbEntry.ConfirmedAt.AsTime()
+	}
 
-	mdbEntry := mdb.EmailEntry{Id: pb.Id, Email: pb.Email, ConfirmedAt: &t, OptOut: pb.OptOut}
+	mdbEntry := mdb.EmailEntry{Id: pbEntry.Id, Email: pbEntry.Email, ConfirmedAt: &t, OptOut: pbEntry.OptOut}
 	return &mdbEntry
 }
 
-func mdbEntryToPb(mdbEntry *mdb.EmailEntry) *proto.EmailEntry {
-	return &proto.EmailEntry{
-		Id:          mdbEntry.Id,
-		Email:       mdbEntry.Email,
-		ConfirmedAt: mdbEntry.ConfirmedAt.Unix(),
-		OptOut:      mdbEntry.OptOut,
+func mdbEntryToPb(mdbEntry *mdb.EmailEntry) *pb.EmailEntry {
+	pbEntry := &pb.EmailEntry{
+		Id:           mdbEntry.Id,
+		Email:        mdbEntry.Email,
+		OptOut:       mdbEntry.OptOut,
+		OptOutReason: mdbEntry.OptOutReason,
+	}
+	if mdbEntry.CreatedAt != nil {
+		pbEntry.CreatedAt = timestamppb.New(*mdbEntry.CreatedAt)
 	}
+	// The database stores 0 for emails that are not confirmed yet.
+	if mdbEntry.ConfirmedAt != nil && mdbEntry.ConfirmedAt.Unix() != 0 {
+		pbEntry.ConfirmedAt = timestamppb.New(*mdbEntry.ConfirmedAt)
+	}
+	return pbEntry
+}
+
+func emailResponse(ctx context.Context, db *sql.DB, email string) (*pb.EmailResponse, error) {
+	entry, err := mdb.GetEmail(ctx, db, email)
+	return entryResponse(email, entry, err)
 }
 
-func emailResponse(db *sql.DB, email string) (*proto.EmailResponse, error) {
-	entry, err := mdb.GetEmail(db, email)
+// entryResponse turns the entry read for the email into a response.
+func entryResponse(email string, entry *mdb.EmailEntry, err error) (*pb.EmailResponse, error) {
 	if err != nil {
-		return &proto.EmailResponse{}, err
+		return nil, storageError(err)
 	}
 	if entry == nil {
-		return &proto.EmailResponse{}, nil
+		return nil, notFound(email)
 	}
 
 	res := mdbEntryToPb(entry)
-	return &proto.EmailResponse{EmailEntry: res}, nil
+	return &pb.EmailResponse{EmailEntry: res}, nil
 }
 
-func (s *MailService) CreateEmail(ctx context.Context, r *proto.CreateEmailRequest) (*proto.EmailResponse, error) {
-	s.logger.Printf("Create email: %v\n", r.EmailAddr)
+// CreateEmail creates the email. The organization keys get the address
+// alone, whether it was created or already existed, for the emails of the
+// other organizations not to be disclosed.
+func (s *MailService) CreateEmail(ctx context.Context, r *pb.CreateEmailRequest) (*pb.EmailResponse, error) {
+	var err error
+	if apiKey := apiKeyFromContext(ctx); apiKey != nil {
+		err = mdb.CreateEmailForApiKey(ctx, s.db, r.EmailAddr, *apiKey)
+	} else {
+		err = mdb.CreateEmail(ctx, s.db, r.EmailAddr)
+	}
+	scoped := orgFromContext(ctx) != ""
+	if scoped && mdb.IsUniqueViolation(err) {
+		return &pb.EmailResponse{EmailEntry: &pb.EmailEntry{Email: r.EmailAddr}}, nil
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	if s.confirmations != nil {
+		if err := s.confirmations.Send(ctx, r.EmailAddr); err != nil {
+			logging.FromContext(ctx).Warn("queueing confirmation failed", "email", r.EmailAddr, "err", err)
+		}
+	}
+
+	if scoped {
+		return &pb.EmailResponse{EmailEntry: &pb.EmailEntry{Email: r.EmailAddr}}, nil
+	}
 
-	if err := mdb.CreateEmail(s.db, r.EmailAddr); err != nil {
-		return &proto.EmailResponse{}, err
+	res, err := emailResponse(ctx, s.db, r.EmailAddr)
+	if err != nil {
+		return nil, err
 	}
 
-	return emailResponse(s.db, r.EmailAddr)
+	tokens, err := mdb.GetEmailTokens(ctx, s.db, r.EmailAddr)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	res.UnsubscribeToken = tokens.UnsubscribeToken
+	return res, nil
 }
 
-func (s *MailService) UpdateEmail(ctx context.Context, r *proto.UpdateEmailRequest) (*proto.EmailResponse, error) {
-	s.logger.Printf("Update email for %v\n", r.EmailEntry)
+func (s *MailService) UpdateEmail(ctx context.Context, r *pb.UpdateEmailRequest) (*pb.EmailResponse, error) {
+	if err := sanitize.Email("email_entry.email", r.EmailEntry.Email); err != nil {
+		return nil, invalidArgument("email_entry.email", err)
+	}
+	if err := s.checkOrg(ctx, r.EmailEntry.Email); err != nil {
+		return nil, err
+	}
 
 	mdbEntry := pbEntryToMdb(r.EmailEntry)
 
-	if err := mdb.UpsertEmail(s.db, *mdbEntry); err != nil {
-		return &proto.EmailResponse{}, err
+	if err := mdb.UpsertEmail(ctx, s.db, *mdbEntry); err != nil {
+		return nil, storageError(err)
+	}
+
+	return emailResponse(ctx, s.db, mdbEntry.Email)
+}
+
+func (s *MailService) DeleteEmail(ctx context.Context, r *pb.DeleteEmailRequest) (*pb.EmailResponse, error) {
+	if err := s.checkOrg(ctx, r.EmailAddr); err != nil {
+		return nil, err
+	}
+
+	res, err := emailResponse(ctx, s.db, r.EmailAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mdb.DeleteEmailByEmail(ctx, s.db, r.EmailAddr); err != nil {
+		return nil, storageError(err)
+	}
+	return res, nil
+}
+
+// ConfirmEmail takes the confirm token of a confirmation link, whose
+// signature is checked when the server has a link secret, the bare tokens
+// being refused then. CreateEmail does not return it, for the email to be
+// confirmed by its owner.
+func (s *MailService) ConfirmEmail(ctx context.Context, r *pb.ConfirmEmailRequest) (*pb.EmailResponse, error) {
+	token := r.Token
+	if s.links != nil {
+		var err error
+		token, err = s.links.ConfirmToken(token)
+		if errors.Is(err, personalize.ErrLinkExpired) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		if err != nil {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+	}
+	email, err := mdb.ConfirmEmail(ctx, s.db, token)
+	if errors.Is(err, mdb.ErrInvalidToken) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return emailResponse(ctx, s.db, email)
+}
+
+func (s *MailService) ResendConfirmation(ctx context.Context, r *pb.ResendConfirmationRequest) (*pb.EmailResponse, error) {
+	if s.confirmations == nil {
+		return nil, status.Error(codes.FailedPrecondition, "confirmation emails are not sent by this server")
+	}
+	if err := s.checkOrg(ctx, r.EmailAddr); err != nil {
+		return nil, err
+	}
+	err := s.confirmations.Send(ctx, r.EmailAddr)
+	if errors.Is(err, mdb.ErrEmailNotFound) {
+		return nil, notFound(r.EmailAddr)
+	}
+	if errors.Is(err, confirmation.ErrNotPending) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if errors.Is(err, confirmation.ErrTooSoon) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return emailResponse(ctx, s.db, r.EmailAddr)
+}
+
+func (s *MailService) UnsubscribeEmail(ctx context.Context, r *pb.UnsubscribeEmailRequest) (*pb.EmailResponse, error) {
+	switch target := r.Target.(type) {
+	case *pb.UnsubscribeEmailRequest_Token:
+		token := target.Token
+		if s.links != nil {
+			var err error
+			if token, err = s.links.UnsubscribeToken(token); err != nil {
+				return nil, status.Error(codes.NotFound, err.Error())
+			}
+		}
+		email, err := mdb.UnsubscribeByToken(ctx, s.db, token)
+		if errors.Is(err, mdb.ErrInvalidToken) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		if err != nil {
+			return nil, storageError(err)
+		}
+		return emailResponse(ctx, s.db, email)
+
+	case *pb.UnsubscribeEmailRequest_EmailAddr:
+		if err := s.checkOrg(ctx, target.EmailAddr); err != nil {
+			return nil, err
+		}
+		res, err := emailResponse(ctx, s.db, target.EmailAddr)
+		if err != nil {
+			return nil, err
+		}
+		if err := mdb.OptOutEmail(ctx, s.db, target.EmailAddr, mdb.UnsubscribeReason); err != nil {
+			return nil, storageError(err)
+		}
+		res.EmailEntry.OptOut = true
+		res.EmailEntry.OptOutReason = mdb.UnsubscribeReason
+		return res, nil
 	}
 
-	return emailResponse(s.db, mdbEntry.Email)
+	return nil, invalidArgument("target", errors.New("email_addr or token is required"))
 }
 
-func (s *MailService) DeleteEmail(ctx context.Context, r *proto.DeleteEmailRequest) (*proto.EmailResponse, error) {
-	s.logger.Printf("Delete email for %v\n", r.EmailAddr)
+func (s *MailService) BulkUnsubscribe(ctx context.Context, r *pb.BulkUnsubscribeRequest) (*pb.BulkUnsubscribeResponse, error) {
+	if (len(r.EmailAddrs) == 0) == (r.Domain == "") {
+		return nil, invalidArgument("email_addrs", errors.New("exactly one of email_addrs or domain is required"))
+	}
+	if r.Domain != "" {
+		if err := sanitize.Check("domain", r.Domain); err != nil {
+			return nil, invalidArgument("domain", err)
+		}
+	}
 
-	if err := mdb.DeleteEmailByEmail(s.db, r.EmailAddr); err != nil {
-		return &proto.EmailResponse{}, err
+	affected, err := mdb.BulkUnsubscribe(ctx, s.db, r.EmailAddrs, r.Domain, r.Delete)
+	if err != nil {
+		return nil, storageError(err)
 	}
-	return emailResponse(s.db, r.EmailAddr)
+	return &pb.BulkUnsubscribeResponse{Affected: affected}, nil
 }
 
-func (s *MailService) GetEmail(ctx context.Context, r *proto.GetEmailRequest) (*proto.EmailResponse, error) {
-	s.logger.Printf("Get email: %v\n", r.EmailAddr)
-	return emailResponse(s.db, r.EmailAddr)
+func (s *MailService) GetEmail(ctx context.Context, r *pb.GetEmailRequest) (*pb.EmailResponse, error) {
+	if err := s.checkOrg(ctx, r.EmailAddr); err != nil {
+		return nil, err
+	}
+	entry, err := s.cache.GetEmail(ctx, s.db, r.EmailAddr)
+	return entryResponse(r.EmailAddr, entry, err)
 }
 
-func (s *MailService) GetEmailBatch(ctx context.Context, r *proto.GetEmailBatchRequest) (*proto.GetEmailBatchResponse, error) {
-	s.logger.Printf("GetEmailBatch: count %v, page: %v\n", r.Count, r.Page)
+func (s *MailService) GetEmailBatch(ctx context.Context, r *pb.GetEmailBatchRequest) (*pb.GetEmailBatchResponse, error) {
+	page, count := int32(1), int32(defaultBatchCount)
+	if r.Page != nil {
+		page = *r.Page
+	}
+	if r.Count != nil {
+		count = *r.Count
+	}
+
+	sort, ok := emailSorts[r.Sort]
+	if !ok {
+		return nil, invalidArgument("sort", fmt.Errorf("unknown sort %v", r.Sort))
+	}
 
 	params := mdb.GetBatchEmailQueryParams{
-		Count: int(r.Count),
-		Page:  int(r.Page),
+		Count:             int(count),
+		Page:              int(page),
+		ConfirmedOnly:     r.ConfirmedOnly,
+		IncludeOptOut:     r.IncludeOptOut,
+		IncludeComplained: r.IncludeComplained,
+		Tag:               r.Tag,
+		Query:             r.Query,
+		Sort:              sort,
+		Org:               orgFromContext(ctx),
+	}
+	if r.CreatedAfter != nil {
+		params.CreatedAfter = r.CreatedAfter.AsTime()
+	}
+	if r.PageToken != "" {
+		token, err := decodePageToken(r.PageToken)
+		if err != nil {
+			return nil, invalidArgument("page_token", err)
+		}
+		page = token.page
+		params.Page = int(token.page)
+		params.AfterId = token.afterId
+	}
+
+	total, err := mdb.CountEmails(ctx, s.db, params)
+	if err != nil {
+		return nil, storageError(err)
 	}
 
-	entries, err := mdb.GetEmailBatch(s.db, params)
+	entries, err := mdb.GetEmailBatch(ctx, s.db, params)
 	if err != nil {
-		return &proto.GetEmailBatchResponse{}, err
+		return nil, storageError(err)
 	}
 
-	pbEntries := make([]*proto.EmailEntry, 0, len(entries))
+	pbEntries := make([]*pb.EmailEntry, 0, len(entries))
 	for _, entry := range entries {
 		pbEntries = append(pbEntries, mdbEntryToPb(entry))
 	}
-	return &proto.GetEmailBatchResponse{EmailEntries: pbEntries}, nil
+
+	res := &pb.GetEmailBatchResponse{EmailEntries: pbEntries}
+	paginate(res, page, count, total, sort == mdb.SortId)
+	return res, nil
+}
+
+func (s *MailService) StreamEmails(r *pb.GetEmailBatchRequest, stream pb.MailingListService_StreamEmailsServer) error {
+	batchSize := 100
+	if r.Count != nil {
+		batchSize = int(*r.Count)
+	}
+
+	err := mdb.StreamEmails(stream.Context(), s.db, batchSize, func(entry *mdb.EmailEntry) error {
+		return stream.Send(mdbEntryToPb(entry))
+	})
+	if err != nil {
+		return storageError(err)
+	}
+	return nil
+}
+
+// bulkCreateBatchSize is how many streamed emails are committed per transaction.
+const bulkCreateBatchSize = 500
+
+// errSubscriberQuota is reported for the emails of BulkCreateEmails and
+// ImportEmails beyond the subscriber quota of the API key.
+var errSubscriberQuota = errors.New("subscriber quota exceeded")
+
+func (s *MailService) BulkCreateEmails(stream pb.MailingListService_BulkCreateEmailsServer) error {
+	summary := &pb.BulkCreateSummary{}
+	batch := make([]string, 0, bulkCreateBatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		created, overQuota, err := mdb.CreateEmails(stream.Context(), s.db, batch, apiKeyFromContext(stream.Context()))
+		if err != nil {
+			return storageError(err)
+		}
+		for _, email := range overQuota {
+			summary.Errors = append(summary.Errors, &pb.BulkCreateError{EmailAddr: email, Error: errSubscriberQuota.Error()})
+		}
+		summary.Created += created
+		summary.Duplicates += int64(len(batch)-len(overQuota)) - created
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		// RecvMsg rather than Recv keeps the invalid emails, to report them.
+		r := &pb.CreateEmailRequest{}
+		err := stream.RecvMsg(r)
+		if err == io.EOF {
+			break
+		}
+		var invalid *invalidMessage
+		if errors.As(err, &invalid) {
+			summary.Received++
+			summary.Errors = append(summary.Errors, &pb.BulkCreateError{EmailAddr: r.EmailAddr, Error: status.Convert(err).Message()})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		summary.Received++
+
+		batch = append(batch, r.EmailAddr)
+		if len(batch) == bulkCreateBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(summary)
 }
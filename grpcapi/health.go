@@ -0,0 +1,59 @@
+package grpcapi
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"mailinglist/state"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	serviceName         = "mailinglist.v1.MailingListService"
+	healthCheckInterval = 5 * time.Second
+	healthCheckTimeout  = 2 * time.Second
+)
+
+func checkDatabase(ctx context.Context, db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	var tables int
+	return db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master`).Scan(&tables)
+}
+
+// watchHealth periodically checks the database and reports the mail service,
+// and the server as a whole, as NOT_SERVING while it is unreachable or the
+// server is a lame duck, until the context is done.
+func watchHealth(ctx context.Context, db *sql.DB, st *state.State, healthServer *health.Server, logger *slog.Logger) {
+	serving := healthpb.HealthCheckResponse_UNKNOWN
+
+	for {
+		// Taken first not to miss a change during the check.
+		changed := st.LameDuckChanged()
+		next := healthpb.HealthCheckResponse_SERVING
+		if st.LameDuck() {
+			next = healthpb.HealthCheckResponse_NOT_SERVING
+		} else if err := checkDatabase(ctx, db); err != nil {
+			next = healthpb.HealthCheckResponse_NOT_SERVING
+			logger.Error("health check failed", "err", err)
+		}
+
+		if next != serving {
+			logger.Info("health status changed", "status", next.String())
+			healthServer.SetServingStatus("", next)
+			healthServer.SetServingStatus(serviceName, next)
+			serving = next
+		}
+
+		select {
+		case <-changed:
+		case <-time.After(healthCheckInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mailinglist/logging"
+	"mailinglist/redisstore"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// idempotencyInterceptor runs the unary writes with an idempotency-key in
+// their metadata once, across the servers sharing the store, replaying the
+// response to the retries. The keys are scoped to the verified API key and
+// the method, the calls without a key, which nothing tells apart, running
+// every time. Failed calls are not saved and run again when retried.
+func idempotencyInterceptor(store *redisstore.Store) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get("idempotency-key")
+		apiKey := apiKeyFromContext(ctx)
+		if store == nil || apiKey == nil || len(values) == 0 || values[0] == "" || !writeMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		logger := logging.FromContext(ctx)
+		key := fmt.Sprintf("%d\x00%s\x00%s", apiKey.Id, info.FullMethod, values[0])
+		saved, err := store.ClaimIdempotencyKey(ctx, key)
+		if errors.Is(err, redisstore.ErrInProgress) {
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+		if err != nil {
+			logger.Warn("idempotency key not checked", "err", err)
+			return handler(ctx, req)
+		}
+
+		if saved != nil {
+			response := &anypb.Any{}
+			if err := proto.Unmarshal(saved, response); err == nil {
+				if res, err := response.UnmarshalNew(); err == nil {
+					logger.Info("idempotent call replayed")
+					grpc.SetHeader(ctx, metadata.Pairs("idempotent-replayed", "true"))
+					return res, nil
+				}
+			}
+		}
+
+		res, err := handler(ctx, req)
+
+		// The key is settled even if the client has gone.
+		settle := context.WithoutCancel(ctx)
+		var storeErr error
+		if err != nil {
+			storeErr = store.ReleaseIdempotencyKey(settle, key)
+		} else if msg, ok := res.(proto.Message); ok {
+			response, anyErr := anypb.New(msg)
+			var data []byte
+			if anyErr == nil {
+				data, anyErr = proto.Marshal(response)
+			}
+			if anyErr == nil {
+				storeErr = store.SaveIdempotencyKey(settle, key, data)
+			} else {
+				storeErr = errors.Join(anyErr, store.ReleaseIdempotencyKey(settle, key))
+			}
+		}
+		if storeErr != nil {
+			logger.Warn("idempotency key not saved", "err", storeErr)
+		}
+		return res, err
+	}
+}
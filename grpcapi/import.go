@@ -0,0 +1,127 @@
+package grpcapi
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"mailinglist/mdb"
+	pb "mailinglist/proto/mailinglist/v1"
+	"mailinglist/sanitize"
+	"strings"
+)
+
+// maxImportErrors caps the invalid rows listed in an import summary.
+const maxImportErrors = 1000
+
+// chunkReader reads the data of the chunks received on an import stream.
+type chunkReader struct {
+	stream pb.MailingListService_ImportEmailsServer
+	buf    []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		chunk, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = chunk.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (s *MailService) ImportEmails(stream pb.MailingListService_ImportEmailsServer) error {
+	summary := &pb.ImportSummary{}
+	batch := make([]string, 0, bulkCreateBatchSize)
+	// rows are the rows of the emails of the batch, to report them.
+	rows := make(map[string]int, bulkCreateBatchSize)
+
+	rowError := func(row int, email, msg string) {
+		summary.Invalid++
+		if len(summary.Errors) < maxImportErrors {
+			summary.Errors = append(summary.Errors, &pb.ImportRowError{Row: int64(row), EmailAddr: email, Error: msg})
+		}
+	}
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		created, overQuota, err := mdb.CreateEmails(stream.Context(), s.db, batch, apiKeyFromContext(stream.Context()))
+		if err != nil {
+			return storageError(err)
+		}
+		for _, email := range overQuota {
+			rowError(rows[email], email, errSubscriberQuota.Error())
+		}
+		summary.Created += created
+		summary.Duplicates += int64(len(batch)-len(overQuota)) - created
+		batch = batch[:0]
+		clear(rows)
+		return nil
+	}
+
+	reader := csv.NewReader(&chunkReader{stream: stream})
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	column := 0
+	first := true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			summary.Rows++
+			rowError(parseErr.StartLine, "", parseErr.Err.Error())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		row, _ := reader.FieldPos(0)
+
+		if first {
+			first = false
+			header := false
+			for i, name := range record {
+				if strings.EqualFold(strings.TrimSpace(name), "email") {
+					column, header = i, true
+					break
+				}
+			}
+			if header {
+				continue
+			}
+		}
+
+		summary.Rows++
+		if column >= len(record) {
+			rowError(row, "", "missing email column")
+			continue
+		}
+		email := strings.TrimSpace(record[column])
+		if err := sanitize.Email("email", email); err != nil {
+			rowError(row, email, err.Error())
+			continue
+		}
+
+		batch = append(batch, email)
+		rows[email] = row
+		if len(batch) == bulkCreateBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(summary)
+}
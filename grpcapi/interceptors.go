@@ -0,0 +1,105 @@
+package grpcapi
+
+import (
+	"context"
+	"log/slog"
+	"mailinglist/crash"
+	"mailinglist/logging"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	rpcHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_handled_total",
+		Help: "Total number of RPCs completed on the server, by method and status code.",
+	}, []string{"method", "code"})
+
+	rpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_handling_seconds",
+		Help:    "Latency of RPCs handled by the server, by method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+func logRPC(logger *slog.Logger, method string, start time.Time, err error) {
+	code := status.Code(err)
+	elapsed := time.Since(start)
+
+	rpcHandled.WithLabelValues(method, code.String()).Inc()
+	rpcDuration.WithLabelValues(method).Observe(elapsed.Seconds())
+
+	if err != nil {
+		logger.Info("rpc", "code", code.String(), "elapsed", elapsed, "err", err)
+	} else {
+		logger.Info("rpc", "code", code.String(), "elapsed", elapsed)
+	}
+}
+
+// requestId returns the id of the call, the x-request-id metadata sent by
+// the client, or by the REST gateway, when valid.
+func requestId(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	sent := ""
+	if values := md.Get("x-request-id"); len(values) > 0 {
+		sent = values[0]
+	}
+	return logging.NewRequestId(sent)
+}
+
+// loggingInterceptor logs every RPC with its latency and status code and
+// records them as Prometheus metrics. The handlers get a logger with the
+// method and the request id in their context, the id being returned in
+// the x-request-id header.
+func loggingInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		id := requestId(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+		rpcLogger := logger.With("method", info.FullMethod, "request_id", id)
+		res, err := handler(logging.NewContext(logging.WithRequestId(ctx, id), rpcLogger), req)
+		logRPC(rpcLogger, info.FullMethod, start, err)
+		return res, err
+	}
+}
+
+func loggingStreamInterceptor(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		id := requestId(ss.Context())
+		ss.SetHeader(metadata.Pairs("x-request-id", id))
+		rpcLogger := logger.With("method", info.FullMethod, "request_id", id)
+		ctx := logging.NewContext(logging.WithRequestId(ss.Context(), id), rpcLogger)
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		logRPC(rpcLogger, info.FullMethod, start, err)
+		return err
+	}
+}
+
+// recoveryInterceptor turns a panic in a handler into an Internal error
+// instead of crashing the server process, logging and reporting it.
+func recoveryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			crash.Handle(ctx, r, "method", info.FullMethod)
+			err = status.Error(codes.Internal, "internal server error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+func recoveryStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			crash.Handle(ss.Context(), r, "method", info.FullMethod)
+			err = status.Error(codes.Internal, "internal server error")
+		}
+	}()
+	return handler(srv, ss)
+}
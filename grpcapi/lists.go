@@ -0,0 +1,174 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mailinglist/mdb"
+	pb "mailinglist/proto/mailinglist/v1"
+	"mailinglist/sanitize"
+	"regexp"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func mdbListToPb(list *mdb.MailingList) *pb.MailingList {
+	return &pb.MailingList{
+		Id:          list.Id,
+		Name:        list.Name,
+		CreatedAt:   timestamppb.New(list.CreatedAt),
+		MemberCount: list.MemberCount,
+	}
+}
+
+// checkName validates a list name or a tag.
+func checkName(field, value string) error {
+	if value == "" {
+		return invalidArgument(field, errors.New(field+" is required"))
+	}
+	if err := sanitize.Check(field, value); err != nil {
+		return invalidArgument(field, err)
+	}
+	return nil
+}
+
+// membershipError maps the not found errors of list and tag operations.
+func membershipError(err error, list, email string) error {
+	switch {
+	case errors.Is(err, mdb.ErrListNotFound):
+		return resourceNotFound("list", list)
+	case errors.Is(err, mdb.ErrEmailNotFound):
+		return notFound(email)
+	}
+	return storageError(err)
+}
+
+func (s *MailService) CreateList(ctx context.Context, r *pb.CreateListRequest) (*pb.MailingList, error) {
+	if err := checkName("name", r.Name); err != nil {
+		return nil, err
+	}
+
+	list, err := mdb.CreateList(ctx, s.db, r.Name)
+	if mdb.IsUniqueViolation(err) {
+		return nil, status.Errorf(codes.AlreadyExists, "list %v already exists", r.Name)
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return mdbListToPb(list), nil
+}
+
+func (s *MailService) ListLists(ctx context.Context, r *pb.ListListsRequest) (*pb.ListListsResponse, error) {
+	lists, err := mdb.GetLists(ctx, s.db)
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	res := &pb.ListListsResponse{Lists: make([]*pb.MailingList, 0, len(lists))}
+	for _, list := range lists {
+		res.Lists = append(res.Lists, mdbListToPb(list))
+	}
+	return res, nil
+}
+
+func (s *MailService) AddToList(ctx context.Context, r *pb.ListMembershipRequest) (*emptypb.Empty, error) {
+	if err := mdb.AddToList(ctx, s.db, r.List, r.EmailAddr); err != nil {
+		return nil, membershipError(err, r.List, r.EmailAddr)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *MailService) RemoveFromList(ctx context.Context, r *pb.ListMembershipRequest) (*emptypb.Empty, error) {
+	if err := mdb.RemoveFromList(ctx, s.db, r.List, r.EmailAddr); err != nil {
+		return nil, membershipError(err, r.List, r.EmailAddr)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *MailService) TagEmail(ctx context.Context, r *pb.TagEmailRequest) (*emptypb.Empty, error) {
+	if err := checkName("tag", r.Tag); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkOrg(ctx, r.EmailAddr); err != nil {
+		return nil, err
+	}
+	if err := mdb.TagEmail(ctx, s.db, r.EmailAddr, r.Tag); err != nil {
+		return nil, membershipError(err, "", r.EmailAddr)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *MailService) UntagEmail(ctx context.Context, r *pb.TagEmailRequest) (*emptypb.Empty, error) {
+	if err := s.checkOrg(ctx, r.EmailAddr); err != nil {
+		return nil, err
+	}
+	if err := mdb.UntagEmail(ctx, s.db, r.EmailAddr, r.Tag); err != nil {
+		return nil, membershipError(err, "", r.EmailAddr)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// fieldName matches the names of the fields, for {{index .Fields "name"}}
+// to be written as such in the templates.
+var fieldName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+func (s *MailService) GetEmailFields(ctx context.Context, r *pb.GetEmailFieldsRequest) (*pb.EmailFields, error) {
+	if err := s.checkOrg(ctx, r.EmailAddr); err != nil {
+		return nil, err
+	}
+	entry, err := mdb.GetEmail(ctx, s.db, r.EmailAddr)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	if entry == nil {
+		return nil, notFound(r.EmailAddr)
+	}
+	fields, err := mdb.GetEmailFields(ctx, s.db, r.EmailAddr)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return &pb.EmailFields{Fields: fields}, nil
+}
+
+func (s *MailService) SetEmailFields(ctx context.Context, r *pb.SetEmailFieldsRequest) (*pb.EmailFields, error) {
+	if len(r.Fields) == 0 {
+		return nil, invalidArgument("fields", errors.New("fields is required"))
+	}
+	for name, value := range r.Fields {
+		if !fieldName.MatchString(name) {
+			return nil, invalidArgument("fields", fmt.Errorf("invalid field name %q, expected lowercase letters, digits and _", name))
+		}
+		if len(value) > 1000 {
+			return nil, invalidArgument("fields", fmt.Errorf("field %v is longer than 1000 bytes", name))
+		}
+		if err := sanitize.Check(name, value); err != nil {
+			return nil, invalidArgument("fields", err)
+		}
+	}
+
+	if err := s.checkOrg(ctx, r.EmailAddr); err != nil {
+		return nil, err
+	}
+	fields, err := mdb.SetEmailFields(ctx, s.db, r.EmailAddr, r.Fields)
+	if err != nil {
+		return nil, membershipError(err, "", r.EmailAddr)
+	}
+	return &pb.EmailFields{Fields: fields}, nil
+}
+
+func (s *MailService) ListByTag(ctx context.Context, r *pb.ListByTagRequest) (*pb.GetEmailBatchResponse, error) {
+	if err := checkName("tag", r.Tag); err != nil {
+		return nil, err
+	}
+
+	return s.GetEmailBatch(ctx, &pb.GetEmailBatchRequest{
+		Page:      r.Page,
+		Count:     r.Count,
+		PageToken: r.PageToken,
+		Tag:       r.Tag,
+	})
+}
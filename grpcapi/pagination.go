@@ -0,0 +1,62 @@
+package grpcapi
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mailinglist/mdb"
+	pb "mailinglist/proto/mailinglist/v1"
+)
+
+const defaultBatchCount = 5
+
+var emailSorts = map[pb.EmailSort]string{
+	pb.EmailSort_EMAIL_SORT_ID:              mdb.SortId,
+	pb.EmailSort_EMAIL_SORT_ID_DESC:         mdb.SortIdDesc,
+	pb.EmailSort_EMAIL_SORT_EMAIL:           mdb.SortEmail,
+	pb.EmailSort_EMAIL_SORT_EMAIL_DESC:      mdb.SortEmailDesc,
+	pb.EmailSort_EMAIL_SORT_CREATED_AT:      mdb.SortCreatedAt,
+	pb.EmailSort_EMAIL_SORT_CREATED_AT_DESC: mdb.SortCreatedAtDesc,
+}
+
+var errInvalidPageToken = errors.New("invalid page token")
+
+// pageToken points to the next page. When sorting by id it also carries
+// the id of the last email, so the next page starts right after it.
+type pageToken struct {
+	page    int32
+	afterId int64
+}
+
+func (t pageToken) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", t.page, t.afterId)))
+}
+
+func decodePageToken(s string) (pageToken, error) {
+	var t pageToken
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return t, errInvalidPageToken
+	}
+	if _, err := fmt.Sscanf(string(raw), "%d:%d", &t.page, &t.afterId); err != nil || t.page < 1 || t.afterId < 0 {
+		return t, errInvalidPageToken
+	}
+	return t, nil
+}
+
+// paginate fills in the pagination metadata of a batch response.
+func paginate(res *pb.GetEmailBatchResponse, page, count int32, total int64, byId bool) {
+	res.TotalCount = total
+	res.Page = page
+	res.PageCount = int32((total + int64(count) - 1) / int64(count))
+
+	entries := res.EmailEntries
+	if page < res.PageCount && len(entries) == int(count) {
+		next := pageToken{page: page + 1}
+		if byId {
+			next.afterId = entries[len(entries)-1].Id
+		}
+		res.NextPageToken = next.encode()
+	}
+}
@@ -0,0 +1,116 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"mailinglist/ratelimit"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// GatewayTokenHeader carries the token of the REST gateway of the server,
+// which forwards the address of its callers in x-forwarded-for.
+const GatewayTokenHeader = "x-gateway-token"
+
+// forwarders are the callers believed to tell the address of their own in
+// x-forwarded-for: the REST gateway of this server, and the trusted
+// proxies.
+type forwarders struct {
+	gatewayToken string
+	proxies      ratelimit.Proxies
+}
+
+// peerAddr returns the address of the caller, the forwarded one for the
+// calls of the forwarders.
+func (f *forwarders) peerAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if f.fromGateway(md) || f.proxies.Trusted(host) {
+		return f.proxies.Forwarded(host, md.Get("x-forwarded-for"))
+	}
+	return host
+}
+
+// fromGateway reports whether the call carries the token of the gateway.
+// The callers of the gateway can send the header too, but not the token.
+func (f *forwarders) fromGateway(md metadata.MD) bool {
+	if f.gatewayToken == "" {
+		return false
+	}
+	for _, token := range md.Get(GatewayTokenHeader) {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(f.gatewayToken)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// bulkMethods are the batches, streams, imports and exports, a class of
+// their own for the rate limits, as the batches of the JSON API.
+var bulkMethods = map[string]bool{
+	"/mailinglist.v1.MailingListService/GetEmailBatch":    true,
+	"/mailinglist.v1.MailingListService/StreamEmails":     true,
+	"/mailinglist.v1.MailingListService/BulkCreateEmails": true,
+	"/mailinglist.v1.MailingListService/BulkUnsubscribe":  true,
+	"/mailinglist.v1.MailingListService/Watch":            true,
+	"/mailinglist.v1.MailingListService/ExportEmails":     true,
+	"/mailinglist.v1.MailingListService/ImportEmails":     true,
+	"/mailinglist.v1.MailingListService/Sync":             true,
+}
+
+// methodClass returns the class of the RPC for the rate limits, the
+// writeMethods being writes and the others reads, as the health checks.
+func methodClass(method string) string {
+	switch {
+	case bulkMethods[method]:
+		return ratelimit.ClassBulk
+	case writeMethods[method]:
+		return ratelimit.ClassWrite
+	}
+	return ratelimit.ClassRead
+}
+
+// rateLimit checks the limits once the call is authenticated, by the
+// verified API key in the context, its organization, or the address.
+func rateLimit(ctx context.Context, limiter *ratelimit.Limiter, f *forwarders, method string) error {
+	request := ratelimit.Request{Addr: f.peerAddr(ctx), Class: methodClass(method)}
+	if apiKey := apiKeyFromContext(ctx); apiKey != nil {
+		request.KeyId = apiKey.Id
+		request.Org = apiKey.Org
+	}
+	if !limiter.Allow(ctx, request) {
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	return nil
+}
+
+func rateLimitInterceptor(limiter *ratelimit.Limiter, f *forwarders) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := rateLimit(ctx, limiter, f, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func rateLimitStreamInterceptor(limiter *ratelimit.Limiter, f *forwarders) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := rateLimit(ss.Context(), limiter, f, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,108 @@
+package grpcapi
+
+import (
+	"context"
+	"mailinglist/mdb"
+	"mailinglist/ratelimit"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// peerContext returns the context of a call from the address with the
+// metadata pairs.
+func peerContext(addr string, pairs ...string) context.Context {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+	return peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 4242}})
+}
+
+func TestPeerAddr(t *testing.T) {
+	proxies, err := ratelimit.ParseProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &forwarders{gatewayToken: "gateway", proxies: proxies}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		addr string
+	}{
+		{name: "direct", ctx: peerContext("192.0.2.1"), addr: "192.0.2.1"},
+		{name: "forwarded by anyone", ctx: peerContext("192.0.2.1", "x-forwarded-for", "198.51.100.1"), addr: "192.0.2.1"},
+		{name: "forwarded by a proxy", ctx: peerContext("10.0.0.1", "x-forwarded-for", "198.51.100.1"), addr: "198.51.100.1"},
+		{
+			name: "forwarded by the gateway",
+			ctx:  peerContext("127.0.0.1", GatewayTokenHeader, "gateway", "x-forwarded-for", "198.51.100.1"),
+			addr: "198.51.100.1",
+		},
+		{
+			name: "gateway token forged",
+			ctx:  peerContext("192.0.2.1", GatewayTokenHeader, "nope", "x-forwarded-for", "198.51.100.1"),
+			addr: "192.0.2.1",
+		},
+		{name: "no peer", ctx: context.Background(), addr: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if addr := f.peerAddr(test.ctx); addr != test.addr {
+				t.Errorf("peerAddr = %v, want %v", addr, test.addr)
+			}
+		})
+	}
+}
+
+// Without a gateway token, the gateway header of the callers is ignored.
+func TestPeerAddrWithoutGateway(t *testing.T) {
+	f := &forwarders{}
+	ctx := peerContext("192.0.2.1", GatewayTokenHeader, "", "x-forwarded-for", "198.51.100.1")
+	if addr := f.peerAddr(ctx); addr != "192.0.2.1" {
+		t.Errorf("peerAddr = %v, want 192.0.2.1", addr)
+	}
+}
+
+func TestMethodClass(t *testing.T) {
+	for method, class := range map[string]string{
+		"GetEmail":      ratelimit.ClassRead,
+		"CreateEmail":   ratelimit.ClassWrite,
+		"GetEmailBatch": ratelimit.ClassBulk,
+		"ImportEmails":  ratelimit.ClassBulk,
+	} {
+		if got := methodClass(servicePrefix + method); got != class {
+			t.Errorf("methodClass(%v) = %v, want %v", method, got, class)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter := ratelimit.New([]ratelimit.Rule{
+		{Name: "caller", Scope: ratelimit.ScopeCaller, Rate: 0.001, Burst: 1},
+	})
+	f := &forwarders{}
+	withKey := context.WithValue(peerContext("192.0.2.1"), apiKeyContextKey{}, &mdb.ApiKey{Id: 1, Name: "test"})
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{name: "first call", ctx: peerContext("192.0.2.1"), code: codes.OK},
+		{name: "second call", ctx: peerContext("192.0.2.1"), code: codes.ResourceExhausted},
+		{name: "other address", ctx: peerContext("192.0.2.2"), code: codes.OK},
+		// The calls with a key have a bucket of their own.
+		{name: "with key", ctx: withKey, code: codes.OK},
+		{name: "with key again", ctx: withKey, code: codes.ResourceExhausted},
+	}
+
+	for _, test := range tests {
+		err := rateLimit(test.ctx, limiter, f, servicePrefix+"GetEmail")
+		if code := status.Code(err); code != test.code {
+			t.Errorf("%v: rateLimit = %v, want %v", test.name, err, test.code)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package grpcapi
+
+import (
+	"context"
+	"mailinglist/state"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writeMethods are the RPCs rejected while the server is in read-only mode.
+var writeMethods = map[string]bool{
+	"/mailinglist.v1.MailingListService/CreateEmail": true,
+	"/mailinglist.v1.MailingListService/UpdateEmail": true,
+	"/mailinglist.v1.MailingListService/DeleteEmail": true,
+
+	"/mailinglist.v1.MailingListService/ConfirmEmail":       true,
+	"/mailinglist.v1.MailingListService/ResendConfirmation": true,
+	"/mailinglist.v1.MailingListService/UnsubscribeEmail":   true,
+	"/mailinglist.v1.MailingListService/BulkUnsubscribe":    true,
+
+	"/mailinglist.v1.MailingListService/BulkCreateEmails": true,
+	"/mailinglist.v1.MailingListService/ImportEmails":     true,
+	"/mailinglist.v1.MailingListService/Sync":             true,
+
+	"/mailinglist.v1.MailingListService/CreateList":     true,
+	"/mailinglist.v1.MailingListService/AddToList":      true,
+	"/mailinglist.v1.MailingListService/RemoveFromList": true,
+	"/mailinglist.v1.MailingListService/TagEmail":       true,
+	"/mailinglist.v1.MailingListService/UntagEmail":     true,
+	"/mailinglist.v1.MailingListService/SetEmailFields": true,
+
+	"/mailinglist.v1.MailingListService/CreateCampaign":     true,
+	"/mailinglist.v1.MailingListService/UpdateCampaign":     true,
+	"/mailinglist.v1.MailingListService/DeleteCampaign":     true,
+	"/mailinglist.v1.MailingListService/ScheduleCampaign":   true,
+	"/mailinglist.v1.MailingListService/UnscheduleCampaign": true,
+}
+
+func readOnlyInterceptor(st *state.State) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if writeMethods[info.FullMethod] && st.ReadOnly() {
+			return nil, status.Error(codes.Unavailable, "service is in read-only mode")
+		}
+		return handler(ctx, req)
+	}
+}
+
+func readOnlyStreamInterceptor(st *state.State) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if writeMethods[info.FullMethod] && st.ReadOnly() {
+			return status.Error(codes.Unavailable, "service is in read-only mode")
+		}
+		return handler(srv, ss)
+	}
+}
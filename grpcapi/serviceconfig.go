@@ -0,0 +1,71 @@
+package grpcapi
+
+// ServiceConfig is the default gRPC service config of the mail service,
+// for clients to pass to their channel (grpc.WithDefaultServiceConfig in
+// Go). It is also served by the JSON server at /grpc/service-config.json.
+//
+// The idempotent methods are retried when the server is unavailable or the
+// database is busy (ABORTED). ConfirmEmail is not, as a retry of a
+// confirmation which went through finds its token used. Reads get a 5s timeout and writes 10s; the
+// streaming methods have none as they may run for long.
+const ServiceConfig = `{
+  "methodConfig": [
+    {
+      "name": [
+        {"service": "mailinglist.v1.MailingListService", "method": "GetEmail"},
+        {"service": "mailinglist.v1.MailingListService", "method": "GetEmailBatch"},
+        {"service": "mailinglist.v1.MailingListService", "method": "ListLists"},
+        {"service": "mailinglist.v1.MailingListService", "method": "ListByTag"},
+        {"service": "mailinglist.v1.MailingListService", "method": "GetEmailFields"},
+        {"service": "mailinglist.v1.MailingListService", "method": "GetEmailBounces"},
+        {"service": "mailinglist.v1.MailingListService", "method": "GetStats"},
+        {"service": "mailinglist.v1.MailingListService", "method": "GetCampaign"},
+        {"service": "mailinglist.v1.MailingListService", "method": "ListCampaigns"},
+        {"service": "mailinglist.v1.MailingListService", "method": "GetCampaignStats"}
+      ],
+      "timeout": "5s",
+      "retryPolicy": {
+        "maxAttempts": 4,
+        "initialBackoff": "0.1s",
+        "maxBackoff": "1s",
+        "backoffMultiplier": 2,
+        "retryableStatusCodes": ["UNAVAILABLE", "ABORTED"]
+      }
+    },
+    {
+      "name": [
+        {"service": "mailinglist.v1.MailingListService", "method": "UpdateEmail"},
+        {"service": "mailinglist.v1.MailingListService", "method": "DeleteEmail"},
+        {"service": "mailinglist.v1.MailingListService", "method": "UnsubscribeEmail"},
+        {"service": "mailinglist.v1.MailingListService", "method": "BulkUnsubscribe"},
+        {"service": "mailinglist.v1.MailingListService", "method": "AddToList"},
+        {"service": "mailinglist.v1.MailingListService", "method": "RemoveFromList"},
+        {"service": "mailinglist.v1.MailingListService", "method": "TagEmail"},
+        {"service": "mailinglist.v1.MailingListService", "method": "UntagEmail"},
+        {"service": "mailinglist.v1.MailingListService", "method": "SetEmailFields"},
+        {"service": "mailinglist.v1.MailingListService", "method": "UpdateCampaign"},
+        {"service": "mailinglist.v1.MailingListService", "method": "DeleteCampaign"},
+        {"service": "mailinglist.v1.MailingListService", "method": "ScheduleCampaign"},
+        {"service": "mailinglist.v1.MailingListService", "method": "UnscheduleCampaign"}
+      ],
+      "timeout": "10s",
+      "retryPolicy": {
+        "maxAttempts": 4,
+        "initialBackoff": "0.1s",
+        "maxBackoff": "1s",
+        "backoffMultiplier": 2,
+        "retryableStatusCodes": ["UNAVAILABLE", "ABORTED"]
+      }
+    },
+    {
+      "name": [
+        {"service": "mailinglist.v1.MailingListService", "method": "CreateEmail"},
+        {"service": "mailinglist.v1.MailingListService", "method": "ConfirmEmail"},
+        {"service": "mailinglist.v1.MailingListService", "method": "ResendConfirmation"},
+        {"service": "mailinglist.v1.MailingListService", "method": "CreateList"},
+        {"service": "mailinglist.v1.MailingListService", "method": "CreateCampaign"}
+      ],
+      "timeout": "10s"
+    }
+  ]
+}`
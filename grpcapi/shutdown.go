@@ -0,0 +1,115 @@
+package grpcapi
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/peer"
+)
+
+type activeCall struct {
+	method string
+	peer   string
+	start  time.Time
+}
+
+// callTracker keeps the RPCs in flight, so the ones cut off by a forced
+// stop can be reported.
+type callTracker struct {
+	mu     sync.Mutex
+	nextId int64
+	calls  map[int64]activeCall
+}
+
+func newCallTracker() *callTracker {
+	return &callTracker{calls: make(map[int64]activeCall)}
+}
+
+func (t *callTracker) begin(ctx context.Context, method string) int64 {
+	call := activeCall{method: method, start: time.Now()}
+	if p, ok := peer.FromContext(ctx); ok {
+		call.peer = p.Addr.String()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextId++
+	t.calls[t.nextId] = call
+	return t.nextId
+}
+
+func (t *callTracker) end(id int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.calls, id)
+}
+
+// active returns the calls in flight, oldest first.
+func (t *callTracker) active() []activeCall {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	calls := make([]activeCall, 0, len(t.calls))
+	for _, call := range t.calls {
+		calls = append(calls, call)
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].start.Before(calls[j].start) })
+	return calls
+}
+
+func (t *callTracker) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	id := t.begin(ctx, info.FullMethod)
+	defer t.end(id)
+	return handler(ctx, req)
+}
+
+func (t *callTracker) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	id := t.begin(ss.Context(), info.FullMethod)
+	defer t.end(id)
+	return handler(srv, ss)
+}
+
+// Server is the gRPC server of the mail service.
+type Server struct {
+	*grpc.Server
+	logger *slog.Logger
+	health *health.Server
+	calls  *callTracker
+
+	// stopHealth stops the checks of watchHealth.
+	stopHealth context.CancelFunc
+}
+
+// Shutdown stops accepting new RPCs and waits up to timeout for the calls
+// in flight, streams such as Watch included, before forcing the server to
+// stop. The calls cut off are logged.
+func (s *Server) Shutdown(timeout time.Duration) {
+	s.stopHealth()
+	s.health.Shutdown()
+
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.logger.Info("gRPC server drained")
+		return
+	case <-time.After(timeout):
+	}
+
+	calls := s.calls.active()
+	s.logger.Warn("gRPC drain timed out, cutting off the calls", "timeout", timeout, "calls", len(calls))
+	for _, call := range calls {
+		s.logger.Warn("call cut off", "method", call.method, "peer", call.peer, "running", time.Since(call.start).Round(time.Millisecond))
+	}
+	s.Stop()
+	<-done
+}
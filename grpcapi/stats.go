@@ -0,0 +1,42 @@
+package grpcapi
+
+import (
+	"context"
+	"mailinglist/mdb"
+	pb "mailinglist/proto/mailinglist/v1"
+	"sort"
+	"time"
+)
+
+const defaultStatsDays = 30
+
+func (s *MailService) GetStats(ctx context.Context, r *pb.StatsRequest) (*pb.StatsResponse, error) {
+	days := int32(defaultStatsDays)
+	if r.Days != nil {
+		days = *r.Days
+	}
+
+	stats, err := mdb.GetStats(ctx, s.db, time.Now().AddDate(0, 0, 1-int(days)))
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	res := &pb.StatsResponse{
+		Total:       stats.Total,
+		Subscribed:  stats.Subscribed,
+		Confirmed:   stats.Confirmed,
+		Unconfirmed: stats.Unconfirmed,
+		OptedOut:    stats.OptedOut,
+		Deleted:     stats.Deleted,
+	}
+	for reason, count := range stats.OptOutReasons {
+		res.OptOutReasons = append(res.OptOutReasons, &pb.OptOutReasonCount{Reason: reason, Count: count})
+	}
+	sort.Slice(res.OptOutReasons, func(i, j int) bool {
+		return res.OptOutReasons[i].Reason < res.OptOutReasons[j].Reason
+	})
+	for _, signups := range stats.Signups {
+		res.Signups = append(res.Signups, &pb.DailyCount{Date: signups.Day.Format("2006-01-02"), Count: signups.Count})
+	}
+	return res, nil
+}
@@ -0,0 +1,213 @@
+package grpcapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	pb "mailinglist/proto/mailinglist/v1"
+	"mailinglist/state"
+	"mailinglist/tracing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	syncBatchSize    = 500
+	syncPollInterval = time.Second
+	syncRetryDelay   = 5 * time.Second
+)
+
+func mdbEventToPb(event *mdb.EmailEvent) *pb.SyncEvent {
+	return &pb.SyncEvent{
+		Seq:         event.Seq,
+		Email:       event.Email,
+		ConfirmedAt: event.ConfirmedAt,
+		OptOut:      event.OptOut,
+		DeletedAt:   event.DeletedAt,
+		Purged:      event.Purged,
+		ChangedAt:   event.ChangedAt,
+	}
+}
+
+func pbEventToMdb(event *pb.SyncEvent) mdb.EmailEvent {
+	return mdb.EmailEvent{
+		Seq:         event.Seq,
+		Email:       event.Email,
+		ConfirmedAt: event.ConfirmedAt,
+		OptOut:      event.OptOut,
+		DeletedAt:   event.DeletedAt,
+		Purged:      event.Purged,
+		ChangedAt:   event.ChangedAt,
+	}
+}
+
+// sendSyncEvents sends the local events after seq, then keeps polling for
+// new ones until the context is done.
+func sendSyncEvents(ctx context.Context, db *sql.DB, seq int64, send func(*pb.SyncEvent) error) error {
+	ticker := time.NewTicker(syncPollInterval)
+	defer ticker.Stop()
+
+	for {
+		events, err := mdb.GetEventsSince(ctx, db, seq, syncBatchSize)
+		if err != nil {
+			return storageError(err)
+		}
+		for _, event := range events {
+			if err := send(mdbEventToPb(event)); err != nil {
+				return err
+			}
+			seq = event.Seq
+		}
+
+		if len(events) == syncBatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// receiveSyncEvents applies the events of the peer, recording each one as
+// the new checkpoint for the peer.
+func receiveSyncEvents(ctx context.Context, db *sql.DB, peer string, recv func() (*pb.SyncEvent, error)) error {
+	for {
+		event, err := recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		applied, err := mdb.ApplySyncEvent(ctx, db, pbEventToMdb(event))
+		if err != nil {
+			return storageError(err)
+		}
+		if applied {
+			logging.FromContext(ctx).Info("sync applied change", "email", event.Email, "peer", peer)
+		}
+
+		if err := mdb.SetSyncCheckpoint(ctx, db, peer, event.Seq); err != nil {
+			return storageError(err)
+		}
+	}
+}
+
+// syncEvents runs both directions of a sync stream until one of them ends.
+func syncEvents(ctx context.Context, db *sql.DB, peer string, since int64, send func(*pb.SyncEvent) error, recv func() (*pb.SyncEvent, error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 2)
+	go func() { errs <- sendSyncEvents(ctx, db, since, send) }()
+	go func() { errs <- receiveSyncEvents(ctx, db, peer, recv) }()
+
+	return <-errs
+}
+
+func (s *MailService) Sync(stream pb.MailingListService_SyncServer) error {
+	hello, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if hello.InstanceId == "" {
+		return invalidArgument("instance_id", errors.New("first sync event must carry the instance_id"))
+	}
+	logging.FromContext(stream.Context()).Info("sync", "peer", hello.InstanceId, "since", hello.Seq)
+
+	instanceId, err := mdb.InstanceId(stream.Context(), s.db)
+	if err != nil {
+		return storageError(err)
+	}
+	checkpoint, err := mdb.GetSyncCheckpoint(stream.Context(), s.db, hello.InstanceId)
+	if err != nil {
+		return storageError(err)
+	}
+	if err := stream.Send(&pb.SyncEvent{InstanceId: instanceId, Seq: checkpoint}); err != nil {
+		return err
+	}
+
+	return syncEvents(stream.Context(), s.db, hello.InstanceId, hello.Seq, stream.Send, stream.Recv)
+}
+
+// SyncWithPeer keeps a Sync stream open to the instance at peerAddr,
+// reconnecting after failures, until the returned stop function is called.
+// Checkpoints for the peer are kept under its address. No sync happens
+// while the server is in read-only mode. The API key, if any, must have the
+// write scope on the peer. A nil logger logs to the default logger.
+func SyncWithPeer(db *sql.DB, peerAddr, apiKey string, creds credentials.TransportCredentials, st *state.State, logger *slog.Logger) (stop func()) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("sync_peer", peerAddr)
+
+	ctx, cancel := context.WithCancel(logging.NewContext(context.Background(), logger))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if !st.ReadOnly() {
+				if err := syncWithPeer(ctx, db, logger, peerAddr, apiKey, creds); err != nil && ctx.Err() == nil {
+					logger.Error("sync failed", "err", err)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(syncRetryDelay):
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func syncWithPeer(ctx context.Context, db *sql.DB, logger *slog.Logger, peerAddr, apiKey string, creds credentials.TransportCredentials) error {
+	opts := append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, tracing.DialOptions()...)
+	conn, err := grpc.Dial(peerAddr, opts...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	instanceId, err := mdb.InstanceId(ctx, db)
+	if err != nil {
+		return err
+	}
+	checkpoint, err := mdb.GetSyncCheckpoint(ctx, db, peerAddr)
+	if err != nil {
+		return err
+	}
+
+	if apiKey != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", apiKey)
+	}
+
+	stream, err := pb.NewMailingListServiceClient(conn).Sync(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&pb.SyncEvent{InstanceId: instanceId, Seq: checkpoint}); err != nil {
+		return err
+	}
+
+	hello, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	logger.Info("sync", "instance_id", hello.InstanceId, "since", hello.Seq)
+
+	return syncEvents(ctx, db, peerAddr, hello.Seq, stream.Send, stream.Recv)
+}
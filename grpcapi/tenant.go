@@ -0,0 +1,85 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"mailinglist/mdb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// orgMethods are the RPCs scoped to the organization of the caller. The
+// other RPCs span every email and are denied to organization keys.
+var orgMethods = map[string]bool{
+	"/mailinglist.v1.MailingListService/CreateEmail":        true,
+	"/mailinglist.v1.MailingListService/UpdateEmail":        true,
+	"/mailinglist.v1.MailingListService/DeleteEmail":        true,
+	"/mailinglist.v1.MailingListService/ConfirmEmail":       true,
+	"/mailinglist.v1.MailingListService/ResendConfirmation": true,
+	"/mailinglist.v1.MailingListService/UnsubscribeEmail":   true,
+	"/mailinglist.v1.MailingListService/GetEmail":           true,
+	"/mailinglist.v1.MailingListService/GetEmailBatch":      true,
+	"/mailinglist.v1.MailingListService/ExportEmails":       true,
+	"/mailinglist.v1.MailingListService/TagEmail":           true,
+	"/mailinglist.v1.MailingListService/UntagEmail":         true,
+	"/mailinglist.v1.MailingListService/GetEmailFields":     true,
+	"/mailinglist.v1.MailingListService/GetEmailBounces":    true,
+	"/mailinglist.v1.MailingListService/SetEmailFields":     true,
+	"/mailinglist.v1.MailingListService/ListByTag":          true,
+}
+
+type orgContextKey struct{}
+
+// orgFromContext returns the organization the call is scoped to, "" when
+// it is not scoped.
+func orgFromContext(ctx context.Context) string {
+	org, _ := ctx.Value(orgContextKey{}).(string)
+	return org
+}
+
+// orgFromMetadata returns the organization sent as "x-org-id".
+func orgFromMetadata(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("x-org-id"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// orgContext scopes the call to the organization of the API key, checking
+// that it matches the organization requested in the metadata.
+func orgContext(ctx context.Context, apiKey *mdb.ApiKey, method string) (context.Context, error) {
+	if org := orgFromMetadata(ctx); org != "" && org != apiKey.Org {
+		return nil, status.Errorf(codes.PermissionDenied, "API key %v does not belong to organization %v", apiKey.Name, org)
+	}
+	if apiKey.Org == "" {
+		return ctx, nil
+	}
+	if !orgMethods[method] {
+		return nil, status.Errorf(codes.PermissionDenied, "%v is not available to organization API keys", method)
+	}
+	return context.WithValue(ctx, orgContextKey{}, apiKey.Org), nil
+}
+
+// checkOrg reports emails of other organizations as not found, so their
+// existence is not disclosed.
+func (s *MailService) checkOrg(ctx context.Context, email string) error {
+	org := orgFromContext(ctx)
+	if org == "" {
+		return nil
+	}
+
+	emailOrg, err := mdb.EmailOrg(ctx, s.db, email)
+	if errors.Is(err, mdb.ErrEmailNotFound) {
+		return notFound(email)
+	}
+	if err != nil {
+		return storageError(err)
+	}
+	if emailOrg != org {
+		return notFound(email)
+	}
+	return nil
+}
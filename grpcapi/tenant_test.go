@@ -0,0 +1,158 @@
+package grpcapi
+
+import (
+	"context"
+	"mailinglist/mdb"
+	pb "mailinglist/proto/mailinglist/v1"
+	"slices"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestOrgContext(t *testing.T) {
+	unscoped := &mdb.ApiKey{Name: "admin"}
+	acme := &mdb.ApiKey{Name: "acme", Org: "acme"}
+	tests := []struct {
+		name   string
+		apiKey *mdb.ApiKey
+		org    string
+		method string
+		code   codes.Code
+		scoped string
+	}{
+		{name: "unscoped key", apiKey: unscoped, method: "GetEmail"},
+		{name: "unscoped key on a global method", apiKey: unscoped, method: "CreateList"},
+		{name: "unscoped key asking an organization", apiKey: unscoped, org: "acme", method: "GetEmail", code: codes.PermissionDenied},
+		{name: "organization key", apiKey: acme, method: "GetEmail", scoped: "acme"},
+		{name: "organization key asking it", apiKey: acme, org: "acme", method: "GetEmail", scoped: "acme"},
+		{name: "organization key asking another", apiKey: acme, org: "other", method: "GetEmail", code: codes.PermissionDenied},
+		{name: "organization key on a global method", apiKey: acme, method: "CreateList", code: codes.PermissionDenied},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.org != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-org-id", test.org))
+			}
+			ctx, err := orgContext(ctx, test.apiKey, servicePrefix+test.method)
+			if code := status.Code(err); code != test.code {
+				t.Fatalf("orgContext = %v, want %v", err, test.code)
+			}
+			if err == nil && orgFromContext(ctx) != test.scoped {
+				t.Errorf("organization %q, want %q", orgFromContext(ctx), test.scoped)
+			}
+		})
+	}
+}
+
+// The organization keys only see the emails created with the keys of their
+// organization, the others being reported not found.
+func TestTenancy(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	s := &MailService{db: db}
+	a := newAuthenticator(t, db, true)
+
+	keys := make(map[string]*mdb.ApiKey)
+	for name, org := range map[string]string{"admin": "", "acme": "acme", "acme2": "acme", "other": "other"} {
+		apiKey, err := mdb.CreateApiKey(ctx, db, name, mdb.ScopeWrite, org, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[name] = apiKey
+	}
+	for email, key := range map[string]string{"jane@acme.example": "acme", "john@acme.example": "acme2", "ann@other.example": "other"} {
+		if err := mdb.CreateEmailForApiKey(ctx, db, email, *keys[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mdb.CreateEmail(ctx, db, "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key     string
+		visible []string
+	}{
+		{key: "admin", visible: []string{"ann@other.example", "bob@example.com", "jane@acme.example", "john@acme.example"}},
+		{key: "acme", visible: []string{"jane@acme.example", "john@acme.example"}},
+		{key: "other", visible: []string{"ann@other.example"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			authenticated := func(method string) context.Context {
+				t.Helper()
+				ctx, err := a.authenticate(keyContext(keys[test.key].Key), servicePrefix+method)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return ctx
+			}
+
+			for _, email := range []string{"ann@other.example", "bob@example.com", "jane@acme.example", "john@acme.example"} {
+				_, err := s.GetEmail(authenticated("GetEmail"), &pb.GetEmailRequest{EmailAddr: email})
+				code := codes.NotFound
+				if slices.Contains(test.visible, email) {
+					code = codes.OK
+				}
+				if status.Code(err) != code {
+					t.Errorf("GetEmail(%v) = %v, want %v", email, err, code)
+				}
+			}
+
+			res, err := s.GetEmailBatch(authenticated("GetEmailBatch"), &pb.GetEmailBatchRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var emails []string
+			for _, entry := range res.EmailEntries {
+				emails = append(emails, entry.Email)
+			}
+			slices.Sort(emails)
+			if !slices.Equal(emails, test.visible) {
+				t.Errorf("GetEmailBatch = %v, want %v", emails, test.visible)
+			}
+		})
+	}
+}
+
+// Creating an email of another organization neither fails nor discloses
+// it, and leaves it to its organization.
+func TestTenancyCreateExisting(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	s := &MailService{db: db}
+	a := newAuthenticator(t, db, true)
+
+	other, err := mdb.CreateApiKey(ctx, db, "other", mdb.ScopeWrite, "other", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.CreateEmailForApiKey(ctx, db, "ann@other.example", *other); err != nil {
+		t.Fatal(err)
+	}
+	acme, err := mdb.CreateApiKey(ctx, db, "acme", mdb.ScopeWrite, "acme", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callCtx, err := a.authenticate(keyContext(acme.Key), servicePrefix+"CreateEmail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.CreateEmail(callCtx, &pb.CreateEmailRequest{EmailAddr: "ann@other.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.EmailEntry.Id != 0 || res.EmailEntry.Email != "ann@other.example" {
+		t.Errorf("CreateEmail = %v, want the address alone", res.EmailEntry)
+	}
+	if org, err := mdb.EmailOrg(ctx, db, "ann@other.example"); err != nil || org != "other" {
+		t.Errorf("organization of the email = %q, %v, want other", org, err)
+	}
+}
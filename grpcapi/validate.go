@@ -0,0 +1,146 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	pb "mailinglist/proto/mailinglist/v1"
+	"mailinglist/sanitize"
+	"unicode/utf8"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func fieldRules(fd protoreflect.FieldDescriptor) *pb.FieldRules {
+	opts, ok := fd.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return nil
+	}
+	rules, _ := proto.GetExtension(opts, pb.E_Rules).(*pb.FieldRules)
+	return rules
+}
+
+// checkValue checks a single value, or element of a repeated field,
+// against the rules of its field.
+func checkValue(field string, fd protoreflect.FieldDescriptor, rules *pb.FieldRules, v protoreflect.Value) error {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		s := v.String()
+		if rules.Required && s == "" {
+			return fmt.Errorf("%v is required", field)
+		}
+		if rules.MaxLen > 0 && utf8.RuneCountInString(s) > int(rules.MaxLen) {
+			return fmt.Errorf("%v must be at most %d characters", field, rules.MaxLen)
+		}
+		if rules.Email && s != "" {
+			return sanitize.Email(field, s)
+		}
+
+	case protoreflect.Int32Kind, protoreflect.Int64Kind, protoreflect.Sint32Kind, protoreflect.Sint64Kind:
+		n := v.Int()
+		if rules.Min != nil && n < *rules.Min {
+			return fmt.Errorf("%v must be at least %d", field, *rules.Min)
+		}
+		if rules.Max != nil && n > *rules.Max {
+			return fmt.Errorf("%v must be at most %d", field, *rules.Max)
+		}
+	}
+	return nil
+}
+
+// validate enforces the (mailinglist.v1.rules) options of the fields of
+// a message, recursing into the messages it contains.
+func validate(m protoreflect.Message, prefix string) error {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		field := prefix + string(fd.Name())
+		rules := fieldRules(fd)
+
+		if !m.Has(fd) {
+			if rules != nil && rules.Required {
+				return invalidArgument(field, fmt.Errorf("%v is required", field))
+			}
+			if fd.HasPresence() {
+				continue
+			}
+		}
+
+		switch {
+		case fd.IsList():
+			list := m.Get(fd).List()
+			for j := 0; j < list.Len(); j++ {
+				if fd.Kind() == protoreflect.MessageKind {
+					if err := validate(list.Get(j).Message(), fmt.Sprintf("%v[%d].", field, j)); err != nil {
+						return err
+					}
+				} else if rules != nil {
+					if err := checkValue(fmt.Sprintf("%v[%d]", field, j), fd, rules, list.Get(j)); err != nil {
+						return invalidArgument(field, err)
+					}
+				}
+			}
+		case fd.IsMap():
+		case fd.Kind() == protoreflect.MessageKind:
+			if err := validate(m.Get(fd).Message(), field+"."); err != nil {
+				return err
+			}
+		case rules != nil:
+			if err := checkValue(field, fd, rules, m.Get(fd)); err != nil {
+				return invalidArgument(field, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validationInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if m, ok := req.(proto.Message); ok {
+		if err := validate(m.ProtoReflect(), ""); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+// invalidMessage is the error of RecvMsg for a message received whole but
+// breaking the rules of its fields. The handlers of the streams of items,
+// as BulkCreateEmails, report the item and go on with the next one, the
+// others failing with the InvalidArgument status of err.
+type invalidMessage struct {
+	err error
+}
+
+func (e *invalidMessage) Error() string {
+	return e.err.Error()
+}
+
+func (e *invalidMessage) GRPCStatus() *status.Status {
+	return status.Convert(e.err)
+}
+
+// validatingStream validates the messages received on a stream.
+type validatingStream struct {
+	grpc.ServerStream
+}
+
+func (s *validatingStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if msg, ok := m.(proto.Message); ok {
+		if err := validate(msg.ProtoReflect(), ""); err != nil {
+			return &invalidMessage{err: err}
+		}
+	}
+	return nil
+}
+
+// validationStreamInterceptor validates the request of the server streams
+// and each message of the client streams.
+func validationStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &validatingStream{ServerStream: ss})
+}
@@ -0,0 +1,201 @@
+package grpcapi
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	pb "mailinglist/proto/mailinglist/v1"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	watchPollInterval = 500 * time.Millisecond
+	watchBufferSize   = 256
+)
+
+var eventKinds = map[string]pb.SubscriberEventKind{
+	mdb.EventCreated:      pb.SubscriberEventKind_SUBSCRIBER_EVENT_KIND_CREATED,
+	mdb.EventConfirmed:    pb.SubscriberEventKind_SUBSCRIBER_EVENT_KIND_CONFIRMED,
+	mdb.EventUnsubscribed: pb.SubscriberEventKind_SUBSCRIBER_EVENT_KIND_UNSUBSCRIBED,
+	mdb.EventBounced:      pb.SubscriberEventKind_SUBSCRIBER_EVENT_KIND_BOUNCED,
+	mdb.EventComplained:   pb.SubscriberEventKind_SUBSCRIBER_EVENT_KIND_COMPLAINED,
+	mdb.EventResubscribed: pb.SubscriberEventKind_SUBSCRIBER_EVENT_KIND_RESUBSCRIBED,
+	mdb.EventDeleted:      pb.SubscriberEventKind_SUBSCRIBER_EVENT_KIND_DELETED,
+	mdb.EventRestored:     pb.SubscriberEventKind_SUBSCRIBER_EVENT_KIND_RESTORED,
+	mdb.EventUpdated:      pb.SubscriberEventKind_SUBSCRIBER_EVENT_KIND_UPDATED,
+	mdb.EventPurged:       pb.SubscriberEventKind_SUBSCRIBER_EVENT_KIND_PURGED,
+}
+
+func mdbEventToSubscriberEvent(event *mdb.EmailEvent) *pb.SubscriberEvent {
+	pbEvent := &pb.SubscriberEvent{
+		Seq:        event.Seq,
+		Email:      event.Email,
+		Kind:       eventKinds[event.Kind],
+		OccurredAt: timestamppb.New(time.Unix(event.ChangedAt, 0)),
+		OptOut:     event.OptOut,
+	}
+	if event.ConfirmedAt != 0 {
+		pbEvent.ConfirmedAt = timestamppb.New(time.Unix(event.ConfirmedAt, 0))
+	}
+	return pbEvent
+}
+
+// eventHub polls the event table, which records the changes made through
+// every API and by sync, and fans new events out to the watchers.
+type eventHub struct {
+	db     *sql.DB
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	watchers map[chan *mdb.EmailEvent]struct{}
+}
+
+func newEventHub(db *sql.DB, logger *slog.Logger) *eventHub {
+	return &eventHub{
+		db:       db,
+		logger:   logger,
+		watchers: make(map[chan *mdb.EmailEvent]struct{}),
+	}
+}
+
+// subscribe registers a watcher. Its channel is closed when the watcher
+// falls too far behind.
+func (h *eventHub) subscribe() chan *mdb.EmailEvent {
+	ch := make(chan *mdb.EmailEvent, watchBufferSize)
+	h.mu.Lock()
+	h.watchers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan *mdb.EmailEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.watchers[ch]; ok {
+		delete(h.watchers, ch)
+		close(ch)
+	}
+}
+
+func (h *eventHub) publish(event *mdb.EmailEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.watchers {
+		select {
+		case ch <- event:
+		default:
+			delete(h.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *eventHub) run() {
+	ctx := logging.NewContext(context.Background(), h.logger)
+	seq, err := mdb.LastEventSeq(ctx, h.db)
+	for err != nil {
+		h.logger.Error("watch failed to read events", "err", err)
+		time.Sleep(syncRetryDelay)
+		seq, err = mdb.LastEventSeq(ctx, h.db)
+	}
+
+	for {
+		events, err := mdb.GetEventsSince(ctx, h.db, seq, syncBatchSize)
+		if err != nil {
+			h.logger.Error("watch failed to read events", "err", err)
+		}
+		for _, event := range events {
+			h.publish(event)
+			seq = event.Seq
+		}
+
+		if len(events) < syncBatchSize {
+			time.Sleep(watchPollInterval)
+		}
+	}
+}
+
+func emailDomain(email string) string {
+	return email[strings.LastIndex(email, "@")+1:]
+}
+
+func (s *MailService) Watch(r *pb.WatchRequest, stream pb.MailingListService_WatchServer) error {
+	kinds := make(map[pb.SubscriberEventKind]bool)
+	for _, kind := range r.Kinds {
+		kinds[kind] = true
+	}
+	send := func(event *mdb.EmailEvent) error {
+		pbEvent := mdbEventToSubscriberEvent(event)
+		if len(kinds) > 0 && !kinds[pbEvent.Kind] {
+			return nil
+		}
+		if r.Domain != "" && !strings.EqualFold(emailDomain(event.Email), r.Domain) {
+			return nil
+		}
+		return stream.Send(pbEvent)
+	}
+
+	var seq int64
+	replay := func() error {
+		for {
+			events, err := mdb.GetEventsSince(stream.Context(), s.db, seq, syncBatchSize)
+			if err != nil {
+				return storageError(err)
+			}
+			for _, event := range events {
+				if err := send(event); err != nil {
+					return err
+				}
+				seq = event.Seq
+			}
+			if len(events) < syncBatchSize {
+				return nil
+			}
+		}
+	}
+
+	// The recorded events are replayed once before subscribing, so a long
+	// backlog cannot overflow the watcher, and once after to catch up with
+	// the events recorded meanwhile. Published events already replayed are
+	// skipped.
+	if r.SinceSeq != nil {
+		seq = *r.SinceSeq
+		if err := replay(); err != nil {
+			return err
+		}
+	}
+
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+
+	if r.SinceSeq != nil {
+		if err := replay(); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-ch:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "watcher fell too far behind")
+			}
+			if event.Seq <= seq {
+				continue
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
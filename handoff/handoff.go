@@ -0,0 +1,323 @@
+// Package handoff passes the listening sockets of the server to a new
+// process started from the executable, e.g. after a binary upgrade, so
+// that the ports are never closed during a deploy. Both processes accept
+// on the sockets until the old one has shut down gracefully. The sockets
+// can also be passed by systemd on socket activation.
+package handoff
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// envListeners lists the inherited sockets, network/addr=fd, comma
+	// separated.
+	envListeners = "MAILING_LIST_HANDOFF_LISTENERS"
+	// envReady is the fd the new process writes to once it serves.
+	envReady = "MAILING_LIST_HANDOFF_READY"
+
+	// pauseGrace is how long the connections accepted before the pause
+	// have to send their requests, which the http servers drop once
+	// shutting down.
+	pauseGrace = time.Second
+)
+
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// listener stops accepting once paused, leaving the connections to the new
+// process, until closed.
+type listener struct {
+	net.Listener
+	paused    chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func wrap(inner net.Listener) *listener {
+	return &listener{Listener: inner, paused: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case <-l.paused:
+		<-l.closed
+		return nil, net.ErrClosed
+	default:
+	}
+	conn, err := l.Listener.Accept()
+	select {
+	case <-l.paused:
+		if err != nil {
+			// The deadline interrupted the pending accept.
+			<-l.closed
+			return nil, net.ErrClosed
+		}
+	default:
+	}
+	return conn, err
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return l.Listener.Close()
+}
+
+func (l *listener) pause() {
+	close(l.paused)
+	if d, ok := l.Listener.(deadliner); ok {
+		d.SetDeadline(time.Now())
+	}
+}
+
+var (
+	mu sync.Mutex
+	// inherited are the sockets handed by the previous process, by
+	// network/addr, until taken.
+	inherited map[string]*os.File
+	// listeners are the sockets to hand to the next process.
+	listeners = make(map[string]*listener)
+)
+
+func key(network, addr string) string {
+	return network + "/" + addr
+}
+
+// parseInherited reads the inherited sockets, and the ones passed by
+// systemd, once, under mu.
+func parseInherited() {
+	if inherited != nil {
+		return
+	}
+	parseActivated()
+	inherited = make(map[string]*os.File)
+	for _, spec := range strings.Split(os.Getenv(envListeners), ",") {
+		name, fd, ok := strings.Cut(spec, "=")
+		n, err := strconv.Atoi(fd)
+		if !ok || err != nil {
+			continue
+		}
+		inherited[name] = os.NewFile(uintptr(n), name)
+	}
+	os.Unsetenv(envListeners)
+}
+
+// Inherited returns the socket handed by the previous process, or passed
+// by systemd, for the network and address, to be handed to the next
+// process too.
+func Inherited(network, addr string) (net.Listener, bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	parseInherited()
+
+	file, ok := inherited[key(network, addr)]
+	if !ok {
+		// systemd keeps its unix sockets, not removed on close.
+		if inner, ok := takeActivated(network, addr); ok {
+			l := wrap(inner)
+			listeners[key(network, addr)] = l
+			return l, true, nil
+		}
+		return nil, false, nil
+	}
+	delete(inherited, key(network, addr))
+	defer file.Close()
+
+	inner, err := net.FileListener(file)
+	if err != nil {
+		return nil, false, fmt.Errorf("inheriting %v : %w", key(network, addr), err)
+	}
+	// Removed on close as if bound by this process, unless handed over.
+	if unix, ok := inner.(*net.UnixListener); ok {
+		unix.SetUnlinkOnClose(true)
+	}
+	l := wrap(inner)
+	listeners[key(network, addr)] = l
+	return l, true, nil
+}
+
+// Register adds a socket to hand to the next process, returning the
+// listener to serve on.
+func Register(network, addr string, inner net.Listener) net.Listener {
+	mu.Lock()
+	defer mu.Unlock()
+	l := wrap(inner)
+	listeners[key(network, addr)] = l
+	return l
+}
+
+// Listen returns the socket handed by the previous process for the
+// network and address, or listens anew, the socket being handed to the
+// next process.
+func Listen(network, addr string) (net.Listener, error) {
+	listener, ok, err := Inherited(network, addr)
+	if ok || err != nil {
+		return listener, err
+	}
+	if listener, err = net.Listen(network, addr); err != nil {
+		return nil, err
+	}
+	return Register(network, addr, listener), nil
+}
+
+// Ready tells the previous process, if any, that this one serves, so that
+// it shuts down. The sockets it handed, or systemd passed, and that were
+// not taken, as after a change of the addresses, are closed.
+func Ready() error {
+	mu.Lock()
+	parseInherited()
+	for name, file := range inherited {
+		file.Close()
+		delete(inherited, name)
+	}
+	closeActivated()
+	mu.Unlock()
+
+	fd, err := strconv.Atoi(os.Getenv(envReady))
+	if err != nil {
+		return nil
+	}
+	os.Unsetenv(envReady)
+	ready := os.NewFile(uintptr(fd), "ready")
+	defer ready.Close()
+	_, err = ready.Write([]byte{1})
+	return err
+}
+
+// Upgrade starts the executable again, with the same arguments, handing it
+// the sockets, and returns once it is ready to serve. The new process is
+// killed when ctx is done before, and its failures to start are returned.
+// Once it is ready, this process stops accepting connections and is expected
+// to shut down gracefully.
+func Upgrade(ctx context.Context) (pid int, err error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer readyReader.Close()
+
+	mu.Lock()
+	// The fds of the new process start at 3, the ready pipe first.
+	files := []*os.File{readyWriter}
+	var specs []string
+	for name, l := range listeners {
+		file, err := dup(name, l.Listener)
+		if err != nil {
+			mu.Unlock()
+			closeAll(files)
+			return 0, fmt.Errorf("handing %v : %w", name, err)
+		}
+		specs = append(specs, name+"="+strconv.Itoa(3+len(files)))
+		files = append(files, file)
+	}
+	mu.Unlock()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, envListeners+"=") && !strings.HasPrefix(env, envReady+"=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	cmd.Env = append(cmd.Env, envListeners+"="+strings.Join(specs, ","), envReady+"=3")
+
+	err = cmd.Start()
+	// The new process has its copies.
+	closeAll(files)
+	if err != nil {
+		return 0, err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	ready := make(chan error, 1)
+	go func() {
+		// Fails once the new process exits without writing.
+		_, err := readyReader.Read(make([]byte, 1))
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		if exitErr := <-exited; exitErr != nil {
+			err = exitErr
+		}
+		return 0, fmt.Errorf("the new process is not ready: %w", err)
+	}
+
+	mu.Lock()
+	for _, l := range listeners {
+		// The new process serves the unix sockets, which must outlive the
+		// closing of the ones of this process.
+		if unix, ok := l.Listener.(*net.UnixListener); ok {
+			unix.SetUnlinkOnClose(false)
+		}
+		l.pause()
+	}
+	mu.Unlock()
+	time.Sleep(pauseGrace)
+	return cmd.Process.Pid, nil
+}
+
+// dup returns a copy of the socket of the listener for the new process. The
+// File methods of the listeners are not used as their files set the
+// socket, shared with this process, to blocking when passed to the new
+// process, after which the accepts of this process cannot be interrupted.
+func dup(name string, l net.Listener) (*os.File, error) {
+	conn, ok := l.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("%v has no file descriptor", name)
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var fd int
+	var dupErr error
+	err = raw.Control(func(sysfd uintptr) {
+		// Not leaked to the processes started meanwhile.
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if fd, dupErr = syscall.Dup(int(sysfd)); dupErr == nil {
+			syscall.CloseOnExec(fd)
+		}
+	})
+	if err == nil {
+		err = dupErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+func closeAll(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
@@ -0,0 +1,72 @@
+package handoff
+
+import (
+	"net"
+	"path/filepath"
+
+	"github.com/coreos/go-systemd/v22/activation"
+)
+
+// activated are the sockets passed by systemd on socket activation, until
+// taken.
+var activated []net.Listener
+
+// parseActivated reads the sockets passed by systemd once, under mu. The
+// ones which are not listening stream sockets are closed.
+func parseActivated() {
+	for _, file := range activation.Files(true) {
+		if l, err := net.FileListener(file); err == nil {
+			activated = append(activated, l)
+		}
+		file.Close()
+	}
+}
+
+// takeActivated returns the socket passed by systemd listening on the
+// network and address, under mu.
+func takeActivated(network, addr string) (net.Listener, bool) {
+	for i, l := range activated {
+		if listensOn(l, network, addr) {
+			activated = append(activated[:i], activated[i+1:]...)
+			return l, true
+		}
+	}
+	return nil, false
+}
+
+// listensOn reports whether the listener is bound to the address, written
+// as in the settings: ":9091" matches a socket bound to all the
+// interfaces, a relative path the absolute one.
+func listensOn(l net.Listener, network, addr string) bool {
+	switch bound := l.Addr().(type) {
+	case *net.UnixAddr:
+		if network != "unix" {
+			return false
+		}
+		// The paths of the units are absolute.
+		want, err := filepath.Abs(addr)
+		return bound.Name == addr || err == nil && bound.Name == want
+	case *net.TCPAddr:
+		if network != "tcp" {
+			return false
+		}
+		want, err := net.ResolveTCPAddr(network, addr)
+		if err != nil || want.Port != bound.Port {
+			return false
+		}
+		if want.IP == nil || want.IP.IsUnspecified() {
+			return bound.IP.IsUnspecified()
+		}
+		return want.IP.Equal(bound.IP)
+	}
+	return false
+}
+
+// closeActivated closes the sockets passed by systemd and not taken, under
+// mu.
+func closeActivated() {
+	for _, l := range activated {
+		l.Close()
+	}
+	activated = nil
+}
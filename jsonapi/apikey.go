@@ -0,0 +1,172 @@
+package jsonapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"mailinglist/sanitize"
+	"mailinglist/state"
+	"net/http"
+	"strings"
+)
+
+type apiKeyContextKey struct{}
+
+func apiKeyFromRequest(request *http.Request) *mdb.ApiKey {
+	apiKey, _ := request.Context().Value(apiKeyContextKey{}).(*mdb.ApiKey)
+	return apiKey
+}
+
+// apiKeyMiddleware resolves the X-API-Key header and enforces the daily
+// request quota of the key. Requests without a key are only let through
+// when keys are not required. Requests are not counted in read-only mode.
+func apiKeyMiddleware(db *sql.DB, st *state.State, requireApiKey bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			key := request.Header.Get("X-API-Key")
+			if key == "" {
+				if requireApiKey {
+					returnErr(writer, errors.New("missing API key"), http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(writer, request)
+				return
+			}
+
+			apiKey, err := mdb.GetApiKey(request.Context(), db, key)
+			if err != nil {
+				returnErr(writer, err, http.StatusInternalServerError)
+				return
+			}
+			if apiKey == nil {
+				returnErr(writer, errors.New("invalid API key"), http.StatusUnauthorized)
+				return
+			}
+
+			if !apiKey.CanWrite() && !isReadMethod(request.Method) {
+				returnErr(writer, errors.New("API key is not allowed to write"), http.StatusForbidden)
+				return
+			}
+
+			if !st.ReadOnly() {
+				if err := mdb.CountRequest(request.Context(), db, *apiKey); err != nil {
+					if errors.Is(err, mdb.ErrQuotaExceeded) {
+						returnErr(writer, errors.New("daily request quota exceeded"), http.StatusTooManyRequests)
+						return
+					}
+					returnErr(writer, err, http.StatusInternalServerError)
+					return
+				}
+			}
+
+			ctx := context.WithValue(request.Context(), apiKeyContextKey{}, apiKey)
+			next.ServeHTTP(writer, request.WithContext(ctx))
+		})
+	}
+}
+
+// adminMiddleware only lets through requests carrying the admin token
+// as a bearer token.
+func adminMiddleware(adminToken string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			token := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+				returnErr(writer, errors.New("invalid admin token"), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
+
+func GetUsage(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		apiKey := apiKeyFromRequest(request)
+		if apiKey == nil {
+			returnErr(writer, errors.New("missing API key"), http.StatusUnauthorized)
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Debug("get API key usage", "api_key", apiKey.Name)
+			return mdb.GetApiKeyUsage(request.Context(), db, *apiKey)
+		})
+	})
+}
+
+// writeKeyMiddleware only lets through the keys with the write scope and
+// not scoped to an organization, for the endpoints which mail or reveal
+// every email, such as the campaigns, reads included.
+func writeKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		apiKey := apiKeyFromRequest(request)
+		if apiKey == nil {
+			returnErr(writer, errors.New("missing API key"), http.StatusUnauthorized)
+			return
+		}
+		if !apiKey.CanWrite() {
+			returnErr(writer, errors.New("API key is not allowed to write"), http.StatusForbidden)
+			return
+		}
+		if apiKey.Org != "" {
+			returnErr(writer, errors.New("not available to organization API keys"), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// orgKeyMiddleware rejects the keys of an organization on the endpoints
+// that are not scoped to one.
+func orgKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if apiKey := apiKeyFromRequest(request); apiKey != nil && apiKey.Org != "" {
+			returnErr(writer, errors.New("organization API keys must use the /v1 API"), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func CreateApiKey(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		params := &struct {
+			Name              string
+			Scope             string
+			Org               string
+			MaxSubscribers    int64
+			MaxRequestsPerDay int64
+		}{}
+		fromJson(request.Body, params)
+
+		if params.Scope == "" {
+			params.Scope = mdb.ScopeWrite
+		}
+		if params.Scope != mdb.ScopeRead && params.Scope != mdb.ScopeWrite {
+			returnErr(writer, errors.New("scope must be read or write"), http.StatusBadRequest)
+			return
+		}
+
+		if params.Name == "" {
+			returnErr(writer, errors.New("missing API key name"), http.StatusBadRequest)
+			return
+		}
+		if err := sanitize.Check("Name", params.Name); err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+		if err := sanitize.Check("Org", params.Org); err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("create API key", "name", params.Name)
+			return mdb.CreateApiKey(request.Context(), db, params.Name, params.Scope, params.Org, params.MaxSubscribers, params.MaxRequestsPerDay)
+		})
+	})
+}
@@ -0,0 +1,93 @@
+package jsonapi
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/urfave/negroni"
+)
+
+// maxAuditedBody caps how much of a request body is read for its target.
+const maxAuditedBody = 4096
+
+// auditTarget returns the email of the request body, else the id in its path.
+func auditTarget(request *http.Request, body []byte) string {
+	target := struct{ Email string }{}
+	if json.Unmarshal(body, &target) == nil && target.Email != "" {
+		return target.Email
+	}
+	if id := mux.Vars(request)["id"]; id != "" {
+		return "id:" + id
+	}
+	return ""
+}
+
+// auditMiddleware records every request that could write in the audit
+// log, the same way as the gRPC server does, in read-only mode too, as
+// the admin requests turning it off. The caller is named by its API key,
+// else by the principal given.
+func auditMiddleware(db *sql.DB, principal string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if isReadMethod(request.Method) {
+				next.ServeHTTP(writer, request)
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(request.Body, maxAuditedBody))
+			if err != nil {
+				returnErr(writer, err, http.StatusBadRequest)
+				return
+			}
+			request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), request.Body))
+
+			lrw := negroni.NewResponseWriter(writer)
+			next.ServeHTTP(lrw, request)
+
+			entry := mdb.AuditEntry{
+				At:         time.Now(),
+				Principal:  principal,
+				Source:     mdb.AuditSourceHttp,
+				Action:     request.Method + " " + request.URL.Path,
+				Target:     auditTarget(request, body),
+				Outcome:    strconv.Itoa(lrw.Status()),
+				RemoteAddr: request.RemoteAddr,
+			}
+			if apiKey := apiKeyFromRequest(request); apiKey != nil {
+				entry.Principal = "key:" + apiKey.Name
+			}
+
+			if err := mdb.RecordAudit(context.Background(), db, entry); err != nil {
+				logging.FromContext(request.Context()).Error("failed to audit", "action", entry.Action, "err", err)
+			}
+		})
+	}
+}
+
+func GetAuditLog(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		limit := 100
+		if v := request.URL.Query().Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+				returnErr(writer, errors.New("limit must be a positive number"), http.StatusBadRequest)
+				return
+			}
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Debug("get audit log")
+			return mdb.GetAuditLog(request.Context(), db, limit)
+		})
+	})
+}
@@ -0,0 +1,240 @@
+package jsonapi
+
+import (
+	"database/sql"
+	"errors"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// campaignStatus maps the errors of the campaign operations to a status.
+func campaignStatus(err error) int {
+	switch {
+	case errors.Is(err, mdb.ErrCampaignNotFound), errors.Is(err, mdb.ErrListNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, mdb.ErrCampaignState):
+		return http.StatusConflict
+	case errors.Is(err, mdb.ErrTooManyBuckets):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func CreateCampaign(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		campaign := &mdb.Campaign{}
+		fromJson(request.Body, campaign)
+		if err := campaign.Validate(); err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		created, err := mdb.CreateCampaign(request.Context(), db, *campaign)
+		if err != nil {
+			returnErr(writer, err, campaignStatus(err))
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("create campaign", "id", created.Id, "campaign", created.Name)
+			return created, nil
+		})
+	})
+}
+
+func GetCampaign(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		campaign, err := mdb.GetCampaign(request.Context(), db, id)
+		if err != nil {
+			returnErr(writer, err, campaignStatus(err))
+			return
+		}
+		returnJson(writer, func() (interface{}, error) {
+			return campaign, nil
+		})
+	})
+}
+
+// GetCampaigns lists the campaigns, only the ones in the state of the
+// state parameter when set.
+func GetCampaigns(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		state := mdb.CampaignState(request.URL.Query().Get("state"))
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Debug("get campaigns", "state", state)
+			return mdb.GetCampaigns(request.Context(), db, state)
+		})
+	})
+}
+
+func UpdateCampaign(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		campaign := &mdb.Campaign{}
+		fromJson(request.Body, campaign)
+		if err := campaign.Validate(); err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		updated, err := mdb.UpdateCampaign(request.Context(), db, id, *campaign)
+		if err != nil {
+			returnErr(writer, err, campaignStatus(err))
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("update campaign", "id", id)
+			return updated, nil
+		})
+	})
+}
+
+func DeleteCampaign(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		if err := mdb.DeleteCampaign(request.Context(), db, id); err != nil {
+			returnErr(writer, err, campaignStatus(err))
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("delete campaign", "id", id)
+			return "", nil
+		})
+	})
+}
+
+// GetCampaignRecipients lists the recipients of a campaign by email, with
+// the hash of the message rendered for each, 100 at a time or limit, after
+// the email of the after parameter and only in the state of the status
+// parameter when set.
+func GetCampaignRecipients(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+		query := request.URL.Query()
+		limit := 100
+		if v := query.Get("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+				returnErr(writer, errors.New("limit must be a positive number"), http.StatusBadRequest)
+				return
+			}
+		}
+
+		recipients, err := mdb.GetCampaignRecipients(request.Context(), db, id,
+			mdb.RecipientStatus(query.Get("status")), query.Get("after"), limit)
+		if err != nil {
+			returnErr(writer, err, campaignStatus(err))
+			return
+		}
+		returnJson(writer, func() (interface{}, error) {
+			return recipients, nil
+		})
+	})
+}
+
+// GetCampaignStats returns the stats of the campaign, with a series of
+// buckets of the interval parameter, hour or day, an hour by default.
+func GetCampaignStats(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+		interval := mdb.StatsHour
+		if v := request.URL.Query().Get("interval"); v != "" {
+			interval = mdb.StatsInterval(v)
+			if err := interval.Validate(); err != nil {
+				returnErr(writer, err, http.StatusBadRequest)
+				return
+			}
+		}
+
+		stats, err := mdb.GetCampaignStats(request.Context(), db, id, interval)
+		if err != nil {
+			returnErr(writer, err, campaignStatus(err))
+			return
+		}
+		returnJson(writer, func() (interface{}, error) {
+			return stats, nil
+		})
+	})
+}
+
+type campaignSchedule struct {
+	At time.Time
+}
+
+func ScheduleCampaign(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		params := &campaignSchedule{}
+		fromJson(request.Body, params)
+		if params.At.IsZero() {
+			returnErr(writer, errors.New("missing At"), http.StatusBadRequest)
+			return
+		}
+
+		campaign, err := mdb.ScheduleCampaign(request.Context(), db, id, params.At)
+		if err != nil {
+			returnErr(writer, err, campaignStatus(err))
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("schedule campaign", "id", id, "at", params.At)
+			return campaign, nil
+		})
+	})
+}
+
+func UnscheduleCampaign(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		campaign, err := mdb.UnscheduleCampaign(request.Context(), db, id)
+		if err != nil {
+			returnErr(writer, err, campaignStatus(err))
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("unschedule campaign", "id", id)
+			return campaign, nil
+		})
+	})
+}
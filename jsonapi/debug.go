@@ -0,0 +1,113 @@
+package jsonapi
+
+import (
+	"bytes"
+	"io"
+	"mailinglist/logging"
+	"mailinglist/state"
+	"net/http"
+	"regexp"
+)
+
+// maxLoggedBody caps how much of a body is written to the debug log.
+const maxLoggedBody = 4096
+
+var emailPattern = regexp.MustCompile(`([A-Za-z0-9._%+\-])[A-Za-z0-9._%+\-]*@([A-Za-z0-9.\-]+)`)
+
+// redact partially masks the email addresses in a body, keeping the first
+// character of the local part and the domain.
+func redact(body []byte) []byte {
+	return emailPattern.ReplaceAll(body, []byte("$1***@$2"))
+}
+
+func truncated(body []byte, size int) string {
+	if size > len(body) {
+		return string(redact(body)) + "...(truncated)"
+	}
+	return string(redact(body))
+}
+
+// capWriter forwards the response while keeping up to maxLoggedBody bytes
+// of it for logging.
+type capWriter struct {
+	http.ResponseWriter
+	buf  bytes.Buffer
+	size int
+}
+
+func (w *capWriter) Write(p []byte) (int, error) {
+	w.size += len(p)
+	if remaining := maxLoggedBody - w.buf.Len(); remaining > 0 {
+		if len(p) < remaining {
+			remaining = len(p)
+		}
+		w.buf.Write(p[:remaining])
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// readCloser reads the body read ahead, and closes the original one.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// debugBodyMiddleware logs redacted request and response bodies while
+// debug body logging is switched on.
+func debugBodyMiddleware(st *state.State) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if !st.DebugBodies() {
+				next.ServeHTTP(writer, request)
+				return
+			}
+
+			// Only the logged prefix is read ahead, the rest of the body
+			// being streamed to the handler as it reads it.
+			prefix, err := io.ReadAll(io.LimitReader(request.Body, maxLoggedBody+1))
+			if err != nil {
+				returnErr(writer, err, http.StatusBadRequest)
+				return
+			}
+			request.Body = readCloser{io.MultiReader(bytes.NewReader(prefix), request.Body), request.Body}
+
+			logged := prefix
+			if len(logged) > maxLoggedBody {
+				logged = logged[:maxLoggedBody]
+			}
+			logging.FromContext(request.Context()).Info("request body", "body", truncated(logged, len(prefix)))
+
+			cw := &capWriter{ResponseWriter: writer}
+			defer func() {
+				logging.FromContext(request.Context()).Info("response body", "body", truncated(cw.buf.Bytes(), cw.size))
+			}()
+			next.ServeHTTP(cw, request)
+		})
+	}
+}
+
+type debugBodiesStatus struct {
+	DebugBodies bool
+}
+
+func GetDebugBodies(st *state.State) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		returnJson(writer, func() (interface{}, error) {
+			return debugBodiesStatus{DebugBodies: st.DebugBodies()}, nil
+		})
+	})
+}
+
+func SetDebugBodies(st *state.State) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		status := &debugBodiesStatus{}
+		fromJson(request.Body, status)
+
+		st.SetDebugBodies(status.DebugBodies)
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("set debug body logging", "debug_bodies", status.DebugBodies)
+			return debugBodiesStatus{DebugBodies: st.DebugBodies()}, nil
+		})
+	})
+}
@@ -0,0 +1,92 @@
+package jsonapi
+
+import (
+	"database/sql"
+	"errors"
+	"mailinglist/delivery"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// GetWebhookEndpoints returns the circuit breakers of the endpoints the
+// email events are delivered to, with their pending and dead deliveries.
+func GetWebhookEndpoints(dispatcher *delivery.Dispatcher) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Debug("get webhook endpoints")
+			return dispatcher.Endpoints(request.Context())
+		})
+	})
+}
+
+// RemoveWebhookEndpoint stops delivering the email events to an endpoint
+// no server sends to anymore, dropping its deliveries.
+func RemoveWebhookEndpoint(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		name := mux.Vars(request)["name"]
+
+		err := mdb.RemoveWebhookEndpoint(request.Context(), db, name)
+		if errors.Is(err, mdb.ErrEndpointNotFound) {
+			returnErr(writer, err, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			returnErr(writer, err, http.StatusInternalServerError)
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("remove webhook endpoint", "name", name)
+			return "", nil
+		})
+	})
+}
+
+// GetDeadDeliveries returns the most recent deliveries that failed for good.
+func GetDeadDeliveries(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		limit := 100
+		if v := request.URL.Query().Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+				returnErr(writer, errors.New("limit must be a positive number"), http.StatusBadRequest)
+				return
+			}
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Debug("get dead webhook deliveries")
+			return mdb.GetDeadWebhookDeliveries(request.Context(), db, limit)
+		})
+	})
+}
+
+// RetryDeadDelivery sends a dead delivery again, with its attempts reset.
+func RetryDeadDelivery(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		err = mdb.RequeueWebhookDelivery(request.Context(), db, id)
+		if errors.Is(err, mdb.ErrDeliveryNotFound) {
+			returnErr(writer, err, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			returnErr(writer, err, http.StatusInternalServerError)
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("retry dead webhook delivery", "id", id)
+			return "", nil
+		})
+	})
+}
@@ -0,0 +1,82 @@
+package jsonapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// emailFields maps the accepted names of the fields query parameter,
+// lowercased and without underscores, to the JSON keys of an email entry.
+var emailFields = map[string]string{
+	"id":           "Id",
+	"email":        "Email",
+	"confirmedat":  "ConfirmedAt",
+	"optout":       "OptOut",
+	"optoutreason": "OptOutReason",
+	"createdat":    "CreatedAt",
+}
+
+// trashFields additionally allow selecting when a trashed email was deleted.
+var trashFields = map[string]string{
+	"id":           "Id",
+	"email":        "Email",
+	"confirmedat":  "ConfirmedAt",
+	"optout":       "OptOut",
+	"optoutreason": "OptOutReason",
+	"createdat":    "CreatedAt",
+	"deletedat":    "DeletedAt",
+}
+
+// getFieldsParam parses ?fields=email,confirmed_at into JSON keys. A nil
+// result means all fields were requested.
+func getFieldsParam(request *http.Request, allowed map[string]string) ([]string, error) {
+	fieldsParam := request.URL.Query().Get("fields")
+	if fieldsParam == "" {
+		return nil, nil
+	}
+
+	fields := make([]string, 0)
+	for _, name := range strings.Split(fieldsParam, ",") {
+		normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), "_", ""))
+		if normalized == "" {
+			continue
+		}
+		key, ok := allowed[normalized]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		fields = append(fields, key)
+	}
+	return fields, nil
+}
+
+// projectFields reduces every element of the list to the requested fields.
+func projectFields(list interface{}, fields []string) (interface{}, error) {
+	if fields == nil {
+		return list, nil
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	projected := make([]map[string]json.RawMessage, 0, len(entries))
+	for _, entry := range entries {
+		p := make(map[string]json.RawMessage, len(fields))
+		for _, field := range fields {
+			if value, ok := entry[field]; ok {
+				p[field] = value
+			}
+		}
+		projected = append(projected, p)
+	}
+	return projected, nil
+}
@@ -0,0 +1,58 @@
+package jsonapi
+
+import (
+	"errors"
+	"mailinglist/logging"
+	"mailinglist/state"
+	"net/http"
+)
+
+// flagMiddleware rejects the requests with code while the flag is off.
+func flagMiddleware(st *state.State, name string, code int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if err := st.Flags().Check(name); err != nil {
+				if code == http.StatusServiceUnavailable {
+					writer.Header().Set("Retry-After", "60")
+				}
+				returnErr(writer, err, code)
+				return
+			}
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
+
+type flagValues struct {
+	Flags map[string]bool
+}
+
+func GetFlags(st *state.State) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		returnJson(writer, func() (interface{}, error) {
+			return flagValues{Flags: st.Flags().All()}, nil
+		})
+	})
+}
+
+// SetFlags changes the flags given, leaving the others.
+func SetFlags(st *state.State) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		params := &flagValues{}
+		fromJson(request.Body, params)
+		if len(params.Flags) == 0 {
+			returnErr(writer, errors.New("missing flags"), http.StatusBadRequest)
+			return
+		}
+
+		if err := st.Flags().Set(params.Flags); err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("set flags", "flags", params.Flags)
+			return flagValues{Flags: st.Flags().All()}, nil
+		})
+	})
+}
@@ -0,0 +1,111 @@
+package jsonapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mailinglist/logging"
+	"mailinglist/redisstore"
+	"net/http"
+)
+
+// savedResponse is the response to an idempotency key, replayed to the
+// retries.
+type savedResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// recordWriter forwards the response while keeping all of it.
+type recordWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *recordWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.buf.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// idempotencyMiddleware runs the writes with an Idempotency-Key header
+// once, across the servers sharing the store, replaying the response to the
+// retries. The keys are scoped to the verified API key, the method and the
+// path, the requests without a key, which nothing tells apart, running
+// every time. Failed writes are not saved and run again when retried.
+func idempotencyMiddleware(store *redisstore.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			idempotencyKey := request.Header.Get("Idempotency-Key")
+			apiKey := apiKeyFromRequest(request)
+			if store == nil || apiKey == nil || idempotencyKey == "" || isReadMethod(request.Method) {
+				next.ServeHTTP(writer, request)
+				return
+			}
+
+			logger := logging.FromContext(request.Context())
+			key := fmt.Sprintf("%d\x00%s %s\x00%s", apiKey.Id, request.Method, request.URL.Path, idempotencyKey)
+			saved, err := store.ClaimIdempotencyKey(request.Context(), key)
+			if errors.Is(err, redisstore.ErrInProgress) {
+				writer.Header().Set("Retry-After", "1")
+				returnErr(writer, err, http.StatusConflict)
+				return
+			}
+			if err != nil {
+				logger.Warn("idempotency key not checked", "err", err)
+				next.ServeHTTP(writer, request)
+				return
+			}
+
+			if saved != nil {
+				response := savedResponse{}
+				if err := json.Unmarshal(saved, &response); err == nil {
+					logger.Info("idempotent request replayed")
+					if response.ContentType != "" {
+						writer.Header().Set("Content-Type", response.ContentType)
+					}
+					writer.Header().Set("Idempotent-Replayed", "true")
+					writer.WriteHeader(response.Status)
+					writer.Write(response.Body)
+					return
+				}
+			}
+
+			rw := &recordWriter{ResponseWriter: writer}
+			next.ServeHTTP(rw, request)
+			if rw.status == 0 {
+				rw.status = http.StatusOK
+			}
+
+			// The response is sent, the key is settled even if the client
+			// has gone.
+			ctx := context.WithoutCancel(request.Context())
+			if rw.status < 200 || rw.status >= 300 {
+				err = store.ReleaseIdempotencyKey(ctx, key)
+			} else {
+				data, _ := json.Marshal(savedResponse{
+					Status:      rw.status,
+					ContentType: rw.Header().Get("Content-Type"),
+					Body:        rw.buf.Bytes(),
+				})
+				err = store.SaveIdempotencyKey(ctx, key, data)
+			}
+			if err != nil {
+				logger.Warn("idempotency key not saved", "err", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package jsonapi
+
+import (
+	"errors"
+	"mailinglist/logging"
+	"mailinglist/scheduler"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type jobEnabled struct {
+	Enabled bool
+}
+
+// returnJob returns the status of a job, or 404 for an unknown job.
+func returnJob(writer http.ResponseWriter, status scheduler.Status, err error) {
+	if errors.Is(err, scheduler.ErrUnknownJob) {
+		returnErr(writer, err, http.StatusNotFound)
+		return
+	}
+	returnJson(writer, func() (interface{}, error) {
+		return status, err
+	})
+}
+
+// GetJobs returns the status of the recurring jobs.
+func GetJobs(sched *scheduler.Scheduler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Debug("get jobs")
+			return sched.Jobs(), nil
+		})
+	})
+}
+
+// SetJob enables or disables a job.
+func SetJob(sched *scheduler.Scheduler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		name := mux.Vars(request)["name"]
+		params := &jobEnabled{}
+		fromJson(request.Body, params)
+
+		logging.FromContext(request.Context()).Info("set job enabled", "job", name, "enabled", params.Enabled)
+		status, err := sched.SetEnabled(name, params.Enabled)
+		returnJob(writer, status, err)
+	})
+}
+
+// RunJob runs a job now, out of its schedule, and returns before it is
+// done.
+func RunJob(sched *scheduler.Scheduler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		name := mux.Vars(request)["name"]
+
+		logging.FromContext(request.Context()).Info("run job", "job", name)
+		if err := sched.RunNow(name); err != nil {
+			returnJob(writer, scheduler.Status{}, err)
+			return
+		}
+		status, err := sched.Job(name)
+		returnJob(writer, status, err)
+	})
+}
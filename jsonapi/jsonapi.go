@@ -5,14 +5,31 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"mailinglist/confirmation"
+	"mailinglist/delivery"
+	"mailinglist/flags"
+	"mailinglist/handoff"
+	"mailinglist/logging"
+	"mailinglist/mailer"
 	"mailinglist/mdb"
+	"mailinglist/personalize"
+	"mailinglist/ratelimit"
+	"mailinglist/redisstore"
+	"mailinglist/sanitize"
+	"mailinglist/scheduler"
+	"mailinglist/state"
+	"mailinglist/webhooks"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/negroni"
 )
 
@@ -35,7 +52,7 @@ func returnJson[T any](writer http.ResponseWriter, withData func() (T, error)) {
 		writer.WriteHeader(http.StatusInternalServerError)
 		errJson, err := json.Marshal(&err)
 		if err != nil {
-			log.Println(err)
+			slog.Error("encoding the error", "err", err)
 		}
 		writer.Write(errJson)
 		return
@@ -43,7 +60,7 @@ func returnJson[T any](writer http.ResponseWriter, withData func() (T, error)) {
 
 	dataJson, err := json.Marshal(data)
 	if err != nil {
-		log.Println(err)
+		slog.Error("encoding the response", "err", err)
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -90,7 +107,44 @@ func getPagingParams(request *http.Request) (*mdb.GetBatchEmailQueryParams, erro
 		}
 	}
 
-	return &mdb.GetBatchEmailQueryParams{Page: page, Count: count}, nil
+	params := &mdb.GetBatchEmailQueryParams{Page: page, Count: count}
+	if err := getFilterParams(request, params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+func getFilterParams(request *http.Request, params *mdb.GetBatchEmailQueryParams) error {
+	query := request.URL.Query()
+	var err error
+
+	if v := query.Get("confirmed_only"); v != "" {
+		if params.ConfirmedOnly, err = strconv.ParseBool(v); err != nil {
+			return err
+		}
+	}
+	if v := query.Get("include_opt_out"); v != "" {
+		if params.IncludeOptOut, err = strconv.ParseBool(v); err != nil {
+			return err
+		}
+	}
+	if v := query.Get("include_complained"); v != "" {
+		if params.IncludeComplained, err = strconv.ParseBool(v); err != nil {
+			return err
+		}
+	}
+	if v := query.Get("created_after"); v != "" {
+		if params.CreatedAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return err
+		}
+	}
+
+	params.Tag = query.Get("tag")
+	params.Sort = query.Get("sort")
+	if !mdb.ValidSort(params.Sort) {
+		return fmt.Errorf("unknown sort %q", params.Sort)
+	}
+	return nil
 }
 
 func extractIdFromRequest(request *http.Request) (int64, error) {
@@ -100,7 +154,10 @@ func extractIdFromRequest(request *http.Request) (int64, error) {
 	return strconv.ParseInt(idStr, 10, 64)
 }
 
-func CreateEmail(db *sql.DB) http.Handler {
+// CreateEmail creates the email, and queues its confirmation when
+// confirmations is set. The email is kept when the confirmation fails,
+// for it can be sent again.
+func CreateEmail(db *sql.DB, confirmations *confirmation.Sender) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		if request.Method != http.MethodPost {
 			writer.WriteHeader(http.StatusMethodNotAllowed)
@@ -110,26 +167,75 @@ func CreateEmail(db *sql.DB) http.Handler {
 		entry := &mdb.EmailEntry{}
 		fromJson(request.Body, entry)
 
-		if err := mdb.CreateEmail(db, entry.Email); err != nil {
+		if err := sanitize.Email("Email", entry.Email); err != nil {
 			returnErr(writer, err, http.StatusBadRequest)
 			return
 		}
 
+		var err error
+		if apiKey := apiKeyFromRequest(request); apiKey != nil {
+			err = mdb.CreateEmailForApiKey(request.Context(), db, entry.Email, *apiKey)
+		} else {
+			err = mdb.CreateEmail(request.Context(), db, entry.Email)
+		}
+
+		if errors.Is(err, mdb.ErrQuotaExceeded) {
+			returnErr(writer, errors.New("subscriber quota exceeded"), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+		if confirmations != nil {
+			if err := confirmations.Send(request.Context(), entry.Email); err != nil {
+				logging.FromContext(request.Context()).Warn("queueing confirmation failed", "email", entry.Email, "err", err)
+			}
+		}
+
 		returnJson(writer, func() (interface{}, error) {
-			log.Printf("JSON Create email: %v\n", entry.Email)
-			return mdb.GetEmail(db, entry.Email)
+			logging.FromContext(request.Context()).Info("create email", "email", entry.Email)
+			return mdb.GetEmail(request.Context(), db, entry.Email)
 		})
 	})
 }
 
-func GetEmail(db *sql.DB) http.Handler {
+// ResendConfirmation queues again the confirmation of the email of the
+// body, e.g. {"Email": "jane@example.com"}, with a new link.
+func ResendConfirmation(confirmations *confirmation.Sender) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		entry := &mdb.EmailEntry{}
+		fromJson(request.Body, entry)
+
+		err := confirmations.Send(request.Context(), entry.Email)
+		if errors.Is(err, mdb.ErrEmailNotFound) {
+			returnErr(writer, err, http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, confirmation.ErrNotPending) {
+			returnErr(writer, err, http.StatusConflict)
+			return
+		}
+		if errors.Is(err, confirmation.ErrTooSoon) {
+			returnErr(writer, err, http.StatusTooManyRequests)
+			return
+		}
+		if err != nil {
+			returnErr(writer, err, http.StatusInternalServerError)
+			return
+		}
+		writer.WriteHeader(http.StatusAccepted)
+	})
+}
+
+func GetEmail(db *sql.DB, cache *redisstore.Store) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 
 		email := request.URL.Query().Get("email")
 
 		returnJson(writer, func() (interface{}, error) {
-			log.Printf("JSON Get email: %v\n", email)
-			return mdb.GetEmail(db, email)
+			logging.FromContext(request.Context()).Debug("get email", "email", email)
+			return cache.GetEmail(request.Context(), db, email)
 		})
 	})
 }
@@ -141,11 +247,22 @@ func GetBatchEmail(db *sql.DB) http.Handler {
 
 		if err != nil {
 			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		fields, err := getFieldsParam(request, emailFields)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
 		}
 
 		returnJson(writer, func() (interface{}, error) {
-			log.Printf("JSON Get batch email: %v\n", params)
-			return mdb.GetEmailBatch(db, *params)
+			logging.FromContext(request.Context()).Debug("get batch email", "params", params)
+			emails, err := mdb.GetEmailBatch(request.Context(), db, *params)
+			if err != nil {
+				return nil, err
+			}
+			return projectFields(emails, fields)
 		})
 	})
 }
@@ -161,14 +278,24 @@ func UpdateEmail(db *sql.DB) http.Handler {
 		entry := &mdb.EmailEntry{}
 		fromJson(request.Body, entry)
 
-		if err := mdb.UpdateEmail(db, *entry, id); err != nil {
+		if err := sanitize.Email("Email", entry.Email); err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		err = mdb.UpdateEmail(request.Context(), db, *entry, id)
+		if errors.Is(err, mdb.ErrEmailNotFound) {
+			returnErr(writer, err, http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			returnErr(writer, err, http.StatusBadRequest)
 			return
 		}
 
 		returnJson(writer, func() (interface{}, error) {
-			log.Printf("JSON Update email: %v\n", entry.Email)
-			return mdb.GetEmail(db, entry.Email)
+			logging.FromContext(request.Context()).Info("update email", "email", entry.Email)
+			return mdb.GetEmail(request.Context(), db, entry.Email)
 		})
 	})
 }
@@ -181,60 +308,300 @@ func DeleteEmail(db *sql.DB) http.Handler {
 			return
 		}
 
-		if err = mdb.DeleteEmail(db, id); err != nil {
+		if err = mdb.DeleteEmail(request.Context(), db, id); err != nil {
 			returnErr(writer, err, http.StatusBadRequest)
 			return
 		}
 
 		returnJson(writer, func() (interface{}, error) {
-			log.Printf("JSON Delete email for ID: %v\n", id)
+			logging.FromContext(request.Context()).Info("delete email", "id", id)
 			return "", nil
 		})
 	})
 }
 
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("[request] -> [%s] %s\n", r.Method, r.RequestURI)
-		lrw := negroni.NewResponseWriter(w)
-		defer func() {
-			log.Printf("[response] -> [%s] [%d]\n", r.RequestURI, lrw.Status())
-		}()
-		next.ServeHTTP(lrw, r)
+func ServiceConfig(config string) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		setJsonHeader(writer)
+		io.WriteString(writer, config)
 	})
 }
 
-func Serve(db *sql.DB, bind string) *http.Server {
+// loggingMiddleware logs the requests and their responses, and passes a
+// logger with the method and path of the request to the handlers.
+func loggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestLogger := logger.With("method", r.Method, "path", r.URL.Path, "request_id", logging.RequestId(r.Context()))
+			requestLogger.Info("request", "uri", r.RequestURI)
+			start := time.Now()
+			lrw := negroni.NewResponseWriter(w)
+			defer func() {
+				requestLogger.Info("response", "status", lrw.Status(), "elapsed", time.Since(start))
+			}()
+			next.ServeHTTP(lrw, r.WithContext(logging.NewContext(r.Context(), requestLogger)))
+		})
+	}
+}
+
+// Options controls optional behavior of the JSON API server.
+type Options struct {
+	// RequireApiKey rejects requests without an X-API-Key header.
+	RequireApiKey bool
+	// AdminToken enables the /admin endpoints, guarded by this bearer token.
+	AdminToken string
+	// TrashRetention is how long deleted emails can be restored.
+	TrashRetention time.Duration
+	// Webhooks are the email providers accepted at /webhooks/provider/{name}.
+	Webhooks *webhooks.Providers
+	// State is shared with the gRPC server and toggled through /admin.
+	State *state.State
+	// Gateway serves the REST API generated from the proto under /v1/.
+	Gateway http.Handler
+	// RateLimiter throttles callers by API key, organization or address,
+	// with rules managed under /admin/rate-limits. It is shared with the
+	// gRPC server, which enforces it for the /v1/ routes.
+	RateLimiter *ratelimit.Limiter
+	// TrustedProxies are believed to tell the address of their callers
+	// for the rate limits.
+	TrustedProxies ratelimit.Proxies
+	// GrpcServiceConfig is served at /grpc/service-config.json for the
+	// clients of the gRPC server.
+	GrpcServiceConfig string
+	// Scheduler runs the recurring jobs managed under /admin/jobs.
+	Scheduler *scheduler.Scheduler
+	// Redis, when set, caches the emails, and keeps the idempotency keys
+	// of the writes to /email for all the servers.
+	Redis *redisstore.Store
+	// Deliveries sends the email events to the webhook endpoints, whose
+	// deliveries are managed under /admin/webhooks.
+	Deliveries *delivery.Dispatcher
+	// Mailer sends the emails, a test one with /admin/mail/test.
+	Mailer mailer.Sender
+	// Links checks the links opened at /confirm/{token},
+	// /unsubscribe/{token} and /t/click/{token}.
+	Links *personalize.Personalizer
+	// Confirmations, when set, sends the new emails their confirmation,
+	// again with /email/resend-confirmation.
+	Confirmations *confirmation.Sender
+	// AdminBind serves /admin and /metrics with ServeAdmin on their own
+	// address, e.g. a loopback one, rather than with the API.
+	AdminBind string
+	// Diagnostics are served under /debug/ by ServeAdmin.
+	Diagnostics http.Handler
+	// Logger logs the requests, the default logger when nil.
+	Logger *slog.Logger
+}
+
+func Serve(db *sql.DB, bind string, opts Options) *http.Server {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("server", "json")
+	requestLogging := loggingMiddleware(logger)
+
 	router := mux.NewRouter().StrictSlash(true)
 
 	api := router.PathPrefix("/email").Subrouter()
-	api.Use(loggingMiddleware)
-	api.Handle("", GetEmail(db)).Methods(http.MethodGet)
-	api.Handle("", CreateEmail(db)).Methods(http.MethodPost)
+	api.Use(requestLogging)
+	api.Use(debugBodyMiddleware(opts.State))
+	api.Use(readOnlyMiddleware(opts.State))
+	api.Use(apiKeyMiddleware(db, opts.State, opts.RequireApiKey))
+	api.Use(rateLimitMiddleware(opts.RateLimiter, opts.TrustedProxies))
+	api.Use(orgKeyMiddleware)
+	api.Use(idempotencyMiddleware(opts.Redis))
+	api.Use(auditMiddleware(db, "anonymous"))
+	api.Handle("", GetEmail(db, opts.Redis)).Methods(http.MethodGet)
+	api.Handle("", flagMiddleware(opts.State, flags.Signup, http.StatusForbidden)(CreateEmail(db, opts.Confirmations))).Methods(http.MethodPost)
+	if opts.Confirmations != nil {
+		api.Handle("/resend-confirmation", flagMiddleware(opts.State, flags.Signup, http.StatusForbidden)(ResendConfirmation(opts.Confirmations))).Methods(http.MethodPost)
+	}
+	api.Handle("/trash", GetTrash(db, opts.TrashRetention)).Methods(http.MethodGet)
+	api.Handle("/trash/{id}/restore", RestoreEmail(db, opts.TrashRetention)).Methods(http.MethodPost)
 	api.Handle("/{id}", UpdateEmail(db)).Methods(http.MethodPut)
 	api.Handle("/{id}", DeleteEmail(db)).Methods(http.MethodDelete)
 
 	api.Handle("/batch", GetBatchEmail(db)).Methods(http.MethodGet)
 
-	log.Printf("JSON API serve and listening on %v\n", bind)
+	campaigns := router.PathPrefix("/campaigns").Subrouter()
+	campaigns.Use(requestLogging)
+	campaigns.Use(debugBodyMiddleware(opts.State))
+	campaigns.Use(readOnlyMiddleware(opts.State))
+	campaigns.Use(apiKeyMiddleware(db, opts.State, true))
+	campaigns.Use(rateLimitMiddleware(opts.RateLimiter, opts.TrustedProxies))
+	campaigns.Use(writeKeyMiddleware)
+	campaigns.Use(auditMiddleware(db, "anonymous"))
+	campaigns.Handle("", GetCampaigns(db)).Methods(http.MethodGet)
+	campaigns.Handle("", CreateCampaign(db)).Methods(http.MethodPost)
+	campaigns.Handle("/{id}", GetCampaign(db)).Methods(http.MethodGet)
+	campaigns.Handle("/{id}", UpdateCampaign(db)).Methods(http.MethodPut)
+	campaigns.Handle("/{id}", DeleteCampaign(db)).Methods(http.MethodDelete)
+	campaigns.Handle("/{id}/schedule", ScheduleCampaign(db)).Methods(http.MethodPost)
+	campaigns.Handle("/{id}/unschedule", UnscheduleCampaign(db)).Methods(http.MethodPost)
+	campaigns.Handle("/{id}/preview", PreviewCampaign(db)).Methods(http.MethodPost)
+	campaigns.Handle("/{id}/recipients", GetCampaignRecipients(db)).Methods(http.MethodGet)
+	campaigns.Handle("/{id}/stats", GetCampaignStats(db)).Methods(http.MethodGet)
+
+	templates := router.PathPrefix("/templates").Subrouter()
+	templates.Use(requestLogging)
+	templates.Use(debugBodyMiddleware(opts.State))
+	templates.Use(readOnlyMiddleware(opts.State))
+	templates.Use(apiKeyMiddleware(db, opts.State, true))
+	templates.Use(rateLimitMiddleware(opts.RateLimiter, opts.TrustedProxies))
+	templates.Use(writeKeyMiddleware)
+	templates.Use(auditMiddleware(db, "anonymous"))
+	templates.Handle("", GetTemplates(db)).Methods(http.MethodGet)
+	templates.Handle("", CreateTemplate(db)).Methods(http.MethodPost)
+	templates.Handle("/{id}", GetTemplate(db)).Methods(http.MethodGet)
+	templates.Handle("/{id}", UpdateTemplate(db)).Methods(http.MethodPut)
+	templates.Handle("/{id}", DeleteTemplate(db)).Methods(http.MethodDelete)
+	templates.Handle("/{id}/preview", PreviewTemplate(db)).Methods(http.MethodPost)
+
+	// The links sent to the subscribers, their tokens standing for a key.
+	link := func(handler http.Handler) http.Handler {
+		return requestLogging(rateLimitMiddleware(opts.RateLimiter, opts.TrustedProxies)(readOnlyMiddleware(opts.State)(handler)))
+	}
+	router.Handle("/unsubscribe/{token}", link(UnsubscribePage())).Methods(http.MethodGet)
+	router.Handle("/unsubscribe/{token}", link(UnsubscribeLink(db, opts.Links))).Methods(http.MethodPost)
+	router.Handle("/confirm/{token}", link(ConfirmLink(db, opts.Links))).Methods(http.MethodGet)
+	router.Handle("/t/click/{token}", link(ClickLink(db, opts.Links, opts.State))).Methods(http.MethodGet)
+	router.Handle("/t/open/{token}", link(OpenPixel(db, opts.Links, opts.State))).Methods(http.MethodGet)
+
+	usage := router.PathPrefix("/usage").Subrouter()
+	usage.Use(requestLogging)
+	usage.Use(apiKeyMiddleware(db, opts.State, true))
+	usage.Use(rateLimitMiddleware(opts.RateLimiter, opts.TrustedProxies))
+	usage.Handle("", GetUsage(db)).Methods(http.MethodGet)
+
+	router.Handle("/readyz", Ready(db, opts.State)).Methods(http.MethodGet)
+
+	if opts.GrpcServiceConfig != "" {
+		router.Handle("/grpc/service-config.json", ServiceConfig(opts.GrpcServiceConfig)).Methods(http.MethodGet)
+	}
+
+	if opts.Gateway != nil {
+		router.PathPrefix("/v1/").Handler(requestLogging(opts.Gateway))
+	}
+
+	hooks := router.PathPrefix("/webhooks").Subrouter()
+	hooks.Use(requestLogging)
+	hooks.Use(debugBodyMiddleware(opts.State))
+	hooks.Use(readOnlyMiddleware(opts.State))
+	hooks.Use(flagMiddleware(opts.State, flags.Webhooks, http.StatusServiceUnavailable))
+	hooks.Handle("/provider/{name}", ProviderWebhook(db, opts.Webhooks)).Methods(http.MethodPost)
+
+	// Else served by ServeAdmin.
+	if opts.AdminBind == "" {
+		router.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+		if opts.AdminToken != "" {
+			adminRoutes(router, db, opts, requestLogging)
+		}
+	}
 
 	serv := &http.Server{
 		Addr:         bind,
-		Handler:      router,
 		IdleTimeout:  120 * time.Second,
 		ReadTimeout:  1 * time.Second,
 		WriteTimeout: 1 * time.Second,
 	}
+	serv.Handler = streamingMiddleware(serv, requestIdMiddleware(recoveryMiddleware(logger)(router)))
 
+	listenAndServe(serv, logger)
+	return serv
+}
+
+// ServeAdmin starts the admin server on opts.AdminBind, serving /admin and
+// /metrics apart from the API, and the diagnostics under /debug/.
+func ServeAdmin(db *sql.DB, opts Options) *http.Server {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("server", "admin")
+
+	router := mux.NewRouter().StrictSlash(true)
+	router.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+	if opts.AdminToken != "" {
+		adminRoutes(router, db, opts, loggingMiddleware(logger))
+	}
+	if opts.Diagnostics != nil {
+		router.PathPrefix("/debug/").Handler(opts.Diagnostics)
+	}
+
+	// No write timeout, the CPU profiles and traces last for seconds.
+	serv := &http.Server{
+		Addr:              opts.AdminBind,
+		IdleTimeout:       120 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	serv.Handler = requestIdMiddleware(recoveryMiddleware(logger)(router))
+	listenAndServe(serv, logger)
+	return serv
+}
+
+// listenAndServe serves on serv.Addr, exiting on failure. The socket is
+// bound before returning, so that it is handed over on upgrades.
+func listenAndServe(serv *http.Server, logger *slog.Logger) {
+	listener, err := handoff.Listen("tcp", serv.Addr)
+	if err != nil {
+		logger.Error("error starting the server", "err", err)
+		os.Exit(1)
+	}
 	go func() {
-		log.Printf("Starting server on port %v...\n ", serv.Addr)
-		if err := serv.ListenAndServe(); err != nil {
-			log.Fatalf("error starting the server: %v", err)
+		logger.Info("starting server", "addr", serv.Addr)
+		if err := serv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("error starting the server", "err", err)
+			os.Exit(1)
 		}
 	}()
+}
 
-	return serv
+// adminRoutes adds the /admin endpoints, guarded by the admin token.
+func adminRoutes(router *mux.Router, db *sql.DB, opts Options, requestLogging mux.MiddlewareFunc) {
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(requestLogging)
+	admin.Use(adminMiddleware(opts.AdminToken))
+	admin.Use(auditMiddleware(db, "admin"))
+	admin.Handle("/read-only", GetReadOnly(opts.State)).Methods(http.MethodGet)
+	admin.Handle("/read-only", SetReadOnly(opts.State)).Methods(http.MethodPut)
+	admin.Handle("/debug-bodies", GetDebugBodies(opts.State)).Methods(http.MethodGet)
+	admin.Handle("/debug-bodies", SetDebugBodies(opts.State)).Methods(http.MethodPut)
+	admin.Handle("/lame-duck", GetLameDuck(opts.State)).Methods(http.MethodGet)
+	admin.Handle("/lame-duck", SetLameDuck(opts.State)).Methods(http.MethodPut)
+	admin.Handle("/flags", GetFlags(opts.State)).Methods(http.MethodGet)
+	admin.Handle("/flags", SetFlags(opts.State)).Methods(http.MethodPut)
+	admin.Handle("/audit", GetAuditLog(db)).Methods(http.MethodGet)
+
+	adminWrites := admin.NewRoute().Subrouter()
+	adminWrites.Use(readOnlyMiddleware(opts.State))
+	adminWrites.Handle("/keys", CreateApiKey(db)).Methods(http.MethodPost)
+
+	if opts.RateLimiter != nil {
+		admin.Handle("/rate-limits", GetRateLimits(opts.RateLimiter)).Methods(http.MethodGet)
+		admin.Handle("/rate-limits", SetRateLimits(opts.RateLimiter)).Methods(http.MethodPut)
+	}
+
+	if opts.Scheduler != nil {
+		admin.Handle("/jobs", GetJobs(opts.Scheduler)).Methods(http.MethodGet)
+		admin.Handle("/jobs/{name}", SetJob(opts.Scheduler)).Methods(http.MethodPut)
+		adminWrites.Handle("/jobs/{name}/run", RunJob(opts.Scheduler)).Methods(http.MethodPost)
+	}
 
+	if opts.Deliveries != nil {
+		admin.Handle("/webhooks/endpoints", GetWebhookEndpoints(opts.Deliveries)).Methods(http.MethodGet)
+		admin.Handle("/webhooks/dead", GetDeadDeliveries(db)).Methods(http.MethodGet)
+		adminWrites.Handle("/webhooks/dead/{id}/retry", RetryDeadDelivery(db)).Methods(http.MethodPost)
+	}
+	// Any server removes the endpoints, including those of the others.
+	adminWrites.Handle("/webhooks/endpoints/{name}", RemoveWebhookEndpoint(db)).Methods(http.MethodDelete)
+
+	if opts.Mailer != nil {
+		admin.Handle("/mail/test", SendTestMail(opts.Mailer)).Methods(http.MethodPost)
+		admin.Handle("/mail/queue", GetMailQueue(db)).Methods(http.MethodGet)
+		admin.Handle("/mail/failed", GetFailedMessages(db)).Methods(http.MethodGet)
+		adminWrites.Handle("/mail/failed/{id}/retry", RetryFailedMessage(db)).Methods(http.MethodPost)
+	}
 }
 
 func Shutdown(serv *http.Server) {
@@ -0,0 +1,67 @@
+package jsonapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mailinglist/logging"
+	"mailinglist/state"
+	"net/http"
+	"time"
+)
+
+const readyTimeout = 2 * time.Second
+
+var errLameDuck = errors.New("server is a lame duck, draining before a shutdown")
+
+type readiness struct {
+	Ready bool
+}
+
+// Ready answers the readiness probes of the load balancers and the
+// orchestrators: a 503 while the database is unreachable or the server is
+// a lame duck, which keeps serving the requests it still receives.
+func Ready(db *sql.DB, st *state.State) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if st.LameDuck() {
+			returnErr(writer, errLameDuck, http.StatusServiceUnavailable)
+			return
+		}
+		ctx, cancel := context.WithTimeout(request.Context(), readyTimeout)
+		defer cancel()
+		if err := db.PingContext(ctx); err != nil {
+			logging.FromContext(ctx).Error("readiness check failed", "err", err)
+			returnErr(writer, errors.New("database unreachable"), http.StatusServiceUnavailable)
+			return
+		}
+		returnJson(writer, func() (interface{}, error) {
+			return readiness{Ready: true}, nil
+		})
+	})
+}
+
+type lameDuckStatus struct {
+	LameDuck bool
+}
+
+func GetLameDuck(st *state.State) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		returnJson(writer, func() (interface{}, error) {
+			return lameDuckStatus{LameDuck: st.LameDuck()}, nil
+		})
+	})
+}
+
+func SetLameDuck(st *state.State) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		status := &lameDuckStatus{}
+		fromJson(request.Body, status)
+
+		st.SetLameDuck(status.LameDuck)
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("set lame duck mode", "lame_duck", status.LameDuck)
+			return lameDuckStatus{LameDuck: st.LameDuck()}, nil
+		})
+	})
+}
@@ -0,0 +1,155 @@
+package jsonapi
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"mailinglist/personalize"
+	"mailinglist/state"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// The pages of the links sent to the subscribers, who open them in a
+// browser.
+const (
+	unsubscribePage = `<!DOCTYPE html>
+<html><body>
+<form method="post"><p>Unsubscribe from this mailing list?</p><button type="submit">Unsubscribe</button></form>
+</body></html>
+`
+	unsubscribedPage = `<!DOCTYPE html>
+<html><body><p>You are unsubscribed.</p></body></html>
+`
+	confirmedPage = `<!DOCTYPE html>
+<html><body><p>Your subscription is confirmed.</p></body></html>
+`
+	invalidLinkPage = `<!DOCTYPE html>
+<html><body><p>This link is invalid or was already used.</p></body></html>
+`
+	expiredLinkPage = `<!DOCTYPE html>
+<html><body><p>This link has expired, please ask for a new one.</p></body></html>
+`
+)
+
+// pixel is the tracking pixel of the messages, a transparent 1x1 GIF.
+var pixel = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\x00\x00\x00!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+func returnPage(writer http.ResponseWriter, page string, status int) {
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(status)
+	fmt.Fprint(writer, page)
+}
+
+// tokenStatus maps the errors of the links to a page.
+func tokenStatus(writer http.ResponseWriter, err error) {
+	if errors.Is(err, mdb.ErrInvalidToken) {
+		returnPage(writer, invalidLinkPage, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, personalize.ErrLinkExpired) {
+		returnPage(writer, expiredLinkPage, http.StatusGone)
+		return
+	}
+	returnErr(writer, err, http.StatusInternalServerError)
+}
+
+// UnsubscribePage asks to confirm the unsubscription, the mail clients and
+// the scanners opening the links not to unsubscribe anyone.
+func UnsubscribePage() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		returnPage(writer, unsubscribePage, http.StatusOK)
+	})
+}
+
+// UnsubscribeLink opts out the email holding the unsubscribe token of the
+// link, checked by links. It is also the one-click unsubscription of the
+// List-Unsubscribe-Post header, whose mail clients post
+// List-Unsubscribe=One-Click without asking.
+func UnsubscribeLink(db *sql.DB, links *personalize.Personalizer) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		token, err := links.UnsubscribeToken(mux.Vars(request)["token"])
+		if err != nil {
+			tokenStatus(writer, err)
+			return
+		}
+		email, err := mdb.UnsubscribeByToken(request.Context(), db, token)
+		if err != nil {
+			tokenStatus(writer, err)
+			return
+		}
+		logging.FromContext(request.Context()).Info("unsubscribe by link", "email", email)
+		returnPage(writer, unsubscribedPage, http.StatusOK)
+	})
+}
+
+// ConfirmLink confirms the email holding the confirm token of the link,
+// once its signature and expiry are checked by links.
+func ConfirmLink(db *sql.DB, links *personalize.Personalizer) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		token, err := links.ConfirmToken(mux.Vars(request)["token"])
+		if err != nil {
+			tokenStatus(writer, err)
+			return
+		}
+		email, err := mdb.ConfirmEmail(request.Context(), db, token)
+		if err != nil {
+			tokenStatus(writer, err)
+			return
+		}
+		logging.FromContext(request.Context()).Info("confirm by link", "email", email)
+		returnPage(writer, confirmedPage, http.StatusOK)
+	})
+}
+
+// ClickLink records the click on a link of a campaign, then redirects to
+// the URL of the link, once links checked its signature, so that it does
+// not redirect anywhere else. The clicks are not recorded in read-only
+// mode, but still redirected.
+func ClickLink(db *sql.DB, links *personalize.Personalizer, st *state.State) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		target := query.Get("u")
+		campaignId, emailId, err := links.Click(mux.Vars(request)["token"], target, query.Get("s"))
+		if err != nil {
+			tokenStatus(writer, err)
+			return
+		}
+		if !st.ReadOnly() {
+			// The click is redirected even when it cannot be recorded.
+			recorded, err := mdb.RecordClick(request.Context(), db, campaignId, emailId, target)
+			if err == nil && !recorded {
+				logging.FromContext(request.Context()).Info("click of a campaign or email deleted", "campaign", campaignId, "email_id", emailId)
+			}
+		}
+		writer.Header().Set("Cache-Control", "no-store")
+		http.Redirect(writer, request, target, http.StatusFound)
+	})
+}
+
+// OpenPixel records the open of a message of a campaign, once links
+// checked the signature of its tracking pixel, then returns the pixel.
+// The opens are not recorded in read-only mode, but the pixel is still
+// returned.
+func OpenPixel(db *sql.DB, links *personalize.Personalizer, st *state.State) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		campaignId, emailId, err := links.Open(mux.Vars(request)["token"], request.URL.Query().Get("s"))
+		if err != nil {
+			tokenStatus(writer, err)
+			return
+		}
+		if !st.ReadOnly() {
+			// The pixel is returned even when the open cannot be recorded.
+			recorded, err := mdb.RecordOpen(request.Context(), db, campaignId, emailId)
+			if err == nil && !recorded {
+				logging.FromContext(request.Context()).Info("open of a campaign or email deleted", "campaign", campaignId, "email_id", emailId)
+			}
+		}
+		writer.Header().Set("Content-Type", "image/gif")
+		writer.Header().Set("Cache-Control", "no-store")
+		writer.Write(pixel)
+	})
+}
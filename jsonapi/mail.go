@@ -0,0 +1,103 @@
+package jsonapi
+
+import (
+	"database/sql"
+	"errors"
+	"mailinglist/logging"
+	"mailinglist/mailer"
+	"mailinglist/mdb"
+	"net/http"
+	"strconv"
+)
+
+type testMail struct {
+	To string
+}
+
+type testMailResult struct {
+	Sent bool
+}
+
+// SendTestMail sends a test email through the mail provider, to check its
+// settings.
+func SendTestMail(sender mailer.Sender) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		params := &testMail{}
+		fromJson(request.Body, params)
+		if params.To == "" {
+			returnErr(writer, errors.New("missing recipient"), http.StatusBadRequest)
+			return
+		}
+
+		err := sender.Send(request.Context(), &mailer.Message{
+			To:      params.To,
+			Subject: "Mailing list test email",
+			Text:    "This email checks the mail settings of the mailing list server.\n",
+		})
+		if err != nil {
+			logging.FromContext(request.Context()).Error("sending the test email failed", "to", params.To, "err", err)
+			returnErr(writer, err, http.StatusBadGateway)
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("test email sent", "to", params.To)
+			return testMailResult{Sent: true}, nil
+		})
+	})
+}
+
+// GetMailQueue returns the number of messages of the send queue by status.
+func GetMailQueue(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Debug("get mail queue")
+			return mdb.CountMessages(request.Context(), db)
+		})
+	})
+}
+
+// GetFailedMessages returns the most recent messages that failed for good.
+func GetFailedMessages(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		limit := 100
+		if v := request.URL.Query().Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+				returnErr(writer, errors.New("limit must be a positive number"), http.StatusBadRequest)
+				return
+			}
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Debug("get failed messages")
+			return mdb.GetFailedMessages(request.Context(), db, limit)
+		})
+	})
+}
+
+// RetryFailedMessage sends a failed message again, with its attempts reset.
+func RetryFailedMessage(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		err = mdb.RequeueMessage(request.Context(), db, id)
+		if errors.Is(err, mdb.ErrMessageNotFound) {
+			returnErr(writer, err, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			returnErr(writer, err, http.StatusInternalServerError)
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("retry failed message", "id", id)
+			return "", nil
+		})
+	})
+}
@@ -0,0 +1,92 @@
+package jsonapi
+
+import (
+	"errors"
+	"mailinglist/logging"
+	"mailinglist/ratelimit"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var errRateLimited = errors.New("rate limit exceeded")
+
+// rateLimitMiddleware rejects callers exceeding the shared rate limits,
+// identified by the API key verified by apiKeyMiddleware, which must come
+// before, its organization, or their address. The address is the one of
+// X-Forwarded-For for the requests of the trusted proxies.
+func rateLimitMiddleware(limiter *ratelimit.Limiter, proxies ratelimit.Proxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			host, _, err := net.SplitHostPort(request.RemoteAddr)
+			if err != nil {
+				host = request.RemoteAddr
+			}
+			if proxies.Trusted(host) {
+				host = proxies.Forwarded(host, request.Header.Values("X-Forwarded-For"))
+			}
+
+			limited := ratelimit.Request{Addr: host, Class: endpointClass(request)}
+			if apiKey := apiKeyFromRequest(request); apiKey != nil {
+				limited.KeyId = apiKey.Id
+				limited.Org = apiKey.Org
+			}
+			if !limiter.Allow(request.Context(), limited) {
+				writer.Header().Set("Retry-After", "1")
+				returnErr(writer, errRateLimited, http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
+
+// endpointClass returns the class of the endpoint for the rate limits, as
+// methodClass of the gRPC server: the batches are bulk, the JSON API
+// having no streams, imports nor exports.
+func endpointClass(request *http.Request) string {
+	switch {
+	case strings.HasSuffix(request.URL.Path, "/batch"):
+		return ratelimit.ClassBulk
+	case isReadMethod(request.Method):
+		return ratelimit.ClassRead
+	}
+	return ratelimit.ClassWrite
+}
+
+type rateLimits struct {
+	Rules []ratelimit.Rule
+}
+
+func GetRateLimits(limiter *ratelimit.Limiter) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		returnJson(writer, func() (interface{}, error) {
+			return rateLimits{Rules: limiter.Rules()}, nil
+		})
+	})
+}
+
+// SetRateLimits replaces the rate limiting rules of this server, applied
+// by its JSON and gRPC servers until it restarts or reloads its
+// configuration.
+func SetRateLimits(limiter *ratelimit.Limiter) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		params := &rateLimits{}
+		fromJson(request.Body, params)
+		// An empty list removes the limits, a missing one is a mistake.
+		if params.Rules == nil {
+			returnErr(writer, errors.New("missing rules"), http.StatusBadRequest)
+			return
+		}
+
+		if err := limiter.SetRules(params.Rules); err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("set rate limits", "rules", len(params.Rules))
+			return rateLimits{Rules: limiter.Rules()}, nil
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package jsonapi
+
+import (
+	"errors"
+	"mailinglist/logging"
+	"mailinglist/state"
+	"net/http"
+)
+
+var errReadOnly = errors.New("service is in read-only mode")
+
+func isReadMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// readOnlyMiddleware rejects requests that could write while the server
+// is in read-only mode.
+func readOnlyMiddleware(st *state.State) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if !isReadMethod(request.Method) && st.ReadOnly() {
+				writer.Header().Set("Retry-After", "60")
+				returnErr(writer, errReadOnly, http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
+
+type readOnlyStatus struct {
+	ReadOnly bool
+}
+
+func GetReadOnly(st *state.State) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		returnJson(writer, func() (interface{}, error) {
+			return readOnlyStatus{ReadOnly: st.ReadOnly()}, nil
+		})
+	})
+}
+
+func SetReadOnly(st *state.State) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		status := &readOnlyStatus{}
+		fromJson(request.Body, status)
+
+		st.SetReadOnly(status.ReadOnly)
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("set read-only mode", "read_only", status.ReadOnly)
+			return readOnlyStatus{ReadOnly: st.ReadOnly()}, nil
+		})
+	})
+}
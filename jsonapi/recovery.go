@@ -0,0 +1,46 @@
+package jsonapi
+
+import (
+	"errors"
+	"log/slog"
+	"mailinglist/crash"
+	"mailinglist/logging"
+	"net/http"
+)
+
+// requestIdMiddleware gives every request an id, the X-Request-Id header
+// sent by the client when valid, returned in the X-Request-Id header of
+// the response and carried in the context for the logs and the crash
+// reports.
+func requestIdMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id := logging.NewRequestId(request.Header.Get("X-Request-Id"))
+		writer.Header().Set("X-Request-Id", id)
+		// Forwarded by the REST gateway to the gRPC server.
+		request.Header.Set("X-Request-Id", id)
+		next.ServeHTTP(writer, request.WithContext(logging.WithRequestId(request.Context(), id)))
+	})
+}
+
+// recoveryMiddleware turns a panic in a handler into a 500 instead of a
+// dropped connection, logging and reporting it.
+func recoveryMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			defer func() {
+				value := recover()
+				if value == nil {
+					return
+				}
+				if value == http.ErrAbortHandler {
+					// Aborts the response on purpose.
+					panic(value)
+				}
+				ctx := logging.NewContext(request.Context(), logger.With("method", request.Method, "path", request.URL.Path, "request_id", logging.RequestId(request.Context())))
+				crash.Handle(ctx, value, "method", request.Method, "path", request.URL.Path)
+				returnErr(writer, errors.New("internal server error"), http.StatusInternalServerError)
+			}()
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package jsonapi
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// streamingPaths are the gateway routes streaming their response for as
+// long as the call lasts.
+var streamingPaths = map[string]bool{
+	"/v1/emails:stream": true,
+	"/v1/events:watch":  true,
+}
+
+// streamingMiddleware lifts the write timeout of the server for the
+// streaming routes, which it would cut, and ends their streams, which never
+// end on their own, when the server shuts down. The other routes are
+// served as is.
+func streamingMiddleware(serv *http.Server, next http.Handler) http.Handler {
+	shutdown := make(chan struct{})
+	serv.RegisterOnShutdown(func() {
+		close(shutdown)
+	})
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if !streamingPaths[request.URL.Path] {
+			next.ServeHTTP(writer, request)
+			return
+		}
+		http.NewResponseController(writer).SetWriteDeadline(time.Time{})
+
+		ctx, cancel := context.WithCancel(request.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-shutdown:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		next.ServeHTTP(writer, request.WithContext(ctx))
+	})
+}
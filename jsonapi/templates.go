@@ -0,0 +1,179 @@
+package jsonapi
+
+import (
+	"database/sql"
+	"errors"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"mailinglist/templates"
+	"net/http"
+)
+
+// templateStatus maps the errors of the template operations to a status.
+func templateStatus(err error) int {
+	switch {
+	case errors.Is(err, mdb.ErrTemplateNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, mdb.ErrTemplateExists):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func CreateTemplate(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		tmpl := &mdb.EmailTemplate{}
+		fromJson(request.Body, tmpl)
+		if err := tmpl.Validate(); err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		created, err := mdb.CreateEmailTemplate(request.Context(), db, *tmpl)
+		if err != nil {
+			returnErr(writer, err, templateStatus(err))
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("create template", "id", created.Id, "template", created.Name)
+			return created, nil
+		})
+	})
+}
+
+func GetTemplate(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		tmpl, err := mdb.GetEmailTemplate(request.Context(), db, id)
+		if err != nil {
+			returnErr(writer, err, templateStatus(err))
+			return
+		}
+		returnJson(writer, func() (interface{}, error) {
+			return tmpl, nil
+		})
+	})
+}
+
+func GetTemplates(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Debug("get templates")
+			return mdb.GetEmailTemplates(request.Context(), db)
+		})
+	})
+}
+
+func UpdateTemplate(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		tmpl := &mdb.EmailTemplate{}
+		fromJson(request.Body, tmpl)
+		if err := tmpl.Validate(); err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		updated, err := mdb.UpdateEmailTemplate(request.Context(), db, id, *tmpl)
+		if err != nil {
+			returnErr(writer, err, templateStatus(err))
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("update template", "id", id)
+			return updated, nil
+		})
+	})
+}
+
+func DeleteTemplate(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		if err := mdb.DeleteEmailTemplate(request.Context(), db, id); err != nil {
+			returnErr(writer, err, templateStatus(err))
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("delete template", "id", id)
+			return "", nil
+		})
+	})
+}
+
+// PreviewTemplate renders a stored template with the merge tags of the
+// body, templates.Sample when it has no Email.
+func PreviewTemplate(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		tmpl, err := mdb.GetEmailTemplate(request.Context(), db, id)
+		if err != nil {
+			returnErr(writer, err, templateStatus(err))
+			return
+		}
+		preview(writer, request, tmpl.Template())
+	})
+}
+
+// PreviewCampaign renders a campaign as PreviewTemplate does.
+func PreviewCampaign(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		campaign, err := mdb.GetCampaign(request.Context(), db, id)
+		if err != nil {
+			returnErr(writer, err, campaignStatus(err))
+			return
+		}
+		preview(writer, request, campaign.Template())
+	})
+}
+
+func preview(writer http.ResponseWriter, request *http.Request, tmpl templates.Template) {
+	data := templates.Sample
+	params := &templates.Data{}
+	fromJson(request.Body, params)
+	if params.Email != "" {
+		data = *params
+	}
+
+	parsed, err := templates.Parse(tmpl)
+	if err != nil {
+		returnErr(writer, err, http.StatusBadRequest)
+		return
+	}
+	rendered, err := parsed.Render(data)
+	if err != nil {
+		returnErr(writer, err, http.StatusBadRequest)
+		return
+	}
+	returnJson(writer, func() (interface{}, error) {
+		return rendered, nil
+	})
+}
@@ -0,0 +1,54 @@
+package jsonapi
+
+import (
+	"database/sql"
+	"errors"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"net/http"
+	"time"
+)
+
+func GetTrash(db *sql.DB, retention time.Duration) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		fields, err := getFieldsParam(request, trashFields)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Debug("get trash")
+			entries, err := mdb.GetTrash(request.Context(), db, time.Now().Add(-retention))
+			if err != nil {
+				return nil, err
+			}
+			return projectFields(entries, fields)
+		})
+	})
+}
+
+func RestoreEmail(db *sql.DB, retention time.Duration) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id, err := extractIdFromRequest(request)
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		err = mdb.RestoreEmail(request.Context(), db, id, time.Now().Add(-retention))
+		if errors.Is(err, mdb.ErrNotInTrash) {
+			returnErr(writer, err, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			returnErr(writer, err, http.StatusInternalServerError)
+			return
+		}
+
+		returnJson(writer, func() (interface{}, error) {
+			logging.FromContext(request.Context()).Info("restore email", "id", id)
+			return "", nil
+		})
+	})
+}
@@ -0,0 +1,63 @@
+package jsonapi
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"mailinglist/bounces"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"mailinglist/webhooks"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ProviderWebhook receives bounce, complaint and unsubscribe notifications
+// from the email provider named in the path. The bounces are counted, the
+// hard ones suppressing their email, and the other emails are opted out.
+func ProviderWebhook(db *sql.DB, providers *webhooks.Providers) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		name := mux.Vars(request)["name"]
+		provider, ok := providers.Get(name)
+		if !ok {
+			returnErr(writer, errors.New("unknown provider"), http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(request.Body, 1<<20))
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		events, err := provider.Events(request.Header, body)
+		if errors.Is(err, webhooks.ErrInvalidSignature) {
+			returnErr(writer, err, http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			returnErr(writer, err, http.StatusBadRequest)
+			return
+		}
+
+		for _, event := range events {
+			logging.FromContext(request.Context()).Info("webhook event", "provider", name, "kind", event.Kind, "email", event.Email)
+			var err error
+			switch event.Kind {
+			case webhooks.Bounce, webhooks.SoftBounce:
+				err = bounces.Record(request.Context(), db, event.Email, event.Kind == webhooks.Bounce, name)
+			case webhooks.Complaint:
+				err = bounces.RecordComplaint(request.Context(), db, event.Email, name)
+			default:
+				err = mdb.OptOutEmail(request.Context(), db, event.Email, string(event.Kind))
+			}
+			if err != nil {
+				returnErr(writer, err, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		writer.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,103 @@
+// Package leader elects, among the servers sharing a database, the one
+// running the scheduled jobs. The leader holds a lease in the database,
+// which it renews while it runs and another server takes once it expires.
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Holder returns an id of this process, unique among the servers.
+func Holder() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	buf := make([]byte, 4)
+	rand.Read(buf)
+	return fmt.Sprintf("%v-%v-%v", host, os.Getpid(), hex.EncodeToString(buf))
+}
+
+// Lease is the election of a leader through a lease of the database.
+type Lease struct {
+	db     *sql.DB
+	name   string
+	holder string
+	ttl    time.Duration
+	logger *slog.Logger
+
+	leader atomic.Bool
+}
+
+// NewLease returns the election of the named lease, with the holder of this
+// server. The leader keeps the lease for ttl after its last renewal.
+func NewLease(db *sql.DB, name, holder string, ttl time.Duration, logger *slog.Logger) *Lease {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Lease{
+		db:     db,
+		name:   name,
+		holder: holder,
+		ttl:    ttl,
+		logger: logger.With("lease", name, "holder", holder),
+	}
+}
+
+// IsLeader reports whether this server held the lease at its last renewal.
+func (l *Lease) IsLeader() bool {
+	return l.leader.Load()
+}
+
+// Run tries to take or renew the lease three times per ttl, until ctx is
+// done, then gives up the lease.
+func (l *Lease) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		l.renew(ctx)
+		select {
+		case <-ctx.Done():
+			if l.leader.Swap(false) {
+				// ctx is done, the lease is released with a fresh one.
+				release, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				mdb.ReleaseLease(logging.NewContext(release, l.logger), l.db, l.name, l.holder)
+				cancel()
+				l.logger.Info("leadership released")
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *Lease) renew(ctx context.Context) {
+	held, err := mdb.AcquireLease(logging.NewContext(ctx, l.logger), l.db, l.name, l.holder, l.ttl)
+	if err != nil && ctx.Err() != nil {
+		// Stopping, Run releases the lease.
+		return
+	}
+	if err != nil {
+		// Without knowing, another server may take over, so stepping down
+		// avoids running the jobs twice.
+		held = false
+	}
+	if was := l.leader.Swap(held); was != held {
+		if held {
+			l.logger.Info("leadership acquired")
+		} else {
+			l.logger.Warn("leadership lost")
+		}
+	}
+}
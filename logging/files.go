@@ -0,0 +1,142 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Stderr is the name of the standard error among the files.
+const Stderr = "-"
+
+// componentKeys are the attributes naming the component of a logger, as
+// set by the servers and the workers.
+var componentKeys = map[string]bool{"server": true, "component": true}
+
+// Files sets the files the records are written to, rotated once too large
+// or too old, along with the standard error.
+type Files struct {
+	// Path is the file of the records, the standard error when empty or
+	// Stderr.
+	Path string
+	// Components are the files of the records of the components, by the
+	// value of their server or component attribute, e.g. json or
+	// scheduler.
+	Components map[string]string
+	// MaxSize is the size in megabytes past which a file is rotated.
+	MaxSize int
+	// RotateInterval rotates the files at this interval too, never when 0.
+	RotateInterval time.Duration
+	// MaxAge is how long the rotated files are kept, in whole days, forever
+	// when 0.
+	MaxAge time.Duration
+	// MaxBackups is how many rotated files are kept for each file, all when 0.
+	MaxBackups int
+	// Compress gzips the rotated files.
+	Compress bool
+}
+
+// Open returns a logger like New, writing the records to the files and to
+// the standard error, each record once.
+func Open(files Files, level slog.Leveler, format string) (*slog.Logger, error) {
+	writers := make(map[string]io.Writer)
+	writer := func(path string) io.Writer {
+		if path == "" || path == Stderr {
+			return os.Stderr
+		}
+		if w, ok := writers[path]; ok {
+			return w
+		}
+		w := &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    files.MaxSize,
+			MaxAge:     int(math.Ceil(files.MaxAge.Hours() / 24)),
+			MaxBackups: files.MaxBackups,
+			Compress:   files.Compress,
+			LocalTime:  true,
+		}
+		if files.RotateInterval > 0 {
+			go rotate(w, files.RotateInterval)
+		}
+		writers[path] = io.MultiWriter(os.Stderr, w)
+		return writers[path]
+	}
+
+	logger, err := New(writer(files.Path), level, format)
+	if err != nil {
+		return nil, err
+	}
+	r := &router{handler: logger.Handler(), components: make(map[string]slog.Handler)}
+	for component, path := range files.Components {
+		c, err := New(writer(path), level, format)
+		if err != nil {
+			return nil, err
+		}
+		r.components[component] = c.Handler()
+	}
+	return slog.New(r), nil
+}
+
+// rotate rotates the file at the interval, for the life of the process.
+func rotate(w *lumberjack.Logger, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := w.Rotate(); err != nil {
+			slog.Warn("rotating the log file failed", "file", w.Filename, "err", err)
+		}
+	}
+}
+
+// router sends the records of the loggers of a component to its handler,
+// the others to the default one. The attributes and groups added before the
+// component are replayed on its handler.
+type router struct {
+	handler    slog.Handler
+	components map[string]slog.Handler
+	// derive replays the attributes and groups added so far.
+	derive []func(slog.Handler) slog.Handler
+}
+
+func (r *router) Enabled(ctx context.Context, level slog.Level) bool {
+	return r.handler.Enabled(ctx, level)
+}
+
+func (r *router) Handle(ctx context.Context, record slog.Record) error {
+	return r.handler.Handle(ctx, record)
+}
+
+func (r *router) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := r.with(func(h slog.Handler) slog.Handler {
+		return h.WithAttrs(attrs)
+	})
+	for _, attr := range attrs {
+		if !componentKeys[attr.Key] {
+			continue
+		}
+		if h, ok := r.components[attr.Value.String()]; ok {
+			for _, derive := range next.derive {
+				h = derive(h)
+			}
+			next.handler = h
+		}
+	}
+	return next
+}
+
+func (r *router) WithGroup(name string) slog.Handler {
+	return r.with(func(h slog.Handler) slog.Handler {
+		return h.WithGroup(name)
+	})
+}
+
+func (r *router) with(derive func(slog.Handler) slog.Handler) *router {
+	return &router{
+		handler:    derive(r.handler),
+		components: r.components,
+		derive:     append(r.derive[:len(r.derive):len(r.derive)], derive),
+	}
+}
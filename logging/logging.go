@@ -0,0 +1,89 @@
+// Package logging builds the structured logger of the server and carries
+// it in the contexts, so that the storage and the APIs log with the
+// attributes of the request they serve.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJson = "json"
+)
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", level)
+	}
+	return l, nil
+}
+
+// New returns a logger writing the records of at least the level to w, as
+// text or as JSON lines. A *slog.LevelVar level can be changed later.
+func New(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(format) {
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJson:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q, expected %v or %v", format, FormatText, FormatJson)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of the context, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+type requestIdKey struct{}
+
+// WithRequestId returns a context carrying the id of the request.
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// RequestId returns the id of the request of the context, "" outside of
+// a request.
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// NewRequestId returns the id of a request, random unless the client sent
+// a valid one: up to 64 letters, digits, dashes, dots and underscores.
+func NewRequestId(sent string) string {
+	valid := sent != "" && len(sent) <= 64
+	for _, c := range sent {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+			valid = false
+			break
+		}
+	}
+	if valid {
+		return sent
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
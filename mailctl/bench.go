@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mailinglist/client"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type benchCmd struct {
+	Rps         float64       `default:"50" help:"calls started per second"`
+	Duration    time.Duration `default:"60s" help:"how long the calls run"`
+	Mix         string        `default:"create=1,get=8,batch=1" help:"relative weights of the create, get and batch calls"`
+	Concurrency int           `default:"64" help:"most calls in flight, the rate drops when the server cannot keep up"`
+	Domain      string        `default:"bench.example.com" help:"domain of the emails created"`
+	Cleanup     bool          `help:"delete the emails created once done"`
+}
+
+const (
+	benchCreate = "create"
+	benchGet    = "get"
+	benchBatch  = "batch"
+)
+
+var benchOps = []string{benchCreate, benchGet, benchBatch}
+
+// parseMix reads the weights of the calls, e.g. create=1,get=8,batch=1.
+func parseMix(mix string) (map[string]int, int, error) {
+	weights := map[string]int{}
+	total := 0
+	for _, part := range strings.Split(mix, ",") {
+		op, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		weight, err := strconv.Atoi(value)
+		if !ok || err != nil || weight < 0 {
+			return nil, 0, fmt.Errorf("invalid mix %q, expected e.g. create=1,get=8,batch=1", part)
+		}
+		if op != benchCreate && op != benchGet && op != benchBatch {
+			return nil, 0, fmt.Errorf("unknown call %q in the mix", op)
+		}
+		weights[op] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, 0, fmt.Errorf("the mix has no call")
+	}
+	return weights, total, nil
+}
+
+// benchStats are the results of the calls of one kind.
+type benchStats struct {
+	latencies []time.Duration
+	errors    map[string]int
+}
+
+// benchRecorder collects the results of the calls and the emails created,
+// which the get calls read.
+type benchRecorder struct {
+	mu      sync.Mutex
+	stats   map[string]*benchStats
+	created []string
+}
+
+func (r *benchRecorder) record(op string, latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats[op]
+	if stats == nil {
+		stats = &benchStats{errors: map[string]int{}}
+		r.stats[op] = stats
+	}
+	stats.latencies = append(stats.latencies, latency)
+	if err != nil {
+		stats.errors[errorMessage(err)]++
+	}
+}
+
+func (r *benchRecorder) addCreated(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.created = append(r.created, addr)
+}
+
+// randomCreated returns one of the emails created, empty when there are
+// none yet.
+func (r *benchRecorder) randomCreated() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.created) == 0 {
+		return ""
+	}
+	return r.created[rand.Intn(len(r.created))]
+}
+
+// benchReport is the summary of the calls of one kind.
+type benchReport struct {
+	Op        string         `json:"op"`
+	Calls     int            `json:"calls"`
+	Errors    int            `json:"errors"`
+	ErrorRate float64        `json:"error_rate"`
+	P50       time.Duration  `json:"p50_ns"`
+	P90       time.Duration  `json:"p90_ns"`
+	P99       time.Duration  `json:"p99_ns"`
+	Max       time.Duration  `json:"max_ns"`
+	Rps       float64        `json:"rps"`
+	ByError   map[string]int `json:"by_error,omitempty"`
+}
+
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*q)]
+}
+
+func (r *benchRecorder) reports(elapsed time.Duration) []benchReport {
+	var reports []benchReport
+	for _, op := range benchOps {
+		stats := r.stats[op]
+		if stats == nil {
+			continue
+		}
+		sort.Slice(stats.latencies, func(i, j int) bool {
+			return stats.latencies[i] < stats.latencies[j]
+		})
+
+		report := benchReport{
+			Op:      op,
+			Calls:   len(stats.latencies),
+			P50:     percentile(stats.latencies, 0.5),
+			P90:     percentile(stats.latencies, 0.9),
+			P99:     percentile(stats.latencies, 0.99),
+			Max:     percentile(stats.latencies, 1),
+			Rps:     float64(len(stats.latencies)) / elapsed.Seconds(),
+			ByError: stats.errors,
+		}
+		for _, n := range stats.errors {
+			report.Errors += n
+		}
+		report.ErrorRate = float64(report.Errors) / float64(report.Calls)
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+func printBenchReports(reports []benchReport) error {
+	if args.Output == outputJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(reports)
+	}
+
+	round := func(d time.Duration) time.Duration {
+		return d.Round(10 * time.Microsecond)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CALL\tCALLS\tRPS\tERRORS\tP50\tP90\tP99\tMAX")
+	for _, report := range reports {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.2f%%\t%v\t%v\t%v\t%v\n",
+			report.Op, report.Calls, report.Rps, report.ErrorRate*100,
+			round(report.P50), round(report.P90), round(report.P99), round(report.Max))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, report := range reports {
+		for message, n := range report.ByError {
+			log.Printf("%v: %v failed with %v\n", report.Op, n, message)
+		}
+	}
+	return nil
+}
+
+// benchmark drives the mix of calls at the rate of the command for its
+// duration, then reports their latencies and errors.
+func benchmark(ctx context.Context, c *client.MailingListClient, cmd *benchCmd) error {
+	weights, total, err := parseMix(cmd.Mix)
+	if err != nil {
+		return err
+	}
+	if cmd.Rps <= 0 || cmd.Concurrency <= 0 {
+		return fmt.Errorf("--rps and --concurrency must be positive")
+	}
+
+	recorder := &benchRecorder{stats: map[string]*benchStats{}}
+	prefix := fmt.Sprintf("bench-%d-", time.Now().Unix())
+	var seq int64
+	var seqMu sync.Mutex
+	nextEmail := func() string {
+		seqMu.Lock()
+		defer seqMu.Unlock()
+		seq++
+		return fmt.Sprintf("%v%d@%v", prefix, seq, cmd.Domain)
+	}
+
+	call := func(op string) {
+		// The gets need an email created before.
+		if op == benchGet && recorder.randomCreated() == "" {
+			op = benchCreate
+		}
+
+		start := time.Now()
+		var err error
+		switch op {
+		case benchCreate:
+			addr := nextEmail()
+			_, err = c.CreateEmail(ctx, addr)
+			if err == nil {
+				recorder.addCreated(addr)
+			}
+		case benchGet:
+			_, err = c.GetEmail(ctx, recorder.randomCreated())
+		case benchBatch:
+			_, err = c.ListEmails(ctx, client.ListOptions{Count: 20})
+		}
+		recorder.record(op, time.Since(start), err)
+	}
+	pick := func() string {
+		n := rand.Intn(total)
+		for _, op := range benchOps {
+			if n < weights[op] {
+				return op
+			}
+			n -= weights[op]
+		}
+		return benchGet
+	}
+
+	log.Printf("running %v calls per second for %v\n", cmd.Rps, cmd.Duration)
+	limiter := rate.NewLimiter(rate.Limit(cmd.Rps), 1)
+	slots := make(chan struct{}, cmd.Concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline, cancel := context.WithTimeout(ctx, cmd.Duration)
+	defer cancel()
+	for limiter.Wait(deadline) == nil {
+		select {
+		case slots <- struct{}{}:
+		case <-deadline.Done():
+		}
+		if deadline.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(op string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			call(op)
+		}(pick())
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if err := printBenchReports(recorder.reports(elapsed)); err != nil {
+		return err
+	}
+
+	if cmd.Cleanup && len(recorder.created) > 0 {
+		result, err := runBulk(ctx, recorder.created, bulkFlags{Workers: 8}, c.DeleteEmails)
+		if err != nil {
+			return err
+		}
+		log.Printf("deleted the %v emails created\n", len(result.Emails))
+		printBulkErrors(result.Errors)
+	}
+	return nil
+}
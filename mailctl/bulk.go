@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mailinglist/client"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+)
+
+// bulkFlags spread the calls of the commands making one call per email.
+type bulkFlags struct {
+	Workers int     `default:"8" help:"how many calls run at once"`
+	Rate    float64 `help:"calls started per second, unlimited by default"`
+}
+
+// runBulk runs a bulk operation of the client with the flags, showing the
+// calls done on a terminal.
+func runBulk(ctx context.Context, addrs []string, flags bulkFlags, fn func(context.Context, []string, client.BulkOptions) (*client.BulkResult, error)) (*client.BulkResult, error) {
+	var calls int64
+	opts := client.BulkOptions{
+		Workers: flags.Workers,
+		Rate:    flags.Rate,
+		OnDone: func() {
+			atomic.AddInt64(&calls, 1)
+		},
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	if progressShown() {
+		wg.Add(1)
+		count := func() int64 {
+			return atomic.LoadInt64(&calls)
+		}
+		formatCount := func(n int64) string {
+			return strconv.FormatInt(n, 10)
+		}
+		go showProgress(count, int64(len(addrs)), formatCount, done, &wg)
+	}
+
+	result, err := fn(ctx, addrs, opts)
+	close(done)
+	wg.Wait()
+	return result, err
+}
+
+// printBulkErrors lists the failed emails, then how many failed with each
+// error.
+func printBulkErrors(errs []client.BulkError) {
+	if len(errs) == 0 {
+		return
+	}
+
+	counts := map[string]int{}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "EMAIL\tERROR")
+	for _, bulkErr := range errs {
+		message := errorMessage(bulkErr.Err)
+		counts[message]++
+		fmt.Fprintf(tw, "%s\t%s\n", bulkErr.Email, message)
+	}
+	tw.Flush()
+
+	messages := make([]string, 0, len(counts))
+	for message := range counts {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return counts[messages[i]] > counts[messages[j]]
+	})
+	for _, message := range messages {
+		log.Printf("%v failed with %v\n", counts[message], message)
+	}
+}
+
+// bulkImport creates the emails of the file with a call each, for the
+// servers where the streaming import is not available.
+func bulkImport(ctx context.Context, c *client.MailingListClient, r io.Reader, format string, cmd *importCmd) error {
+	emails, lines, issues, err := collectRows(r, format)
+	if err != nil {
+		return err
+	}
+
+	result, err := runBulk(ctx, emails, cmd.bulkFlags, c.CreateEmails)
+	if err != nil {
+		return err
+	}
+
+	summary := &client.ImportSummary{
+		Rows:    int64(len(emails) + len(issues)),
+		Created: int64(len(result.Emails)),
+	}
+	for _, issue := range issues {
+		summary.Invalid++
+		summary.Errors = append(summary.Errors, issue.importError())
+	}
+	for _, bulkErr := range result.Errors {
+		switch {
+		case errors.Is(bulkErr.Err, client.ErrAlreadyExists):
+			summary.Duplicates++
+			continue
+		case errors.Is(bulkErr.Err, client.ErrInvalidArgument):
+			summary.Invalid++
+		default:
+			summary.Failed++
+		}
+		summary.Errors = append(summary.Errors, client.ImportRowError{
+			Row:   int64(lines[bulkErr.Index]),
+			Email: bulkErr.Email,
+			Error: bulkErr.Err.Error(),
+		})
+	}
+	sort.Slice(summary.Errors, func(i, j int) bool {
+		return summary.Errors[i].Row < summary.Errors[j].Row
+	})
+	printImportReport(summary, false)
+	return nil
+}
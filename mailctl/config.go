@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profile holds the settings of one server, e.g. staging or production.
+// The flags and environment variables override them.
+type profile struct {
+	Transport  string        `yaml:"transport"`
+	GrpcAddr   string        `yaml:"grpc_addr"`
+	HttpAddr   string        `yaml:"http_addr"`
+	TLS        bool          `yaml:"tls"`
+	CaCert     string        `yaml:"ca_cert"`
+	ClientCert string        `yaml:"client_cert"`
+	ClientKey  string        `yaml:"client_key"`
+	Token      string        `yaml:"token"`
+	Output     string        `yaml:"output"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+type configFile struct {
+	// Profile is used when --profile is not given.
+	Profile  string             `yaml:"profile"`
+	Profiles map[string]profile `yaml:"profiles"`
+}
+
+// defaults are the settings used when neither the flags nor the profile
+// set them.
+var defaults = profile{
+	Transport: transportGrpc,
+	GrpcAddr:  ":9092",
+	HttpAddr:  "localhost:9091",
+	Output:    outputTable,
+}
+
+func defaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".mailinglist", "config.yaml")
+}
+
+// expandHome replaces a leading ~/ of a path with the home directory.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+// loadProfile reads the profile of the configuration file, the one named
+// by its profile key when name is empty. A missing file is only an error
+// when a profile is asked for.
+func loadProfile(path, name string) (profile, error) {
+	f, err := os.Open(expandHome(path))
+	if errors.Is(err, fs.ErrNotExist) && name == "" {
+		return profile{}, nil
+	}
+	if err != nil {
+		return profile{}, err
+	}
+	defer f.Close()
+
+	var config configFile
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && err != io.EOF {
+		return profile{}, fmt.Errorf("parsing %v : %w", path, err)
+	}
+
+	if name == "" {
+		name = config.Profile
+	}
+	if name == "" {
+		return profile{}, nil
+	}
+	p, ok := config.Profiles[name]
+	if !ok {
+		return profile{}, fmt.Errorf("no profile %q in %v", name, path)
+	}
+	p.CaCert = expandHome(p.CaCert)
+	p.ClientCert = expandHome(p.ClientCert)
+	p.ClientKey = expandHome(p.ClientKey)
+	return p, nil
+}
+
+func setDefault[T comparable](value *T, defaults ...T) {
+	var zero T
+	for _, d := range defaults {
+		if *value != zero {
+			return
+		}
+		*value = d
+	}
+}
+
+// applyProfile fills the settings the flags left unset from the profile,
+// then from the defaults.
+func applyProfile(p profile) {
+	setDefault(&args.Transport, p.Transport, defaults.Transport)
+	setDefault(&args.GrpcAddr, p.GrpcAddr, defaults.GrpcAddr)
+	setDefault(&args.HttpAddr, p.HttpAddr, defaults.HttpAddr)
+	setDefault(&args.Output, p.Output, defaults.Output)
+	setDefault(&args.Timeout, p.Timeout, defaultTimeout())
+
+	args.TLS = args.TLS || p.TLS
+	// The certificates come together, either from the flags or the profile.
+	if args.CaCert == "" && args.ClientCert == "" && args.ClientKey == "" {
+		args.CaCert, args.ClientCert, args.ClientKey = p.CaCert, p.ClientCert, p.ClientKey
+	}
+	// The token of the profile is only sent when no key was given.
+	setDefault(&args.Token, args.ApiKey, p.Token)
+}
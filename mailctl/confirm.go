@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// errCanceled is returned when a confirmation is declined.
+var errCanceled = errors.New("canceled")
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// readAnswer prints the prompt and reads the answer, the shell replaces it
+// to read from its prompt.
+var readAnswer = func(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	return stdinReader.ReadString('\n')
+}
+
+// confirm asks before a destructive change, returning errCanceled unless
+// the answer is yes. It does not ask with --yes, nor when the standard
+// input is not a terminal, so that scripts are not blocked.
+func confirm(format string, a ...interface{}) error {
+	if args.Yes || !isTerminal(os.Stdin) {
+		return nil
+	}
+
+	answer, err := readAnswer(fmt.Sprintf(format, a...) + " [y/N] ")
+	if err != nil {
+		return errCanceled
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errCanceled
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"mailinglist/client"
+)
+
+// The exit codes tell scripts why a command failed. Invalid flags exit
+// with exitUsage, from the argument parser.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+	// exitNotFound is returned when the email or token does not exist.
+	exitNotFound = 3
+	// exitInvalid is returned when the server, or the checks made before
+	// calling it, rejected an email or a field.
+	exitInvalid = 4
+	// exitAuth is returned when the token is missing, unknown or not
+	// allowed to make the call.
+	exitAuth = 5
+	// exitTransport is returned when the server could not be reached or
+	// did not answer in time, even after the retries.
+	exitTransport = 6
+	// exitConflict is returned when creating an email that exists.
+	exitConflict = 7
+)
+
+// exitCode is the exit code of a command that returned err.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, client.ErrNotFound), errors.Is(err, errInvalidConfirmToken),
+		errors.Is(err, errInvalidUnsubscribeToken):
+		return exitNotFound
+	case errors.Is(err, client.ErrInvalidArgument):
+		return exitInvalid
+	case errors.Is(err, client.ErrUnauthenticated), errors.Is(err, client.ErrPermissionDenied):
+		return exitAuth
+	case errors.Is(err, client.ErrUnavailable), errors.Is(err, client.ErrRateLimited),
+		errors.Is(err, context.DeadlineExceeded):
+		return exitTransport
+	case errors.Is(err, client.ErrAlreadyExists):
+		return exitConflict
+	}
+	return exitError
+}
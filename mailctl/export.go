@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mailinglist/client"
+	"os"
+	"strconv"
+)
+
+type exportCmd struct {
+	Format            string `default:"csv" help:"csv or ndjson"`
+	Out               string `help:"file to write, the standard output by default"`
+	Resume            bool   `help:"continue the interrupted export written to --out"`
+	ConfirmedOnly     bool   `arg:"--confirmedonly"`
+	IncludeOptOut     bool   `arg:"--includeoptout"`
+	IncludeComplained bool   `arg:"--includecomplained" help:"with --includeoptout, also export the emails which complained"`
+	Tag               string
+}
+
+// resumeFile drops the partial row at the end of an interrupted export
+// and returns the id of the email in the last complete row, 0 when the
+// export has to start over.
+func resumeFile(f *os.File, format client.ExportFormat) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// Reads back from the end until the two newlines around the last
+	// complete row are found.
+	const blockSize = 4 << 10
+	var tail []byte
+	end := info.Size()
+	for pos := info.Size(); pos > 0 && bytes.Count(tail, []byte("\n")) < 2; {
+		n := int64(blockSize)
+		if pos < n {
+			n = pos
+		}
+		pos -= n
+		block := make([]byte, n)
+		if _, err := f.ReadAt(block, pos); err != nil {
+			return 0, err
+		}
+		tail = append(block, tail...)
+	}
+
+	// The rows written are complete, so a row without its newline was cut
+	// while writing.
+	lastNewline := bytes.LastIndexByte(tail, '\n')
+	if lastNewline < 0 {
+		return 0, f.Truncate(0)
+	}
+	end -= int64(len(tail) - lastNewline - 1)
+	if err := f.Truncate(end); err != nil {
+		return 0, err
+	}
+	line := tail[bytes.LastIndexByte(tail[:lastNewline], '\n')+1 : lastNewline]
+
+	var id int64
+	if format == client.ExportNdjson {
+		var entry struct {
+			Id json.Number `json:"id"`
+		}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return 0, fmt.Errorf("reading the last row : %w", err)
+		}
+		id, err = entry.Id.Int64()
+	} else {
+		var record []string
+		record, err = csv.NewReader(bytes.NewReader(line)).Read()
+		if err == nil && record[0] == "id" {
+			// Only the header was written.
+			return 0, f.Truncate(0)
+		}
+		if err == nil {
+			id, err = strconv.ParseInt(record[0], 10, 64)
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("reading the last row : %w", err)
+	}
+	return id, nil
+}
+
+func exportEmails(ctx context.Context, c *client.MailingListClient, cmd *exportCmd) error {
+	format := client.ExportFormat(cmd.Format)
+	if format != client.ExportCsv && format != client.ExportNdjson {
+		return fmt.Errorf("unknown format %q", cmd.Format)
+	}
+	if cmd.Resume && cmd.Out == "" {
+		return errors.New("--resume requires --out")
+	}
+
+	var out io.Writer = os.Stdout
+	var afterId int64
+	if cmd.Out != "" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if cmd.Resume {
+			flags = os.O_RDWR | os.O_CREATE
+		}
+		file, err := os.OpenFile(cmd.Out, flags, 0644)
+		if err != nil {
+			return fmt.Errorf("opening %v : %w", cmd.Out, err)
+		}
+		defer file.Close()
+
+		if cmd.Resume {
+			afterId, err = resumeFile(file, format)
+			if err != nil {
+				return fmt.Errorf("resuming %v : %w", cmd.Out, err)
+			}
+			if _, err := file.Seek(0, io.SeekEnd); err != nil {
+				return fmt.Errorf("resuming %v : %w", cmd.Out, err)
+			}
+			if afterId > 0 {
+				log.Printf("resuming after email %v\n", afterId)
+			}
+		}
+		out = file
+	}
+
+	lastId, err := c.ExportEmails(ctx, out, client.ExportOptions{
+		Format:            format,
+		ConfirmedOnly:     cmd.ConfirmedOnly,
+		IncludeOptOut:     cmd.IncludeOptOut,
+		IncludeComplained: cmd.IncludeComplained,
+		Tag:               cmd.Tag,
+		AfterId:           afterId,
+	})
+	if err != nil && cmd.Out != "" {
+		log.Printf("export interrupted after email %v, run it again with --resume to continue\n", lastId)
+	}
+	return err
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mailinglist/client"
+	"mailinglist/sanitize"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chzyer/readline"
+)
+
+const (
+	formatCsv    = "csv"
+	formatNdjson = "ndjson"
+)
+
+type importCmd struct {
+	File   string `arg:"positional,required" help:"CSV or NDJSON file of emails, - reads the standard input"`
+	Format string `help:"csv or ndjson, detected from the file extension by default"`
+	DryRun bool   `arg:"--dry-run" help:"report what the import would do, comparing the file with the listed emails"`
+	// SkipInvalid only applies to files, the rows read from the standard
+	// input are checked as they are sent.
+	SkipInvalid bool `arg:"--skip-invalid" help:"import the valid rows of a file with invalid ones instead of failing"`
+	Bulk        bool `help:"create the emails with a call each instead of the streaming import, as done over HTTP"`
+	bulkFlags
+}
+
+// maxListedIssues is how many invalid rows are listed when an import
+// fails because of them.
+const maxListedIssues = 20
+
+// checkFile reads the whole file to fail before sending anything when it
+// has invalid rows, which are listed.
+func checkFile(file *os.File, format string) error {
+	issues, err := normalizeRows(file, format, io.Discard)
+	if err != nil {
+		return fmt.Errorf("reading %v : %w", file.Name(), err)
+	}
+	if len(issues) == 0 {
+		_, err := file.Seek(0, io.SeekStart)
+		return err
+	}
+
+	for i, issue := range issues {
+		if i == maxListedIssues {
+			log.Printf("... and %v more\n", len(issues)-maxListedIssues)
+			break
+		}
+		log.Printf("%v:%d:%d: %v\n", file.Name(), issue.Line, issue.Column, issue.Message)
+	}
+	return fmt.Errorf("%v has %v invalid rows, nothing was imported; fix them or use --skip-invalid", file.Name(), len(issues))
+}
+
+// countingReader counts the bytes read, for the progress bar.
+type countingReader struct {
+	r    io.Reader
+	read int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(&r.read, int64(n))
+	return n, err
+}
+
+func (r *countingReader) count() int64 {
+	return atomic.LoadInt64(&r.read)
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// showProgress redraws a progress bar of count on the standard error until
+// done is closed, formatting the amounts with unit. total is 0 when
+// unknown.
+func showProgress(count func() int64, total int64, unit func(int64) string, done <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	const width = 30
+	draw := func() {
+		n := count()
+		if total <= 0 {
+			fmt.Fprintf(os.Stderr, "\rsent %v", unit(n))
+			return
+		}
+		filled := int(n * width / total)
+		if filled > width {
+			filled = width
+		}
+		fmt.Fprintf(os.Stderr, "\r[%s%s] %3d%% %v/%v",
+			strings.Repeat("=", filled), strings.Repeat(" ", width-filled),
+			n*100/total, unit(n), unit(total))
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			draw()
+			fmt.Fprintln(os.Stderr)
+			return
+		case <-ticker.C:
+			draw()
+		}
+	}
+}
+
+// isTerminal reports whether f is a terminal, where the progress bar is
+// shown and the confirmations asked.
+func isTerminal(f *os.File) bool {
+	return readline.IsTerminal(int(f.Fd()))
+}
+
+// progressShown reports whether the long commands show their progress,
+// on a terminal unless --quiet.
+func progressShown() bool {
+	return !args.Quiet && isTerminal(os.Stderr)
+}
+
+// dryRunImport reads the CSV rows like the server does, and reports the
+// emails already listed as duplicates instead of creating the others.
+func dryRunImport(ctx context.Context, c *client.MailingListClient, r io.Reader) (*client.ImportSummary, error) {
+	existing := map[string]bool{}
+	opts := client.ListOptions{Count: batchSize, IncludeOptOut: true, IncludeComplained: true}
+	err := listPages(ctx, c, opts, func(page *client.EmailPage) bool {
+		for _, email := range page.Emails {
+			existing[email.Email] = true
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &client.ImportSummary{}
+	rowError := func(row int, email, message string) {
+		summary.Invalid++
+		summary.Errors = append(summary.Errors, client.ImportRowError{Row: int64(row), Email: email, Error: message})
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	column := 0
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			summary.Rows++
+			rowError(parseErr.StartLine, "", parseErr.Err.Error())
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		row, _ := reader.FieldPos(0)
+
+		if first {
+			header := false
+			for i, name := range record {
+				if strings.EqualFold(strings.TrimSpace(name), "email") {
+					column, header = i, true
+					break
+				}
+			}
+			if header {
+				continue
+			}
+		}
+
+		summary.Rows++
+		if column >= len(record) {
+			rowError(row, "", "missing email column")
+			continue
+		}
+		email := strings.TrimSpace(record[column])
+		if err := sanitize.Email("email", email); err != nil {
+			rowError(row, email, err.Error())
+			continue
+		}
+		if existing[email] {
+			summary.Duplicates++
+			continue
+		}
+		existing[email] = true
+		summary.Created++
+	}
+	return summary, nil
+}
+
+func printImportReport(summary *client.ImportSummary, dryRun bool) {
+	verb := "imported"
+	if dryRun {
+		verb = "dry run, would import"
+	}
+	failed := ""
+	if summary.Failed > 0 {
+		failed = fmt.Sprintf(", %v failed", summary.Failed)
+	}
+	log.Printf("%v %v rows: %v created, %v duplicates, %v invalid%v\n",
+		verb, summary.Rows, summary.Created, summary.Duplicates, summary.Invalid, failed)
+	if len(summary.Errors) == 0 {
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROW\tEMAIL\tERROR")
+	for _, rowErr := range summary.Errors {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", rowErr.Row, rowErr.Email, rowErr.Error)
+	}
+	tw.Flush()
+	if unlisted := summary.Invalid + summary.Failed - int64(len(summary.Errors)); unlisted > 0 {
+		log.Printf("%v more invalid rows are not listed\n", unlisted)
+	}
+}
+
+func importEmails(ctx context.Context, c *client.MailingListClient, cmd *importCmd) error {
+	format := cmd.Format
+	if format == "" {
+		format = formatCsv
+		switch strings.ToLower(filepath.Ext(cmd.File)) {
+		case ".ndjson", ".jsonl":
+			format = formatNdjson
+		}
+	}
+	if format != formatCsv && format != formatNdjson {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	file := os.Stdin
+	if cmd.File != "-" {
+		var err error
+		file, err = os.Open(cmd.File)
+		if err != nil {
+			return fmt.Errorf("opening %v : %w", cmd.File, err)
+		}
+		defer file.Close()
+	}
+
+	var size int64
+	if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+		size = info.Size()
+		if !cmd.SkipInvalid {
+			if err := checkFile(file, format); err != nil {
+				return err
+			}
+		}
+	}
+	// The streaming import is not served over HTTP.
+	if !cmd.DryRun && (cmd.Bulk || args.Transport == transportHttp) {
+		return bulkImport(ctx, c, file, format, cmd)
+	}
+	counter := &countingReader{r: file}
+
+	// The rows are checked and normalized on their way to the server.
+	var issues []rowIssue
+	converted := make(chan struct{})
+	r, pw := io.Pipe()
+	go func() {
+		var err error
+		issues, err = normalizeRows(counter, format, pw)
+		pw.CloseWithError(err)
+		close(converted)
+	}()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	if progressShown() {
+		wg.Add(1)
+		go showProgress(counter.count, size, formatBytes, done, &wg)
+	}
+
+	var summary *client.ImportSummary
+	var err error
+	if cmd.DryRun {
+		summary, err = dryRunImport(ctx, c, r)
+	} else {
+		summary, err = c.ImportEmails(ctx, r)
+	}
+	close(done)
+	wg.Wait()
+	// Unblocks the conversion if the server stopped reading early.
+	r.Close()
+	<-converted
+	if err != nil {
+		return err
+	}
+
+	if len(issues) > 0 {
+		summary.Rows += int64(len(issues))
+		summary.Invalid += int64(len(issues))
+		for _, issue := range issues {
+			summary.Errors = append(summary.Errors, issue.importError())
+		}
+		sort.Slice(summary.Errors, func(i, j int) bool {
+			return summary.Errors[i].Row < summary.Errors[j].Row
+		})
+	}
+	printImportReport(summary, cmd.DryRun)
+	return nil
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mailinglist/client"
+	"mailinglist/tlsutil"
+	"mailinglist/tracing"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alexflint/go-arg"
+)
+
+type createCmd struct {
+	Email string `arg:"positional,required"`
+}
+
+type getCmd struct {
+	Email string `arg:"positional,required"`
+}
+
+type updateCmd struct {
+	Email     string `arg:"positional,required"`
+	Confirm   bool   `help:"mark the email as confirmed now"`
+	Unconfirm bool   `help:"clear the confirmation of the email"`
+	OptOut    bool   `arg:"--optout" help:"opt the email out"`
+	OptIn     bool   `arg:"--optin" help:"opt the email back in"`
+}
+
+type confirmCmd struct {
+	Target string `arg:"positional,required" placeholder:"EMAIL|TOKEN" help:"email, or token of its confirmation link"`
+}
+
+type deleteCmd struct {
+	Emails []string `arg:"positional"`
+	File   string   `help:"CSV or NDJSON file of more emails to delete, - reads the standard input"`
+	DryRun bool     `arg:"--dry-run" help:"show the emails that would be deleted"`
+	bulkFlags
+}
+
+type listCmd struct {
+	Page              int32  `default:"1"`
+	Count             int32  `default:"20"`
+	PageToken         string `arg:"--pagetoken" help:"next page token of a previous list"`
+	ConfirmedOnly     bool   `arg:"--confirmedonly"`
+	IncludeOptOut     bool   `arg:"--includeoptout"`
+	IncludeComplained bool   `arg:"--includecomplained" help:"with --includeoptout, also list the emails which complained"`
+	Tag               string
+	Sort              string `default:"id" help:"id, email or created_at, prefixed with - to sort descending, e.g. --sort=-id"`
+	All               bool   `help:"list the emails of every page, from --pagetoken if given"`
+}
+
+type searchCmd struct {
+	Query             string `arg:"positional,required" help:"part of the email addresses to find"`
+	Limit             int    `help:"stop after this many emails, 0 returns all of them"`
+	ConfirmedOnly     bool   `arg:"--confirmedonly"`
+	IncludeOptOut     bool   `arg:"--includeoptout"`
+	IncludeComplained bool   `arg:"--includecomplained" help:"with --includeoptout, also find the emails which complained"`
+	Tag               string
+}
+
+// commands are the subcommands, also run by the shell.
+type commands struct {
+	Create      *createCmd      `arg:"subcommand:create" help:"add an email to the mailing list"`
+	Get         *getCmd         `arg:"subcommand:get" help:"show an email"`
+	Update      *updateCmd      `arg:"subcommand:update" help:"confirm or opt out an email"`
+	Confirm     *confirmCmd     `arg:"subcommand:confirm" help:"confirm an email, or the email of a confirmation link token"`
+	Delete      *deleteCmd      `arg:"subcommand:delete" help:"delete emails"`
+	List        *listCmd        `arg:"subcommand:list" help:"list a page of emails"`
+	Search      *searchCmd      `arg:"subcommand:search" help:"find the emails containing a text"`
+	Import      *importCmd      `arg:"subcommand:import" help:"create the emails of a CSV or NDJSON file"`
+	Export      *exportCmd      `arg:"subcommand:export" help:"write the emails to a CSV or NDJSON file"`
+	Watch       *watchCmd       `arg:"subcommand:watch" help:"print the subscriber events as they happen"`
+	Unsubscribe *unsubscribeCmd `arg:"subcommand:unsubscribe" help:"opt out an email, or those of a domain or matching a pattern"`
+	Sync        *syncCmd        `arg:"subcommand:sync" help:"copy the missing emails and changes of a server to another"`
+	Seed        *seedCmd        `arg:"subcommand:seed" help:"create fake subscribers on a development server"`
+	Stats       *statsCmd       `arg:"subcommand:stats" help:"show the subscriber totals and the daily signups"`
+	Bench       *benchCmd       `arg:"subcommand:bench" help:"measure the latencies of a mix of calls at a given rate"`
+}
+
+var args struct {
+	commands
+	Shell *shellCmd `arg:"subcommand:shell" help:"run the commands from an interactive prompt"`
+
+	Config  string `arg:"env:MAILING_LIST_CONFIG" help:"configuration file with the profiles [default: ~/.mailinglist/config.yaml]"`
+	Profile string `arg:"env:MAILING_LIST_PROFILE" help:"profile of the configuration file setting the defaults of the flags below"`
+
+	Transport string `arg:"env:MAILING_LIST_TRANSPORT" help:"grpc, or http to call the REST API of the JSON server [default: grpc]"`
+	GrpcAddr  string `arg:"env:MAILING_LIST_GRPC_ADDR" help:"host:port of the gRPC server [default: :9092]"`
+	HttpAddr  string `arg:"--http-addr,env:MAILING_LIST_HTTP_ADDR" help:"host:port or URL of the JSON server, with --transport http [default: localhost:9091]"`
+
+	Timeout time.Duration `arg:"env:MAILING_LIST_TIMEOUT" help:"deadline of each call, imports, exports and watches run until done [default: 30s for list and search, 10s otherwise]"`
+	Output  string        `arg:"-o,env:MAILING_LIST_OUTPUT" help:"table, json or csv [default: table]"`
+
+	TLS        bool   `arg:"--tls,env:MAILING_LIST_TLS" help:"connect with TLS, verifying the server with the system CAs"`
+	CaCert     string `arg:"--ca-cert,env:MAILING_LIST_GRPC_CA_CERT" help:"CA verifying the server instead, implies --tls"`
+	ClientCert string `arg:"--client-cert,env:MAILING_LIST_GRPC_CLIENT_CERT" help:"certificate presented to servers requiring mTLS, implies --tls"`
+	ClientKey  string `arg:"--client-key,env:MAILING_LIST_GRPC_CLIENT_KEY" help:"private key of --client-cert"`
+
+	Yes   bool `arg:"-y,--yes,env:MAILING_LIST_YES" help:"do not ask before deleting"`
+	Quiet bool `arg:"-q,--quiet,env:MAILING_LIST_QUIET" help:"only print the results and the errors, which the exit code tells apart"`
+
+	Token  string `arg:"--token,env:MAILING_LIST_TOKEN" help:"API key sent with each call"`
+	ApiKey string `arg:"--api-key,env:MAILING_LIST_API_KEY" help:"same as --token"`
+
+	OtlpEndpoint string `arg:"--otlp-endpoint,env:MAILING_LIST_OTLP_ENDPOINT" help:"host:port of an OTLP gRPC collector receiving the traces"`
+	OtlpInsecure bool   `arg:"--otlp-insecure,env:MAILING_LIST_OTLP_INSECURE"`
+}
+
+const (
+	transportGrpc = "grpc"
+	transportHttp = "http"
+)
+
+// defaultTimeout is the deadline of each call when neither --timeout nor the
+// profile set it. The pages of emails take longer to fetch, so the shell
+// has their timeout too.
+func defaultTimeout() time.Duration {
+	if args.List != nil || args.Search != nil || args.Unsubscribe != nil || args.Sync != nil || args.Shell != nil {
+		return 30 * time.Second
+	}
+	return 10 * time.Second
+}
+
+// batchSize is how many emails are requested at once when going through
+// every page.
+const batchSize = 100
+
+func printEntries(entries []*client.Email, single bool) error {
+	if err := renderers[args.Output](os.Stdout, entries, single); err != nil {
+		return fmt.Errorf("writing the output : %w", err)
+	}
+	return nil
+}
+
+// errorMessage is how the errors of the commands are reported.
+func errorMessage(err error) string {
+	if errors.Is(err, client.ErrNotFound) {
+		return "email not found"
+	}
+	if errors.Is(err, errCanceled) {
+		return err.Error()
+	}
+	return fmt.Sprintf("error: %v", err)
+}
+
+func createEmail(ctx context.Context, c *client.MailingListClient, cmd *createCmd) error {
+	email, err := c.CreateEmail(ctx, cmd.Email)
+	if err != nil {
+		return err
+	}
+	return printEntries([]*client.Email{email}, true)
+}
+
+func getEmail(ctx context.Context, c *client.MailingListClient, cmd *getCmd) error {
+	email, err := c.GetEmail(ctx, cmd.Email)
+	if err != nil {
+		return err
+	}
+	return printEntries([]*client.Email{email}, true)
+}
+
+func updateEmail(ctx context.Context, c *client.MailingListClient, cmd *updateCmd) error {
+	if cmd.Confirm && cmd.Unconfirm {
+		return errors.New("--confirm and --unconfirm are mutually exclusive")
+	}
+	if cmd.OptOut && cmd.OptIn {
+		return errors.New("--optout and --optin are mutually exclusive")
+	}
+
+	email, err := c.GetEmail(ctx, cmd.Email)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Confirm {
+		now := time.Now()
+		email.ConfirmedAt = &now
+	}
+	if cmd.Unconfirm {
+		email.ConfirmedAt = nil
+	}
+	if cmd.OptOut {
+		email.OptOut = true
+	}
+	if cmd.OptIn {
+		email.OptOut = false
+		email.OptOutReason = ""
+	}
+
+	email, err = c.UpdateEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	return printEntries([]*client.Email{email}, true)
+}
+
+// The tokens that do not exist, or were already used for confirmations,
+// exit like the missing emails.
+var (
+	errInvalidConfirmToken     = errors.New("invalid or already used confirmation token")
+	errInvalidUnsubscribeToken = errors.New("invalid unsubscribe token")
+)
+
+// isToken tells the tokens of the confirmation and unsubscribe links from
+// the emails.
+func isToken(target string) bool {
+	return !strings.Contains(target, "@")
+}
+
+func confirmEmail(ctx context.Context, c *client.MailingListClient, cmd *confirmCmd) error {
+	if isToken(cmd.Target) {
+		email, err := c.ConfirmEmail(ctx, cmd.Target)
+		if errors.Is(err, client.ErrNotFound) {
+			return errInvalidConfirmToken
+		}
+		if err != nil {
+			return err
+		}
+		return printEntries([]*client.Email{email}, true)
+	}
+
+	email, err := c.GetEmail(ctx, cmd.Target)
+	if err != nil {
+		return err
+	}
+	if email.ConfirmedAt != nil {
+		log.Println("already confirmed")
+		return printEntries([]*client.Email{email}, true)
+	}
+
+	now := time.Now()
+	email.ConfirmedAt = &now
+	email, err = c.UpdateEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	return printEntries([]*client.Email{email}, true)
+}
+
+func deleteEmail(ctx context.Context, c *client.MailingListClient, cmd *deleteCmd) error {
+	addrs := cmd.Emails
+	if cmd.File != "" {
+		fileAddrs, err := readEmailsFile(cmd.File)
+		if err != nil {
+			return err
+		}
+		addrs = append(addrs, fileAddrs...)
+	}
+	switch len(addrs) {
+	case 0:
+		return errors.New("no email to delete")
+	case 1:
+		if cmd.File == "" {
+			return deleteOneEmail(ctx, c, cmd, addrs[0])
+		}
+	}
+
+	var result *client.BulkResult
+	var err error
+	if cmd.DryRun {
+		result, err = runBulk(ctx, addrs, cmd.bulkFlags, c.GetEmails)
+	} else {
+		if err := confirm("Delete %v emails?", len(addrs)); err != nil {
+			return err
+		}
+		result, err = runBulk(ctx, addrs, cmd.bulkFlags, c.DeleteEmails)
+	}
+	if err != nil {
+		return err
+	}
+
+	if cmd.DryRun {
+		log.Printf("dry run, would delete %v emails:\n", len(result.Emails))
+	}
+	if err := printEntries(result.Emails, false); err != nil {
+		return err
+	}
+	if !cmd.DryRun {
+		log.Printf("deleted %v emails\n", len(result.Emails))
+	}
+	printBulkErrors(result.Errors)
+	return nil
+}
+
+// readEmailsFile reads the emails of a CSV or NDJSON file, failing on its
+// invalid rows.
+func readEmailsFile(path string) ([]string, error) {
+	format := formatCsv
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ndjson", ".jsonl":
+		format = formatNdjson
+	}
+
+	file := os.Stdin
+	if path != "-" {
+		var err error
+		file, err = os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening %v : %w", path, err)
+		}
+		defer file.Close()
+	}
+
+	emails, _, issues, err := collectRows(file, format)
+	if err != nil {
+		return nil, fmt.Errorf("reading %v : %w", path, err)
+	}
+	if len(issues) > 0 {
+		issue := issues[0]
+		return nil, fmt.Errorf("%v:%d:%d: %v", path, issue.Line, issue.Column, issue.Message)
+	}
+	return emails, nil
+}
+
+func deleteOneEmail(ctx context.Context, c *client.MailingListClient, cmd *deleteCmd, addr string) error {
+	if cmd.DryRun {
+		email, err := c.GetEmail(ctx, addr)
+		if err != nil {
+			return err
+		}
+		log.Println("dry run, would delete:")
+		return printEntries([]*client.Email{email}, true)
+	}
+
+	if err := confirm("Delete %v?", addr); err != nil {
+		return err
+	}
+	email, err := c.DeleteEmail(ctx, addr)
+	if err != nil {
+		return err
+	}
+	return printEntries([]*client.Email{email}, true)
+}
+
+// listPages calls fn with the pages of the emails selected by opts, from
+// opts.PageToken or opts.Page, until the last one or until fn returns
+// false. The sorts without page tokens go through the page numbers.
+func listPages(ctx context.Context, c *client.MailingListClient, opts client.ListOptions, fn func(*client.EmailPage) bool) error {
+	for {
+		page, err := c.ListEmails(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if !fn(page) {
+			return nil
+		}
+
+		switch {
+		case page.NextPageToken != "":
+			opts.PageToken = page.NextPageToken
+		case opts.PageToken == "" && page.Page < page.PageCount:
+			opts.Page = page.Page + 1
+		default:
+			return nil
+		}
+	}
+}
+
+func listEmails(ctx context.Context, c *client.MailingListClient, cmd *listCmd) error {
+	opts := client.ListOptions{
+		Page:              cmd.Page,
+		Count:             cmd.Count,
+		PageToken:         cmd.PageToken,
+		ConfirmedOnly:     cmd.ConfirmedOnly,
+		IncludeOptOut:     cmd.IncludeOptOut,
+		IncludeComplained: cmd.IncludeComplained,
+		Tag:               cmd.Tag,
+		Sort:              client.Sort(cmd.Sort),
+	}
+
+	if cmd.All {
+		opts.Count = batchSize
+		var emails []*client.Email
+		err := listPages(ctx, c, opts, func(page *client.EmailPage) bool {
+			emails = append(emails, page.Emails...)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if err := printEntries(emails, false); err != nil {
+			return err
+		}
+		log.Printf("%v emails\n", len(emails))
+		return nil
+	}
+
+	page, err := c.ListEmails(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := printEntries(page.Emails, false); err != nil {
+		return err
+	}
+	log.Printf("page %v of %v, %v emails in total\n", page.Page, page.PageCount, page.TotalCount)
+	if page.NextPageToken != "" {
+		log.Printf("next page: --pagetoken %v\n", page.NextPageToken)
+	}
+	return nil
+}
+
+func searchEmails(ctx context.Context, c *client.MailingListClient, cmd *searchCmd) error {
+	opts := client.ListOptions{
+		Count:             batchSize,
+		ConfirmedOnly:     cmd.ConfirmedOnly,
+		IncludeOptOut:     cmd.IncludeOptOut,
+		IncludeComplained: cmd.IncludeComplained,
+		Tag:               cmd.Tag,
+		Query:             cmd.Query,
+	}
+
+	var found []*client.Email
+	err := listPages(ctx, c, opts, func(page *client.EmailPage) bool {
+		found = append(found, page.Emails...)
+		if cmd.Limit > 0 && len(found) >= cmd.Limit {
+			found = found[:cmd.Limit]
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if err := printEntries(found, false); err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		log.Println("no email entries found")
+	}
+	return nil
+}
+
+// run runs the subcommand set in cmds.
+func run(ctx context.Context, c *client.MailingListClient, cmds *commands) error {
+	switch {
+	case cmds.Create != nil:
+		return createEmail(ctx, c, cmds.Create)
+	case cmds.Get != nil:
+		return getEmail(ctx, c, cmds.Get)
+	case cmds.Update != nil:
+		return updateEmail(ctx, c, cmds.Update)
+	case cmds.Confirm != nil:
+		return confirmEmail(ctx, c, cmds.Confirm)
+	case cmds.Delete != nil:
+		return deleteEmail(ctx, c, cmds.Delete)
+	case cmds.List != nil:
+		return listEmails(ctx, c, cmds.List)
+	case cmds.Search != nil:
+		return searchEmails(ctx, c, cmds.Search)
+	case cmds.Import != nil:
+		return importEmails(ctx, c, cmds.Import)
+	case cmds.Export != nil:
+		return exportEmails(ctx, c, cmds.Export)
+	case cmds.Watch != nil:
+		return watchEvents(ctx, c, cmds.Watch)
+	case cmds.Unsubscribe != nil:
+		return unsubscribeEmails(ctx, c, cmds.Unsubscribe)
+	case cmds.Sync != nil:
+		return syncServers(ctx, cmds.Sync)
+	case cmds.Seed != nil:
+		return seed(ctx, c, cmds.Seed)
+	case cmds.Stats != nil:
+		return showStats(ctx, c, cmds.Stats)
+	case cmds.Bench != nil:
+		return benchmark(ctx, c, cmds.Bench)
+	}
+	return nil
+}
+
+// clientConfig connects to the server at addr, the address of the JSON
+// server with --transport http, with the settings of the flags.
+func clientConfig(addr string) (client.Config, error) {
+	tlsConf, err := tlsConfig()
+	if err != nil {
+		return client.Config{}, err
+	}
+
+	config := client.Config{
+		Addr:        addr,
+		TLS:         tlsConf,
+		Timeout:     args.Timeout,
+		ApiKey:      args.Token,
+		DialOptions: tracing.DialOptions(),
+	}
+	if args.Transport == transportHttp {
+		config.Addr, config.HTTPAddr = args.GrpcAddr, addr
+	}
+	return config, nil
+}
+
+func tlsConfig() (*tls.Config, error) {
+	if !args.TLS && args.CaCert == "" && args.ClientCert == "" {
+		return nil, nil
+	}
+
+	config, err := tlsutil.ClientConfig(args.CaCert, args.ClientCert, args.ClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("loading the TLS configuration : %w", err)
+	}
+	return config, nil
+}
+
+func main() {
+	p, err := arg.NewParser(arg.Config{}, &args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	// Like p.Fail, but with exitUsage.
+	fail := func(message string) {
+		p.WriteUsageForSubcommand(os.Stderr, p.SubcommandNames()...)
+		fmt.Fprintln(os.Stderr, "error:", message)
+		os.Exit(exitUsage)
+	}
+	err = p.Parse(os.Args[1:])
+	switch {
+	case errors.Is(err, arg.ErrHelp):
+		p.WriteHelpForSubcommand(os.Stdout, p.SubcommandNames()...)
+		os.Exit(exitOK)
+	case err != nil:
+		fail(err.Error())
+	case p.Subcommand() == nil:
+		p.WriteHelp(os.Stderr)
+		os.Exit(exitUsage)
+	}
+	if args.Token != "" && args.ApiKey != "" {
+		fail("--token and --api-key are mutually exclusive")
+	}
+	if args.Config == "" {
+		args.Config = defaultConfigPath()
+	}
+	prof, err := loadProfile(args.Config, args.Profile)
+	if err != nil {
+		fail(err.Error())
+	}
+	applyProfile(prof)
+	if _, ok := renderers[args.Output]; !ok {
+		fail(fmt.Sprintf("unknown output %q", args.Output))
+	}
+	if args.Transport != transportGrpc && args.Transport != transportHttp {
+		fail(fmt.Sprintf("unknown transport %q", args.Transport))
+	}
+	if (args.ClientCert == "") != (args.ClientKey == "") {
+		fail("--client-cert and --client-key go together")
+	}
+	log.SetFlags(0)
+	if args.Quiet {
+		log.SetOutput(io.Discard)
+	}
+
+	os.Exit(runMain())
+}
+
+// runMain runs the command of the parsed flags and returns the exit code,
+// once the connection is closed and the traces flushed. The errors are
+// printed even with --quiet.
+func runMain() int {
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    args.OtlpEndpoint,
+		Insecure:    args.OtlpInsecure,
+		ServiceName: "mailctl",
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error setting up tracing : %v\n", err)
+		return exitError
+	}
+	defer shutdownTracing(context.Background())
+
+	addr := args.GrpcAddr
+	if args.Transport == transportHttp {
+		addr = args.HttpAddr
+	}
+	config, err := clientConfig(addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return exitUsage
+	}
+	c, err := client.New(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error connecting to the server at %v : %v\n", addr, err)
+		return exitTransport
+	}
+	defer c.Close()
+
+	if args.Shell != nil {
+		err = runShell(c)
+	} else {
+		err = run(context.Background(), c, &args.commands)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, errorMessage(err))
+	}
+	return exitCode(err)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mailinglist/client"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	outputTable = "table"
+	outputJson  = "json"
+	outputCsv   = "csv"
+)
+
+// renderers write the emails in each output format. single is set for the
+// commands returning one email, so that the JSON output is an object
+// instead of an array.
+var renderers = map[string]func(w io.Writer, entries []*client.Email, single bool) error{
+	outputTable: renderTable,
+	outputJson:  renderJson,
+	outputCsv:   renderCsv,
+}
+
+var entryHeader = []string{"id", "email", "confirmed_at", "opt_out", "opt_out_reason", "created_at"}
+
+func formatTime(t *time.Time, layout string, loc *time.Location) string {
+	if t == nil {
+		return ""
+	}
+	return t.In(loc).Format(layout)
+}
+
+func renderTable(w io.Writer, entries []*client.Email, single bool) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tCONFIRMED\tOPT OUT\tREASON\tCREATED")
+	for _, entry := range entries {
+		optOut := "no"
+		if entry.OptOut {
+			optOut = "yes"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			entry.Id,
+			entry.Email,
+			formatTime(entry.ConfirmedAt, "2006-01-02 15:04", time.Local),
+			optOut,
+			entry.OptOutReason,
+			formatTime(entry.CreatedAt, "2006-01-02 15:04", time.Local))
+	}
+	return tw.Flush()
+}
+
+func renderJson(w io.Writer, entries []*client.Email, single bool) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if single && len(entries) == 1 {
+		return encoder.Encode(entries[0])
+	}
+	if entries == nil {
+		entries = []*client.Email{}
+	}
+	return encoder.Encode(entries)
+}
+
+func renderCsv(w io.Writer, entries []*client.Email, single bool) error {
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write(entryHeader)
+	for _, entry := range entries {
+		csvWriter.Write([]string{
+			strconv.FormatInt(entry.Id, 10),
+			entry.Email,
+			formatTime(entry.ConfirmedAt, time.RFC3339, time.UTC),
+			strconv.FormatBool(entry.OptOut),
+			entry.OptOutReason,
+			formatTime(entry.CreatedAt, time.RFC3339, time.UTC),
+		})
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mailinglist/client"
+	"strings"
+)
+
+// ndjsonKeys are the keys holding the email in the lines of NDJSON files.
+var ndjsonKeys = []string{"email", "email_addr", "emailAddr"}
+
+// rowIssue is a row of an imported file rejected before reaching the
+// server, at its line and column.
+type rowIssue struct {
+	Line, Column int
+	Email        string
+	Message      string
+}
+
+func (i rowIssue) importError() client.ImportRowError {
+	return client.ImportRowError{
+		Row:   int64(i.Line),
+		Email: i.Email,
+		Error: fmt.Sprintf("column %d: %v", i.Column, i.Message),
+	}
+}
+
+// readRows calls fn with the email of each row of a CSV or NDJSON file and
+// where it starts, or with the issue of the rows that cannot be read. The
+// CSV files are read like the server does, the email column being the
+// one of an "email" header, the first one otherwise.
+func readRows(r io.Reader, format string, fn func(line, column int, email string, issue *rowIssue) error) error {
+	if format == formatNdjson {
+		return readNdjsonRows(r, fn)
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	column := 0
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			issue := &rowIssue{Line: parseErr.StartLine, Column: parseErr.Column, Message: parseErr.Err.Error()}
+			if err := fn(issue.Line, issue.Column, "", issue); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if first {
+			header := false
+			for i, name := range record {
+				if strings.EqualFold(strings.TrimSpace(name), "email") {
+					column, header = i, true
+					break
+				}
+			}
+			if header {
+				continue
+			}
+		}
+
+		if column >= len(record) {
+			line, _ := reader.FieldPos(0)
+			err = fn(line, 1, "", &rowIssue{Line: line, Column: 1, Message: "missing email column"})
+		} else {
+			line, col := reader.FieldPos(column)
+			err = fn(line, col, record[column], nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func readNdjsonRows(r io.Reader, fn func(line, column int, email string, issue *rowIssue) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &fields); err != nil {
+			column := 1
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				column = int(syntaxErr.Offset)
+			}
+			issue := &rowIssue{Line: line, Column: column, Message: "invalid JSON: " + err.Error()}
+			if err := fn(line, column, "", issue); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var err error
+		found := false
+		for _, key := range ndjsonKeys {
+			if email, ok := fields[key].(string); ok {
+				column := strings.Index(text, `"`+key+`"`) + 1
+				err, found = fn(line, column, email, nil), true
+				break
+			}
+		}
+		if !found {
+			err = fn(line, 1, "", &rowIssue{Line: line, Column: 1, Message: "missing email field"})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// normalizeRow normalizes the email of a row read by readRows, returning
+// the issue of the row if it is invalid.
+func normalizeRow(line, column int, email string, issue *rowIssue) (string, *rowIssue) {
+	if issue != nil {
+		return "", issue
+	}
+	normalized, err := client.NormalizeEmail(email)
+	if err != nil {
+		return "", &rowIssue{Line: line, Column: column, Email: email, Message: err.Error()}
+	}
+	return normalized, nil
+}
+
+// collectRows returns the normalized emails of the valid rows of the file
+// with their lines, and the issues of the others.
+func collectRows(r io.Reader, format string) (emails []string, lines []int, issues []rowIssue, err error) {
+	err = readRows(r, format, func(line, column int, email string, issue *rowIssue) error {
+		email, issue = normalizeRow(line, column, email, issue)
+		if issue != nil {
+			issues = append(issues, *issue)
+		} else {
+			emails = append(emails, email)
+			lines = append(lines, line)
+		}
+		return nil
+	})
+	return emails, lines, issues, err
+}
+
+// normalizeRows writes the emails of the file normalized as CSV rows,
+// each on the line of the file it was read from so that the rows reported
+// by the server match the lines of the file. The rows that cannot be read
+// or whose email is invalid are returned instead, and never sent.
+func normalizeRows(r io.Reader, format string, w io.Writer) ([]rowIssue, error) {
+	var issues []rowIssue
+	csvWriter := csv.NewWriter(w)
+	next := 1
+	err := readRows(r, format, func(line, column int, email string, issue *rowIssue) error {
+		email, issue = normalizeRow(line, column, email, issue)
+		if issue != nil {
+			issues = append(issues, *issue)
+			return nil
+		}
+
+		// The empty lines are skipped by the server.
+		if line > next {
+			if _, err := io.WriteString(w, strings.Repeat("\n", line-next)); err != nil {
+				return err
+			}
+		}
+		csvWriter.Write([]string{email})
+		csvWriter.Flush()
+		next = line + 1
+		return csvWriter.Error()
+	})
+	return issues, err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mailinglist/client"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+type seedCmd struct {
+	Count          int      `default:"1000" help:"how many subscribers to create"`
+	ConfirmedRatio float64  `arg:"--confirmed-ratio" default:"0.8" help:"share of the subscribers confirmed, between 0 and 1"`
+	OptOutRatio    float64  `arg:"--optout-ratio" default:"0.05" help:"share of the subscribers opted out, between 0 and 1"`
+	Domain         []string `help:"domains of the emails [default: example.com example.net example.org]"`
+	Seed           int64    `help:"seed of the generated data, the same seed giving the same emails [default: random]"`
+	bulkFlags
+}
+
+// The domains reserved for examples never receive mail, so the generated
+// subscribers cannot reach anyone.
+var seedDomains = []string{"example.com", "example.net", "example.org"}
+
+var seedFirstNames = []string{
+	"ada", "alan", "alice", "amir", "ana", "anna", "ben", "bob", "carla", "chen",
+	"chloe", "dan", "david", "diego", "elena", "emma", "eva", "felix", "grace", "hana",
+	"ivan", "jack", "james", "jane", "john", "julia", "kai", "karim", "laura", "leo",
+	"lina", "lucas", "maria", "mark", "mia", "nina", "noah", "olga", "omar", "paul",
+	"priya", "rosa", "sam", "sara", "sofia", "tom", "wei", "yuki", "zoe", "zara",
+}
+
+var seedLastNames = []string{
+	"adams", "ali", "baker", "brown", "chen", "clark", "costa", "davis", "diaz", "evans",
+	"fischer", "garcia", "green", "hall", "ivanova", "jones", "kim", "kowalski", "lee", "lopez",
+	"martin", "miller", "moore", "muller", "nguyen", "novak", "patel", "petrov", "rossi", "sato",
+	"silva", "singh", "smith", "taylor", "tanaka", "walker", "wang", "white", "wilson", "young",
+}
+
+// seedAddress returns an address built from a random name, in one of the
+// usual formats.
+func seedAddress(rnd *rand.Rand, domains []string) string {
+	first := seedFirstNames[rnd.Intn(len(seedFirstNames))]
+	last := seedLastNames[rnd.Intn(len(seedLastNames))]
+	domain := domains[rnd.Intn(len(domains))]
+
+	var local string
+	switch rnd.Intn(5) {
+	case 0:
+		local = first + "." + last
+	case 1:
+		local = first[:1] + last
+	case 2:
+		local = first + "_" + last
+	case 3:
+		local = fmt.Sprintf("%v%d", first, 1950+rnd.Intn(60))
+	default:
+		local = fmt.Sprintf("%v.%v%d", first, last, rnd.Intn(100))
+	}
+	return local + "@" + domain
+}
+
+// seedEmails generates the subscribers of the command, with unique
+// addresses and confirmations spread over the last 90 days.
+func seedEmails(cmd *seedCmd, rnd *rand.Rand) []*client.Email {
+	domains := cmd.Domain
+	if len(domains) == 0 {
+		domains = seedDomains
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool, cmd.Count)
+	emails := make([]*client.Email, 0, cmd.Count)
+	for len(emails) < cmd.Count {
+		addr := seedAddress(rnd, domains)
+		// The names run out long before large counts, a suffix keeps the
+		// addresses unique.
+		at := strings.LastIndexByte(addr, '@')
+		local, domain := addr[:at], addr[at:]
+		for n := 2; seen[addr]; n++ {
+			addr = fmt.Sprintf("%v+%d%v", local, n, domain)
+		}
+		seen[addr] = true
+
+		email := &client.Email{Email: addr}
+		if rnd.Float64() < cmd.ConfirmedRatio {
+			confirmedAt := now.Add(-time.Duration(rnd.Int63n(int64(90 * 24 * time.Hour)))).Truncate(time.Second)
+			email.ConfirmedAt = &confirmedAt
+		}
+		email.OptOut = rnd.Float64() < cmd.OptOutRatio
+		emails = append(emails, email)
+	}
+	return emails
+}
+
+// seed creates fake subscribers on a development server, for demos and
+// load tests. The emails that already exist are overwritten.
+func seed(ctx context.Context, c *client.MailingListClient, cmd *seedCmd) error {
+	if cmd.Count <= 0 {
+		return errors.New("--count must be positive")
+	}
+	if cmd.ConfirmedRatio < 0 || cmd.ConfirmedRatio > 1 || cmd.OptOutRatio < 0 || cmd.OptOutRatio > 1 {
+		return errors.New("--confirmed-ratio and --optout-ratio must be between 0 and 1")
+	}
+	if cmd.Seed == 0 {
+		cmd.Seed = time.Now().UnixNano()
+	}
+
+	emails := seedEmails(cmd, rand.New(rand.NewSource(cmd.Seed)))
+	addrs := make([]string, len(emails))
+	for i, email := range emails {
+		addrs[i] = email.Email
+	}
+
+	log.Printf("creating %v subscribers with --seed %v\n", len(emails), cmd.Seed)
+	start := time.Now()
+	result, err := runBulk(ctx, addrs, cmd.bulkFlags, func(ctx context.Context, _ []string, opts client.BulkOptions) (*client.BulkResult, error) {
+		return c.UpdateEmails(ctx, emails, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	elapsed := time.Since(start)
+	log.Printf("seeded %v subscribers in %v, %.0f per second\n",
+		len(result.Emails), elapsed.Round(time.Millisecond), float64(len(result.Emails))/elapsed.Seconds())
+	printBulkErrors(result.Errors)
+	return nil
+}
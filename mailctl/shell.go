@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mailinglist/client"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/chzyer/readline"
+)
+
+type shellCmd struct{}
+
+const shellPrompt = "mailctl> "
+
+// shellCompleter completes the subcommands, then their flags.
+type shellCompleter struct {
+	// flags are the flags of each subcommand.
+	flags map[string][]string
+}
+
+// newShellCompleter reads the subcommands and their flags from the arg
+// tags of the commands.
+func newShellCompleter() *shellCompleter {
+	completer := &shellCompleter{flags: map[string][]string{}}
+	t := reflect.TypeOf(commands{})
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := argTag(t.Field(i), "subcommand:")
+		if !ok {
+			continue
+		}
+
+		cmdType := t.Field(i).Type.Elem()
+		flags := []string{"--help"}
+		for j := 0; j < cmdType.NumField(); j++ {
+			field := cmdType.Field(j)
+			if _, positional := argTag(field, "positional"); positional {
+				continue
+			}
+			flag, ok := argTag(field, "--")
+			if !ok {
+				flag = "--" + strings.ToLower(field.Name)
+			}
+			flags = append(flags, flag)
+		}
+		sort.Strings(flags)
+		completer.flags[name] = flags
+	}
+	return completer
+}
+
+// argTag returns the rest of the first key of the arg tag of field
+// starting with prefix, e.g. the name of a subcommand, or the long flag
+// including its dashes.
+func argTag(field reflect.StructField, prefix string) (string, bool) {
+	for _, key := range strings.Split(field.Tag.Get("arg"), ",") {
+		if strings.HasPrefix(key, prefix) {
+			if prefix == "--" {
+				return key, true
+			}
+			return key[len(prefix):], true
+		}
+	}
+	return "", false
+}
+
+// Do implements readline.AutoCompleter, returning the suffixes completing
+// the word before the cursor.
+func (s *shellCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	words := strings.Fields(string(line[:pos]))
+	current := ""
+	if len(words) > 0 && !strings.HasSuffix(string(line[:pos]), " ") {
+		current = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	var candidates []string
+	switch {
+	case len(words) == 0:
+		for name := range s.flags {
+			candidates = append(candidates, name)
+		}
+		candidates = append(candidates, "help", "exit")
+		sort.Strings(candidates)
+	case strings.HasPrefix(current, "-") || current == "":
+		candidates = s.flags[words[0]]
+	}
+
+	var completions [][]rune
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, current) {
+			completions = append(completions, []rune(candidate[len(current):]+" "))
+		}
+	}
+	return completions, len(current)
+}
+
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	dir := filepath.Join(home, ".mailinglist")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "history")
+}
+
+// runShell reads commands from a prompt and runs them with the connection
+// and settings of the global flags, until exit or end of input.
+func runShell(c *client.MailingListClient) error {
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          shellPrompt,
+		HistoryFile:     historyPath(),
+		AutoComplete:    newShellCompleter(),
+		InterruptPrompt: "^C",
+		EOFPrompt:       "exit",
+	})
+	if err != nil {
+		return err
+	}
+	defer rl.Close()
+
+	readAnswer = func(prompt string) (string, error) {
+		rl.SetPrompt(prompt)
+		defer rl.SetPrompt(shellPrompt)
+		return rl.Readline()
+	}
+
+	for {
+		line, err := rl.Readline()
+		if err == readline.ErrInterrupt {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if quit := runShellLine(c, strings.Fields(line)); quit {
+			return nil
+		}
+	}
+}
+
+// runShellLine runs the command of a line of the shell, reporting whether
+// the shell should exit.
+func runShellLine(c *client.MailingListClient, words []string) (quit bool) {
+	if len(words) == 0 {
+		return false
+	}
+	switch words[0] {
+	case "exit", "quit":
+		return true
+	case "help":
+		words = append(words[1:], "--help")
+	}
+
+	var cmds commands
+	p, err := arg.NewParser(arg.Config{Program: "mailctl", IgnoreEnv: true}, &cmds)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, errorMessage(err))
+		return false
+	}
+	err = p.Parse(words)
+	if errors.Is(err, arg.ErrHelp) || (err == nil && p.Subcommand() == nil) {
+		p.WriteHelp(os.Stdout)
+		return false
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return false
+	}
+
+	// Ctrl-C stops the command, e.g. a watch, rather than the shell.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err = run(ctx, c, &cmds)
+	if ctx.Err() != nil {
+		log.Println("interrupted")
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, errorMessage(err))
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mailinglist/client"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+type statsCmd struct {
+	Days int32 `default:"30" help:"days of signups shown, including today"`
+}
+
+// sparkBars draw the sparklines, from no signups to the busiest day.
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws a bar for each count, scaled to the largest one. Only
+// the days without signups get the lowest bar.
+func sparkline(counts []int64) string {
+	var max int64
+	for _, count := range counts {
+		if count > max {
+			max = count
+		}
+	}
+
+	var b strings.Builder
+	for _, count := range counts {
+		bar := 0
+		if count > 0 {
+			steps := int64(len(sparkBars) - 1)
+			bar = int((count*steps + max - 1) / max)
+		}
+		b.WriteRune(sparkBars[bar])
+	}
+	return b.String()
+}
+
+// percent formats n as a percentage of total, empty when total is 0.
+func percent(n, total int64) string {
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1f%%", float64(n)*100/float64(total))
+}
+
+func printStats(stats *client.Stats) error {
+	switch args.Output {
+	case outputJson:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	case outputCsv:
+		csvWriter := csv.NewWriter(os.Stdout)
+		csvWriter.Write([]string{"date", "signups"})
+		for _, signups := range stats.Signups {
+			csvWriter.Write([]string{signups.Date, strconv.FormatInt(signups.Count, 10)})
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "total\t%d\n", stats.Total)
+	fmt.Fprintf(tw, "subscribed\t%d\t%s\tof total\n", stats.Subscribed, percent(stats.Subscribed, stats.Total))
+	fmt.Fprintf(tw, "confirmed\t%d\t%s\tof subscribed\n", stats.Confirmed, percent(stats.Confirmed, stats.Subscribed))
+	fmt.Fprintf(tw, "unconfirmed\t%d\t%s\tof subscribed\n", stats.Unconfirmed, percent(stats.Unconfirmed, stats.Subscribed))
+	fmt.Fprintf(tw, "opted out\t%d\t%s\tof total\n", stats.OptedOut, percent(stats.OptedOut, stats.Total))
+	fmt.Fprintf(tw, "deleted\t%d\n", stats.Deleted)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(stats.OptOutReasons) > 0 {
+		reasons := make([]string, 0, len(stats.OptOutReasons))
+		for reason := range stats.OptOutReasons {
+			reasons = append(reasons, reason)
+		}
+		sort.Slice(reasons, func(i, j int) bool {
+			return stats.OptOutReasons[reasons[i]] > stats.OptOutReasons[reasons[j]]
+		})
+		for i, reason := range reasons {
+			if reason == "" {
+				reason = "unknown"
+			}
+			reasons[i] = fmt.Sprintf("%v %d", reason, stats.OptOutReasons[reasons[i]])
+		}
+		fmt.Printf("\nopt-out reasons: %v\n", strings.Join(reasons, ", "))
+	}
+
+	if len(stats.Signups) == 0 {
+		return nil
+	}
+	counts := make([]int64, len(stats.Signups))
+	var total int64
+	peak := stats.Signups[0]
+	for i, signups := range stats.Signups {
+		counts[i] = signups.Count
+		total += signups.Count
+		if signups.Count > peak.Count {
+			peak = signups
+		}
+	}
+	fmt.Printf("\nsignups over %d days: %d, %.1f per day, at most %d on %v\n",
+		len(counts), total, float64(total)/float64(len(counts)), peak.Count, peak.Date)
+	fmt.Println(sparkline(counts))
+	first, last := stats.Signups[0].Date, stats.Signups[len(counts)-1].Date
+	if gap := len(counts) - len(first) - len(last); gap > 0 {
+		fmt.Printf("%v%v%v\n", first, strings.Repeat(" ", gap), last)
+	} else {
+		fmt.Printf("%v to %v\n", first, last)
+	}
+	return nil
+}
+
+func showStats(ctx context.Context, c *client.MailingListClient, cmd *statsCmd) error {
+	stats, err := c.GetStats(ctx, cmd.Days)
+	if err != nil {
+		return err
+	}
+	return printStats(stats)
+}
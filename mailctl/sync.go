@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mailinglist/client"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+type syncCmd struct {
+	From string `arg:"--from,required" help:"address of the server whose emails are copied, like --grpcaddr, or --http-addr with --transport http"`
+	To   string `arg:"--to,required" help:"address of the server receiving the missing emails and changes"`
+	Diff bool   `help:"only show the differences, without changing the target"`
+	bulkFlags
+}
+
+const (
+	syncCreate = "create"
+	syncUpdate = "update"
+	// syncExtra are the emails only on the target, which are left as is.
+	syncExtra = "extra"
+)
+
+// syncChange is a difference between the emails of the servers.
+type syncChange struct {
+	Change string        `json:"change"`
+	Email  string        `json:"email"`
+	From   *client.Email `json:"from,omitempty"`
+	To     *client.Email `json:"to,omitempty"`
+}
+
+// emailState describes what sync compares, the confirmation and the opt
+// out, which are the fields an update sets.
+func emailState(email *client.Email) string {
+	if email == nil {
+		return "missing"
+	}
+	state := "unconfirmed"
+	if email.ConfirmedAt != nil {
+		state = "confirmed"
+	}
+	if email.OptOut {
+		state += ", opted out"
+	}
+	return state
+}
+
+// allEmails returns the emails of a server by address, the opted out and
+// complained ones included, streaming them over gRPC or going through the
+// pages over HTTP.
+func allEmails(ctx context.Context, c *client.MailingListClient) (map[string]*client.Email, error) {
+	emails := map[string]*client.Email{}
+	_, err := c.ExportEntries(ctx, client.ExportOptions{IncludeOptOut: true, IncludeComplained: true}, func(email *client.Email) error {
+		emails[email.Email] = email
+		return nil
+	})
+	if !errors.Is(err, client.ErrUnsupported) {
+		return emails, err
+	}
+
+	opts := client.ListOptions{Count: batchSize, IncludeOptOut: true, IncludeComplained: true}
+	err = listPages(ctx, c, opts, func(page *client.EmailPage) bool {
+		for _, email := range page.Emails {
+			emails[email.Email] = email
+		}
+		return true
+	})
+	return emails, err
+}
+
+// diffEmails returns the changes bringing the target emails in line with
+// the source ones, sorted by email.
+func diffEmails(from, to map[string]*client.Email) []syncChange {
+	var changes []syncChange
+	for addr, email := range from {
+		target, ok := to[addr]
+		switch {
+		case !ok:
+			changes = append(changes, syncChange{Change: syncCreate, Email: addr, From: email})
+		case emailState(email) != emailState(target):
+			changes = append(changes, syncChange{Change: syncUpdate, Email: addr, From: email, To: target})
+		}
+	}
+	for addr, email := range to {
+		if _, ok := from[addr]; !ok {
+			changes = append(changes, syncChange{Change: syncExtra, Email: addr, To: email})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Email < changes[j].Email
+	})
+	return changes
+}
+
+func printChanges(changes []syncChange) error {
+	switch args.Output {
+	case outputJson:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if changes == nil {
+			changes = []syncChange{}
+		}
+		return encoder.Encode(changes)
+	case outputCsv:
+		csvWriter := csv.NewWriter(os.Stdout)
+		csvWriter.Write([]string{"change", "email", "from", "to"})
+		for _, change := range changes {
+			csvWriter.Write([]string{change.Change, change.Email, emailState(change.From), emailState(change.To)})
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANGE\tEMAIL\tFROM\tTO")
+	for _, change := range changes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", change.Change, change.Email, emailState(change.From), emailState(change.To))
+	}
+	return tw.Flush()
+}
+
+// syncServers compares the emails of two servers and copies the missing
+// emails and the changed confirmations and opt outs to the target. The
+// emails only on the target are listed but never deleted.
+func syncServers(ctx context.Context, cmd *syncCmd) error {
+	config, err := clientConfig(cmd.From)
+	if err != nil {
+		return err
+	}
+	from, err := client.New(config)
+	if err != nil {
+		return fmt.Errorf("connecting to %v : %w", cmd.From, err)
+	}
+	defer from.Close()
+	if config, err = clientConfig(cmd.To); err != nil {
+		return err
+	}
+	to, err := client.New(config)
+	if err != nil {
+		return fmt.Errorf("connecting to %v : %w", cmd.To, err)
+	}
+	defer to.Close()
+
+	fromEmails, err := allEmails(ctx, from)
+	if err != nil {
+		return fmt.Errorf("reading the emails of %v : %w", cmd.From, err)
+	}
+	toEmails, err := allEmails(ctx, to)
+	if err != nil {
+		return fmt.Errorf("reading the emails of %v : %w", cmd.To, err)
+	}
+
+	changes := diffEmails(fromEmails, toEmails)
+	counts := map[string]int{}
+	var updates []*client.Email
+	for _, change := range changes {
+		counts[change.Change]++
+		if change.Change != syncExtra {
+			// The ids are those of the source.
+			email := *change.From
+			email.Id = 0
+			updates = append(updates, &email)
+		}
+	}
+	if err := printChanges(changes); err != nil {
+		return err
+	}
+	log.Printf("%v emails on %v, %v on %v: %v to create, %v to update, %v only on %v\n",
+		len(fromEmails), cmd.From, len(toEmails), cmd.To,
+		counts[syncCreate], counts[syncUpdate], counts[syncExtra], cmd.To)
+	if cmd.Diff || len(updates) == 0 {
+		return nil
+	}
+
+	if err := confirm("Apply %v changes to %v?", len(updates), cmd.To); err != nil {
+		return err
+	}
+	addrs := make([]string, len(updates))
+	for i, email := range updates {
+		addrs[i] = email.Email
+	}
+	result, err := runBulk(ctx, addrs, cmd.bulkFlags, func(ctx context.Context, _ []string, opts client.BulkOptions) (*client.BulkResult, error) {
+		return to.UpdateEmails(ctx, updates, opts)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("applied %v changes to %v\n", len(result.Emails), cmd.To)
+	printBulkErrors(result.Errors)
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mailinglist/client"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type unsubscribeCmd struct {
+	Target  string `arg:"positional" placeholder:"EMAIL|TOKEN" help:"email, or token of its unsubscribe link"`
+	Domain  string `help:"unsubscribe the emails of the domain"`
+	Pattern string `help:"unsubscribe the emails matching a glob pattern, e.g. '*+test@*.example.com'"`
+	Delete  bool   `help:"move the emails to the trash instead of opting them out"`
+	DryRun  bool   `arg:"--dry-run" help:"show the emails that would be unsubscribed"`
+}
+
+// globSpecial matches the wildcards and bracket expressions of a pattern.
+var globSpecial = regexp.MustCompile(`\[[^\]]*\]|[*?\\]`)
+
+// patternQuery returns the longest part of a glob pattern without
+// wildcards, which the searched emails must contain.
+func patternQuery(pattern string) string {
+	longest := ""
+	for _, part := range globSpecial.Split(pattern, -1) {
+		if len(part) > len(longest) {
+			longest = part
+		}
+	}
+	return longest
+}
+
+// matcher returns the search query finding the emails selected by the
+// --domain or --pattern of the command and the function keeping those
+// that match, case insensitively.
+func (cmd *unsubscribeCmd) matcher() (string, func(addr string) bool, error) {
+	if cmd.Domain != "" {
+		domain := strings.ToLower(strings.TrimPrefix(cmd.Domain, "@"))
+		match := func(addr string) bool {
+			at := strings.LastIndexByte(addr, '@')
+			return at >= 0 && strings.ToLower(addr[at+1:]) == domain
+		}
+		return "@" + domain, match, nil
+	}
+
+	pattern := strings.ToLower(cmd.Pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return "", nil, fmt.Errorf("invalid pattern %q : %w", cmd.Pattern, err)
+	}
+	match := func(addr string) bool {
+		matched, _ := path.Match(pattern, strings.ToLower(addr))
+		return matched
+	}
+	return patternQuery(pattern), match, nil
+}
+
+// unsubscribeOneEmail opts out the email, or the email of the unsubscribe
+// token, of the command. It does not ask since it can be opted back in.
+func unsubscribeOneEmail(ctx context.Context, c *client.MailingListClient, cmd *unsubscribeCmd) error {
+	if cmd.Delete {
+		return errors.New("--delete only applies to --domain and --pattern, run delete instead")
+	}
+
+	if isToken(cmd.Target) {
+		if cmd.DryRun {
+			return errors.New("--dry-run needs an email, not a token")
+		}
+		email, err := c.UnsubscribeByToken(ctx, cmd.Target)
+		if errors.Is(err, client.ErrNotFound) {
+			return errInvalidUnsubscribeToken
+		}
+		if err != nil {
+			return err
+		}
+		return printEntries([]*client.Email{email}, true)
+	}
+
+	if cmd.DryRun {
+		email, err := c.GetEmail(ctx, cmd.Target)
+		if err != nil {
+			return err
+		}
+		log.Println("dry run, would unsubscribe:")
+		return printEntries([]*client.Email{email}, true)
+	}
+	email, err := c.UnsubscribeEmail(ctx, cmd.Target)
+	if err != nil {
+		return err
+	}
+	return printEntries([]*client.Email{email}, true)
+}
+
+// unsubscribeEmails finds the emails of a domain or matching a pattern,
+// shows them, and opts them out once confirmed.
+func unsubscribeEmails(ctx context.Context, c *client.MailingListClient, cmd *unsubscribeCmd) error {
+	selectors := 0
+	for _, selector := range []string{cmd.Target, cmd.Domain, cmd.Pattern} {
+		if selector != "" {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		return errors.New("exactly one of an email, --domain and --pattern is required")
+	}
+	if cmd.Target != "" {
+		return unsubscribeOneEmail(ctx, c, cmd)
+	}
+
+	query, match, err := cmd.matcher()
+	if err != nil {
+		return err
+	}
+
+	// The opted out emails can still be deleted.
+	opts := client.ListOptions{Count: batchSize, IncludeOptOut: cmd.Delete, IncludeComplained: cmd.Delete, Query: query}
+	var found []*client.Email
+	err = listPages(ctx, c, opts, func(page *client.EmailPage) bool {
+		for _, email := range page.Emails {
+			if match(email.Email) {
+				found = append(found, email)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		log.Println("no email entries found")
+		return nil
+	}
+
+	action, prompt := "unsubscribe", "Unsubscribe %v emails?"
+	if cmd.Delete {
+		action, prompt = "delete", "Delete %v emails?"
+	}
+	if cmd.DryRun {
+		log.Printf("dry run, would %v %v emails:\n", action, len(found))
+	}
+	if err := printEntries(found, false); err != nil {
+		return err
+	}
+	if cmd.DryRun {
+		return nil
+	}
+	if err := confirm(prompt, len(found)); err != nil {
+		return err
+	}
+
+	// Each batch is changed in its own transaction.
+	var affected int64
+	for start := 0; start < len(found); start += batchSize {
+		end := start + batchSize
+		if end > len(found) {
+			end = len(found)
+		}
+		addrs := make([]string, 0, end-start)
+		for _, email := range found[start:end] {
+			addrs = append(addrs, email.Email)
+		}
+
+		n, err := c.UnsubscribeEmails(ctx, addrs, cmd.Delete)
+		if err != nil {
+			return fmt.Errorf("%vd %v emails before failing : %w", action, affected, err)
+		}
+		affected += n
+	}
+	log.Printf("%vd %v emails\n", action, affected)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mailinglist/client"
+	"os"
+	"strconv"
+	"time"
+)
+
+type watchCmd struct {
+	Kind   []string `help:"only print events of these kinds, e.g. --kind created unsubscribed"`
+	Domain string   `help:"only print events of the emails of this domain"`
+	Since  *int64   `help:"first print the events recorded after this sequence number"`
+}
+
+// eventPrinter returns the function printing each event as it arrives in
+// the output format. Tables use fixed width columns since the rows are not
+// known in advance.
+func eventPrinter() func(*client.Event) error {
+	switch args.Output {
+	case outputJson:
+		encoder := json.NewEncoder(os.Stdout)
+		return func(event *client.Event) error {
+			return encoder.Encode(event)
+		}
+	case outputCsv:
+		csvWriter := csv.NewWriter(os.Stdout)
+		csvWriter.Write([]string{"seq", "occurred_at", "kind", "email", "opt_out", "confirmed_at"})
+		csvWriter.Flush()
+		return func(event *client.Event) error {
+			csvWriter.Write([]string{
+				strconv.FormatInt(event.Seq, 10),
+				event.OccurredAt.UTC().Format(time.RFC3339),
+				string(event.Kind),
+				event.Email,
+				strconv.FormatBool(event.OptOut),
+				formatTime(event.ConfirmedAt, time.RFC3339, time.UTC),
+			})
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+	}
+
+	const row = "%-8v  %-19v  %-12v  %v\n"
+	fmt.Printf(row, "SEQ", "OCCURRED", "KIND", "EMAIL")
+	return func(event *client.Event) error {
+		_, err := fmt.Printf(row, event.Seq, event.OccurredAt.Local().Format("2006-01-02 15:04:05"), event.Kind, event.Email)
+		return err
+	}
+}
+
+func watchEvents(ctx context.Context, c *client.MailingListClient, cmd *watchCmd) error {
+	opts := client.WatchOptions{
+		SinceSeq: cmd.Since,
+		Domain:   cmd.Domain,
+		OnReconnect: func(err error) {
+			log.Printf("reconnecting: %v\n", err)
+		},
+	}
+	for _, kind := range cmd.Kind {
+		opts.Kinds = append(opts.Kinds, client.EventKind(kind))
+	}
+
+	return c.Watch(ctx, opts, eventPrinter())
+}
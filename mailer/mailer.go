@@ -0,0 +1,163 @@
+// Package mailer sends the emails through the provider of the settings,
+// an SMTP server or the APIs of Amazon SES, SendGrid and Mailgun, behind
+// Sender, so that the provider changes without touching the callers.
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"time"
+)
+
+const (
+	sendTimeout = 30 * time.Second
+	// maxErrorBody bounds the answer of a provider kept in the errors.
+	maxErrorBody = 1 << 10
+)
+
+// Message is an email to a single recipient.
+type Message struct {
+	// From is the sender, Options.From when empty.
+	From    string
+	To      string
+	Subject string
+	Text    string
+	// HTML is the alternative to Text, which is sent alone when empty.
+	HTML string
+	// Headers are added to the message, e.g. List-Unsubscribe.
+	Headers map[string]string
+	// ReturnPath is the envelope sender the bounces are returned to, the
+	// address of From when empty. Only the smtp provider sets it, the APIs
+	// reporting the bounces through their webhooks.
+	ReturnPath string
+}
+
+// Sender sends the emails through a provider.
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Options selects and sets up the provider.
+type Options struct {
+	// Provider is smtp, ses, sendgrid or mailgun.
+	Provider string
+	// From is the sender of the messages without one, e.g.
+	// News <news@example.com>.
+	From string
+	// Endpoint is the host:port of the SMTP server, or replaces the base URL
+	// of the API, e.g. https://api.eu.mailgun.net.
+	Endpoint string
+	// Username is the SMTP username or the SES access key id.
+	Username string
+	// Password is the SMTP password, the SES secret access key, or the
+	// SendGrid or Mailgun API key.
+	Password string
+	// Region is the SES region, e.g. eu-west-1.
+	Region string
+	// Domain is the Mailgun sending domain.
+	Domain string
+}
+
+// New returns the sender of the provider.
+func New(opts Options) (Sender, error) {
+	if opts.From != "" {
+		if _, err := mail.ParseAddress(opts.From); err != nil {
+			return nil, fmt.Errorf("invalid sender %q: %w", opts.From, err)
+		}
+	}
+	client := &http.Client{Timeout: sendTimeout}
+
+	var sender Sender
+	switch opts.Provider {
+	case "smtp":
+		if opts.Endpoint == "" {
+			return nil, errors.New("smtp needs the host:port of the server as endpoint")
+		}
+		sender = &smtpSender{addr: opts.Endpoint, username: opts.Username, password: opts.Password}
+	case "ses":
+		if opts.Region == "" || opts.Username == "" || opts.Password == "" {
+			return nil, errors.New("ses needs a region, and the access key id and secret as username and password")
+		}
+		sender = newSES(opts, client)
+	case "sendgrid":
+		if opts.Password == "" {
+			return nil, errors.New("sendgrid needs the API key as password")
+		}
+		sender = newSendGrid(opts, client)
+	case "mailgun":
+		if opts.Domain == "" || opts.Password == "" {
+			return nil, errors.New("mailgun needs the sending domain, and the API key as password")
+		}
+		sender = newMailgun(opts, client)
+	default:
+		return nil, fmt.Errorf("unknown provider %q, expected smtp, ses, sendgrid or mailgun", opts.Provider)
+	}
+	return &defaultFrom{Sender: sender, from: opts.From}, nil
+}
+
+// defaultFrom sets the sender of the messages without one.
+type defaultFrom struct {
+	Sender
+	from string
+}
+
+func (d *defaultFrom) Send(ctx context.Context, msg *Message) error {
+	if msg.From == "" {
+		if d.from == "" {
+			return errors.New("the message has no sender and no default one is set")
+		}
+		m := *msg
+		m.From = d.from
+		msg = &m
+	}
+	return d.Sender.Send(ctx, msg)
+}
+
+// PermanentError is a message the provider rejected, which sending again
+// will not get through, e.g. one to an unknown recipient.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// IsPermanent reports whether err is a PermanentError. The other errors,
+// e.g. a provider down or rejecting the credentials, are worth retrying.
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// do sends the request of an API, failing on an answer other than 2xx, for
+// good on a 4xx but for the credentials, the timeouts and the throttling.
+func do(client *http.Client, req *http.Request, provider string) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%v: %w", provider, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		err := fmt.Errorf("%v: %v: %s", provider, resp.Status, body)
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return err
+		}
+		if resp.StatusCode/100 == 4 {
+			return &PermanentError{err}
+		}
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,50 @@
+package mailer
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// mailgun sends the messages with the messages API of the domain.
+type mailgun struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func newMailgun(opts Options, client *http.Client) *mailgun {
+	base := opts.Endpoint
+	if base == "" {
+		base = "https://api.mailgun.net"
+	}
+	return &mailgun{
+		url:    strings.TrimSuffix(base, "/") + "/v3/" + url.PathEscape(opts.Domain) + "/messages",
+		apiKey: opts.Password,
+		client: client,
+	}
+}
+
+func (m *mailgun) Send(ctx context.Context, msg *Message) error {
+	form := url.Values{
+		"from":    {msg.From},
+		"to":      {msg.To},
+		"subject": {msg.Subject},
+		"text":    {msg.Text},
+	}
+	if msg.HTML != "" {
+		form.Set("html", msg.HTML)
+	}
+	for name, value := range msg.Headers {
+		form.Set("h:"+name, value)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("api", m.apiKey)
+	return do(m.client, req, "mailgun")
+}
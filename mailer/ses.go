@@ -0,0 +1,118 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ses sends the messages with the SendEmail action of the SES v2 API, as
+// raw MIME messages.
+type ses struct {
+	url                  string
+	region               string
+	accessKey, secretKey string
+	client               *http.Client
+}
+
+func newSES(opts Options, client *http.Client) *ses {
+	base := opts.Endpoint
+	if base == "" {
+		base = fmt.Sprintf("https://email.%v.amazonaws.com", opts.Region)
+	}
+	return &ses{
+		url:       strings.TrimSuffix(base, "/") + "/v2/email/outbound-emails",
+		region:    opts.Region,
+		accessKey: opts.Username,
+		secretKey: opts.Password,
+		client:    client,
+	}
+}
+
+type sesRequest struct {
+	FromEmailAddress string
+	Destination      struct {
+		ToAddresses []string
+	}
+	Content struct {
+		Raw struct {
+			// Data is base64 encoded by encoding/json.
+			Data []byte
+		}
+	}
+}
+
+func (s *ses) Send(ctx context.Context, msg *Message) error {
+	data, err := buildMIME(msg)
+	if err != nil {
+		return err
+	}
+	params := sesRequest{FromEmailAddress: msg.From}
+	params.Destination.ToAddresses = []string{msg.To}
+	params.Content.Raw.Data = data
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signV4(req, body, s.accessKey, s.secretKey, s.region, "ses", time.Now())
+	return do(s.client, req, "ses")
+}
+
+// signV4 signs the request with AWS Signature Version 4, over the host,
+// the date and, when set, the content type headers.
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	canonicalHeaders := "host:" + req.URL.Host + "\n" + "x-amz-date:" + amzDate + "\n"
+	signedHeaders := "host;x-amz-date"
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		canonicalHeaders = "content-type:" + strings.TrimSpace(contentType) + "\n" + canonicalHeaders
+		signedHeaders = "content-type;" + signedHeaders
+	}
+
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		// The spaces are escaped as %20, not +.
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,104 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+)
+
+// smtpSender sends the messages to an SMTP server, over TLS on port 465,
+// else with STARTTLS when the server offers it.
+type smtpSender struct {
+	addr               string
+	username, password string
+}
+
+func (s *smtpSender) Send(ctx context.Context, msg *Message) error {
+	data, err := buildMIME(msg)
+	if err != nil {
+		return err
+	}
+	from, _ := mail.ParseAddress(msg.From)
+	to, _ := mail.ParseAddress(msg.To)
+
+	host, port, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	var conn net.Conn
+	if port == "465" {
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: host}}
+		conn, err = dialer.DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp: %w", err)
+	}
+	defer client.Close()
+	envelope := from.Address
+	if msg.ReturnPath != "" {
+		envelope = msg.ReturnPath
+	}
+	if err := s.send(client, host, envelope, to.Address, data); err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	return nil
+}
+
+func (s *smtpSender) send(client *smtp.Client, host, from, to string, data []byte) error {
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.username != "" {
+		// PlainAuth refuses to send the password without TLS, but to
+		// localhost.
+		if err := client.Auth(smtp.PlainAuth("", s.username, s.password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return rejected(err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return rejected(err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return rejected(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return rejected(err)
+	}
+	return client.Quit()
+}
+
+// rejected makes the 5xx replies to the message a PermanentError, the 4xx
+// ones being temporary failures in SMTP.
+func rejected(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return &PermanentError{err}
+	}
+	return err
+}
@@ -0,0 +1,172 @@
+package mdb
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"mailinglist/logging"
+	"os"
+	"strings"
+)
+
+// FakeEmail replaces the address before the domain with a fake derived
+// from key, the same address always giving the same fake.
+func FakeEmail(key []byte, email string) string {
+	domain := "example.invalid"
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		domain = email[i+1:]
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(email))
+	return "user-" + hex.EncodeToString(mac.Sum(nil))[:16] + "@" + domain
+}
+
+// Anonymize writes a copy of the database to path, which must not exist,
+// for the test environments: the addresses are replaced by FakeEmail, the
+// tokens, the fields, the API keys, the audit log, the send queue and the
+// events waiting to be published are dropped, and the statuses, lists and
+// tags are kept.
+func Anonymize(ctx context.Context, db *sql.DB, path string, key []byte) (err error) {
+	ctx, span := startSpan(ctx, "Anonymize")
+	defer endSpan(span, &err)
+
+	if err := Backup(ctx, db, path); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			logging.FromContext(ctx).Error("anonymizing the database", "path", path, "err", err)
+			os.Remove(path)
+		}
+	}()
+
+	out, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	tx, err := out.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The webhook endpoints are added again by the servers started on the
+	// copy, and the outbox is emptied once the emails are rewritten.
+	for _, query := range []string{
+		`DELETE FROM webhook_deliveries`,
+		`DELETE FROM webhook_endpoints`,
+		`DELETE FROM audit_log`,
+		`DELETE FROM sync_instance`,
+		`DELETE FROM sync_checkpoints`,
+		`DELETE FROM leases`,
+		`DELETE FROM email_fields`,
+		`DELETE FROM send_queue`,
+		`UPDATE emails SET confirm_token = NULL, unsubscribe_token = NULL`,
+	} {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("%v: %w", query, err)
+		}
+	}
+
+	var lastSeq int64
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(seq), 0) FROM email_events`).Scan(&lastSeq); err != nil {
+		return err
+	}
+	if err := rewriteEmails(ctx, tx, key, `SELECT DISTINCT email FROM emails`, `UPDATE emails SET email = ? WHERE email = ?`); err != nil {
+		return err
+	}
+	// The events of the rewrite itself.
+	if _, err := tx.ExecContext(ctx, `DELETE FROM email_events WHERE seq > ?`, lastSeq); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM outbox`); err != nil {
+		return err
+	}
+	if err := rewriteEmails(ctx, tx, key, `SELECT DISTINCT email FROM email_events`, `UPDATE email_events SET email = ? WHERE email = ?`); err != nil {
+		return err
+	}
+
+	if err := rewriteEmails(ctx, tx, key, `SELECT email FROM complaints`, `UPDATE complaints SET email = ? WHERE email = ?`); err != nil {
+		return err
+	}
+	if err := rewriteEmails(ctx, tx, key, `SELECT DISTINCT email FROM campaign_recipients`, `UPDATE campaign_recipients SET email = ?, message_hash = NULL WHERE email = ?`); err != nil {
+		return err
+	}
+
+	if err := rewriteApiKeys(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Else the real addresses are left in the free pages.
+	_, err = out.ExecContext(ctx, `VACUUM`)
+	return err
+}
+
+func rewriteEmails(ctx context.Context, tx *sql.Tx, key []byte, selectQuery, updateQuery string) error {
+	rows, err := tx.QueryContext(ctx, selectQuery)
+	if err != nil {
+		return err
+	}
+	var emails []string
+	for rows.Next() {
+		var email sql.NullString
+		if err := rows.Scan(&email); err != nil {
+			rows.Close()
+			return err
+		}
+		if email.Valid {
+			emails = append(emails, email.String)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, email := range emails {
+		if _, err := tx.ExecContext(ctx, updateQuery, FakeEmail(key, email), email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteApiKeys gives the API keys new secrets, keeping their names,
+// scopes and quotas, for the production keys not to work on the copy.
+func rewriteApiKeys(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM api_keys`)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		key, err := newToken()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE api_keys SET key = ? WHERE id = ?`, key, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
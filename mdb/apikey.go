@@ -0,0 +1,251 @@
+package mdb
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"mailinglist/logging"
+	"time"
+)
+
+// ErrQuotaExceeded is returned when an API key has reached one of its quotas.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Scopes of an API key. Keys with the write scope may also read.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// ApiKey identifies a caller of the API together with its plan quotas.
+// A zero quota means unlimited. A key with an Org only sees the emails
+// created with the keys of that organization, keys without one see all.
+type ApiKey struct {
+	Id                int64
+	Key               string
+	Name              string
+	Scope             string
+	Org               string
+	MaxSubscribers    int64
+	MaxRequestsPerDay int64
+}
+
+// CanWrite reports whether the key may modify the list.
+func (k ApiKey) CanWrite() bool {
+	return k.Scope != ScopeRead
+}
+
+// ApiKeyUsage is the current consumption of an API key against its quotas.
+type ApiKeyUsage struct {
+	Name              string
+	Day               string
+	Subscribers       int64
+	MaxSubscribers    int64
+	RequestsToday     int64
+	MaxRequestsPerDay int64
+}
+
+func tryCreateApiKeys(s *schemaTx) {
+	s.tryExec(`
+		CREATE TABLE api_keys (
+			id 						INTEGER PRIMARY KEY,
+			key 					TEXT UNIQUE,
+			name 					TEXT,
+			max_subscribers 		INTEGER,
+			max_requests_per_day 	INTEGER
+		);
+	`)
+	s.tryExec(`
+		CREATE TABLE api_key_usage (
+			api_key_id 	INTEGER,
+			day 		TEXT,
+			requests 	INTEGER,
+			PRIMARY KEY (api_key_id, day)
+		);
+	`)
+	s.tryExec(`ALTER TABLE emails ADD COLUMN api_key_id INTEGER;`)
+	s.tryExec(`ALTER TABLE api_keys ADD COLUMN scope TEXT;`)
+	s.tryExec(`ALTER TABLE api_keys ADD COLUMN org TEXT;`)
+}
+
+func usageDay() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+func CreateApiKey(ctx context.Context, db *sql.DB, name, scope, org string, maxSubscribers, maxRequestsPerDay int64) (*ApiKey, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	key := hex.EncodeToString(buf)
+
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO api_keys (key, name, scope, org, max_subscribers, max_requests_per_day)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?, ?)
+	`, key, name, scope, org, maxSubscribers, maxRequestsPerDay)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("creating API key", "name", name, "err", err)
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ApiKey{
+		Id:                id,
+		Key:               key,
+		Name:              name,
+		Scope:             scope,
+		Org:               org,
+		MaxSubscribers:    maxSubscribers,
+		MaxRequestsPerDay: maxRequestsPerDay,
+	}, nil
+}
+
+func GetApiKey(ctx context.Context, db *sql.DB, key string) (*ApiKey, error) {
+	row := db.QueryRowContext(ctx, `
+		SELECT id, key, name, COALESCE(scope, ?), COALESCE(org, ''), max_subscribers, max_requests_per_day
+		FROM api_keys WHERE key = ?`, ScopeWrite, key)
+
+	apiKey := &ApiKey{}
+	err := row.Scan(&apiKey.Id, &apiKey.Key, &apiKey.Name, &apiKey.Scope, &apiKey.Org, &apiKey.MaxSubscribers, &apiKey.MaxRequestsPerDay)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("getting API key", "err", err)
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+// CountRequest records a request made with the key and returns
+// ErrQuotaExceeded once the daily request quota is used up.
+func CountRequest(ctx context.Context, db *sql.DB, apiKey ApiKey) error {
+	day := usageDay()
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO api_key_usage (api_key_id, day, requests)
+		VALUES (?, ?, 1)
+		ON CONFLICT(api_key_id, day)
+		DO UPDATE SET requests = requests + 1
+	`, apiKey.Id, day)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("counting request", "api_key", apiKey.Name, "err", err)
+		return err
+	}
+
+	if apiKey.MaxRequestsPerDay == 0 {
+		return nil
+	}
+
+	var requests int64
+	err = db.QueryRowContext(ctx, `
+		SELECT requests FROM api_key_usage WHERE api_key_id = ? AND day = ?
+	`, apiKey.Id, day).Scan(&requests)
+	if err != nil {
+		return err
+	}
+
+	if requests > apiKey.MaxRequestsPerDay {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// CreateEmailForApiKey creates an email owned by the key, replacing it when
+// it is in the trash, failing with ErrQuotaExceeded if the key already has
+// its maximum number of subscribers.
+func CreateEmailForApiKey(ctx context.Context, db *sql.DB, email string, apiKey ApiKey) (err error) {
+	ctx, span := startSpan(ctx, "CreateEmailForApiKey")
+	defer endSpan(span, &err)
+
+	maxSubscribers := apiKey.MaxSubscribers
+	if maxSubscribers == 0 {
+		maxSubscribers = -1
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := purgeTrashed(ctx, tx, email); err != nil {
+		logging.FromContext(ctx).Error("creating email", "email", email, "err", err)
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO emails (email, confirmed_at, opt_out, api_key_id, created_at)
+		SELECT ?, 0, false, ?, strftime('%s', 'now')
+		WHERE ? < 0 OR (
+			SELECT COUNT(*) FROM emails WHERE api_key_id = ? AND opt_out = false AND deleted_at IS NULL
+		) < ?
+	`, email, apiKey.Id, maxSubscribers, apiKey.Id, maxSubscribers)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("creating email", "email", email, "err", err)
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrQuotaExceeded
+	}
+	return tx.Commit()
+}
+
+func GetApiKeyUsage(ctx context.Context, db *sql.DB, apiKey ApiKey) (*ApiKeyUsage, error) {
+	usage := &ApiKeyUsage{
+		Name:              apiKey.Name,
+		Day:               usageDay(),
+		MaxSubscribers:    apiKey.MaxSubscribers,
+		MaxRequestsPerDay: apiKey.MaxRequestsPerDay,
+	}
+
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM emails WHERE api_key_id = ? AND opt_out = false AND deleted_at IS NULL
+	`, apiKey.Id).Scan(&usage.Subscribers)
+	if err != nil {
+		logging.FromContext(ctx).Error("getting API key usage", "api_key", apiKey.Name, "err", err)
+		return nil, err
+	}
+
+	err = db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(requests), 0) FROM api_key_usage WHERE api_key_id = ? AND day = ?
+	`, apiKey.Id, usage.Day).Scan(&usage.RequestsToday)
+	if err != nil {
+		logging.FromContext(ctx).Error("getting API key usage", "api_key", apiKey.Name, "err", err)
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// EmailOrg returns the organization of the key that created the email, or
+// "" when it was created without an organization key.
+func EmailOrg(ctx context.Context, db *sql.DB, email string) (string, error) {
+	var org string
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(k.org, '') FROM emails e
+		LEFT JOIN api_keys k ON k.id = e.api_key_id
+		WHERE e.email = ? AND e.deleted_at IS NULL
+	`, email).Scan(&org)
+	if err == sql.ErrNoRows {
+		return "", ErrEmailNotFound
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("getting organization", "email", email, "err", err)
+		return "", err
+	}
+	return org, nil
+}
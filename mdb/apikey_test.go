@@ -0,0 +1,152 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func migratedDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db := openDB(t)
+	if err := Migrate(context.Background(), db, SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCountRequest(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name              string
+		maxRequestsPerDay int64
+		requests          int
+		exceeded          bool
+	}{
+		{name: "under quota", maxRequestsPerDay: 3, requests: 2},
+		{name: "at quota", maxRequestsPerDay: 3, requests: 3},
+		{name: "over quota", maxRequestsPerDay: 3, requests: 4, exceeded: true},
+		{name: "unlimited", requests: 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := migratedDB(t)
+			apiKey, err := CreateApiKey(ctx, db, "test", ScopeWrite, "", 0, test.maxRequestsPerDay)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 1; i < test.requests; i++ {
+				if err := CountRequest(ctx, db, *apiKey); err != nil {
+					t.Fatalf("request %v: %v", i, err)
+				}
+			}
+			err = CountRequest(ctx, db, *apiKey)
+			if test.exceeded != errors.Is(err, ErrQuotaExceeded) || (!test.exceeded && err != nil) {
+				t.Errorf("CountRequest = %v, want exceeded %v", err, test.exceeded)
+			}
+
+			usage, err := GetApiKeyUsage(ctx, db, *apiKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if usage.RequestsToday != int64(test.requests) {
+				t.Errorf("requests today = %v, want %v", usage.RequestsToday, test.requests)
+			}
+		})
+	}
+}
+
+func TestSubscriberQuota(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name           string
+		maxSubscribers int64
+		// before runs before the key creates its last email.
+		before   func(db *sql.DB, apiKey *ApiKey) error
+		exceeded bool
+	}{
+		{name: "under quota", maxSubscribers: 3},
+		{name: "unlimited"},
+		{
+			name:           "at quota",
+			maxSubscribers: 2,
+			exceeded:       true,
+		},
+		{
+			name:           "opted out not counted",
+			maxSubscribers: 2,
+			before: func(db *sql.DB, apiKey *ApiKey) error {
+				return OptOutEmail(ctx, db, "user0@example.com", "test")
+			},
+		},
+		{
+			name:           "trashed not counted",
+			maxSubscribers: 2,
+			before: func(db *sql.DB, apiKey *ApiKey) error {
+				return DeleteEmailByEmail(ctx, db, "user0@example.com")
+			},
+		},
+		{
+			name:           "other key not counted",
+			maxSubscribers: 2,
+			before: func(db *sql.DB, apiKey *ApiKey) error {
+				if err := DeleteEmailByEmail(ctx, db, "user0@example.com"); err != nil {
+					return err
+				}
+				other, err := CreateApiKey(ctx, db, "other", ScopeWrite, "", 0, 0)
+				if err != nil {
+					return err
+				}
+				return CreateEmailForApiKey(ctx, db, "user0@example.com", *other)
+			},
+		},
+	}
+
+	create := map[string]func(db *sql.DB, email string, apiKey *ApiKey) error{
+		"CreateEmailForApiKey": func(db *sql.DB, email string, apiKey *ApiKey) error {
+			return CreateEmailForApiKey(ctx, db, email, *apiKey)
+		},
+		"CreateEmails": func(db *sql.DB, email string, apiKey *ApiKey) error {
+			created, overQuota, err := CreateEmails(ctx, db, []string{email}, apiKey)
+			if err != nil {
+				return err
+			}
+			if len(overQuota) > 0 {
+				return ErrQuotaExceeded
+			}
+			if created != 1 {
+				return fmt.Errorf("created %v emails", created)
+			}
+			return nil
+		},
+	}
+
+	for name, create := range create {
+		for _, test := range tests {
+			t.Run(name+"/"+test.name, func(t *testing.T) {
+				db := migratedDB(t)
+				apiKey, err := CreateApiKey(ctx, db, "test", ScopeWrite, "", test.maxSubscribers, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i := range 2 {
+					if err := create(db, fmt.Sprintf("user%v@example.com", i), apiKey); err != nil {
+						t.Fatalf("email %v: %v", i, err)
+					}
+				}
+				if test.before != nil {
+					if err := test.before(db, apiKey); err != nil {
+						t.Fatal(err)
+					}
+				}
+				err = create(db, "jane@example.com", apiKey)
+				if test.exceeded != errors.Is(err, ErrQuotaExceeded) || (!test.exceeded && err != nil) {
+					t.Errorf("%v = %v, want exceeded %v", name, err, test.exceeded)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+	"time"
+)
+
+// Sources of the audit log entries.
+const (
+	AuditSourceGrpc = "grpc"
+	AuditSourceHttp = "http"
+)
+
+// AuditEntry records who changed what, through which API, and how it went.
+type AuditEntry struct {
+	Id         int64
+	At         time.Time
+	Principal  string
+	Source     string
+	Action     string
+	Target     string
+	Outcome    string
+	RemoteAddr string
+}
+
+func tryCreateAudit(s *schemaTx) {
+	s.tryExec(`
+		CREATE TABLE audit_log (
+			id 			INTEGER PRIMARY KEY,
+			at 			INTEGER,
+			principal 	TEXT,
+			source 		TEXT,
+			action 		TEXT,
+			target 		TEXT,
+			outcome 	TEXT,
+			remote_addr TEXT
+		);
+	`)
+	s.tryExec(`CREATE INDEX audit_log_at ON audit_log (at);`)
+}
+
+func RecordAudit(ctx context.Context, db *sql.DB, entry AuditEntry) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO audit_log (at, principal, source, action, target, outcome, remote_addr)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, entry.At.Unix(), entry.Principal, entry.Source, entry.Action, entry.Target, entry.Outcome, entry.RemoteAddr)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("recording audit entry", "entry", entry, "err", err)
+		return err
+	}
+	return nil
+}
+
+// GetAuditLog returns the most recent audit entries, newest first.
+func GetAuditLog(ctx context.Context, db *sql.DB, limit int) ([]*AuditEntry, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, at, principal, source, action, target, outcome, remote_addr
+		FROM audit_log ORDER BY id DESC LIMIT ?
+	`, limit)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("getting audit log", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*AuditEntry, 0)
+	for rows.Next() {
+		var (
+			entry AuditEntry
+			at    int64
+		)
+		err := rows.Scan(&entry.Id, &at, &entry.Principal, &entry.Source, &entry.Action, &entry.Target, &entry.Outcome, &entry.RemoteAddr)
+		if err != nil {
+			return nil, err
+		}
+		entry.At = time.Unix(at, 0)
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,20 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+)
+
+// Backup writes a consistent snapshot of the database to path, which must
+// not exist, while the database stays in use.
+func Backup(ctx context.Context, db *sql.DB, path string) (err error) {
+	ctx, span := startSpan(ctx, "Backup")
+	defer endSpan(span, &err)
+
+	if _, err = db.ExecContext(ctx, `VACUUM INTO ?`, path); err != nil {
+		logging.FromContext(ctx).Error("backing up the database", "path", path, "err", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+	"time"
+)
+
+// BounceReason is recorded as opt-out reason of the emails suppressed
+// after a hard bounce.
+const BounceReason = "bounce"
+
+// Bounces counts the bounces of an email: the hard ones, for good, which
+// suppress it, and the soft ones, e.g. a full mailbox.
+type Bounces struct {
+	Hard int64
+	Soft int64
+	// LastBouncedAt is nil for the emails which never bounced.
+	LastBouncedAt *time.Time
+}
+
+func createBounces(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE email_bounces (
+			email_id 		INTEGER PRIMARY KEY,
+			hard 			INTEGER,
+			soft 			INTEGER,
+			last_bounced_at INTEGER
+		);
+		CREATE TRIGGER emails_delete_bounces AFTER DELETE ON emails
+		BEGIN
+			DELETE FROM email_bounces WHERE email_id = OLD.id;
+		END;
+	`)
+	return err
+}
+
+func dropBounces(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		DROP TRIGGER emails_delete_bounces;
+		DROP TABLE email_bounces;
+	`)
+	return err
+}
+
+// RecordBounce counts a bounce of the email, and opts it out with
+// BounceReason after a hard one, unless it already opted out. It reports
+// whether the email was suppressed, and fails with ErrEmailNotFound.
+func RecordBounce(ctx context.Context, db *sql.DB, email string, hard bool) (suppressed bool, err error) {
+	ctx, span := startSpan(ctx, "RecordBounce")
+	defer endSpan(span, &err)
+
+	eid, err := emailId(ctx, db, email)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	hardCount, softCount := 0, 1
+	if hard {
+		hardCount, softCount = 1, 0
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO email_bounces (email_id, hard, soft, last_bounced_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT DO UPDATE SET
+			hard = hard + excluded.hard, soft = soft + excluded.soft, last_bounced_at = excluded.last_bounced_at
+	`, eid, hardCount, softCount, time.Now().Unix())
+	if err != nil {
+		logging.FromContext(ctx).Error("recording bounce", "email", email, "err", err)
+		return false, err
+	}
+
+	if hard {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE emails SET opt_out = true, opt_out_reason = ? WHERE id = ? AND NOT opt_out
+		`, BounceReason, eid)
+		if err != nil {
+			logging.FromContext(ctx).Error("suppressing bounced email", "email", email, "err", err)
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+		suppressed = n > 0
+	}
+	return suppressed, tx.Commit()
+}
+
+// GetBounces returns the bounces of the email, none for the emails which
+// never bounced. It fails with ErrEmailNotFound.
+func GetBounces(ctx context.Context, db *sql.DB, email string) (*Bounces, error) {
+	eid, err := emailId(ctx, db, email)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		b         Bounces
+		bouncedAt int64
+	)
+	err = db.QueryRowContext(ctx, `
+		SELECT hard, soft, last_bounced_at FROM email_bounces WHERE email_id = ?
+	`, eid).Scan(&b.Hard, &b.Soft, &bouncedAt)
+	if err == sql.ErrNoRows {
+		return &b, nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("getting bounces", "email", email, "err", err)
+		return nil, err
+	}
+	t := time.Unix(bouncedAt, 0)
+	b.LastBouncedAt = &t
+	return &b, nil
+}
@@ -0,0 +1,288 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mailinglist/logging"
+	"mailinglist/sanitize"
+	"mailinglist/templates"
+	"net/mail"
+	"time"
+)
+
+var (
+	ErrCampaignNotFound = errors.New("campaign not found")
+	// ErrCampaignState is returned for the changes the state of the
+	// campaign does not allow, e.g. editing a campaign being sent.
+	ErrCampaignState = errors.New("campaign cannot be changed in its state")
+)
+
+// CampaignState is a step of the lifecycle of a campaign: a draft is
+// edited, then scheduled, then sent. A scheduled campaign goes back to
+// draft when unscheduled.
+type CampaignState string
+
+const (
+	CampaignDraft     CampaignState = "draft"
+	CampaignScheduled CampaignState = "scheduled"
+	CampaignSending   CampaignState = "sending"
+	CampaignSent      CampaignState = "sent"
+)
+
+// Segment selects the recipients of a campaign: the subscribed emails of
+// List, with Tag, each one only when set.
+type Segment struct {
+	List string
+	Tag  string
+}
+
+// Campaign is an email sent to a segment of the subscribers. The subject
+// and the bodies are templates, rendered for each recipient with the merge
+// tags of templates.Data.
+type Campaign struct {
+	Id   int64
+	Name string
+	// From is the sender, the one of the mail settings when empty.
+	From     string
+	Subject  string
+	TextBody string
+	// HTMLBody is the alternative to TextBody, optional.
+	HTMLBody    string
+	Segment     Segment
+	State       CampaignState
+	ScheduledAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	SentAt      *time.Time
+	Progress    CampaignProgress
+}
+
+// Validate checks the content of the campaign: its sender, and its subject
+// and bodies with templates.Parse.
+func (c *Campaign) Validate() error {
+	if c.Name == "" {
+		return errors.New("Name is required")
+	}
+	if err := sanitize.Check("Name", c.Name); err != nil {
+		return err
+	}
+	if c.From != "" {
+		if _, err := mail.ParseAddress(c.From); err != nil {
+			return fmt.Errorf("From: %w", err)
+		}
+	}
+	if c.Subject == "" {
+		return errors.New("Subject is required")
+	}
+	if c.TextBody == "" {
+		return errors.New("TextBody is required")
+	}
+	_, err := templates.Parse(c.Template())
+	return err
+}
+
+// Template returns the subject and the bodies of the campaign.
+func (c *Campaign) Template() templates.Template {
+	return templates.Template{Subject: c.Subject, Text: c.TextBody, HTML: c.HTMLBody}
+}
+
+func createCampaigns(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE campaigns (
+			id 				INTEGER PRIMARY KEY,
+			name 			TEXT,
+			from_addr 		TEXT,
+			subject 		TEXT,
+			text_body 		TEXT,
+			html_body 		TEXT,
+			segment_list 	TEXT,
+			segment_tag 	TEXT,
+			state 			TEXT,
+			scheduled_at 	INTEGER,
+			created_at 		INTEGER,
+			updated_at 		INTEGER,
+			sent_at 		INTEGER
+		);
+		CREATE INDEX campaigns_state ON campaigns (state, scheduled_at);
+	`)
+	return err
+}
+
+func dropCampaigns(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `DROP TABLE campaigns`)
+	return err
+}
+
+const campaignColumns = `id, name, from_addr, subject, text_body, html_body, segment_list, segment_tag,
+	state, scheduled_at, created_at, updated_at, sent_at,
+	(SELECT COUNT(*) FROM campaign_recipients r WHERE r.campaign_id = campaigns.id),
+	(SELECT COUNT(*) FROM campaign_recipients r WHERE r.campaign_id = campaigns.id AND r.status = 'pending'),
+	(SELECT COUNT(*) FROM campaign_recipients r WHERE r.campaign_id = campaigns.id AND r.status = 'queued'),
+	(SELECT COUNT(*) FROM campaign_recipients r WHERE r.campaign_id = campaigns.id AND r.status = 'sent'),
+	(SELECT COUNT(*) FROM campaign_recipients r WHERE r.campaign_id = campaigns.id AND r.status = 'failed')`
+
+func campaignFromRow(row interface{ Scan(...any) error }) (*Campaign, error) {
+	var (
+		c                    Campaign
+		createdAt, updatedAt int64
+		scheduledAt, sentAt  sql.NullInt64
+	)
+	err := row.Scan(&c.Id, &c.Name, &c.From, &c.Subject, &c.TextBody, &c.HTMLBody, &c.Segment.List, &c.Segment.Tag,
+		&c.State, &scheduledAt, &createdAt, &updatedAt, &sentAt,
+		&c.Progress.Total, &c.Progress.Pending, &c.Progress.Queued, &c.Progress.Sent, &c.Progress.Failed)
+	if err != nil {
+		return nil, err
+	}
+	c.CreatedAt = time.Unix(createdAt, 0)
+	c.UpdatedAt = time.Unix(updatedAt, 0)
+	if scheduledAt.Valid {
+		t := time.Unix(scheduledAt.Int64, 0)
+		c.ScheduledAt = &t
+	}
+	if sentAt.Valid {
+		t := time.Unix(sentAt.Int64, 0)
+		c.SentAt = &t
+	}
+	return &c, nil
+}
+
+// checkSegment fails with ErrListNotFound for a segment of an unknown list.
+func checkSegment(ctx context.Context, db *sql.DB, segment Segment) error {
+	if segment.List == "" {
+		return nil
+	}
+	_, err := listId(ctx, db, segment.List)
+	return err
+}
+
+// CreateCampaign creates the campaign as a draft.
+func CreateCampaign(ctx context.Context, db *sql.DB, c Campaign) (*Campaign, error) {
+	if err := checkSegment(ctx, db, c.Segment); err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO campaigns (name, from_addr, subject, text_body, html_body, segment_list, segment_tag, state, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, c.Name, c.From, c.Subject, c.TextBody, c.HTMLBody, c.Segment.List, c.Segment.Tag, CampaignDraft, now, now)
+	if err != nil {
+		logging.FromContext(ctx).Error("creating campaign", "campaign", c.Name, "err", err)
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return GetCampaign(ctx, db, id)
+}
+
+func GetCampaign(ctx context.Context, db *sql.DB, id int64) (*Campaign, error) {
+	row := db.QueryRowContext(ctx, `SELECT `+campaignColumns+` FROM campaigns WHERE id = ?`, id)
+	c, err := campaignFromRow(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrCampaignNotFound
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("getting campaign", "id", id, "err", err)
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCampaigns returns the campaigns in the state, all of them when empty,
+// the latest first.
+func GetCampaigns(ctx context.Context, db *sql.DB, state CampaignState) ([]*Campaign, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+campaignColumns+` FROM campaigns
+		WHERE ? = '' OR state = ?
+		ORDER BY id DESC
+	`, state, state)
+	if err != nil {
+		logging.FromContext(ctx).Error("getting campaigns", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	campaigns := make([]*Campaign, 0)
+	for rows.Next() {
+		c, err := campaignFromRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, c)
+	}
+	return campaigns, rows.Err()
+}
+
+// UpdateCampaign replaces the content and the segment of a draft.
+func UpdateCampaign(ctx context.Context, db *sql.DB, id int64, c Campaign) (*Campaign, error) {
+	if err := checkSegment(ctx, db, c.Segment); err != nil {
+		return nil, err
+	}
+	res, err := db.ExecContext(ctx, `
+		UPDATE campaigns
+			SET name = ?, from_addr = ?, subject = ?, text_body = ?, html_body = ?, segment_list = ?, segment_tag = ?, updated_at = ?
+		WHERE id = ? AND state = ?
+	`, c.Name, c.From, c.Subject, c.TextBody, c.HTMLBody, c.Segment.List, c.Segment.Tag, time.Now().Unix(), id, CampaignDraft)
+	if err := changedCampaign(ctx, db, "updating campaign", id, res, err); err != nil {
+		return nil, err
+	}
+	return GetCampaign(ctx, db, id)
+}
+
+// DeleteCampaign deletes a campaign, but while it is being sent.
+func DeleteCampaign(ctx context.Context, db *sql.DB, id int64) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM campaigns WHERE id = ? AND state != ?`, id, CampaignSending)
+	if err := changedCampaign(ctx, db, "deleting campaign", id, res, err); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ScheduleCampaign schedules a draft, or a scheduled campaign again, to be
+// sent at the time.
+func ScheduleCampaign(ctx context.Context, db *sql.DB, id int64, at time.Time) (*Campaign, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE campaigns SET state = ?, scheduled_at = ?, updated_at = ?
+		WHERE id = ? AND state IN (?, ?)
+	`, CampaignScheduled, at.Unix(), time.Now().Unix(), id, CampaignDraft, CampaignScheduled)
+	if err := changedCampaign(ctx, db, "scheduling campaign", id, res, err); err != nil {
+		return nil, err
+	}
+	return GetCampaign(ctx, db, id)
+}
+
+// UnscheduleCampaign turns a scheduled campaign back into a draft.
+func UnscheduleCampaign(ctx context.Context, db *sql.DB, id int64) (*Campaign, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE campaigns SET state = ?, scheduled_at = NULL, updated_at = ?
+		WHERE id = ? AND state = ?
+	`, CampaignDraft, time.Now().Unix(), id, CampaignScheduled)
+	if err := changedCampaign(ctx, db, "unscheduling campaign", id, res, err); err != nil {
+		return nil, err
+	}
+	return GetCampaign(ctx, db, id)
+}
+
+// changedCampaign tells apart, when a change conditioned on the state of
+// the campaign changed nothing, an unknown campaign from one in another
+// state. The other errors are logged with the action.
+func changedCampaign(ctx context.Context, db *sql.DB, action string, id int64, res sql.Result, err error) error {
+	if err != nil {
+		logging.FromContext(ctx).Error(action, "id", id, "err", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM campaigns WHERE id = ?)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrCampaignNotFound
+	}
+	return ErrCampaignState
+}
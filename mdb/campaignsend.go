@@ -0,0 +1,268 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+	"time"
+)
+
+// RecipientStatus is where the sending of a campaign to a recipient is:
+// pending until the message is rendered into the send queue, queued until
+// it is sent or fails for good.
+type RecipientStatus string
+
+const (
+	RecipientPending RecipientStatus = "pending"
+	RecipientQueued  RecipientStatus = "queued"
+	RecipientSent    RecipientStatus = "sent"
+	RecipientFailed  RecipientStatus = "failed"
+)
+
+// CampaignRecipient is a recipient of a campaign, with the hash of the
+// message rendered for it once queued.
+type CampaignRecipient struct {
+	Email       string
+	Status      RecipientStatus
+	Error       string
+	MessageHash string
+	SentAt      *time.Time
+}
+
+// CampaignProgress counts the recipients of a campaign by status, all
+// zero until the campaign is sent.
+type CampaignProgress struct {
+	Total   int64
+	Pending int64
+	Queued  int64
+	Sent    int64
+	Failed  int64
+}
+
+func createCampaignRecipients(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE campaign_recipients (
+			campaign_id 	INTEGER,
+			email 			TEXT,
+			status 			TEXT,
+			error 			TEXT,
+			sent_at 		INTEGER,
+			PRIMARY KEY (campaign_id, email)
+		);
+		CREATE INDEX campaign_recipients_status ON campaign_recipients (campaign_id, status);
+		CREATE TRIGGER campaigns_delete_recipients AFTER DELETE ON campaigns
+		BEGIN
+			DELETE FROM campaign_recipients WHERE campaign_id = OLD.id;
+		END;
+	`)
+	return err
+}
+
+func dropCampaignRecipients(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		DROP TRIGGER campaigns_delete_recipients;
+		DROP TABLE campaign_recipients;
+	`)
+	return err
+}
+
+// StartDueCampaigns moves the campaigns scheduled at now or before to
+// sending, expanding the segment of each into its recipients, the emails
+// subscribed at that time, and confirmed with confirmedOnly. It returns the
+// ids of the campaigns started.
+func StartDueCampaigns(ctx context.Context, db *sql.DB, now time.Time, confirmedOnly bool) (started []int64, err error) {
+	ctx, span := startSpan(ctx, "StartDueCampaigns")
+	defer endSpan(span, &err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id FROM campaigns WHERE state = ? AND scheduled_at <= ? ORDER BY scheduled_at ASC
+	`, CampaignScheduled, now.Unix())
+	if err != nil {
+		logging.FromContext(ctx).Error("getting the due campaigns", "err", err)
+		return nil, err
+	}
+	var due []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range due {
+		ok, err := startCampaign(ctx, db, id, now, confirmedOnly)
+		if err != nil {
+			logging.FromContext(ctx).Error("starting campaign", "id", id, "err", err)
+			return started, err
+		}
+		if ok {
+			started = append(started, id)
+		}
+	}
+	return started, nil
+}
+
+// startCampaign starts the campaign, unless it was unscheduled or
+// scheduled later meanwhile.
+func startCampaign(ctx context.Context, db *sql.DB, id int64, now time.Time, confirmedOnly bool) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE campaigns SET state = ?, updated_at = ?
+		WHERE id = ? AND state = ? AND scheduled_at <= ?
+	`, CampaignSending, now.Unix(), id, CampaignScheduled, now.Unix())
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	var segment Segment
+	if err := tx.QueryRowContext(ctx, `SELECT segment_list, segment_tag FROM campaigns WHERE id = ?`, id).Scan(&segment.List, &segment.Tag); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO campaign_recipients (campaign_id, email, status)
+		SELECT ?, e.email, ? FROM emails e
+		WHERE e.opt_out = false AND e.deleted_at IS NULL
+			AND (? = false OR COALESCE(e.confirmed_at, 0) > 0)
+			AND (? = '' OR e.id IN (
+				SELECT m.email_id FROM list_members m JOIN mailing_lists l ON l.id = m.list_id WHERE l.name = ?
+			))
+			AND (? = '' OR e.id IN (SELECT t.email_id FROM email_tags t WHERE t.tag = ?))
+	`, id, RecipientPending, confirmedOnly, segment.List, segment.List, segment.Tag, segment.Tag)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// PendingRecipients returns up to limit emails the campaign is still to be
+// sent to.
+func PendingRecipients(ctx context.Context, db *sql.DB, campaignId int64, limit int) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT email FROM campaign_recipients WHERE campaign_id = ? AND status = ? ORDER BY email LIMIT ?
+	`, campaignId, RecipientPending, limit)
+	if err != nil {
+		logging.FromContext(ctx).Error("getting the pending recipients", "id", campaignId, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := make([]string, 0, limit)
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	return emails, rows.Err()
+}
+
+// GetCampaignRecipients returns up to limit recipients of the campaign,
+// only the ones in the status when set, by email after the email after.
+func GetCampaignRecipients(ctx context.Context, db *sql.DB, campaignId int64, status RecipientStatus, after string, limit int) ([]*CampaignRecipient, error) {
+	if _, err := GetCampaign(ctx, db, campaignId); err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT email, status, COALESCE(error, ''), COALESCE(message_hash, ''), sent_at
+		FROM campaign_recipients
+		WHERE campaign_id = ? AND (? = '' OR status = ?) AND email > ?
+		ORDER BY email LIMIT ?
+	`, campaignId, status, status, after, limit)
+	if err != nil {
+		logging.FromContext(ctx).Error("getting the recipients of campaign", "id", campaignId, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipients := make([]*CampaignRecipient, 0)
+	for rows.Next() {
+		var (
+			r      CampaignRecipient
+			sentAt sql.NullInt64
+		)
+		if err := rows.Scan(&r.Email, &r.Status, &r.Error, &r.MessageHash, &sentAt); err != nil {
+			return nil, err
+		}
+		if sentAt.Valid {
+			t := time.Unix(sentAt.Int64, 0)
+			r.SentAt = &t
+		}
+		recipients = append(recipients, &r)
+	}
+	return recipients, rows.Err()
+}
+
+// QueueRecipients puts the messages of the campaign in the send queue, their
+// recipients being queued with them.
+func QueueRecipients(ctx context.Context, db *sql.DB, campaignId int64, messages []*QueuedMessage) (err error) {
+	defer func() {
+		if err != nil {
+			logging.FromContext(ctx).Error("queueing the recipients of campaign", "id", campaignId, "err", err)
+		}
+	}()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, m := range messages {
+		m.CampaignId = campaignId
+		if _, err := enqueueMessage(ctx, tx, m); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			UPDATE campaign_recipients SET status = ?, message_hash = ? WHERE campaign_id = ? AND email = ?
+		`, RecipientQueued, m.Hash, campaignId, m.To)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// FailRecipient records that the campaign could not be sent to the email,
+// its message failing to render.
+func FailRecipient(ctx context.Context, db *sql.DB, campaignId int64, email string, cause error) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE campaign_recipients SET status = ?, error = ?, sent_at = ?
+		WHERE campaign_id = ? AND email = ?
+	`, RecipientFailed, cause.Error(), time.Now().Unix(), campaignId, email)
+	if err != nil {
+		logging.FromContext(ctx).Error("failing a recipient of campaign", "id", campaignId, "email", email, "err", err)
+	}
+	return err
+}
+
+// FinishCampaign moves a campaign being sent to sent once none of its
+// recipients is pending or queued, and reports whether it did.
+func FinishCampaign(ctx context.Context, db *sql.DB, id int64) (bool, error) {
+	now := time.Now().Unix()
+	res, err := db.ExecContext(ctx, `
+		UPDATE campaigns SET state = ?, sent_at = ?, updated_at = ?
+		WHERE id = ? AND state = ?
+			AND NOT EXISTS (SELECT 1 FROM campaign_recipients WHERE campaign_id = ? AND status IN (?, ?))
+	`, CampaignSent, now, now, id, CampaignSending, id, RecipientPending, RecipientQueued)
+	if err != nil {
+		logging.FromContext(ctx).Error("finishing campaign", "id", id, "err", err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
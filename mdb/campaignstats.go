@@ -0,0 +1,259 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mailinglist/logging"
+	"time"
+)
+
+// StatsInterval is the length of the buckets of the series of the stats of
+// a campaign, starting on the hour or on the day in UTC.
+type StatsInterval string
+
+const (
+	StatsHour StatsInterval = "hour"
+	StatsDay  StatsInterval = "day"
+)
+
+// maxStatsBuckets bounds the series of the stats of a campaign, the hours
+// of a hundred days.
+const maxStatsBuckets = 2400
+
+// ErrTooManyBuckets is returned for the stats of a campaign whose series
+// would have more than maxStatsBuckets buckets of the interval.
+var ErrTooManyBuckets = errors.New("too many buckets in the series, use a longer interval")
+
+// Validate checks the interval is one of the known ones.
+func (i StatsInterval) Validate() error {
+	if i != StatsHour && i != StatsDay {
+		return fmt.Errorf("unknown stats interval %q, hour or day", i)
+	}
+	return nil
+}
+
+func (i StatsInterval) seconds() int64 {
+	if i == StatsDay {
+		return 86400
+	}
+	return 3600
+}
+
+// CampaignStats counts what became of the messages of a campaign.
+//
+// The bounces, the unsubscriptions and the complaints of an email are
+// counted for the last campaign sent to it before them, once per
+// recipient. Only the hard bounces are counted, the soft ones not
+// suppressing the email.
+type CampaignStats struct {
+	CampaignId int64
+	Interval   StatsInterval
+	// Sent counts the recipients sent their message, Delivered those of
+	// them which did not bounce since, and Failed the recipients whose
+	// message could not be sent.
+	Sent      int64
+	Delivered int64
+	Failed    int64
+	Bounced   int64
+	// Opened counts the recipients whose tracking pixel was loaded, a lower
+	// bound as the mail clients blocking the images load none.
+	Opened int64
+	// Clicked counts the recipients who clicked a link, Clicks the clicks.
+	Clicked      int64
+	Clicks       int64
+	Unsubscribed int64
+	Complained   int64
+	// Series has one bucket per interval from the first message sent to
+	// the last event, including the buckets without any.
+	Series []CampaignStatsBucket
+}
+
+// CampaignStatsBucket counts the events of a campaign during an interval,
+// a recipient who opened or clicked counted as Opened or Clicked in the
+// bucket of their first open or click.
+type CampaignStatsBucket struct {
+	Start        time.Time
+	Sent         int64
+	Bounced      int64
+	Opened       int64
+	Clicked      int64
+	Clicks       int64
+	Unsubscribed int64
+	Complained   int64
+}
+
+func createCampaignStats(ctx context.Context, tx *sql.Tx) error {
+	// The events of an email are told apart between the campaigns sent to
+	// it by the times they were sent.
+	_, err := tx.ExecContext(ctx, `
+		CREATE INDEX campaign_recipients_email ON campaign_recipients (email, sent_at);
+	`)
+	return err
+}
+
+func dropCampaignStats(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `DROP INDEX campaign_recipients_email;`)
+	return err
+}
+
+// GetCampaignStats returns the stats of the campaign, with a series of
+// buckets of the interval, an hour when empty. It fails with
+// ErrCampaignNotFound, and with ErrTooManyBuckets for a series too long.
+func GetCampaignStats(ctx context.Context, db *sql.DB, id int64, interval StatsInterval) (*CampaignStats, error) {
+	c, err := GetCampaign(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+	if interval == "" {
+		interval = StatsHour
+	}
+	seconds := interval.seconds()
+	stats := &CampaignStats{CampaignId: id, Interval: interval, Failed: c.Progress.Failed}
+
+	buckets := make(map[int64]*CampaignStatsBucket)
+	bucket := func(at int64) *CampaignStatsBucket {
+		b := buckets[at/seconds]
+		if b == nil {
+			b = &CampaignStatsBucket{Start: time.Unix(at/seconds*seconds, 0).UTC()}
+			buckets[at/seconds] = b
+		}
+		return b
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT sent_at / ?, COUNT(*) FROM campaign_recipients
+		WHERE campaign_id = ? AND status = ?
+		GROUP BY 1
+	`, seconds, id, RecipientSent)
+	if err != nil {
+		logging.FromContext(ctx).Error("counting the messages sent of campaign", "id", id, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var at, count int64
+		if err := rows.Scan(&at, &count); err != nil {
+			return nil, err
+		}
+		stats.Sent += count
+		bucket(at * seconds).Sent += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT e.kind, MIN(e.changed_at) FROM campaign_recipients r
+		JOIN email_events e ON e.email = r.email AND e.changed_at >= r.sent_at
+		WHERE r.campaign_id = ? AND r.status = ? AND e.kind IN (?, ?, ?)
+			AND NOT EXISTS (
+				SELECT 1 FROM campaign_recipients later
+				WHERE later.email = r.email AND later.status = ?
+					AND later.sent_at > r.sent_at AND later.sent_at <= e.changed_at
+			)
+		GROUP BY e.kind, r.email
+	`, id, RecipientSent, EventBounced, EventUnsubscribed, EventComplained, RecipientSent)
+	if err != nil {
+		logging.FromContext(ctx).Error("counting the events of campaign", "id", id, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			kind string
+			at   int64
+		)
+		if err := rows.Scan(&kind, &at); err != nil {
+			return nil, err
+		}
+		switch kind {
+		case EventBounced:
+			stats.Bounced++
+			bucket(at).Bounced++
+		case EventUnsubscribed:
+			stats.Unsubscribed++
+			bucket(at).Unsubscribed++
+		case EventComplained:
+			stats.Complained++
+			bucket(at).Complained++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT MIN(opened_at) FROM opens WHERE campaign_id = ? GROUP BY email_id
+	`, id)
+	if err != nil {
+		logging.FromContext(ctx).Error("counting the opens of campaign", "id", id, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var at int64
+		if err := rows.Scan(&at); err != nil {
+			return nil, err
+		}
+		stats.Opened++
+		bucket(at).Opened++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT email_id, clicked_at FROM clicks WHERE campaign_id = ? ORDER BY clicked_at
+	`, id)
+	if err != nil {
+		logging.FromContext(ctx).Error("counting the clicks of campaign", "id", id, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	clicked := make(map[int64]bool)
+	for rows.Next() {
+		var emailId, at int64
+		if err := rows.Scan(&emailId, &at); err != nil {
+			return nil, err
+		}
+		b := bucket(at)
+		stats.Clicks++
+		b.Clicks++
+		if !clicked[emailId] {
+			clicked[emailId] = true
+			stats.Clicked++
+			b.Clicked++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats.Delivered = stats.Sent - stats.Bounced
+
+	if len(buckets) == 0 {
+		return stats, nil
+	}
+	first, last := int64(-1), int64(-1)
+	for at := range buckets {
+		if first < 0 || at < first {
+			first = at
+		}
+		if at > last {
+			last = at
+		}
+	}
+	if last-first >= maxStatsBuckets {
+		return nil, ErrTooManyBuckets
+	}
+	for at := first; at <= last; at++ {
+		b := buckets[at]
+		if b == nil {
+			b = &CampaignStatsBucket{Start: time.Unix(at*seconds, 0).UTC()}
+		}
+		stats.Series = append(stats.Series, *b)
+	}
+	return stats, nil
+}
@@ -0,0 +1,63 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+	"time"
+)
+
+func createClicks(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE clicks (
+			id 			INTEGER PRIMARY KEY,
+			campaign_id INTEGER,
+			email_id 	INTEGER,
+			url 		TEXT,
+			clicked_at 	INTEGER
+		);
+		CREATE INDEX clicks_campaign ON clicks (campaign_id, clicked_at);
+		CREATE TRIGGER campaigns_delete_clicks AFTER DELETE ON campaigns
+		BEGIN
+			DELETE FROM clicks WHERE campaign_id = OLD.id;
+		END;
+		CREATE TRIGGER emails_delete_clicks AFTER DELETE ON emails
+		BEGIN
+			DELETE FROM clicks WHERE email_id = OLD.id;
+		END;
+	`)
+	return err
+}
+
+func dropClicks(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		DROP TRIGGER emails_delete_clicks;
+		DROP TRIGGER campaigns_delete_clicks;
+		DROP TABLE clicks;
+	`)
+	return err
+}
+
+// RecordClick records a click of the email on the link to the url of a
+// message of the campaign. It reports false, recording nothing, when the
+// campaign or the email was deleted since.
+func RecordClick(ctx context.Context, db *sql.DB, campaignId, emailId int64, url string) (recorded bool, err error) {
+	ctx, span := startSpan(ctx, "RecordClick")
+	defer endSpan(span, &err)
+
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO clicks (campaign_id, email_id, url, clicked_at)
+		SELECT ?, ?, ?, ?
+		WHERE EXISTS (SELECT 1 FROM campaigns WHERE id = ?)
+			AND EXISTS (SELECT 1 FROM emails WHERE id = ? AND deleted_at IS NULL)
+	`, campaignId, emailId, url, time.Now().Unix(), campaignId, emailId)
+	if err != nil {
+		logging.FromContext(ctx).Error("recording click", "campaign", campaignId, "email_id", emailId, "err", err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,106 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+	"time"
+)
+
+// ComplaintReason is recorded as opt-out reason of the emails whose
+// recipient reported a message as spam. Those emails stay opted out: the
+// updates do not opt them back in, no message is sent to them, and they
+// are opted out again when deleted then created again.
+const ComplaintReason = "complaint"
+
+// complained is the SQL condition of the emails which complained.
+const complained = `(opt_out AND opt_out_reason IS '` + ComplaintReason + `')`
+
+// createComplaints keeps the addresses which complained apart from their
+// emails, which may be deleted, the emails created again with one of them
+// being opted out with ComplaintReason.
+func createComplaints(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE complaints (
+			email 			TEXT PRIMARY KEY,
+			complained_at 	INTEGER
+		);
+		INSERT OR IGNORE INTO complaints (email, complained_at)
+		SELECT email, strftime('%s', 'now') FROM emails WHERE `+complained+`;
+		CREATE TRIGGER emails_insert_complained AFTER INSERT ON emails
+		WHEN NOT (NEW.opt_out AND NEW.opt_out_reason IS '`+ComplaintReason+`')
+			AND EXISTS (SELECT 1 FROM complaints WHERE email = NEW.email)
+		BEGIN
+			UPDATE emails SET opt_out = true, opt_out_reason = '`+ComplaintReason+`' WHERE id = NEW.id;
+		END;
+	`)
+	return err
+}
+
+func dropComplaints(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		DROP TRIGGER emails_insert_complained;
+		DROP TABLE complaints;
+	`)
+	return err
+}
+
+// RecordComplaint opts the email out with ComplaintReason, even when it
+// already opted out for another reason, and remembers its address for
+// good. It reports whether the email had not complained yet, and fails
+// with ErrEmailNotFound.
+func RecordComplaint(ctx context.Context, db *sql.DB, email string) (recorded bool, err error) {
+	ctx, span := startSpan(ctx, "RecordComplaint")
+	defer endSpan(span, &err)
+	defer func() {
+		if err != nil && err != ErrEmailNotFound {
+			logging.FromContext(ctx).Error("recording complaint", "email", email, "err", err)
+		}
+	}()
+
+	eid, err := emailId(ctx, db, email)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE emails SET opt_out = true, opt_out_reason = ? WHERE id = ? AND NOT `+complained,
+		ComplaintReason, eid)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT OR IGNORE INTO complaints (email, complained_at) VALUES (?, ?)
+	`, email, time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	return n > 0, tx.Commit()
+}
+
+// Suppressed reports whether the message is not to be sent anymore: its
+// recipient complained, or, for a campaign, opted out or was deleted since
+// the message was queued.
+func Suppressed(ctx context.Context, db *sql.DB, m *QueuedMessage) (bool, error) {
+	var suppressed bool
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM emails WHERE email = ? AND `+complained+`)
+			OR (? AND NOT EXISTS (
+				SELECT 1 FROM emails WHERE email = ? AND NOT COALESCE(opt_out, false) AND deleted_at IS NULL
+			))
+	`, m.To, m.CampaignId != 0, m.To).Scan(&suppressed)
+	if err != nil {
+		logging.FromContext(ctx).Error("checking suppression", "email", m.To, "err", err)
+	}
+	return suppressed, err
+}
@@ -0,0 +1,136 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// complainedDB returns a database whose email jane@example.com complained.
+func complainedDB(t *testing.T) *sql.DB {
+	t.Helper()
+	ctx := context.Background()
+	db := openDB(t)
+	if err := Migrate(ctx, db, SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateEmail(ctx, db, "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := RecordComplaint(ctx, db, "jane@example.com")
+	if err != nil || !recorded {
+		t.Fatalf("RecordComplaint = %v, %v", recorded, err)
+	}
+	return db
+}
+
+func checkComplained(t *testing.T, db *sql.DB) {
+	t.Helper()
+	entry, err := GetEmail(context.Background(), db, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || !entry.OptOut || entry.OptOutReason != ComplaintReason {
+		t.Errorf("email %+v, want it opted out with %v", entry, ComplaintReason)
+	}
+}
+
+func TestComplaintKept(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		change func(db *sql.DB) error
+	}{
+		{
+			name: "complained again",
+			change: func(db *sql.DB) error {
+				recorded, err := RecordComplaint(ctx, db, "jane@example.com")
+				if recorded {
+					t.Error("complaint recorded twice")
+				}
+				return err
+			},
+		},
+		{
+			name: "upserted",
+			change: func(db *sql.DB) error {
+				return UpsertEmail(ctx, db, EmailEntry{Email: "jane@example.com", ConfirmedAt: &now})
+			},
+		},
+		{
+			name: "synced opted in",
+			change: func(db *sql.DB) error {
+				applied, err := ApplySyncEvent(ctx, db, EmailEvent{Email: "jane@example.com", ConfirmedAt: now.Unix(), ChangedAt: now.Unix() + 60})
+				if !applied {
+					t.Error("sync event not applied")
+				}
+				return err
+			},
+		},
+		{
+			name: "deleted and created again",
+			change: func(db *sql.DB) error {
+				if err := DeleteEmailByEmail(ctx, db, "jane@example.com"); err != nil {
+					return err
+				}
+				return CreateEmail(ctx, db, "jane@example.com")
+			},
+		},
+		{
+			name: "purged and created again",
+			change: func(db *sql.DB) error {
+				if err := DeleteEmailByEmail(ctx, db, "jane@example.com"); err != nil {
+					return err
+				}
+				if _, err := PurgeTrash(ctx, db, now.Add(time.Hour)); err != nil {
+					return err
+				}
+				_, _, err := CreateEmails(ctx, db, []string{"jane@example.com"}, nil)
+				return err
+			},
+		},
+		{
+			name: "purged and synced",
+			change: func(db *sql.DB) error {
+				if _, err := ApplySyncEvent(ctx, db, EmailEvent{Email: "jane@example.com", Purged: true, ChangedAt: now.Unix() + 60}); err != nil {
+					return err
+				}
+				_, err := ApplySyncEvent(ctx, db, EmailEvent{Email: "jane@example.com", ChangedAt: now.Unix() + 120})
+				return err
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := complainedDB(t)
+			if err := test.change(db); err != nil {
+				t.Fatal(err)
+			}
+			checkComplained(t, db)
+		})
+	}
+}
+
+// The emails which complained before the complaints were kept apart are
+// remembered by the migration.
+func TestComplaintsMigrated(t *testing.T) {
+	ctx := context.Background()
+	db := complainedDB(t)
+	if err := Migrate(ctx, db, 12); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(ctx, db, SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteEmailByEmail(ctx, db, "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateEmail(ctx, db, "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	checkComplained(t, db)
+}
@@ -0,0 +1,289 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mailinglist/logging"
+	"strings"
+	"time"
+)
+
+// The states of the webhook deliveries. The delivered ones are removed.
+const (
+	DeliveryPending = "pending"
+	DeliveryDead    = "dead"
+)
+
+// ErrDeliveryNotFound is returned when requeueing a delivery that is not
+// dead.
+var ErrDeliveryNotFound = errors.New("dead webhook delivery not found")
+
+// ErrEndpointNotFound is returned when removing an endpoint which no server
+// added.
+var ErrEndpointNotFound = errors.New("webhook endpoint not found")
+
+// WebhookDelivery is an email event to send to a webhook endpoint, queued in
+// the same transaction as the change.
+type WebhookDelivery struct {
+	Id       int64
+	Endpoint string
+	Kind     string
+	Payload  string
+	Status   string
+	Attempts int
+	// LastError is why the last attempt failed.
+	LastError     string
+	CreatedAt     time.Time
+	NextAttemptAt time.Time
+}
+
+// DeliveryCount is the number of deliveries of an endpoint in a status.
+type DeliveryCount struct {
+	Endpoint string
+	Status   string
+	Count    int64
+}
+
+func tryCreateDeliveries(s *schemaTx) {
+	s.tryExec(`
+		CREATE TABLE webhook_endpoints (
+			name 	TEXT PRIMARY KEY
+		);
+	`)
+	s.tryExec(`
+		CREATE TABLE webhook_deliveries (
+			id 				INTEGER PRIMARY KEY AUTOINCREMENT,
+			endpoint 		TEXT,
+			kind 			TEXT,
+			payload 		TEXT,
+			status 			TEXT,
+			attempts 		INTEGER,
+			last_error 		TEXT,
+			created_at 		INTEGER,
+			next_attempt_at INTEGER
+		);
+	`)
+	s.tryExec(`CREATE INDEX webhook_deliveries_next ON webhook_deliveries (status, next_attempt_at);`)
+}
+
+// createWebhookTrigger queues the email events for the endpoints of all
+// the servers sharing the database. It runs in the transaction of the
+// change, like the event trigger.
+func createWebhookTrigger(ctx context.Context, tx *sql.Tx) error {
+	// The servers used to create it with their endpoints.
+	if _, err := tx.ExecContext(ctx, `DROP TRIGGER IF EXISTS email_events_webhooks;`); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		CREATE TRIGGER email_events_webhooks AFTER INSERT ON email_events
+		BEGIN
+			INSERT INTO webhook_deliveries (endpoint, kind, payload, status, attempts, last_error, created_at, next_attempt_at)
+			SELECT name, NEW.kind, `+eventPayload+`, 'pending', 0, '', strftime('%s', 'now'), strftime('%s', 'now')
+			FROM webhook_endpoints;
+		END;
+	`)
+	return err
+}
+
+func dropWebhookTrigger(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `DROP TRIGGER IF EXISTS email_events_webhooks;`)
+	return err
+}
+
+// AddWebhookEndpoints queues the email events for the endpoints, along with
+// those of the other servers sharing the database.
+func AddWebhookEndpoints(ctx context.Context, db *sql.DB, names []string) error {
+	for _, name := range names {
+		if _, err := db.ExecContext(ctx, `INSERT OR IGNORE INTO webhook_endpoints (name) VALUES (?)`, name); err != nil {
+			logging.FromContext(ctx).Error("adding webhook endpoint", "name", name, "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveWebhookEndpoint stops queueing the email events for the endpoint,
+// removing its deliveries. It fails with ErrEndpointNotFound.
+func RemoveWebhookEndpoint(ctx context.Context, db *sql.DB, name string) (err error) {
+	defer func() {
+		if err != nil && err != ErrEndpointNotFound {
+			logging.FromContext(ctx).Error("removing webhook endpoint", "name", name, "err", err)
+		}
+	}()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM webhook_endpoints WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		return ErrEndpointNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE endpoint = ?`, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func deliveryFromRow(row interface{ Scan(...any) error }) (*WebhookDelivery, error) {
+	var (
+		delivery                 WebhookDelivery
+		createdAt, nextAttemptAt int64
+	)
+	err := row.Scan(&delivery.Id, &delivery.Endpoint, &delivery.Kind, &delivery.Payload, &delivery.Status,
+		&delivery.Attempts, &delivery.LastError, &createdAt, &nextAttemptAt)
+	if err != nil {
+		return nil, err
+	}
+	delivery.CreatedAt = time.Unix(createdAt, 0)
+	delivery.NextAttemptAt = time.Unix(nextAttemptAt, 0)
+	return &delivery, nil
+}
+
+const deliveryColumns = `id, endpoint, kind, payload, status, attempts, last_error, created_at, next_attempt_at`
+
+// ClaimWebhookDelivery takes the next pending delivery due, of one of the
+// endpoints, hiding it from the other workers for the visibility timeout,
+// after which it is retried if not settled. It returns nil when none is
+// due, the deliveries of the endpoints of the other servers being left to
+// them.
+func ClaimWebhookDelivery(ctx context.Context, db *sql.DB, endpoints []string, visibility time.Duration) (*WebhookDelivery, error) {
+	if len(endpoints) == 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	args := []interface{}{now.Add(visibility).Unix(), DeliveryPending, now.Unix()}
+	for _, name := range endpoints {
+		args = append(args, name)
+	}
+
+	row := db.QueryRowContext(ctx, `
+		UPDATE webhook_deliveries SET next_attempt_at = ?
+		WHERE id = (
+			SELECT id FROM webhook_deliveries
+			WHERE status = ? AND next_attempt_at <= ?
+				AND endpoint IN (?`+strings.Repeat(`, ?`, len(endpoints)-1)+`)
+			ORDER BY next_attempt_at, id
+			LIMIT 1)
+		RETURNING `+deliveryColumns, args...)
+
+	delivery, err := deliveryFromRow(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("claiming webhook delivery", "err", err)
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// DeleteWebhookDelivery removes a delivery once sent.
+func DeleteWebhookDelivery(ctx context.Context, db *sql.DB, id int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE id = ?`, id)
+	if err != nil {
+		logging.FromContext(ctx).Error("deleting webhook delivery", "id", id, "err", err)
+	}
+	return err
+}
+
+// RetryWebhookDelivery schedules the delivery again at the given time, with
+// the number of attempts made so far and the error of the last one.
+func RetryWebhookDelivery(ctx context.Context, db *sql.DB, id int64, attempts int, at time.Time, lastError string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET attempts = ?, next_attempt_at = ?, last_error = ?
+		WHERE id = ?
+	`, attempts, at.Unix(), lastError, id)
+	if err != nil {
+		logging.FromContext(ctx).Error("retrying webhook delivery", "id", id, "err", err)
+	}
+	return err
+}
+
+// KillWebhookDelivery moves a delivery that failed for good to the dead
+// letters.
+func KillWebhookDelivery(ctx context.Context, db *sql.DB, id int64, attempts int, lastError string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status = ?, attempts = ?, last_error = ?
+		WHERE id = ?
+	`, DeliveryDead, attempts, lastError, id)
+	if err != nil {
+		logging.FromContext(ctx).Error("killing webhook delivery", "id", id, "err", err)
+	}
+	return err
+}
+
+// GetDeadWebhookDeliveries returns up to limit of the most recent dead
+// deliveries.
+func GetDeadWebhookDeliveries(ctx context.Context, db *sql.DB, limit int) ([]*WebhookDelivery, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+deliveryColumns+`
+		FROM webhook_deliveries WHERE status = ?
+		ORDER BY id DESC LIMIT ?
+	`, DeliveryDead, limit)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("getting dead webhook deliveries", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*WebhookDelivery, 0)
+	for rows.Next() {
+		delivery, err := deliveryFromRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// RequeueWebhookDelivery sends a dead delivery again now, with its attempts
+// reset.
+func RequeueWebhookDelivery(ctx context.Context, db *sql.DB, id int64) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ?
+		WHERE id = ? AND status = ?
+	`, DeliveryPending, time.Now().Unix(), id, DeliveryDead)
+	if err != nil {
+		logging.FromContext(ctx).Error("requeueing webhook delivery", "id", id, "err", err)
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
+
+// CountWebhookDeliveries returns the number of deliveries by endpoint and
+// status.
+func CountWebhookDeliveries(ctx context.Context, db *sql.DB) ([]*DeliveryCount, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT endpoint, status, COUNT(*)
+		FROM webhook_deliveries
+		GROUP BY endpoint, status
+		ORDER BY endpoint, status
+	`)
+	if err != nil {
+		logging.FromContext(ctx).Error("counting webhook deliveries", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*DeliveryCount, 0)
+	for rows.Next() {
+		count := &DeliveryCount{}
+		if err := rows.Scan(&count.Endpoint, &count.Status, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,21 @@
+package mdb
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// IsUniqueViolation reports whether err was caused by inserting an email
+// (or another unique value) that already exists.
+func IsUniqueViolation(err error) bool {
+	var sqlerr sqlite3.Error
+	return errors.As(err, &sqlerr) && sqlerr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// IsBusy reports whether err was caused by the database being locked by
+// another writer, in which case the operation can be retried.
+func IsBusy(err error) bool {
+	var sqlerr sqlite3.Error
+	return errors.As(err, &sqlerr) && (sqlerr.Code == sqlite3.ErrBusy || sqlerr.Code == sqlite3.ErrLocked)
+}
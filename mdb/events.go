@@ -0,0 +1,140 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+)
+
+// Kinds of email events.
+const (
+	EventCreated      = "created"
+	EventConfirmed    = "confirmed"
+	EventUnsubscribed = "unsubscribed"
+	EventBounced      = "bounced"
+	EventComplained   = "complained"
+	EventResubscribed = "resubscribed"
+	EventDeleted      = "deleted"
+	EventRestored     = "restored"
+	EventUpdated      = "updated"
+	EventPurged       = "purged"
+)
+
+// EmailEvent is a change to an email, recorded by triggers in the same
+// transaction as the change itself. Seq orders the events of this instance.
+type EmailEvent struct {
+	Seq         int64
+	Email       string
+	ConfirmedAt int64
+	OptOut      bool
+	DeletedAt   int64
+	Purged      bool
+	ChangedAt   int64
+	Kind        string
+}
+
+func tryCreateEvents(s *schemaTx) {
+	// changed_at on emails is only set when applying a change from a sync
+	// peer, so that the recorded event keeps the time of the original change.
+	s.tryExec(`ALTER TABLE emails ADD COLUMN changed_at INTEGER;`)
+	s.tryExec(`
+		CREATE TABLE email_events (
+			seq 			INTEGER PRIMARY KEY AUTOINCREMENT,
+			email 			TEXT,
+			confirmed_at 	INTEGER,
+			opt_out 		INTEGER,
+			deleted_at 		INTEGER,
+			purged 			INTEGER,
+			changed_at 		INTEGER
+		);
+	`)
+	s.tryExec(`ALTER TABLE email_events ADD COLUMN kind TEXT;`)
+	s.tryExec(`CREATE INDEX email_events_email ON email_events (email, changed_at);`)
+
+	// The triggers are recreated on every start so that databases created
+	// by older versions record events the same way.
+	s.tryExec(`DROP TRIGGER IF EXISTS emails_insert_event;`)
+	s.tryExec(`DROP TRIGGER IF EXISTS emails_update_event;`)
+	s.tryExec(`DROP TRIGGER IF EXISTS emails_delete_event;`)
+	s.tryExec(`
+		CREATE TRIGGER emails_insert_event AFTER INSERT ON emails
+		BEGIN
+			INSERT INTO email_events (email, confirmed_at, opt_out, deleted_at, purged, changed_at, kind)
+			VALUES (NEW.email, NEW.confirmed_at, NEW.opt_out, NEW.deleted_at, false,
+				COALESCE(NEW.changed_at, strftime('%s', 'now')), 'created');
+		END;
+	`)
+	s.tryExec(`
+		CREATE TRIGGER emails_update_event AFTER UPDATE ON emails
+		WHEN OLD.email IS NOT NEW.email
+			OR OLD.confirmed_at IS NOT NEW.confirmed_at
+			OR OLD.opt_out IS NOT NEW.opt_out
+			OR OLD.deleted_at IS NOT NEW.deleted_at
+			OR (NEW.opt_out_reason IS 'complaint' AND OLD.opt_out_reason IS NOT 'complaint')
+		BEGIN
+			INSERT INTO email_events (email, confirmed_at, opt_out, deleted_at, purged, changed_at, kind)
+			SELECT OLD.email, OLD.confirmed_at, OLD.opt_out, OLD.deleted_at, true, strftime('%s', 'now'), 'purged'
+			WHERE OLD.email IS NOT NEW.email;
+
+			INSERT INTO email_events (email, confirmed_at, opt_out, deleted_at, purged, changed_at, kind)
+			VALUES (NEW.email, NEW.confirmed_at, NEW.opt_out, NEW.deleted_at, false,
+				CASE WHEN NEW.changed_at IS NOT OLD.changed_at THEN NEW.changed_at ELSE strftime('%s', 'now') END,
+				CASE
+					WHEN OLD.email IS NOT NEW.email THEN 'created'
+					WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN 'deleted'
+					WHEN OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN 'restored'
+					WHEN NEW.opt_out AND NEW.opt_out_reason IS 'complaint' AND OLD.opt_out_reason IS NOT 'complaint' THEN 'complained'
+					WHEN NOT OLD.opt_out AND NEW.opt_out THEN
+						CASE NEW.opt_out_reason
+							WHEN 'bounce' THEN 'bounced'
+							WHEN 'complaint' THEN 'complained'
+							ELSE 'unsubscribed'
+						END
+					WHEN OLD.opt_out AND NOT NEW.opt_out THEN 'resubscribed'
+					WHEN NEW.confirmed_at > 0 AND OLD.confirmed_at IS NOT NEW.confirmed_at THEN 'confirmed'
+					ELSE 'updated'
+				END);
+		END;
+	`)
+	s.tryExec(`
+		CREATE TRIGGER emails_delete_event AFTER DELETE ON emails
+		BEGIN
+			INSERT INTO email_events (email, confirmed_at, opt_out, deleted_at, purged, changed_at, kind)
+			VALUES (OLD.email, OLD.confirmed_at, OLD.opt_out, OLD.deleted_at, true, strftime('%s', 'now'), 'purged');
+		END;
+	`)
+}
+
+// GetEventsSince returns up to count events recorded after the given seq.
+func GetEventsSince(ctx context.Context, db *sql.DB, seq int64, count int) ([]*EmailEvent, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT seq, email, confirmed_at, opt_out, COALESCE(deleted_at, 0), purged, changed_at, COALESCE(kind, '')
+		FROM email_events WHERE seq > ?
+		ORDER BY seq ASC
+		LIMIT ?
+	`, seq, count)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("getting events", "since", seq, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*EmailEvent, 0, count)
+	for rows.Next() {
+		event := &EmailEvent{}
+		err := rows.Scan(&event.Seq, &event.Email, &event.ConfirmedAt, &event.OptOut, &event.DeletedAt, &event.Purged, &event.ChangedAt, &event.Kind)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// LastEventSeq returns the seq of the most recent event, or 0 if there are none.
+func LastEventSeq(ctx context.Context, db *sql.DB) (int64, error) {
+	var seq int64
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(seq), 0) FROM email_events`).Scan(&seq)
+	return seq, err
+}
@@ -0,0 +1,96 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+)
+
+// FirstNameField is the field of the first name of a subscriber, merged
+// into the templates as {{.FirstName}}.
+const FirstNameField = "first_name"
+
+func createEmailFields(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE email_fields (
+			email_id 	INTEGER,
+			name 		TEXT,
+			value 		TEXT,
+			PRIMARY KEY (email_id, name)
+		);
+		CREATE TRIGGER emails_delete_fields AFTER DELETE ON emails
+		BEGIN
+			DELETE FROM email_fields WHERE email_id = OLD.id;
+		END;
+		ALTER TABLE campaign_recipients ADD COLUMN message_hash TEXT;
+		ALTER TABLE send_queue ADD COLUMN message_hash TEXT;
+	`)
+	return err
+}
+
+func dropEmailFields(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		ALTER TABLE campaign_recipients DROP COLUMN message_hash;
+		ALTER TABLE send_queue DROP COLUMN message_hash;
+		DROP TRIGGER emails_delete_fields;
+		DROP TABLE email_fields;
+	`)
+	return err
+}
+
+// SetEmailFields sets the fields of the email, e.g. its first name, an
+// empty value removing the field, and returns all its fields.
+func SetEmailFields(ctx context.Context, db *sql.DB, email string, fields map[string]string) (map[string]string, error) {
+	eid, err := emailId(ctx, db, email)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for name, value := range fields {
+		if value == "" {
+			_, err = tx.ExecContext(ctx, `DELETE FROM email_fields WHERE email_id = ? AND name = ?`, eid, name)
+		} else {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO email_fields (email_id, name, value) VALUES (?, ?, ?)
+				ON CONFLICT DO UPDATE SET value = excluded.value
+			`, eid, name, value)
+		}
+		if err != nil {
+			logging.FromContext(ctx).Error("setting email field", "email", email, "field", name, "err", err)
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetEmailFields(ctx, db, email)
+}
+
+// GetEmailFields returns the fields of the email.
+func GetEmailFields(ctx context.Context, db *sql.DB, email string) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT f.name, f.value FROM email_fields f JOIN emails e ON e.id = f.email_id
+		WHERE e.email = ? AND e.deleted_at IS NULL
+	`, email)
+	if err != nil {
+		logging.FromContext(ctx).Error("getting email fields", "email", email, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	fields := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		fields[name] = value
+	}
+	return fields, rows.Err()
+}
@@ -0,0 +1,52 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+	"time"
+)
+
+func tryCreateLeases(s *schemaTx) {
+	s.tryExec(`
+		CREATE TABLE leases (
+			name 		TEXT PRIMARY KEY,
+			holder 		TEXT,
+			expires_at 	INTEGER
+		);
+	`)
+}
+
+// AcquireLease takes or renews the lease of the name for the holder until
+// ttl from now, and reports whether the holder has it. The lease can only
+// be taken from another holder once it has expired, so that the servers
+// sharing the database agree on a single holder.
+func AcquireLease(ctx context.Context, db *sql.DB, name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO leases (name, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE leases.holder = excluded.holder OR leases.expires_at < ?
+	`, name, holder, now.Add(ttl).UnixMilli(), now.UnixMilli())
+
+	if err != nil {
+		logging.FromContext(ctx).Error("acquiring lease", "lease", name, "err", err)
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
+
+// ReleaseLease gives up the lease of the name if the holder has it, so
+// that another holder can take it without waiting for it to expire.
+func ReleaseLease(ctx context.Context, db *sql.DB, name, holder string) error {
+	_, err := db.ExecContext(ctx, `
+		DELETE FROM leases WHERE name = ? AND holder = ?
+	`, name, holder)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("releasing lease", "lease", name, "err", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mailinglist/logging"
+	"time"
+)
+
+var (
+	ErrListNotFound  = errors.New("list not found")
+	ErrEmailNotFound = errors.New("email not found")
+)
+
+// MailingList groups emails, which may belong to any number of lists.
+type MailingList struct {
+	Id          int64
+	Name        string
+	CreatedAt   time.Time
+	MemberCount int64
+}
+
+func tryCreateLists(s *schemaTx) {
+	s.tryExec(`
+		CREATE TABLE mailing_lists (
+			id 			INTEGER PRIMARY KEY,
+			name 		TEXT UNIQUE,
+			created_at 	INTEGER
+		);
+	`)
+	s.tryExec(`
+		CREATE TABLE list_members (
+			list_id 	INTEGER,
+			email_id 	INTEGER,
+			PRIMARY KEY (list_id, email_id)
+		);
+	`)
+	s.tryExec(`
+		CREATE TRIGGER emails_delete_members AFTER DELETE ON emails
+		BEGIN
+			DELETE FROM list_members WHERE email_id = OLD.id;
+		END;
+	`)
+}
+
+func CreateList(ctx context.Context, db *sql.DB, name string) (*MailingList, error) {
+	createdAt := time.Now()
+
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO mailing_lists (name, created_at) VALUES (?, ?)
+	`, name, createdAt.Unix())
+
+	if err != nil {
+		logging.FromContext(ctx).Error("creating list", "list", name, "err", err)
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &MailingList{Id: id, Name: name, CreatedAt: time.Unix(createdAt.Unix(), 0)}, nil
+}
+
+// GetLists returns every list with its number of subscribed members.
+func GetLists(ctx context.Context, db *sql.DB) ([]*MailingList, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT l.id, l.name, l.created_at, COUNT(e.id)
+		FROM mailing_lists l
+		LEFT JOIN list_members m ON m.list_id = l.id
+		LEFT JOIN emails e ON e.id = m.email_id AND e.opt_out = false AND e.deleted_at IS NULL
+		GROUP BY l.id
+		ORDER BY l.name ASC
+	`)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("getting lists", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := make([]*MailingList, 0)
+	for rows.Next() {
+		var (
+			list      MailingList
+			createdAt int64
+		)
+		if err := rows.Scan(&list.Id, &list.Name, &createdAt, &list.MemberCount); err != nil {
+			return nil, err
+		}
+		list.CreatedAt = time.Unix(createdAt, 0)
+		lists = append(lists, &list)
+	}
+	return lists, rows.Err()
+}
+
+func listId(ctx context.Context, db *sql.DB, name string) (int64, error) {
+	var id int64
+	err := db.QueryRowContext(ctx, `SELECT id FROM mailing_lists WHERE name = ?`, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrListNotFound
+	}
+	return id, err
+}
+
+func emailId(ctx context.Context, db *sql.DB, email string) (int64, error) {
+	var id int64
+	err := db.QueryRowContext(ctx, `
+		SELECT id FROM emails WHERE email = ? AND deleted_at IS NULL
+	`, email).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrEmailNotFound
+	}
+	return id, err
+}
+
+// AddToList adds the email to the list. Adding a member twice is a no-op.
+func AddToList(ctx context.Context, db *sql.DB, list, email string) error {
+	lid, err := listId(ctx, db, list)
+	if err != nil {
+		return err
+	}
+	eid, err := emailId(ctx, db, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO list_members (list_id, email_id) VALUES (?, ?)
+		ON CONFLICT DO NOTHING
+	`, lid, eid)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("adding to list", "email", email, "list", list, "err", err)
+		return err
+	}
+	return nil
+}
+
+func RemoveFromList(ctx context.Context, db *sql.DB, list, email string) error {
+	lid, err := listId(ctx, db, list)
+	if err != nil {
+		return err
+	}
+	eid, err := emailId(ctx, db, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `
+		DELETE FROM list_members WHERE list_id = ? AND email_id = ?
+	`, lid, eid)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("removing from list", "email", email, "list", list, "err", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+)
+
+// vacuumFreeRatio is the share of free pages from which the file is
+// rebuilt, VACUUM writing the whole database.
+const vacuumFreeRatio = 0.1
+
+// MaintenanceReport tells what the maintenance did, the sizes in bytes.
+type MaintenanceReport struct {
+	SizeBefore int64
+	SizeAfter  int64
+	// FreeBefore is the space left by the deleted rows, reused by SQLite
+	// but only given back to the file system by VACUUM.
+	FreeBefore int64
+	Vacuumed   bool
+}
+
+// Reclaimed is the space given back to the file system.
+func (r *MaintenanceReport) Reclaimed() int64 {
+	return r.SizeBefore - r.SizeAfter
+}
+
+// sizes returns the size of the database and of its free pages.
+func sizes(ctx context.Context, db *sql.DB) (size, free int64, err error) {
+	err = db.QueryRowContext(ctx, `
+		SELECT page_count * page_size, freelist_count * page_size
+		FROM pragma_page_count(), pragma_freelist_count(), pragma_page_size()
+	`).Scan(&size, &free)
+	return size, free, err
+}
+
+// Maintain refreshes the statistics of the query planner, and rebuilds the
+// database when the deleted rows left enough free pages, to shrink the
+// file after the purges. The rebuild blocks the writes while it runs.
+func Maintain(ctx context.Context, db *sql.DB) (report *MaintenanceReport, err error) {
+	ctx, span := startSpan(ctx, "Maintain")
+	defer endSpan(span, &err)
+	defer func() {
+		if err != nil {
+			logging.FromContext(ctx).Error("maintaining the database", "err", err)
+		}
+	}()
+
+	report = &MaintenanceReport{}
+	if report.SizeBefore, report.FreeBefore, err = sizes(ctx, db); err != nil {
+		return nil, err
+	}
+
+	if _, err = db.ExecContext(ctx, `ANALYZE`); err != nil {
+		return nil, err
+	}
+	if report.SizeBefore > 0 && float64(report.FreeBefore) >= vacuumFreeRatio*float64(report.SizeBefore) {
+		if _, err = db.ExecContext(ctx, `VACUUM`); err != nil {
+			return nil, err
+		}
+		report.Vacuumed = true
+	}
+
+	if report.SizeAfter, _, err = sizes(ctx, db); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
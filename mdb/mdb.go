@@ -1,22 +1,67 @@
 package mdb
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"fmt"
+	"mailinglist/logging"
+	"strings"
 	"time"
 
 	"github.com/mattn/go-sqlite3"
 )
 
 type EmailEntry struct {
-	Id          int64
-	Email       string
-	ConfirmedAt *time.Time
-	OptOut      bool
+	Id           int64
+	Email        string
+	ConfirmedAt  *time.Time
+	OptOut       bool
+	OptOutReason string
+	CreatedAt    *time.Time
 }
 
-func TryCreate(db *sql.DB) {
-	_, err := db.Exec(`
+// emailColumns are the columns scanned by emailEntryFromRow.
+const emailColumns = `id, email, confirmed_at, opt_out, COALESCE(opt_out_reason, ''), created_at`
+
+// SchemaVersion is the version of the schema the last of the Migrations
+// brings the database to, kept in its user_version. It goes up with each
+// change of the tables, so that an older server refuses a database it does
+// not know.
+const SchemaVersion = 14
+
+// GetSchemaVersion returns the version of the schema of the database, 0
+// for the databases created before the schema had a version.
+func GetSchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version)
+	if err != nil {
+		logging.FromContext(ctx).Error("getting the schema version", "err", err)
+	}
+	return version, err
+}
+
+// CheckWritable takes the write lock of the database and releases it at
+// once, failing when the database cannot be written.
+func CheckWritable(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, `ROLLBACK`)
+	return err
+}
+
+// createBaseline creates the tables of the first version of the schema
+// which are missing, those of the databases from before the migrations
+// having been added one by one.
+func createBaseline(ctx context.Context, tx *sql.Tx) error {
+	s := &schemaTx{ctx: ctx, tx: tx}
+	s.tryExec(`
 		CREATE TABLE emails (
 			id 				INTEGER PRIMARY KEY,
 			email   		TEXT UNIQUE,
@@ -24,60 +69,199 @@ func TryCreate(db *sql.DB) {
 			opt_out			INTEGER
 		);
 	`)
+	tryCreateApiKeys(s)
+	s.tryExec(`ALTER TABLE emails ADD COLUMN deleted_at INTEGER;`)
+	s.tryExec(`ALTER TABLE emails ADD COLUMN opt_out_reason TEXT;`)
+	s.tryExec(`ALTER TABLE emails ADD COLUMN created_at INTEGER;`)
+	tryCreateEvents(s)
+	tryCreateSync(s)
+	tryCreateTags(s)
+	tryCreateLists(s)
+	tryCreateTokens(s)
+	tryCreateAudit(s)
+	tryCreateStatsDaily(s)
+	tryCreateLeases(s)
+	tryCreateOutbox(s)
+	tryCreateDeliveries(s)
+	return s.err
+}
 
-	if err != nil {
-		if sqlerr, ok := err.(sqlite3.Error); ok {
-			// Code 1 means that table already exists
-			if sqlerr.Code != 1 {
-				log.Fatalf("cannot create db: %v", sqlerr)
-			}
-		} else {
-			log.Fatalf("unexpected error creating DB: %v", err)
-		}
+// schemaTx runs the statements of the baseline in the transaction of its
+// migration, keeping the first error other than a table, index or column
+// being there already, as in the databases from before the migrations.
+type schemaTx struct {
+	ctx context.Context
+	tx  *sql.Tx
+	err error
+}
+
+func (s *schemaTx) tryExec(query string) {
+	if s.err != nil {
+		return
+	}
+	_, err := s.tx.ExecContext(s.ctx, query)
+	if err != nil && !alreadyExists(err) {
+		s.err = fmt.Errorf("%w, running %v", err, strings.TrimSpace(query))
+	}
+}
+
+// alreadyExists reports whether the statement failed on a table, index or
+// column created before.
+func alreadyExists(err error) bool {
+	sqlerr, ok := err.(sqlite3.Error)
+	if !ok || sqlerr.Code != sqlite3.ErrError {
+		return false
 	}
+	message := sqlerr.Error()
+	return strings.Contains(message, "already exists") || strings.Contains(message, "duplicate column name")
 }
 
 func emailEntryFromRow(row *sql.Rows) (*EmailEntry, error) {
 	var (
-		id          int64
-		email       string
-		confirmedAt int64
-		optOut      bool
+		id           int64
+		email        string
+		confirmedAt  int64
+		optOut       bool
+		optOutReason string
+		createdAt    sql.NullInt64
 	)
-	err := row.Scan(&id, &email, &confirmedAt, &optOut)
+	err := row.Scan(&id, &email, &confirmedAt, &optOut, &optOutReason, &createdAt)
 	if err != nil {
 		return nil, err
 	}
 
 	t := time.Unix(confirmedAt, 0)
-	return &EmailEntry{
-		Id:          id,
-		Email:       email,
-		ConfirmedAt: &t,
-		OptOut:      optOut,
-	}, nil
+	entry := &EmailEntry{
+		Id:           id,
+		Email:        email,
+		ConfirmedAt:  &t,
+		OptOut:       optOut,
+		OptOutReason: optOutReason,
+	}
+	if createdAt.Valid {
+		c := time.Unix(createdAt.Int64, 0)
+		entry.CreatedAt = &c
+	}
+	return entry, nil
 }
 
-func CreateEmail(db *sql.DB, email string) error {
-	_, err := db.Exec(`
-		INSERT INTO emails (email, confirmed_at, opt_out)
-		VALUES (?, 0, false)
-	`, email)
+// CreateEmail creates the email, replacing it when it is in the trash.
+func CreateEmail(ctx context.Context, db *sql.DB, email string) (err error) {
+	ctx, span := startSpan(ctx, "CreateEmail")
+	defer endSpan(span, &err)
 
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("Error creating email for %v\n", email)
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	if err = purgeTrashed(ctx, tx, email); err == nil {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO emails (email, confirmed_at, opt_out, created_at)
+			VALUES (?, 0, false, strftime('%s', 'now'))
+		`, email)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("creating email", "email", email, "err", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateEmails creates the emails in a single transaction, skipping the ones
+// that already exist and replacing the ones in the trash, and returns how
+// many were created. With an API key, the emails are owned by the key, and
+// the ones beyond its subscriber quota are not created but returned.
+func CreateEmails(ctx context.Context, db *sql.DB, emails []string, apiKey *ApiKey) (created int64, overQuota []string, err error) {
+	ctx, span := startSpan(ctx, "CreateEmails")
+	defer endSpan(span, &err)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		apiKeyId sql.NullInt64
+		// remaining is how many emails the key may still own, -1 for any.
+		remaining int64 = -1
+	)
+	if apiKey != nil {
+		apiKeyId = sql.NullInt64{Int64: apiKey.Id, Valid: true}
+		if apiKey.MaxSubscribers > 0 {
+			var owned int64
+			err := tx.QueryRowContext(ctx, `
+				SELECT COUNT(*) FROM emails WHERE api_key_id = ? AND opt_out = false AND deleted_at IS NULL
+			`, apiKey.Id).Scan(&owned)
+			if err != nil {
+				logging.FromContext(ctx).Error("counting the emails of API key", "api_key", apiKey.Name, "err", err)
+				return 0, nil, err
+			}
+			remaining = max(apiKey.MaxSubscribers-owned, 0)
+		}
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO emails (email, confirmed_at, opt_out, api_key_id, created_at)
+		VALUES (?, 0, false, ?, strftime('%s', 'now'))
+		ON CONFLICT(email) DO NOTHING
+	`)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer stmt.Close()
+
+	for _, email := range emails {
+		if err := purgeTrashed(ctx, tx, email); err != nil {
+			logging.FromContext(ctx).Error("creating email", "email", email, "err", err)
+			return 0, nil, err
+		}
+		if remaining == 0 {
+			var exists bool
+			err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM emails WHERE email = ?)`, email).Scan(&exists)
+			if err != nil {
+				logging.FromContext(ctx).Error("creating email", "email", email, "err", err)
+				return 0, nil, err
+			}
+			if !exists {
+				overQuota = append(overQuota, email)
+			}
+			continue
+		}
+		res, err := stmt.ExecContext(ctx, email, apiKeyId)
+		if err != nil {
+			logging.FromContext(ctx).Error("creating email", "email", email, "err", err)
+			return 0, nil, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, nil, err
+		}
+		created += affected
+		if remaining > 0 {
+			remaining -= affected
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.FromContext(ctx).Error("committing emails", "count", len(emails), "err", err)
+		return 0, nil, err
+	}
+	return created, overQuota, nil
 }
 
-func GetEmail(db *sql.DB, email string) (*EmailEntry, error) {
-	rows, err := db.Query(`
-		SELECT id, email, confirmed_at, opt_out 
-		FROM emails where email = ?`, email)
+func GetEmail(ctx context.Context, db *sql.DB, email string) (entry *EmailEntry, err error) {
+	ctx, span := startSpan(ctx, "GetEmail")
+	defer endSpan(span, &err)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+emailColumns+`
+		FROM emails where email = ? AND deleted_at IS NULL`, email)
 
 	if err != nil {
-		log.Printf("Error getting emailEntry for %v: %v\n", email, err)
+		logging.FromContext(ctx).Error("getting email", "email", email, "err", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -88,90 +272,238 @@ func GetEmail(db *sql.DB, email string) (*EmailEntry, error) {
 	return nil, nil
 }
 
-func UpdateEmail(db *sql.DB, emailEntry EmailEntry, id int64) error {
+// UpdateEmail updates the email of the id. The emails which complained
+// stay opted out. It fails with ErrEmailNotFound when the email of the id
+// does not exist or is in the trash.
+func UpdateEmail(ctx context.Context, db *sql.DB, emailEntry EmailEntry, id int64) (err error) {
+	ctx, span := startSpan(ctx, "UpdateEmail")
+	defer endSpan(span, &err)
+
 	t := emailEntry.ConfirmedAt.Unix()
 
-	_, err := db.Exec(`
+	res, err := db.ExecContext(ctx, `
 		UPDATE emails
 			SET email = ?,
 				confirmed_at = ?,
-				opt_out = ?
-		WHERE ID = ?
+				opt_out = ? OR `+complained+`
+		WHERE ID = ? AND deleted_at IS NULL
 	`, emailEntry.Email, t, emailEntry.OptOut, id)
 
 	if err != nil {
-		log.Printf("Error upserting email for entry %v: %v\n", emailEntry, err)
+		logging.FromContext(ctx).Error("upserting email", "entry", emailEntry, "err", err)
 		return err
 	}
 
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrEmailNotFound
+	}
 	return nil
 }
 
-func UpsertEmail(db *sql.DB, emailEntry EmailEntry) error {
+// UpsertEmail creates the email, or updates it when it exists, replacing
+// it when it is in the trash. The emails which complained stay opted out.
+func UpsertEmail(ctx context.Context, db *sql.DB, emailEntry EmailEntry) (err error) {
+	ctx, span := startSpan(ctx, "UpsertEmail")
+	defer endSpan(span, &err)
+
 	t := emailEntry.ConfirmedAt.Unix()
 
-	_, err := db.Exec(`
-		INSERT INTO emails(email, confirmed_at, opt_out)
-		VALUES(?, ?, ?)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := purgeTrashed(ctx, tx, emailEntry.Email); err != nil {
+		logging.FromContext(ctx).Error("upserting email", "entry", emailEntry, "err", err)
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO emails(email, confirmed_at, opt_out, created_at)
+		VALUES(?, ?, ?, strftime('%s', 'now'))
 		ON CONFLICT(email) 
 		DO UPDATE 
 			SET confirmed_at = ?,
-				opt_out = ?
+				opt_out = ? OR `+complained+`
 	`, emailEntry.Email, t, emailEntry.OptOut, t, emailEntry.OptOut)
 
 	if err != nil {
-		log.Printf("Error upserting email for entry %v: %v\n", emailEntry, err)
+		logging.FromContext(ctx).Error("upserting email", "entry", emailEntry, "err", err)
 		return err
 	}
 
+	return tx.Commit()
+}
+
+// DeleteEmail moves the email to the trash, from where it can be restored
+// until the trash retention window has passed.
+func DeleteEmail(ctx context.Context, db *sql.DB, id int64) (err error) {
+	ctx, span := startSpan(ctx, "DeleteEmail")
+	defer endSpan(span, &err)
+
+	_, err = db.ExecContext(ctx, `
+		UPDATE emails SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL
+	`, time.Now().Unix(), id)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("deleting email", "id", id, "err", err)
+		return err
+	}
 	return nil
 }
 
-func DeleteEmail(db *sql.DB, id int64) error {
-	_, err := db.Exec(`
-		UPDATE emails SET opt_out=true WHERE id = ?
-	`, id)
+func DeleteEmailByEmail(ctx context.Context, db *sql.DB, email string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteEmailByEmail")
+	defer endSpan(span, &err)
+
+	_, err = db.ExecContext(ctx, `
+		UPDATE emails SET deleted_at = ? WHERE email = ? AND deleted_at IS NULL
+	`, time.Now().Unix(), email)
 
 	if err != nil {
-		log.Printf("Error deleting email with ID %v: %v\n", id, err)
+		logging.FromContext(ctx).Error("deleting email", "email", email, "err", err)
 		return err
 	}
 	return nil
 }
 
-func DeleteEmailByEmail(db *sql.DB, email string) error {
-	_, err := db.Exec(`
-		UPDATE emails SET opt_out=true WHERE email = ?
-	`, email)
+// OptOutEmail opts the email out of the list, recording why, e.g. after a
+// bounce or a complaint reported by the email provider.
+func OptOutEmail(ctx context.Context, db *sql.DB, email string, reason string) (err error) {
+	ctx, span := startSpan(ctx, "OptOutEmail")
+	defer endSpan(span, &err)
+
+	_, err = db.ExecContext(ctx, `
+		UPDATE emails SET opt_out=true, opt_out_reason = ? WHERE email = ?
+	`, reason, email)
 
 	if err != nil {
-		log.Printf("Error deleting email with email %v: %v\n", email, err)
+		logging.FromContext(ctx).Error("opting out email", "email", email, "err", err)
 		return err
 	}
 	return nil
 }
 
+// Sort orders of an email batch. A leading "-" sorts descending.
+const (
+	SortId            = "id"
+	SortIdDesc        = "-id"
+	SortEmail         = "email"
+	SortEmailDesc     = "-email"
+	SortCreatedAt     = "created_at"
+	SortCreatedAtDesc = "-created_at"
+)
+
+var sortClauses = map[string]string{
+	"":                "id ASC",
+	SortId:            "id ASC",
+	SortIdDesc:        "id DESC",
+	SortEmail:         "email ASC, id ASC",
+	SortEmailDesc:     "email DESC, id ASC",
+	SortCreatedAt:     "created_at ASC, id ASC",
+	SortCreatedAtDesc: "created_at DESC, id ASC",
+}
+
+// ValidSort reports whether sort is one of the supported sort orders.
+func ValidSort(sort string) bool {
+	_, ok := sortClauses[sort]
+	return ok
+}
+
 type GetBatchEmailQueryParams struct {
 	Page, Count int
+	// AfterId, when set, returns the emails following that id instead of
+	// the page, so concurrent inserts do not shift the results. It is only
+	// honored when sorting by id.
+	AfterId int64
+
+	ConfirmedOnly bool
+	IncludeOptOut bool
+	// IncludeComplained also returns, with IncludeOptOut, the emails which
+	// complained, left out otherwise.
+	IncludeComplained bool
+	CreatedAfter      time.Time
+	Tag               string
+	Query             string
+	Sort              string
+	// Org, when set, only selects the emails created with the keys of
+	// that organization.
+	Org string
 }
 
-func GetEmailBatch(db *sql.DB, params GetBatchEmailQueryParams) ([]*EmailEntry, error) {
+// likeEscaper escapes the wildcards of a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// filter returns the WHERE clause selecting the emails matching the params.
+func (params GetBatchEmailQueryParams) filter() (string, []interface{}) {
+	where := "deleted_at IS NULL"
+	var args []interface{}
+
+	if !params.IncludeOptOut {
+		where += " AND opt_out=false"
+	} else if !params.IncludeComplained {
+		where += " AND NOT " + complained
+	}
+	if params.ConfirmedOnly {
+		where += " AND confirmed_at > 0"
+	}
+	if !params.CreatedAfter.IsZero() {
+		where += " AND created_at > ?"
+		args = append(args, params.CreatedAfter.Unix())
+	}
+	if params.Tag != "" {
+		where += " AND id IN (SELECT email_id FROM email_tags WHERE tag = ?)"
+		args = append(args, params.Tag)
+	}
+	if params.Query != "" {
+		where += ` AND email LIKE ? ESCAPE '\'`
+		args = append(args, "%"+likeEscaper.Replace(params.Query)+"%")
+	}
+	if params.Org != "" {
+		where += " AND api_key_id IN (SELECT id FROM api_keys WHERE org = ?)"
+		args = append(args, params.Org)
+	}
+	return where, args
+}
+
+func GetEmailBatch(ctx context.Context, db *sql.DB, params GetBatchEmailQueryParams) (emails []*EmailEntry, err error) {
+	ctx, span := startSpan(ctx, "GetEmailBatch")
+	defer endSpan(span, &err)
+
 	var empty []*EmailEntry
 
-	rows, err := db.Query(`
-		SELECT id, email, confirmed_at, opt_out FROM emails 
-		WHERE opt_out=false ORDER BY id ASC
+	orderBy, ok := sortClauses[params.Sort]
+	if !ok {
+		return empty, fmt.Errorf("unknown sort %q", params.Sort)
+	}
+
+	where, args := params.filter()
+	offset := (params.Page - 1) * params.Count
+	if params.AfterId > 0 && (params.Sort == "" || params.Sort == SortId) {
+		where += " AND id > ?"
+		args = append(args, params.AfterId)
+		offset = 0
+	}
+	args = append(args, params.Count, offset)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+emailColumns+` FROM emails
+		WHERE `+where+` ORDER BY `+orderBy+`
 		LIMIT ? OFFSET ?
-	`, params.Count, (params.Page-1)*params.Count)
+	`, args...)
 
 	if err != nil {
-		log.Printf("Error getting batch emails: %v\n", err)
+		logging.FromContext(ctx).Error("getting batch emails", "err", err)
 		return empty, err
 	}
 
 	defer rows.Close()
 
-	emails := make([]*EmailEntry, 0, params.Count)
+	emails = make([]*EmailEntry, 0, params.Count)
 
 	for rows.Next() {
 		email, err := emailEntryFromRow(rows)
@@ -183,3 +515,129 @@ func GetEmailBatch(db *sql.DB, params GetBatchEmailQueryParams) ([]*EmailEntry,
 
 	return emails, nil
 }
+
+// CountEmails returns the number of emails matching the filters of params.
+func CountEmails(ctx context.Context, db *sql.DB, params GetBatchEmailQueryParams) (count int64, err error) {
+	ctx, span := startSpan(ctx, "CountEmails")
+	defer endSpan(span, &err)
+
+	where, args := params.filter()
+
+	err = db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM emails WHERE `+where, args...).Scan(&count)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("counting emails", "err", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// StreamEmails calls fn for every subscribed email in id order. The table is
+// read in batches of batchSize using the id as a cursor, so no read lock is
+// held while fn runs.
+func StreamEmails(ctx context.Context, db *sql.DB, batchSize int, fn func(*EmailEntry) error) error {
+	var lastId int64
+
+	for {
+		rows, err := db.QueryContext(ctx, `
+			SELECT `+emailColumns+` FROM emails
+			WHERE opt_out=false AND deleted_at IS NULL AND id > ?
+			ORDER BY id ASC
+			LIMIT ?
+		`, lastId, batchSize)
+
+		if err != nil {
+			logging.FromContext(ctx).Error("streaming emails", "err", err)
+			return err
+		}
+
+		emails := make([]*EmailEntry, 0, batchSize)
+		for rows.Next() {
+			email, err := emailEntryFromRow(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			emails = append(emails, email)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, email := range emails {
+			if err := fn(email); err != nil {
+				return err
+			}
+			lastId = email.Id
+		}
+
+		if len(emails) < batchSize {
+			return nil
+		}
+	}
+}
+
+// BulkUnsubscribe opts out the given emails, or every email of the domain
+// when no emails are given, in a single transaction. With del they are moved
+// to the trash instead. It returns how many emails were affected.
+func BulkUnsubscribe(ctx context.Context, db *sql.DB, emails []string, domain string, del bool) (affected int64, err error) {
+	ctx, span := startSpan(ctx, "BulkUnsubscribe")
+	defer endSpan(span, &err)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	set, setArg := "opt_out = true, opt_out_reason = ?", interface{}(UnsubscribeReason)
+	where := "deleted_at IS NULL AND opt_out = false"
+	if del {
+		set, setArg = "deleted_at = ?", time.Now().Unix()
+		where = "deleted_at IS NULL"
+	}
+
+	if len(emails) == 0 {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE emails SET `+set+`
+			WHERE `+where+` AND lower(substr(email, instr(email, '@') + 1)) = lower(?)
+		`, setArg, domain)
+		if err != nil {
+			logging.FromContext(ctx).Error("unsubscribing domain", "domain", domain, "err", err)
+			return 0, err
+		}
+		if affected, err = res.RowsAffected(); err != nil {
+			return 0, err
+		}
+	} else {
+		stmt, err := tx.PrepareContext(ctx, `
+			UPDATE emails SET `+set+` WHERE `+where+` AND email = ?
+		`)
+		if err != nil {
+			return 0, err
+		}
+		defer stmt.Close()
+
+		for _, email := range emails {
+			res, err := stmt.ExecContext(ctx, setArg, email)
+			if err != nil {
+				logging.FromContext(ctx).Error("unsubscribing email", "email", email, "err", err)
+				return 0, err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return 0, err
+			}
+			affected += n
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logging.FromContext(ctx).Error("committing bulk unsubscribe", "err", err)
+		return 0, err
+	}
+	return affected, nil
+}
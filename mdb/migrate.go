@@ -0,0 +1,95 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"mailinglist/logging"
+)
+
+// Migration changes the schema from the version before it to its own.
+type Migration struct {
+	Version int
+	Name    string
+	// Up applies the changes and Down reverts them, nil when they cannot
+	// be, the database having to be restored from a backup instead.
+	Up, Down func(ctx context.Context, tx *sql.Tx) error
+}
+
+// Migrations are the changes of the schema, in order, the version of each
+// being its position from 1. A change of the tables is a new migration,
+// SchemaVersion being the version of the last one.
+var Migrations = []Migration{
+	{Version: 1, Name: "baseline", Up: createBaseline},
+	{Version: 2, Name: "campaigns", Up: createCampaigns, Down: dropCampaigns},
+	{Version: 3, Name: "email templates", Up: createEmailTemplates, Down: dropEmailTemplates},
+	{Version: 4, Name: "campaign recipients", Up: createCampaignRecipients, Down: dropCampaignRecipients},
+	{Version: 5, Name: "send queue", Up: createSendQueue, Down: dropSendQueue},
+	{Version: 6, Name: "email fields", Up: createEmailFields, Down: dropEmailFields},
+	{Version: 7, Name: "bounces", Up: createBounces, Down: dropBounces},
+	{Version: 8, Name: "clicks", Up: createClicks, Down: dropClicks},
+	{Version: 9, Name: "campaign stats", Up: createCampaignStats, Down: dropCampaignStats},
+	{Version: 10, Name: "confirmation cooldown", Up: createConfirmCooldown, Down: dropConfirmCooldown},
+	{Version: 11, Name: "outbox trigger", Up: createOutboxTrigger, Down: dropOutboxTrigger},
+	{Version: 12, Name: "webhook trigger", Up: createWebhookTrigger, Down: dropWebhookTrigger},
+	{Version: 13, Name: "complaints", Up: createComplaints, Down: dropComplaints},
+	{Version: 14, Name: "opens", Up: createOpens, Down: dropOpens},
+}
+
+// Migrate brings the schema of the database up or down to the version, one
+// migration at a time, recording the version reached after each.
+func Migrate(ctx context.Context, db *sql.DB, target int) error {
+	if target < 0 || target > SchemaVersion {
+		return fmt.Errorf("unknown schema version %v, the last is %v", target, SchemaVersion)
+	}
+	version, err := GetSchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("schema version %v is newer than %v, the database was upgraded by a newer release", version, SchemaVersion)
+	}
+
+	logger := logging.FromContext(ctx)
+	for version < target {
+		m := Migrations[version]
+		if err := migrateStep(ctx, db, m.Up, m.Version); err != nil {
+			return fmt.Errorf("applying migration %v %v : %w", m.Version, m.Name, err)
+		}
+		logger.Info("migration applied", "version", m.Version, "migration", m.Name)
+		version = m.Version
+	}
+	for version > target {
+		m := Migrations[version-1]
+		if m.Down == nil {
+			return fmt.Errorf("migration %v %v cannot be reverted, restore a backup instead", m.Version, m.Name)
+		}
+		if err := migrateStep(ctx, db, m.Down, m.Version-1); err != nil {
+			return fmt.Errorf("reverting migration %v %v : %w", m.Version, m.Name, err)
+		}
+		logger.Info("migration reverted", "version", m.Version, "migration", m.Name)
+		version = m.Version - 1
+	}
+	return nil
+}
+
+// migrateStep applies or reverts a migration and records the version it
+// brings the schema to in one transaction, so that a failed migration
+// leaves the database at the version before it.
+func migrateStep(ctx context.Context, db *sql.DB, step func(ctx context.Context, tx *sql.Tx) error, version int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := step(ctx, tx); err != nil {
+		return err
+	}
+	// PRAGMA takes no parameters.
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		logging.FromContext(ctx).Error("setting the schema version", "err", err)
+		return err
+	}
+	return tx.Commit()
+}
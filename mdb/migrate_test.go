@@ -0,0 +1,118 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func schemaVersion(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	version, err := GetSchemaVersion(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestMigrations(t *testing.T) {
+	for i, m := range Migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %v %v at position %v", m.Version, m.Name, i+1)
+		}
+	}
+	if last := Migrations[len(Migrations)-1].Version; last != SchemaVersion {
+		t.Errorf("last migration %v, SchemaVersion %v", last, SchemaVersion)
+	}
+}
+
+// Every migration but the baseline reverts, and applies again on the
+// schema it reverted to.
+func TestMigrateUpDown(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+
+	if err := Migrate(ctx, db, SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	if v := schemaVersion(t, db); v != SchemaVersion {
+		t.Fatalf("version %v after migrating up, want %v", v, SchemaVersion)
+	}
+	if err := Migrate(ctx, db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if v := schemaVersion(t, db); v != 1 {
+		t.Fatalf("version %v after migrating down, want 1", v)
+	}
+	if err := Migrate(ctx, db, 0); err == nil {
+		t.Error("the baseline reverted")
+	}
+	if err := Migrate(ctx, db, SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateEmail(ctx, db, "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateUnknown(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	if err := Migrate(ctx, db, SchemaVersion+1); err == nil {
+		t.Error("migrated to an unknown version")
+	}
+	if _, err := db.Exec(`PRAGMA user_version = 1000`); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(ctx, db, SchemaVersion); err == nil {
+		t.Error("migrated a database of a newer release")
+	}
+}
+
+// A migration failing leaves the schema and its version as they were.
+func TestMigrateStepFailing(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	if err := Migrate(ctx, db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	failing := errors.New("failing")
+	err := migrateStep(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `CREATE TABLE half_done (id INTEGER)`); err != nil {
+			return err
+		}
+		return failing
+	}, 2)
+	if !errors.Is(err, failing) {
+		t.Fatalf("migrateStep = %v, want %v", err, failing)
+	}
+
+	if v := schemaVersion(t, db); v != 1 {
+		t.Errorf("version %v after a failed migration, want 1", v)
+	}
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'half_done'`).Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Error("the table of the failed migration was kept")
+	}
+}
+
+// The baseline adopts the databases created before the migrations, whose
+// tables already exist.
+func TestBaselineExisting(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	if err := Migrate(ctx, db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`PRAGMA user_version = 0`); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(ctx, db, SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+}
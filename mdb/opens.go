@@ -0,0 +1,62 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+	"time"
+)
+
+func createOpens(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE opens (
+			id 			INTEGER PRIMARY KEY,
+			campaign_id INTEGER,
+			email_id 	INTEGER,
+			opened_at 	INTEGER
+		);
+		CREATE INDEX opens_campaign ON opens (campaign_id, opened_at);
+		CREATE TRIGGER campaigns_delete_opens AFTER DELETE ON campaigns
+		BEGIN
+			DELETE FROM opens WHERE campaign_id = OLD.id;
+		END;
+		CREATE TRIGGER emails_delete_opens AFTER DELETE ON emails
+		BEGIN
+			DELETE FROM opens WHERE email_id = OLD.id;
+		END;
+	`)
+	return err
+}
+
+func dropOpens(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		DROP TRIGGER emails_delete_opens;
+		DROP TRIGGER campaigns_delete_opens;
+		DROP TABLE opens;
+	`)
+	return err
+}
+
+// RecordOpen records that the email opened its message of the campaign,
+// its tracking pixel being loaded. It reports false, recording nothing,
+// when the campaign or the email was deleted since.
+func RecordOpen(ctx context.Context, db *sql.DB, campaignId, emailId int64) (recorded bool, err error) {
+	ctx, span := startSpan(ctx, "RecordOpen")
+	defer endSpan(span, &err)
+
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO opens (campaign_id, email_id, opened_at)
+		SELECT ?, ?, ?
+		WHERE EXISTS (SELECT 1 FROM campaigns WHERE id = ?)
+			AND EXISTS (SELECT 1 FROM emails WHERE id = ? AND deleted_at IS NULL)
+	`, campaignId, emailId, time.Now().Unix(), campaignId, emailId)
+	if err != nil {
+		logging.FromContext(ctx).Error("recording open", "campaign", campaignId, "email_id", emailId, "err", err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
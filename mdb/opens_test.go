@@ -0,0 +1,57 @@
+package mdb
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRecordOpen(t *testing.T) {
+	ctx := context.Background()
+	db := migratedDB(t)
+	campaign, err := CreateCampaign(ctx, db, Campaign{Name: "news", Subject: "News", TextBody: "Hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]int64)
+	for _, email := range []string{"jane@example.com", "john@example.com", "ann@example.com"} {
+		if err := CreateEmail(ctx, db, email); err != nil {
+			t.Fatal(err)
+		}
+		entry, err := GetEmail(ctx, db, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[email] = entry.Id
+	}
+	if err := DeleteEmailByEmail(ctx, db, "ann@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		campaignId int64
+		email      string
+		recorded   bool
+	}{
+		{name: "first open", campaignId: campaign.Id, email: "jane@example.com", recorded: true},
+		{name: "opened again", campaignId: campaign.Id, email: "jane@example.com", recorded: true},
+		{name: "other recipient", campaignId: campaign.Id, email: "john@example.com", recorded: true},
+		{name: "email deleted", campaignId: campaign.Id, email: "ann@example.com"},
+		{name: "campaign deleted", campaignId: campaign.Id + 1, email: "jane@example.com"},
+	}
+	for _, test := range tests {
+		recorded, err := RecordOpen(ctx, db, test.campaignId, ids[test.email])
+		if err != nil || recorded != test.recorded {
+			t.Errorf("%v: RecordOpen = %v, %v, want %v", test.name, recorded, err, test.recorded)
+		}
+	}
+
+	// The recipients are counted once, in the bucket of their first open.
+	stats, err := GetCampaignStats(ctx, db, campaign.Id, StatsHour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Opened != 2 || len(stats.Series) != 1 || stats.Series[0].Opened != 2 {
+		t.Errorf("stats = %+v, want 2 opened", stats)
+	}
+}
@@ -0,0 +1,119 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+	"time"
+)
+
+// OutboxMessage is an email event waiting to be published, written in the
+// same transaction as the change. Payload is the JSON of the event.
+type OutboxMessage struct {
+	Id      int64
+	Email   string
+	Kind    string
+	Payload string
+}
+
+// eventPayload is the JSON of the event inserted in email_events, for the
+// triggers publishing it.
+const eventPayload = `json_object(
+	'seq', NEW.seq,
+	'email', NEW.email,
+	'kind', NEW.kind,
+	'confirmed_at', NULLIF(NEW.confirmed_at, 0),
+	'opt_out', json(CASE WHEN NEW.opt_out THEN 'true' ELSE 'false' END),
+	'deleted_at', NULLIF(NEW.deleted_at, 0),
+	'changed_at', NEW.changed_at)`
+
+func tryCreateOutbox(s *schemaTx) {
+	s.tryExec(`
+		CREATE TABLE outbox (
+			id 			INTEGER PRIMARY KEY AUTOINCREMENT,
+			email 		TEXT,
+			payload 	TEXT
+		);
+	`)
+	s.tryExec(`ALTER TABLE outbox ADD COLUMN kind TEXT;`)
+}
+
+// createOutboxTrigger writes the email events to the outbox whatever the
+// settings of the servers, for any of them sharing the database to publish
+// it. The event trigger runs in the transaction of the change, and so does
+// this one.
+func createOutboxTrigger(ctx context.Context, tx *sql.Tx) error {
+	// The servers used to create it while publishing the outbox.
+	if _, err := tx.ExecContext(ctx, `DROP TRIGGER IF EXISTS email_events_outbox;`); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		CREATE TRIGGER email_events_outbox AFTER INSERT ON email_events
+		BEGIN
+			INSERT INTO outbox (email, kind, payload)
+			VALUES (NEW.email, NEW.kind, `+eventPayload+`);
+		END;
+	`)
+	return err
+}
+
+func dropOutboxTrigger(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `DROP TRIGGER IF EXISTS email_events_outbox;`)
+	return err
+}
+
+// GetOutbox returns up to count of the oldest messages of the outbox.
+func GetOutbox(ctx context.Context, db *sql.DB, count int) ([]*OutboxMessage, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, email, COALESCE(kind, ''), payload
+		FROM outbox
+		ORDER BY id ASC
+		LIMIT ?
+	`, count)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("getting outbox", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]*OutboxMessage, 0, count)
+	for rows.Next() {
+		message := &OutboxMessage{}
+		if err := rows.Scan(&message.Id, &message.Email, &message.Kind, &message.Payload); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// DeleteOutbox removes the messages up to id once they are published.
+func DeleteOutbox(ctx context.Context, db *sql.DB, id int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM outbox WHERE id <= ?`, id)
+	if err != nil {
+		logging.FromContext(ctx).Error("deleting outbox", "id", id, "err", err)
+	}
+	return err
+}
+
+// CountOutbox returns how many messages wait to be published.
+func CountOutbox(ctx context.Context, db *sql.DB) (int64, error) {
+	var count int64
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM outbox`).Scan(&count)
+	return count, err
+}
+
+// PruneOutbox removes the messages of the events changed before the given
+// time, which no server published.
+func PruneOutbox(ctx context.Context, db *sql.DB, before time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		DELETE FROM outbox WHERE json_extract(payload, '$.changed_at') < ?
+	`, before.Unix())
+
+	if err != nil {
+		logging.FromContext(ctx).Error("pruning outbox", "err", err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
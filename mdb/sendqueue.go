@@ -0,0 +1,312 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"mailinglist/logging"
+	"strings"
+	"time"
+)
+
+// The states of the queued messages.
+const (
+	MessagePending = "pending"
+	MessageSent    = "sent"
+	MessageFailed  = "failed"
+)
+
+// ErrMessageNotFound is returned when requeueing a message that has not
+// failed.
+var ErrMessageNotFound = errors.New("failed message not found")
+
+// QueuedMessage is an email waiting in the send queue, rendered for its
+// recipient, or its outcome once sent or failed.
+type QueuedMessage struct {
+	Id int64
+	// CampaignId is the campaign the message is sent for, 0 for the others.
+	CampaignId int64
+	From       string
+	To         string
+	Subject    string
+	Text       string
+	HTML       string
+	Headers    map[string]string
+	// Hash is the hex SHA-256 of the rendered message, kept for audit with
+	// the recipient of its campaign.
+	Hash     string
+	Status   string
+	Attempts int
+	// LastError is why the last attempt failed.
+	LastError     string
+	CreatedAt     time.Time
+	NextAttemptAt time.Time
+	// SentAt is when the message was sent, or failed for good.
+	SentAt *time.Time
+}
+
+func createSendQueue(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE send_queue (
+			id 				INTEGER PRIMARY KEY AUTOINCREMENT,
+			campaign_id 	INTEGER,
+			from_addr 		TEXT,
+			to_addr 		TEXT,
+			subject 		TEXT,
+			text_body 		TEXT,
+			html_body 		TEXT,
+			headers 		TEXT,
+			status 			TEXT,
+			attempts 		INTEGER,
+			last_error 		TEXT,
+			created_at 		INTEGER,
+			next_attempt_at INTEGER,
+			sent_at 		INTEGER
+		);
+		CREATE INDEX send_queue_next ON send_queue (status, next_attempt_at);
+		CREATE INDEX send_queue_campaign ON send_queue (campaign_id, to_addr);
+	`)
+	return err
+}
+
+func dropSendQueue(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `DROP TABLE send_queue`)
+	return err
+}
+
+const messageColumns = `id, campaign_id, from_addr, to_addr, subject, text_body, html_body, headers,
+	COALESCE(message_hash, ''), status, attempts, last_error, created_at, next_attempt_at, sent_at`
+
+func messageFromRow(row interface{ Scan(...any) error }) (*QueuedMessage, error) {
+	var (
+		m                        QueuedMessage
+		headers                  string
+		createdAt, nextAttemptAt int64
+		sentAt                   sql.NullInt64
+	)
+	err := row.Scan(&m.Id, &m.CampaignId, &m.From, &m.To, &m.Subject, &m.Text, &m.HTML, &headers,
+		&m.Hash, &m.Status, &m.Attempts, &m.LastError, &createdAt, &nextAttemptAt, &sentAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(headers), &m.Headers); err != nil {
+		return nil, err
+	}
+	m.CreatedAt = time.Unix(createdAt, 0)
+	m.NextAttemptAt = time.Unix(nextAttemptAt, 0)
+	if sentAt.Valid {
+		t := time.Unix(sentAt.Int64, 0)
+		m.SentAt = &t
+	}
+	return &m, nil
+}
+
+// execer runs the statements on the database or in a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func enqueueMessage(ctx context.Context, db execer, m *QueuedMessage) (int64, error) {
+	headers, err := json.Marshal(m.Headers)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().Unix()
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO send_queue (campaign_id, from_addr, to_addr, subject, text_body, html_body, headers,
+			message_hash, status, attempts, last_error, created_at, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0, '', ?, ?)
+	`, m.CampaignId, m.From, m.To, m.Subject, m.Text, m.HTML, string(headers), m.Hash, MessagePending, now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// EnqueueMessage queues a message to be sent as soon as possible, and
+// returns its id.
+func EnqueueMessage(ctx context.Context, db *sql.DB, m *QueuedMessage) (int64, error) {
+	id, err := enqueueMessage(ctx, db, m)
+	if err != nil {
+		logging.FromContext(ctx).Error("queueing message", "err", err)
+	}
+	return id, err
+}
+
+// ClaimMessage takes the next pending message due, to a recipient domain
+// not skipped, hiding it from the other workers for the visibility
+// timeout, after which it is sent again if not settled. It returns nil
+// when none is due.
+func ClaimMessage(ctx context.Context, db *sql.DB, skip []string, visibility time.Duration) (*QueuedMessage, error) {
+	now := time.Now()
+	args := []interface{}{now.Add(visibility).Unix(), MessagePending, now.Unix()}
+	skipped := ""
+	if len(skip) > 0 {
+		skipped = `AND lower(substr(to_addr, instr(to_addr, '@') + 1)) NOT IN (?` + strings.Repeat(`, ?`, len(skip)-1) + `)`
+		for _, domain := range skip {
+			args = append(args, domain)
+		}
+	}
+
+	row := db.QueryRowContext(ctx, `
+		UPDATE send_queue SET next_attempt_at = ?
+		WHERE id = (
+			SELECT id FROM send_queue
+			WHERE status = ? AND next_attempt_at <= ?
+				`+skipped+`
+			ORDER BY next_attempt_at, id
+			LIMIT 1)
+		RETURNING `+messageColumns, args...)
+
+	m, err := messageFromRow(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("claiming message", "err", err)
+		return nil, err
+	}
+	return m, nil
+}
+
+// SettleMessage records the outcome of the last attempt to send a message:
+// sent when sendErr is nil, else failed for good, the recipient of its
+// campaign with it.
+func SettleMessage(ctx context.Context, db *sql.DB, m *QueuedMessage, attempts int, sendErr error) (err error) {
+	defer func() {
+		if err != nil {
+			logging.FromContext(ctx).Error("settling message", "id", m.Id, "err", err)
+		}
+	}()
+
+	status, lastError, recipient := MessageSent, "", RecipientSent
+	if sendErr != nil {
+		status, lastError, recipient = MessageFailed, sendErr.Error(), RecipientFailed
+	}
+	now := time.Now().Unix()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE send_queue SET status = ?, attempts = ?, last_error = ?, sent_at = ?
+		WHERE id = ?
+	`, status, attempts, lastError, now, m.Id)
+	if err != nil {
+		return err
+	}
+	if m.CampaignId != 0 {
+		errText := sql.NullString{String: lastError, Valid: sendErr != nil}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE campaign_recipients SET status = ?, error = ?, sent_at = ?
+			WHERE campaign_id = ? AND email = ?
+		`, recipient, errText, now, m.CampaignId, m.To)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RetryMessage schedules the message again at the given time, with the
+// number of attempts made so far and the error of the last one.
+func RetryMessage(ctx context.Context, db *sql.DB, id int64, attempts int, at time.Time, lastError string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE send_queue SET attempts = ?, next_attempt_at = ?, last_error = ?
+		WHERE id = ?
+	`, attempts, at.Unix(), lastError, id)
+	if err != nil {
+		logging.FromContext(ctx).Error("retrying message", "id", id, "err", err)
+	}
+	return err
+}
+
+// GetFailedMessages returns up to limit of the most recent failed
+// messages.
+func GetFailedMessages(ctx context.Context, db *sql.DB, limit int) ([]*QueuedMessage, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+messageColumns+`
+		FROM send_queue WHERE status = ?
+		ORDER BY id DESC LIMIT ?
+	`, MessageFailed, limit)
+	if err != nil {
+		logging.FromContext(ctx).Error("getting failed messages", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]*QueuedMessage, 0)
+	for rows.Next() {
+		m, err := messageFromRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// RequeueMessage sends a failed message again now, with its attempts
+// reset, the recipient of its campaign being queued again.
+func RequeueMessage(ctx context.Context, db *sql.DB, id int64) (err error) {
+	defer func() {
+		if err != nil && err != ErrMessageNotFound {
+			logging.FromContext(ctx).Error("requeueing message", "id", id, "err", err)
+		}
+	}()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var campaignId int64
+	var to string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE send_queue SET status = ?, attempts = 0, last_error = '', next_attempt_at = ?, sent_at = NULL
+		WHERE id = ? AND status = ?
+		RETURNING campaign_id, to_addr
+	`, MessagePending, time.Now().Unix(), id, MessageFailed).Scan(&campaignId, &to)
+	if err == sql.ErrNoRows {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if campaignId != 0 {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE campaign_recipients SET status = ?, error = NULL, sent_at = NULL
+			WHERE campaign_id = ? AND email = ?
+		`, RecipientQueued, campaignId, to)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CountMessages returns the number of queued messages by status.
+func CountMessages(ctx context.Context, db *sql.DB) (map[string]int64, error) {
+	rows, err := db.QueryContext(ctx, `SELECT status, COUNT(*) FROM send_queue GROUP BY status`)
+	if err != nil {
+		logging.FromContext(ctx).Error("counting messages", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int64{MessagePending: 0, MessageSent: 0, MessageFailed: 0}
+	for rows.Next() {
+		var status string
+		var count int64
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,157 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+	"time"
+)
+
+// DailyCount is the number of emails created on a day, in UTC.
+type DailyCount struct {
+	Day   time.Time
+	Count int64
+}
+
+// Stats summarizes the state of the list.
+type Stats struct {
+	// Total counts the emails that are not in the trash, Deleted those in it.
+	Total       int64
+	Subscribed  int64
+	Confirmed   int64
+	Unconfirmed int64
+	OptedOut    int64
+	Deleted     int64
+	// OptOutReasons breaks OptedOut down by reason.
+	OptOutReasons map[string]int64
+	// Signups has one entry per day since the requested day, including
+	// the days without signups.
+	Signups []DailyCount
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// GetStats returns the stats of the list, with the signups since the given day.
+func GetStats(ctx context.Context, db *sql.DB, since time.Time) (*Stats, error) {
+	stats := &Stats{OptOutReasons: make(map[string]int64)}
+
+	err := db.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(SUM(deleted_at IS NULL), 0),
+			COALESCE(SUM(deleted_at IS NULL AND NOT COALESCE(opt_out, false)), 0),
+			COALESCE(SUM(deleted_at IS NULL AND NOT COALESCE(opt_out, false) AND confirmed_at > 0), 0),
+			COALESCE(SUM(deleted_at IS NULL AND COALESCE(opt_out, false)), 0),
+			COALESCE(SUM(deleted_at IS NOT NULL), 0)
+		FROM emails
+	`).Scan(&stats.Total, &stats.Subscribed, &stats.Confirmed, &stats.OptedOut, &stats.Deleted)
+	if err != nil {
+		logging.FromContext(ctx).Error("counting emails", "err", err)
+		return nil, err
+	}
+	stats.Unconfirmed = stats.Subscribed - stats.Confirmed
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT COALESCE(opt_out_reason, ''), COUNT(*) FROM emails
+		WHERE deleted_at IS NULL AND opt_out
+		GROUP BY 1
+	`)
+	if err != nil {
+		logging.FromContext(ctx).Error("counting opt out reasons", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			reason string
+			count  int64
+		)
+		if err := rows.Scan(&reason, &count); err != nil {
+			return nil, err
+		}
+		stats.OptOutReasons[reason] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	since = truncateDay(since.UTC())
+	rows, err = db.QueryContext(ctx, `
+		SELECT created_at / 86400, COUNT(*) FROM emails
+		WHERE created_at >= ?
+		GROUP BY 1
+	`, since.Unix())
+	if err != nil {
+		logging.FromContext(ctx).Error("counting signups", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	signups := make(map[int64]int64)
+	for rows.Next() {
+		var day, count int64
+		if err := rows.Scan(&day, &count); err != nil {
+			return nil, err
+		}
+		signups[day] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	today := truncateDay(time.Now().UTC())
+	for day := since; !day.After(today); day = day.AddDate(0, 0, 1) {
+		stats.Signups = append(stats.Signups, DailyCount{Day: day, Count: signups[day.Unix()/86400]})
+	}
+	return stats, nil
+}
+
+func tryCreateStatsDaily(s *schemaTx) {
+	s.tryExec(`
+		CREATE TABLE stats_daily (
+			day 			TEXT PRIMARY KEY,
+			total 			INTEGER,
+			subscribed 		INTEGER,
+			confirmed 		INTEGER,
+			opted_out 		INTEGER,
+			deleted 		INTEGER,
+			signups 		INTEGER,
+			updated_at 		INTEGER
+		);
+	`)
+}
+
+// RollupStats records the stats of the list as the ones of the current UTC
+// day, replacing those recorded earlier that day, so that their history is
+// kept once the emails have changed.
+func RollupStats(ctx context.Context, db *sql.DB) (err error) {
+	ctx, span := startSpan(ctx, "RollupStats")
+	defer endSpan(span, &err)
+
+	now := time.Now()
+	stats, err := GetStats(ctx, db, now)
+	if err != nil {
+		return err
+	}
+	var signups int64
+	if len(stats.Signups) > 0 {
+		signups = stats.Signups[len(stats.Signups)-1].Count
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO stats_daily (day, total, subscribed, confirmed, opted_out, deleted, signups, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (day) DO UPDATE SET
+			total = excluded.total, subscribed = excluded.subscribed, confirmed = excluded.confirmed,
+			opted_out = excluded.opted_out, deleted = excluded.deleted, signups = excluded.signups,
+			updated_at = excluded.updated_at
+	`, truncateDay(now.UTC()).Format("2006-01-02"), stats.Total, stats.Subscribed, stats.Confirmed,
+		stats.OptedOut, stats.Deleted, signups, now.Unix())
+	if err != nil {
+		logging.FromContext(ctx).Error("rolling up stats", "err", err)
+		return err
+	}
+	return nil
+}
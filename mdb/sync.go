@@ -0,0 +1,147 @@
+package mdb
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"mailinglist/logging"
+)
+
+func tryCreateSync(s *schemaTx) {
+	s.tryExec(`
+		CREATE TABLE sync_instance (
+			id 	TEXT
+		);
+	`)
+	s.tryExec(`
+		CREATE TABLE sync_checkpoints (
+			peer 	TEXT PRIMARY KEY,
+			seq 	INTEGER
+		);
+	`)
+}
+
+// InstanceId returns the id identifying this database to sync peers,
+// generating it on first use.
+func InstanceId(ctx context.Context, db *sql.DB) (string, error) {
+	var id string
+	err := db.QueryRowContext(ctx, `SELECT id FROM sync_instance`).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id = hex.EncodeToString(buf)
+
+	if _, err := db.ExecContext(ctx, `INSERT INTO sync_instance (id) VALUES (?)`, id); err != nil {
+		logging.FromContext(ctx).Error("storing instance id", "err", err)
+		return "", err
+	}
+	return id, nil
+}
+
+// GetSyncCheckpoint returns the seq of the last event received from the peer.
+func GetSyncCheckpoint(ctx context.Context, db *sql.DB, peer string) (int64, error) {
+	var seq int64
+	err := db.QueryRowContext(ctx, `SELECT seq FROM sync_checkpoints WHERE peer = ?`, peer).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seq, err
+}
+
+func SetSyncCheckpoint(ctx context.Context, db *sql.DB, peer string, seq int64) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO sync_checkpoints (peer, seq) VALUES (?, ?)
+		ON CONFLICT(peer) DO UPDATE SET seq = ?
+	`, peer, seq, seq)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("storing sync checkpoint", "peer", peer, "err", err)
+		return err
+	}
+	return nil
+}
+
+// ApplySyncEvent applies an event received from a sync peer unless a newer
+// change of the same email is already known (last writer wins). Applied
+// changes keep the original changed_at, so echoing them back is a no-op.
+//
+// The emails which complained stay opted out, whatever the peer sent.
+//
+// changed_at being in seconds, changes made in the same second on both
+// sides tie: the one with the highest state of purged, deleted, opted out
+// and confirmed_at wins on both sides, so that they end up the same, and a
+// deletion or an opt out is never lost to a concurrent change.
+func ApplySyncEvent(ctx context.Context, db *sql.DB, event EmailEvent) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var latest EmailEvent
+	err = tx.QueryRowContext(ctx, `
+		SELECT changed_at, purged, COALESCE(deleted_at, 0), COALESCE(opt_out, false), COALESCE(confirmed_at, 0) FROM email_events
+		WHERE email = ?
+		ORDER BY changed_at DESC, seq DESC
+		LIMIT 1
+	`, event.Email).Scan(&latest.ChangedAt, &latest.Purged, &latest.DeletedAt, &latest.OptOut, &latest.ConfirmedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if err == nil && !newerSyncEvent(event, latest) {
+		return false, nil
+	}
+
+	var deletedAt interface{}
+	if event.DeletedAt != 0 {
+		deletedAt = event.DeletedAt
+	}
+
+	if event.Purged {
+		_, err = tx.ExecContext(ctx, `DELETE FROM emails WHERE email = ?`, event.Email)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO emails (email, confirmed_at, opt_out, deleted_at, changed_at, created_at)
+			VALUES (?, ?, ?, ?, ?, strftime('%s', 'now'))
+			ON CONFLICT(email)
+			DO UPDATE
+				SET confirmed_at = excluded.confirmed_at,
+					opt_out = excluded.opt_out OR `+complained+`,
+					deleted_at = excluded.deleted_at,
+					changed_at = excluded.changed_at
+		`, event.Email, event.ConfirmedAt, event.OptOut, deletedAt, event.ChangedAt)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("applying sync event", "email", event.Email, "err", err)
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// newerSyncEvent reports whether the event wins over the latest one known,
+// by changed_at, then by state for the ties.
+func newerSyncEvent(event, latest EmailEvent) bool {
+	if event.ChangedAt != latest.ChangedAt {
+		return event.ChangedAt > latest.ChangedAt
+	}
+	if event.Purged != latest.Purged {
+		return event.Purged
+	}
+	if (event.DeletedAt != 0) != (latest.DeletedAt != 0) {
+		return event.DeletedAt != 0
+	}
+	if event.OptOut != latest.OptOut {
+		return event.OptOut
+	}
+	return event.ConfirmedAt > latest.ConfirmedAt
+}
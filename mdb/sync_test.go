@@ -0,0 +1,152 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+)
+
+func TestNewerSyncEvent(t *testing.T) {
+	tests := []struct {
+		name          string
+		event, latest EmailEvent
+		newer         bool
+	}{
+		{name: "later", event: EmailEvent{ChangedAt: 2}, latest: EmailEvent{ChangedAt: 1, OptOut: true}, newer: true},
+		{name: "earlier", event: EmailEvent{ChangedAt: 1, OptOut: true}, latest: EmailEvent{ChangedAt: 2}},
+		{name: "same", event: EmailEvent{ChangedAt: 1, ConfirmedAt: 1}, latest: EmailEvent{ChangedAt: 1, ConfirmedAt: 1}},
+		{name: "purged", event: EmailEvent{ChangedAt: 1, Purged: true}, latest: EmailEvent{ChangedAt: 1, DeletedAt: 1, OptOut: true}, newer: true},
+		{name: "purge lost", event: EmailEvent{ChangedAt: 1, DeletedAt: 1, OptOut: true}, latest: EmailEvent{ChangedAt: 1, Purged: true}},
+		{name: "deleted", event: EmailEvent{ChangedAt: 1, DeletedAt: 1}, latest: EmailEvent{ChangedAt: 1, OptOut: true}, newer: true},
+		{name: "deletion lost", event: EmailEvent{ChangedAt: 1, OptOut: true}, latest: EmailEvent{ChangedAt: 1, DeletedAt: 1}},
+		{name: "opted out", event: EmailEvent{ChangedAt: 1, OptOut: true}, latest: EmailEvent{ChangedAt: 1, ConfirmedAt: 1}, newer: true},
+		{name: "opt out lost", event: EmailEvent{ChangedAt: 1, ConfirmedAt: 1}, latest: EmailEvent{ChangedAt: 1, OptOut: true}},
+		{name: "confirmed later", event: EmailEvent{ChangedAt: 1, ConfirmedAt: 2}, latest: EmailEvent{ChangedAt: 1, ConfirmedAt: 1}, newer: true},
+		{name: "confirmed earlier", event: EmailEvent{ChangedAt: 1, ConfirmedAt: 1}, latest: EmailEvent{ChangedAt: 1, ConfirmedAt: 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if newer := newerSyncEvent(test.event, test.latest); newer != test.newer {
+				t.Errorf("newerSyncEvent = %v, want %v", newer, test.newer)
+			}
+		})
+	}
+}
+
+// syncedState is the state of an email after syncing, "" when purged.
+func syncedState(t *testing.T, db *sql.DB, email string) string {
+	t.Helper()
+	var (
+		confirmedAt int64
+		optOut      bool
+		deleted     bool
+	)
+	err := db.QueryRow(`
+		SELECT confirmed_at, opt_out, deleted_at IS NOT NULL FROM emails WHERE email = ?
+	`, email).Scan(&confirmedAt, &optOut, &deleted)
+	if err == sql.ErrNoRows {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := EmailEvent{ConfirmedAt: confirmedAt, OptOut: optOut}
+	if deleted {
+		event.DeletedAt = 1
+	}
+	return eventState(event)
+}
+
+// eventState names the state of the email the event leaves.
+func eventState(e EmailEvent) string {
+	switch {
+	case e.Purged:
+		return ""
+	case e.DeletedAt != 0:
+		return "deleted"
+	case e.OptOut:
+		return "opted out"
+	case e.ConfirmedAt != 0:
+		return fmt.Sprint("confirmed at ", e.ConfirmedAt)
+	}
+	return "pending"
+}
+
+// Two peers receiving the same two changes in opposite orders end up with
+// the same state.
+func TestApplySyncEventConverges(t *testing.T) {
+	ctx := context.Background()
+	const email = "jane@example.com"
+	tests := []struct {
+		name   string
+		a, b   EmailEvent
+		winner string
+	}{
+		{
+			name:   "later wins",
+			a:      EmailEvent{Email: email, OptOut: true, ChangedAt: 100},
+			b:      EmailEvent{Email: email, ConfirmedAt: 50, ChangedAt: 101},
+			winner: "confirmed at 50",
+		},
+		{
+			name:   "opt out wins the tie",
+			a:      EmailEvent{Email: email, ConfirmedAt: 50, ChangedAt: 100},
+			b:      EmailEvent{Email: email, OptOut: true, ChangedAt: 100},
+			winner: "opted out",
+		},
+		{
+			name:   "deletion wins the tie",
+			a:      EmailEvent{Email: email, OptOut: true, ChangedAt: 100},
+			b:      EmailEvent{Email: email, DeletedAt: 100, ChangedAt: 100},
+			winner: "deleted",
+		},
+		{
+			name:   "purge wins the tie",
+			a:      EmailEvent{Email: email, DeletedAt: 100, ChangedAt: 100},
+			b:      EmailEvent{Email: email, Purged: true, ChangedAt: 100},
+			winner: "",
+		},
+		{
+			name:   "latest confirmation wins the tie",
+			a:      EmailEvent{Email: email, ConfirmedAt: 60, ChangedAt: 100},
+			b:      EmailEvent{Email: email, ConfirmedAt: 50, ChangedAt: 100},
+			winner: "confirmed at 60",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var states []string
+			for _, events := range [][]EmailEvent{{test.a, test.b}, {test.b, test.a}} {
+				db := migratedDB(t)
+				if _, err := ApplySyncEvent(ctx, db, EmailEvent{Email: email, ChangedAt: 1}); err != nil {
+					t.Fatal(err)
+				}
+				for _, event := range events {
+					if _, err := ApplySyncEvent(ctx, db, event); err != nil {
+						t.Fatal(err)
+					}
+				}
+				states = append(states, syncedState(t, db, email))
+			}
+			if states[0] != test.winner || states[1] != test.winner {
+				t.Errorf("states = %q, want %q on both peers", states, test.winner)
+			}
+		})
+	}
+}
+
+// An applied event sent back by the peer is a no-op.
+func TestApplySyncEventEcho(t *testing.T) {
+	ctx := context.Background()
+	db := migratedDB(t)
+	event := EmailEvent{Email: "jane@example.com", OptOut: true, ChangedAt: 100}
+	if applied, err := ApplySyncEvent(ctx, db, event); err != nil || !applied {
+		t.Fatalf("ApplySyncEvent = %v, %v", applied, err)
+	}
+	if applied, err := ApplySyncEvent(ctx, db, event); err != nil || applied {
+		t.Errorf("ApplySyncEvent of the echo = %v, %v, want not applied", applied, err)
+	}
+}
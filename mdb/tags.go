@@ -0,0 +1,84 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"mailinglist/logging"
+)
+
+func tryCreateTags(s *schemaTx) {
+	s.tryExec(`
+		CREATE TABLE email_tags (
+			email_id 	INTEGER,
+			tag 		TEXT,
+			PRIMARY KEY (email_id, tag)
+		);
+	`)
+	s.tryExec(`CREATE INDEX email_tags_tag ON email_tags (tag);`)
+	s.tryExec(`
+		CREATE TRIGGER emails_delete_tags AFTER DELETE ON emails
+		BEGIN
+			DELETE FROM email_tags WHERE email_id = OLD.id;
+		END;
+	`)
+}
+
+// TagEmail tags the email. Tagging it twice with the same tag is a no-op.
+func TagEmail(ctx context.Context, db *sql.DB, email, tag string) error {
+	eid, err := emailId(ctx, db, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO email_tags (email_id, tag) VALUES (?, ?)
+		ON CONFLICT DO NOTHING
+	`, eid, tag)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("tagging email", "email", email, "tag", tag, "err", err)
+		return err
+	}
+	return nil
+}
+
+func UntagEmail(ctx context.Context, db *sql.DB, email, tag string) error {
+	eid, err := emailId(ctx, db, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `
+		DELETE FROM email_tags WHERE email_id = ? AND tag = ?
+	`, eid, tag)
+
+	if err != nil {
+		logging.FromContext(ctx).Error("untagging email", "email", email, "tag", tag, "err", err)
+		return err
+	}
+	return nil
+}
+
+// GetEmailTags returns the tags of the email, sorted.
+func GetEmailTags(ctx context.Context, db *sql.DB, email string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT t.tag FROM email_tags t JOIN emails e ON e.id = t.email_id
+		WHERE e.email = ? AND e.deleted_at IS NULL
+		ORDER BY t.tag
+	`, email)
+	if err != nil {
+		logging.FromContext(ctx).Error("getting email tags", "email", email, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]string, 0)
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
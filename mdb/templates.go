@@ -0,0 +1,174 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mailinglist/logging"
+	"mailinglist/sanitize"
+	"mailinglist/templates"
+	"time"
+)
+
+var (
+	ErrTemplateNotFound = errors.New("template not found")
+	ErrTemplateExists   = errors.New("a template with this name already exists")
+)
+
+// EmailTemplate is a template stored by name, e.g. for the confirmation
+// emails.
+type EmailTemplate struct {
+	Id       int64
+	Name     string
+	Subject  string
+	TextBody string
+	// HTMLBody is the alternative to TextBody, optional.
+	HTMLBody  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Validate checks the name of the template, and its subject and bodies
+// with templates.Parse.
+func (t *EmailTemplate) Validate() error {
+	if t.Name == "" {
+		return errors.New("Name is required")
+	}
+	if err := sanitize.Check("Name", t.Name); err != nil {
+		return err
+	}
+	_, err := templates.Parse(t.Template())
+	return err
+}
+
+// Template returns the subject and the bodies of the template.
+func (t *EmailTemplate) Template() templates.Template {
+	return templates.Template{Subject: t.Subject, Text: t.TextBody, HTML: t.HTMLBody}
+}
+
+func createEmailTemplates(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE email_templates (
+			id 			INTEGER PRIMARY KEY,
+			name 		TEXT UNIQUE,
+			subject 	TEXT,
+			text_body 	TEXT,
+			html_body 	TEXT,
+			created_at 	INTEGER,
+			updated_at 	INTEGER
+		);
+	`)
+	return err
+}
+
+func dropEmailTemplates(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `DROP TABLE email_templates`)
+	return err
+}
+
+const templateColumns = `id, name, subject, text_body, html_body, created_at, updated_at`
+
+func templateFromRow(row interface{ Scan(...any) error }) (*EmailTemplate, error) {
+	var (
+		t                    EmailTemplate
+		createdAt, updatedAt int64
+	)
+	if err := row.Scan(&t.Id, &t.Name, &t.Subject, &t.TextBody, &t.HTMLBody, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	t.CreatedAt = time.Unix(createdAt, 0)
+	t.UpdatedAt = time.Unix(updatedAt, 0)
+	return &t, nil
+}
+
+func CreateEmailTemplate(ctx context.Context, db *sql.DB, t EmailTemplate) (*EmailTemplate, error) {
+	now := time.Now().Unix()
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO email_templates (name, subject, text_body, html_body, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, t.Name, t.Subject, t.TextBody, t.HTMLBody, now, now)
+	if IsUniqueViolation(err) {
+		return nil, ErrTemplateExists
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("creating template", "template", t.Name, "err", err)
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return GetEmailTemplate(ctx, db, id)
+}
+
+func GetEmailTemplate(ctx context.Context, db *sql.DB, id int64) (*EmailTemplate, error) {
+	return getEmailTemplate(ctx, db, `id = ?`, id)
+}
+
+// GetEmailTemplateByName returns the template of the name.
+func GetEmailTemplateByName(ctx context.Context, db *sql.DB, name string) (*EmailTemplate, error) {
+	return getEmailTemplate(ctx, db, `name = ?`, name)
+}
+
+func getEmailTemplate(ctx context.Context, db *sql.DB, where string, arg any) (*EmailTemplate, error) {
+	row := db.QueryRowContext(ctx, `SELECT `+templateColumns+` FROM email_templates WHERE `+where, arg)
+	t, err := templateFromRow(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrTemplateNotFound
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("getting template", "template", arg, "err", err)
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetEmailTemplates returns the templates by name.
+func GetEmailTemplates(ctx context.Context, db *sql.DB) ([]*EmailTemplate, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+templateColumns+` FROM email_templates ORDER BY name ASC`)
+	if err != nil {
+		logging.FromContext(ctx).Error("getting templates", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]*EmailTemplate, 0)
+	for rows.Next() {
+		t, err := templateFromRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+func UpdateEmailTemplate(ctx context.Context, db *sql.DB, id int64, t EmailTemplate) (*EmailTemplate, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE email_templates SET name = ?, subject = ?, text_body = ?, html_body = ?, updated_at = ?
+		WHERE id = ?
+	`, t.Name, t.Subject, t.TextBody, t.HTMLBody, time.Now().Unix(), id)
+	if err := changedTemplate(ctx, "updating template", id, res, err); err != nil {
+		return nil, err
+	}
+	return GetEmailTemplate(ctx, db, id)
+}
+
+func DeleteEmailTemplate(ctx context.Context, db *sql.DB, id int64) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM email_templates WHERE id = ?`, id)
+	return changedTemplate(ctx, "deleting template", id, res, err)
+}
+
+func changedTemplate(ctx context.Context, action string, id int64, res sql.Result, err error) error {
+	if IsUniqueViolation(err) {
+		return ErrTemplateExists
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error(action, "id", id, "err", err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	return ErrTemplateNotFound
+}
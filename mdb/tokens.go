@@ -0,0 +1,165 @@
+package mdb
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"mailinglist/logging"
+	"time"
+)
+
+// ErrInvalidToken is returned for unknown or already used tokens.
+var ErrInvalidToken = errors.New("invalid or used token")
+
+// UnsubscribeReason is recorded as opt-out reason of unsubscribed emails.
+const UnsubscribeReason = "unsubscribe"
+
+// EmailTokens are the secrets put in the confirmation and unsubscribe links
+// sent to an email. The confirm token is cleared once used.
+type EmailTokens struct {
+	ConfirmToken     string
+	UnsubscribeToken string
+}
+
+func tryCreateTokens(s *schemaTx) {
+	s.tryExec(`ALTER TABLE emails ADD COLUMN confirm_token TEXT;`)
+	s.tryExec(`ALTER TABLE emails ADD COLUMN unsubscribe_token TEXT;`)
+	s.tryExec(`CREATE UNIQUE INDEX emails_confirm_token ON emails (confirm_token);`)
+	s.tryExec(`CREATE UNIQUE INDEX emails_unsubscribe_token ON emails (unsubscribe_token);`)
+}
+
+func createConfirmCooldown(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `ALTER TABLE emails ADD COLUMN confirm_sent_at INTEGER;`)
+	return err
+}
+
+func dropConfirmCooldown(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `ALTER TABLE emails DROP COLUMN confirm_sent_at;`)
+	return err
+}
+
+func newToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// GetEmailTokens returns the tokens of the email, generating the missing
+// ones. No confirm token is generated for emails already confirmed.
+func GetEmailTokens(ctx context.Context, db *sql.DB, email string) (*EmailTokens, error) {
+	confirmToken, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	unsubscribeToken, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.ExecContext(ctx, `
+		UPDATE emails
+			SET confirm_token = CASE WHEN confirmed_at = 0 THEN COALESCE(confirm_token, ?) END,
+				unsubscribe_token = COALESCE(unsubscribe_token, ?)
+		WHERE email = ? AND deleted_at IS NULL
+	`, confirmToken, unsubscribeToken, email)
+	if err != nil {
+		logging.FromContext(ctx).Error("generating tokens", "email", email, "err", err)
+		return nil, err
+	}
+
+	var (
+		tokens  EmailTokens
+		confirm sql.NullString
+	)
+	err = db.QueryRowContext(ctx, `
+		SELECT confirm_token, unsubscribe_token FROM emails WHERE email = ? AND deleted_at IS NULL
+	`, email).Scan(&confirm, &tokens.UnsubscribeToken)
+	if err == sql.ErrNoRows {
+		return nil, ErrEmailNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	tokens.ConfirmToken = confirm.String
+	return &tokens, nil
+}
+
+// ClaimConfirmation records a confirmation sent to the email now, unless
+// one was sent less than cooldown ago, and reports whether it did. It
+// fails with ErrEmailNotFound for an unknown email.
+func ClaimConfirmation(ctx context.Context, db *sql.DB, email string, cooldown time.Duration) (bool, error) {
+	now := time.Now()
+	res, err := db.ExecContext(ctx, `
+		UPDATE emails SET confirm_sent_at = ?
+		WHERE email = ? AND deleted_at IS NULL AND COALESCE(confirm_sent_at, 0) <= ?
+	`, now.Unix(), email, now.Add(-cooldown).Unix())
+	if err != nil {
+		logging.FromContext(ctx).Error("claiming confirmation", "email", email, "err", err)
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return n > 0, err
+	}
+
+	entry, err := GetEmail(ctx, db, email)
+	if err != nil {
+		return false, err
+	}
+	if entry == nil {
+		return false, ErrEmailNotFound
+	}
+	return false, nil
+}
+
+// ReleaseConfirmation forgets the confirmation claimed for the email, when
+// it could not be sent, for it to be sent again without waiting.
+func ReleaseConfirmation(ctx context.Context, db *sql.DB, email string) error {
+	_, err := db.ExecContext(ctx, `UPDATE emails SET confirm_sent_at = NULL WHERE email = ? AND deleted_at IS NULL`, email)
+	if err != nil {
+		logging.FromContext(ctx).Error("releasing confirmation", "email", email, "err", err)
+	}
+	return err
+}
+
+// ConfirmEmail confirms the email holding the confirm token and returns it.
+func ConfirmEmail(ctx context.Context, db *sql.DB, token string) (string, error) {
+	var email string
+	err := db.QueryRowContext(ctx, `
+		UPDATE emails SET confirmed_at = ?, confirm_token = NULL
+		WHERE confirm_token = ? AND deleted_at IS NULL
+		RETURNING email
+	`, time.Now().Unix(), token).Scan(&email)
+
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidToken
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("confirming email", "err", err)
+		return "", err
+	}
+	return email, nil
+}
+
+// UnsubscribeByToken opts out the email holding the unsubscribe token and
+// returns it. The token stays valid, so unsubscribing twice is a no-op.
+func UnsubscribeByToken(ctx context.Context, db *sql.DB, token string) (string, error) {
+	var email string
+	err := db.QueryRowContext(ctx, `
+		UPDATE emails SET opt_out = true, opt_out_reason = ?
+		WHERE unsubscribe_token = ? AND deleted_at IS NULL
+		RETURNING email
+	`, UnsubscribeReason, token).Scan(&email)
+
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidToken
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("unsubscribing email", "err", err)
+		return "", err
+	}
+	return email, nil
+}
@@ -0,0 +1,117 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mailinglist/logging"
+	"time"
+)
+
+// ErrNotInTrash is returned when restoring an email that is not deleted
+// or whose retention window has already passed.
+var ErrNotInTrash = errors.New("email not found in trash")
+
+type TrashEntry struct {
+	EmailEntry
+	DeletedAt time.Time
+}
+
+// GetTrash returns the emails deleted after the given time, most recent first.
+func GetTrash(ctx context.Context, db *sql.DB, since time.Time) ([]*TrashEntry, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, email, confirmed_at, opt_out, COALESCE(opt_out_reason, ''), created_at, deleted_at FROM emails
+		WHERE deleted_at >= ? ORDER BY deleted_at DESC, id ASC
+	`, since.Unix())
+
+	if err != nil {
+		logging.FromContext(ctx).Error("getting trash", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*TrashEntry, 0)
+	for rows.Next() {
+		var (
+			entry       TrashEntry
+			confirmedAt int64
+			createdAt   sql.NullInt64
+			deletedAt   int64
+		)
+		err := rows.Scan(&entry.Id, &entry.Email, &confirmedAt, &entry.OptOut, &entry.OptOutReason, &createdAt, &deletedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		t := time.Unix(confirmedAt, 0)
+		entry.ConfirmedAt = &t
+		if createdAt.Valid {
+			c := time.Unix(createdAt.Int64, 0)
+			entry.CreatedAt = &c
+		}
+		entry.DeletedAt = time.Unix(deletedAt, 0)
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// RestoreEmail takes the email back out of the trash, provided it was
+// deleted after the given time.
+func RestoreEmail(ctx context.Context, db *sql.DB, id int64, since time.Time) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE emails SET deleted_at = NULL WHERE id = ? AND deleted_at >= ?
+	`, id, since.Unix())
+
+	if err != nil {
+		logging.FromContext(ctx).Error("restoring email", "id", id, "err", err)
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotInTrash
+	}
+	return nil
+}
+
+// purgeTrashed permanently removes the email when it is in the trash, for
+// it to be created again as a new subscription, without the tags, lists
+// and fields it had.
+func purgeTrashed(ctx context.Context, db execer, email string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM emails WHERE email = ? AND deleted_at IS NOT NULL`, email)
+	return err
+}
+
+// PurgeTrash permanently removes emails deleted before the given time.
+func PurgeTrash(ctx context.Context, db *sql.DB, before time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		DELETE FROM emails WHERE deleted_at < ?
+	`, before.Unix())
+
+	if err != nil {
+		logging.FromContext(ctx).Error("purging trash", "err", err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// TrashUnconfirmed moves to the trash the subscriptions created before the
+// given time and never confirmed. The opted out emails are kept, as they
+// record the wish not to be mailed.
+func TrashUnconfirmed(ctx context.Context, db *sql.DB, before time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE emails SET deleted_at = ?
+		WHERE deleted_at IS NULL AND NOT COALESCE(opt_out, false)
+			AND COALESCE(confirmed_at, 0) = 0 AND created_at < ?
+	`, time.Now().Unix(), before.Unix())
+
+	if err != nil {
+		logging.FromContext(ctx).Error("trashing unconfirmed emails", "err", err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,146 @@
+package mdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+// trashedDB returns a database whose email jane@example.com is in the
+// trash, and the id of the email.
+func trashedDB(t *testing.T) (*sql.DB, int64) {
+	t.Helper()
+	ctx := context.Background()
+	db := migratedDB(t)
+	if err := CreateEmail(ctx, db, "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := GetEmail(ctx, db, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteEmailByEmail(ctx, db, "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	return db, entry.Id
+}
+
+func TestGetTrash(t *testing.T) {
+	ctx := context.Background()
+	db, id := trashedDB(t)
+
+	tests := []struct {
+		name  string
+		since time.Time
+		want  bool
+	}{
+		{name: "within the retention", since: time.Now().Add(-time.Hour), want: true},
+		{name: "past the retention", since: time.Now().Add(time.Hour)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trash, err := GetTrash(ctx, db, test.since)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.want != (len(trash) == 1 && trash[0].Id == id && trash[0].Email == "jane@example.com") {
+				t.Errorf("GetTrash = %+v, want jane@example.com %v", trash, test.want)
+			}
+		})
+	}
+}
+
+func TestRestoreEmail(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		id    func(id int64) int64
+		since time.Time
+		err   error
+	}{
+		{name: "in the trash", since: time.Now().Add(-time.Hour)},
+		{name: "past the retention", since: time.Now().Add(time.Hour), err: ErrNotInTrash},
+		{name: "unknown", id: func(id int64) int64 { return id + 1 }, since: time.Now().Add(-time.Hour), err: ErrNotInTrash},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, id := trashedDB(t)
+			if test.id != nil {
+				id = test.id(id)
+			}
+			if err := RestoreEmail(ctx, db, id, test.since); !errors.Is(err, test.err) {
+				t.Fatalf("RestoreEmail = %v, want %v", err, test.err)
+			}
+
+			entry, err := GetEmail(ctx, db, "jane@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored := entry != nil; restored != (test.err == nil) {
+				t.Errorf("GetEmail = %+v, want restored %v", entry, test.err == nil)
+			}
+			if err := RestoreEmail(ctx, db, id, test.since); !errors.Is(err, ErrNotInTrash) {
+				t.Errorf("RestoreEmail again = %v, want %v", err, ErrNotInTrash)
+			}
+		})
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		before time.Time
+		purged int64
+	}{
+		{name: "deleted before", before: time.Now().Add(time.Hour), purged: 1},
+		{name: "deleted after", before: time.Now().Add(-time.Hour)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, id := trashedDB(t)
+			if err := CreateEmail(ctx, db, "john@example.com"); err != nil {
+				t.Fatal(err)
+			}
+			purged, err := PurgeTrash(ctx, db, test.before)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if purged != test.purged {
+				t.Errorf("PurgeTrash = %v, want %v", purged, test.purged)
+			}
+			err = RestoreEmail(ctx, db, id, time.Time{})
+			if purged := errors.Is(err, ErrNotInTrash); purged != (test.purged == 1) {
+				t.Errorf("RestoreEmail = %v, want purged %v", err, test.purged == 1)
+			}
+			if entry, err := GetEmail(ctx, db, "john@example.com"); err != nil || entry == nil {
+				t.Errorf("GetEmail of the email not deleted = %+v, %v", entry, err)
+			}
+		})
+	}
+}
+
+// The emails in the trash are not changed until restored.
+func TestUpdateTrashed(t *testing.T) {
+	ctx := context.Background()
+	db, id := trashedDB(t)
+	now := time.Now()
+	entry := EmailEntry{Email: "jane@example.com", ConfirmedAt: &now}
+	if err := UpdateEmail(ctx, db, entry, id); !errors.Is(err, ErrEmailNotFound) {
+		t.Errorf("UpdateEmail of a trashed email = %v, want %v", err, ErrEmailNotFound)
+	}
+	if err := UpdateEmail(ctx, db, entry, id+1); !errors.Is(err, ErrEmailNotFound) {
+		t.Errorf("UpdateEmail of an unknown email = %v, want %v", err, ErrEmailNotFound)
+	}
+	if err := RestoreEmail(ctx, db, id, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateEmail(ctx, db, entry, id); err != nil {
+		t.Errorf("UpdateEmail of a restored email = %v", err)
+	}
+}
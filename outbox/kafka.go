@@ -0,0 +1,41 @@
+package outbox
+
+import (
+	"context"
+	"mailinglist/mdb"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type kafkaSink struct {
+	writer *kafka.Writer
+}
+
+// NewKafka returns a sink publishing to the topic of the brokers, keyed by
+// email so that the events of an email stay in one partition.
+func NewKafka(brokers []string, topic string) Sink {
+	return &kafkaSink{
+		writer: kafka.NewWriter(kafka.WriterConfig{
+			Brokers:  brokers,
+			Topic:    topic,
+			Balancer: &kafka.Hash{},
+			// Acknowledged by all the in-sync replicas.
+			RequiredAcks: -1,
+			BatchSize:    batchSize,
+			BatchTimeout: 10 * time.Millisecond,
+		}),
+	}
+}
+
+func (s *kafkaSink) Publish(ctx context.Context, outbox []*mdb.OutboxMessage) error {
+	messages := make([]kafka.Message, len(outbox))
+	for i, message := range outbox {
+		messages[i] = kafka.Message{Key: []byte(message.Email), Value: []byte(message.Payload)}
+	}
+	return s.writer.WriteMessages(ctx, messages...)
+}
+
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
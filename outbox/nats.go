@@ -0,0 +1,94 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mailinglist/mdb"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// flushTimeout bounds the wait for the NATS server to receive the events.
+const flushTimeout = 10 * time.Second
+
+type natsSink struct {
+	conn    *nats.Conn
+	js      jetstream.JetStream
+	subject string
+}
+
+// NewNats connects to the NATS server at url and returns a sink publishing
+// each event to the subject followed by its kind, e.g.
+// mailinglist.email-events.unsubscribed. With a stream, the events are
+// kept by JetStream in that stream, created over all the subjects of the
+// events when missing, which also drops the duplicates.
+func NewNats(ctx context.Context, url, subject, stream string) (Sink, error) {
+	conn, err := nats.Connect(url, nats.Name("mailinglist"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to nats at %v : %w", url, err)
+	}
+	sink := &natsSink{conn: conn, subject: subject}
+	if stream == "" {
+		return sink, nil
+	}
+
+	if sink.js, err = jetstream.New(conn); err == nil {
+		// An existing stream is left as configured.
+		_, err = sink.js.Stream(ctx, stream)
+		if errors.Is(err, jetstream.ErrStreamNotFound) {
+			_, err = sink.js.CreateStream(ctx, jetstream.StreamConfig{
+				Name:     stream,
+				Subjects: []string{subject + ".>"},
+			})
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("setting up the jetstream stream %v : %w", stream, err)
+	}
+	return sink, nil
+}
+
+func (s *natsSink) Publish(ctx context.Context, outbox []*mdb.OutboxMessage) error {
+	if s.js == nil {
+		for _, message := range outbox {
+			if err := s.conn.Publish(s.subject+"."+message.Kind, []byte(message.Payload)); err != nil {
+				return err
+			}
+		}
+		// Returns once the server has them, without subscribers acknowledging.
+		ctx, cancel := context.WithTimeout(ctx, flushTimeout)
+		defer cancel()
+		return s.conn.FlushWithContext(ctx)
+	}
+
+	// The messages are sent at once, in order, then their acks awaited.
+	acks := make([]jetstream.PubAckFuture, len(outbox))
+	for i, message := range outbox {
+		var err error
+		acks[i], err = s.js.PublishAsync(s.subject+"."+message.Kind, []byte(message.Payload),
+			jetstream.WithMsgID(strconv.FormatInt(message.Id, 10)))
+		if err != nil {
+			return err
+		}
+	}
+	for _, ack := range acks {
+		select {
+		case <-ack.Ok():
+		case err := <-ack.Err():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *natsSink) Close() error {
+	// Drain flushes the messages not sent yet.
+	return s.conn.Drain()
+}
@@ -0,0 +1,122 @@
+// Package outbox publishes to Kafka or NATS the email events written to
+// the outbox table in the transactions of the changes. The messages leave
+// the outbox once the sink has acknowledged them, so that every event is
+// published at least once and in order for each email; the consumers drop
+// the few duplicates by the seq of the events.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"mailinglist/crash"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"mailinglist/scheduler"
+	"time"
+)
+
+const (
+	pollInterval = 500 * time.Millisecond
+	retryDelay   = 5 * time.Second
+	batchSize    = 100
+)
+
+// Sink is where the events are published.
+type Sink interface {
+	// Publish returns once all the messages are acknowledged, in order.
+	Publish(ctx context.Context, messages []*mdb.OutboxMessage) error
+	Close() error
+}
+
+// Relay moves the messages of the outbox to a sink.
+type Relay struct {
+	db      *sql.DB
+	sink    Sink
+	logger  *slog.Logger
+	elector scheduler.Elector
+}
+
+// NewRelay returns a relay publishing to the sink.
+func NewRelay(db *sql.DB, sink Sink, logger *slog.Logger) *Relay {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Relay{
+		db:     db,
+		sink:   sink,
+		logger: logger.With("component", "outbox"),
+	}
+}
+
+// SetElector makes the relay publish only while the elector tells that this
+// server is the leader, before Run, so that the servers sharing the
+// database do not publish the events twice.
+func (r *Relay) SetElector(elector scheduler.Elector) {
+	r.elector = elector
+}
+
+// Run publishes the outbox until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	ctx = logging.NewContext(ctx, r.logger)
+	if count, err := mdb.CountOutbox(ctx, r.db); err == nil && count > 0 {
+		r.logger.Info("publishing the outbox", "count", count)
+	}
+
+	for {
+		delay := pollInterval
+		if r.elector == nil || r.elector.IsLeader() {
+			published, err := r.safePublish(ctx)
+			if err != nil && ctx.Err() == nil {
+				r.logger.Error("publishing the outbox failed", "err", err)
+				delay = retryDelay
+			}
+			if published == batchSize {
+				delay = 0
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// safePublish is publish, a panic failing the batch rather than the relay.
+// The batch is published again.
+func (r *Relay) safePublish(ctx context.Context) (published int, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			crash.Handle(ctx, v, "component", "outbox-relay")
+			published, err = 0, fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return r.publish(ctx)
+}
+
+// publish sends the oldest messages of the outbox, returning how many.
+func (r *Relay) publish(ctx context.Context) (int, error) {
+	outbox, err := mdb.GetOutbox(ctx, r.db, batchSize)
+	if err != nil || len(outbox) == 0 {
+		return 0, err
+	}
+
+	if err := r.sink.Publish(ctx, outbox); err != nil {
+		return 0, err
+	}
+
+	// Failing here publishes the messages again.
+	if err := mdb.DeleteOutbox(ctx, r.db, outbox[len(outbox)-1].Id); err != nil {
+		return 0, err
+	}
+	r.logger.Debug("outbox published", "count", len(outbox))
+	return len(outbox), nil
+}
+
+// Close flushes and closes the connections of the sink.
+func (r *Relay) Close() error {
+	return r.sink.Close()
+}
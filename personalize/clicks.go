@@ -0,0 +1,101 @@
+package personalize
+
+import (
+	"context"
+	"crypto/hmac"
+	"fmt"
+	"html"
+	"mailinglist/mdb"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// anchorHref matches the href of the anchors of an HTML body, quoted with
+// double or single quotes.
+var anchorHref = regexp.MustCompile(`(?is)(<a\s[^>]*?\bhref\s*=\s*)(?:"([^"]*)"|'([^']*)')`)
+
+// TracksClicks reports whether the links of the campaigns go through the
+// click redirect.
+func (p *Personalizer) TracksClicks() bool {
+	return p.trackClicks && p.publicURL != "" && len(p.secret) > 0
+}
+
+// TrackClicks returns the HTML body of a message of the campaign to the
+// email with its http and https links going through the click redirect,
+// /t/click/{message}?u={url}&s={signature}, the message token telling the
+// campaign and the email, and the signature of the token and the URL
+// keeping the redirect from sending anywhere else. The links to the
+// public URL, e.g. the unsubscribe one, are left. The body is left as is
+// unless the personalizer tracks the clicks. It fails with
+// mdb.ErrEmailNotFound for an email deleted.
+func (p *Personalizer) TrackClicks(ctx context.Context, body string, campaignId int64, email string) (string, error) {
+	if !p.TracksClicks() || body == "" {
+		return body, nil
+	}
+	token, err := p.messageToken(ctx, campaignId, email)
+	if err != nil {
+		return "", err
+	}
+	return anchorHref.ReplaceAllStringFunc(body, func(anchor string) string {
+		match := anchorHref.FindStringSubmatch(anchor)
+		target := html.UnescapeString(match[2] + match[3])
+		if !trackable(target) || strings.HasPrefix(target, p.publicURL+"/") {
+			return anchor
+		}
+		link := p.publicURL + "/t/click/" + token + "?u=" + url.QueryEscape(target) + "&s=" + p.signClick(token, target)
+		return match[1] + `"` + html.EscapeString(link) + `"`
+	}), nil
+}
+
+// Click returns the campaign and the email of the message token of a click
+// link, once the signature of the token and of the URL the link goes to is
+// checked. It fails with mdb.ErrInvalidToken for the links not signed with
+// the secret.
+func (p *Personalizer) Click(token, target, signature string) (campaignId, emailId int64, err error) {
+	if len(p.secret) == 0 || !trackable(target) || !hmac.Equal([]byte(p.signClick(token, target)), []byte(signature)) {
+		return 0, 0, mdb.ErrInvalidToken
+	}
+	return parseMessageToken(token)
+}
+
+// messageToken returns the token of the message of the campaign to the
+// email, {campaign}-{email id}, failing with mdb.ErrEmailNotFound for an
+// email deleted.
+func (p *Personalizer) messageToken(ctx context.Context, campaignId int64, email string) (string, error) {
+	entry, err := mdb.GetEmail(ctx, p.db, email)
+	if err != nil {
+		return "", err
+	}
+	if entry == nil {
+		return "", mdb.ErrEmailNotFound
+	}
+	return fmt.Sprintf("%d-%d", campaignId, entry.Id), nil
+}
+
+// parseMessageToken returns the campaign and the email of a message token.
+func parseMessageToken(token string) (campaignId, emailId int64, err error) {
+	campaign, email, ok := strings.Cut(token, "-")
+	if !ok {
+		return 0, 0, mdb.ErrInvalidToken
+	}
+	if campaignId, err = strconv.ParseInt(campaign, 10, 64); err != nil {
+		return 0, 0, mdb.ErrInvalidToken
+	}
+	if emailId, err = strconv.ParseInt(email, 10, 64); err != nil {
+		return 0, 0, mdb.ErrInvalidToken
+	}
+	return campaignId, emailId, nil
+}
+
+func (p *Personalizer) signClick(token, target string) string {
+	return p.sign(token, "click "+target)
+}
+
+// trackable reports whether the clicks of the link can be tracked, an
+// absolute http or https URL.
+func trackable(target string) bool {
+	u, err := url.Parse(target)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
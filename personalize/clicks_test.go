@@ -0,0 +1,93 @@
+package personalize
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html"
+	"mailinglist/mdb"
+	"net/url"
+	"regexp"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", t.TempDir()+"/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := mdb.Migrate(context.Background(), db, mdb.SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+var trackedLink = regexp.MustCompile(`href="https://lists\.example\.com/t/click/([^"?]+)\?([^"]+)"`)
+
+func TestTrackClicks(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	if err := mdb.CreateEmail(ctx, db, "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := mdb.GetEmail(ctx, db, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := New(db, Options{PublicURL: "https://lists.example.com", LinkSecret: "secret", TrackClicks: true})
+
+	body := `<a href="https://example.com/a?x=1&amp;y=2">A</a> <a href='mailto:jane@example.com'>B</a> ` +
+		`<a href="https://lists.example.com/unsubscribe/tok">C</a>`
+	tracked, err := p.TrackClicks(ctx, body, 7, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := trackedLink.FindAllStringSubmatch(tracked, -1)
+	if len(links) != 1 {
+		t.Fatalf("TrackClicks = %v, want one link tracked", tracked)
+	}
+	query, err := url.ParseQuery(html.UnescapeString(links[0][2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Get("u") != "https://example.com/a?x=1&y=2" {
+		t.Errorf("link to %v, want https://example.com/a?x=1&y=2", query.Get("u"))
+	}
+
+	campaignId, emailId, err := p.Click(links[0][1], query.Get("u"), query.Get("s"))
+	if err != nil || campaignId != 7 || emailId != entry.Id {
+		t.Errorf("Click = %v, %v, %v, want 7, %v", campaignId, emailId, err, entry.Id)
+	}
+	// The signature holds for its URL only, so that the redirect sends
+	// nowhere else.
+	if _, _, err := p.Click(links[0][1], "https://evil.example.net/", query.Get("s")); !errors.Is(err, mdb.ErrInvalidToken) {
+		t.Errorf("Click to another URL = %v, want %v", err, mdb.ErrInvalidToken)
+	}
+	if _, _, err := p.Click("7-999", query.Get("u"), query.Get("s")); !errors.Is(err, mdb.ErrInvalidToken) {
+		t.Errorf("Click of another token = %v, want %v", err, mdb.ErrInvalidToken)
+	}
+
+	if _, err := p.TrackClicks(ctx, body, 7, "john@example.com"); !errors.Is(err, mdb.ErrEmailNotFound) {
+		t.Errorf("TrackClicks of an unknown email = %v, want %v", err, mdb.ErrEmailNotFound)
+	}
+}
+
+func TestTrackClicksDisabled(t *testing.T) {
+	body := `<a href="https://example.com/">A</a>`
+	for _, opts := range []Options{
+		{PublicURL: "https://lists.example.com", LinkSecret: "secret"},
+		{PublicURL: "https://lists.example.com", TrackClicks: true},
+	} {
+		p := New(nil, opts)
+		if tracked, err := p.TrackClicks(context.Background(), body, 7, "jane@example.com"); err != nil || tracked != body {
+			t.Errorf("TrackClicks with %+v = %v, %v, want the body as is", opts, tracked, err)
+		}
+		if _, _, err := p.Click("7-1", "https://example.com/", "sig"); !errors.Is(err, mdb.ErrInvalidToken) {
+			t.Errorf("Click with %+v = %v, want %v", opts, err, mdb.ErrInvalidToken)
+		}
+	}
+}
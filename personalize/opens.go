@@ -0,0 +1,52 @@
+package personalize
+
+import (
+	"context"
+	"crypto/hmac"
+	"html"
+	"mailinglist/mdb"
+	"regexp"
+)
+
+// bodyEnd matches the closing body tag of an HTML body.
+var bodyEnd = regexp.MustCompile(`(?i)</body\s*>`)
+
+// TracksOpens reports whether the HTML bodies of the campaigns carry a
+// tracking pixel.
+func (p *Personalizer) TracksOpens() bool {
+	return p.trackOpens && p.publicURL != "" && len(p.secret) > 0
+}
+
+// TrackOpens returns the HTML body of a message of the campaign to the
+// email with a tracking pixel, an image of /t/open/{message}?s={signature}
+// before the closing body tag, else at the end, the message token telling
+// the campaign and the email. The body is left as is unless the
+// personalizer tracks the opens. It fails with mdb.ErrEmailNotFound for an
+// email deleted.
+func (p *Personalizer) TrackOpens(ctx context.Context, body string, campaignId int64, email string) (string, error) {
+	if !p.TracksOpens() || body == "" {
+		return body, nil
+	}
+	token, err := p.messageToken(ctx, campaignId, email)
+	if err != nil {
+		return "", err
+	}
+
+	link := p.publicURL + "/t/open/" + token + "?s=" + p.sign(token, "open")
+	pixel := `<img src="` + html.EscapeString(link) + `" width="1" height="1" alt="" style="border:0">`
+	if loc := bodyEnd.FindAllStringIndex(body, -1); len(loc) > 0 {
+		at := loc[len(loc)-1][0]
+		return body[:at] + pixel + body[at:], nil
+	}
+	return body + pixel, nil
+}
+
+// Open returns the campaign and the email of the message token of a
+// tracking pixel, once its signature is checked. It fails with
+// mdb.ErrInvalidToken for the pixels not signed with the secret.
+func (p *Personalizer) Open(token, signature string) (campaignId, emailId int64, err error) {
+	if len(p.secret) == 0 || !hmac.Equal([]byte(p.sign(token, "open")), []byte(signature)) {
+		return 0, 0, mdb.ErrInvalidToken
+	}
+	return parseMessageToken(token)
+}
@@ -0,0 +1,75 @@
+package personalize
+
+import (
+	"context"
+	"errors"
+	"html"
+	"mailinglist/mdb"
+	"regexp"
+	"testing"
+)
+
+var trackingPixel = regexp.MustCompile(`<img src="https://lists\.example\.com/t/open/([^"?]+)\?s=([^"]+)"[^>]*>`)
+
+func TestTrackOpens(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	if err := mdb.CreateEmail(ctx, db, "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := mdb.GetEmail(ctx, db, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := New(db, Options{PublicURL: "https://lists.example.com", LinkSecret: "secret", TrackOpens: true})
+
+	tests := []struct {
+		name string
+		body string
+		// before is what the pixel is inserted before.
+		before string
+	}{
+		{name: "document", body: "<html><body><p>Hi</p></BODY></html>", before: "</BODY></html>"},
+		{name: "fragment", body: "<p>Hi</p>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracked, err := p.TrackOpens(ctx, test.body, 7, "jane@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			loc := trackingPixel.FindStringSubmatchIndex(tracked)
+			if loc == nil || tracked[:loc[0]]+tracked[loc[1]:] != test.body || tracked[loc[1]:] != test.before {
+				t.Fatalf("TrackOpens = %v, want the pixel before %q", tracked, test.before)
+			}
+			token, signature := tracked[loc[2]:loc[3]], html.UnescapeString(tracked[loc[4]:loc[5]])
+			campaignId, emailId, err := p.Open(token, signature)
+			if err != nil || campaignId != 7 || emailId != entry.Id {
+				t.Errorf("Open = %v, %v, %v, want 7, %v", campaignId, emailId, err, entry.Id)
+			}
+			if _, _, err := p.Open("7-999", signature); !errors.Is(err, mdb.ErrInvalidToken) {
+				t.Errorf("Open of another token = %v, want %v", err, mdb.ErrInvalidToken)
+			}
+		})
+	}
+
+	if _, err := p.TrackOpens(ctx, "<p>Hi</p>", 7, "john@example.com"); !errors.Is(err, mdb.ErrEmailNotFound) {
+		t.Errorf("TrackOpens of an unknown email = %v, want %v", err, mdb.ErrEmailNotFound)
+	}
+}
+
+func TestTrackOpensDisabled(t *testing.T) {
+	body := "<p>Hi</p>"
+	for _, opts := range []Options{
+		{PublicURL: "https://lists.example.com", LinkSecret: "secret", TrackClicks: true},
+		{PublicURL: "https://lists.example.com", TrackOpens: true},
+	} {
+		p := New(nil, opts)
+		if tracked, err := p.TrackOpens(context.Background(), body, 7, "jane@example.com"); err != nil || tracked != body {
+			t.Errorf("TrackOpens with %+v = %v, %v, want the body as is", opts, tracked, err)
+		}
+		if _, _, err := p.Open("7-1", "sig"); !errors.Is(err, mdb.ErrInvalidToken) {
+			t.Errorf("Open with %+v = %v, want %v", opts, err, mdb.ErrInvalidToken)
+		}
+	}
+}
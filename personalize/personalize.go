@@ -0,0 +1,208 @@
+// Package personalize gathers the merge tags of a subscriber, from their
+// fields and tags, with their own unsubscribe and confirmation links, and
+// the List-Unsubscribe headers of the messages sent to them. The links of
+// the campaigns can go through a click redirect, tracking who opens them.
+package personalize
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"mailinglist/mdb"
+	"mailinglist/templates"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrLinkExpired is returned for the signed confirmation links past their
+// expiry.
+var ErrLinkExpired = errors.New("expired link")
+
+// Options sets the links of the personalizer.
+type Options struct {
+	// PublicURL is the base of the links, the address of the JSON server
+	// for the subscribers, no links being given when empty.
+	PublicURL string
+	// LinkSecret signs the links, the confirmation ones then expiring
+	// after ConfirmExpiry. They hold the bare tokens when empty.
+	LinkSecret    string
+	ConfirmExpiry time.Duration
+	// UnsubscribeMailto is the address of the mailto: List-Unsubscribe,
+	// e.g. unsubscribe@example.com, next to the link. None when empty.
+	UnsubscribeMailto string
+	// TrackClicks sends the links of the campaigns through the click
+	// redirect, which needs the link secret.
+	TrackClicks bool
+	// TrackOpens adds a tracking pixel to the HTML bodies of the
+	// campaigns, which needs the link secret.
+	TrackOpens bool
+}
+
+// Recipient is the personalization of a message for its recipient.
+type Recipient struct {
+	Data templates.Data
+	// Headers are the List-Unsubscribe ones, nil without links.
+	Headers map[string]string
+}
+
+// Personalizer returns the merge tags of the subscribers.
+type Personalizer struct {
+	db            *sql.DB
+	publicURL     string
+	secret        []byte
+	confirmExpiry time.Duration
+	mailto        string
+	trackClicks   bool
+	trackOpens    bool
+}
+
+// New returns the personalizer with the links of opts.
+func New(db *sql.DB, opts Options) *Personalizer {
+	return &Personalizer{
+		db:            db,
+		publicURL:     strings.TrimSuffix(opts.PublicURL, "/"),
+		secret:        []byte(opts.LinkSecret),
+		confirmExpiry: opts.ConfirmExpiry,
+		mailto:        opts.UnsubscribeMailto,
+		trackClicks:   opts.TrackClicks,
+		trackOpens:    opts.TrackOpens,
+	}
+}
+
+// Recipient returns the merge tags and the headers of the messages to
+// the email, generating its tokens when missing. It fails with
+// mdb.ErrEmailNotFound for an email deleted.
+func (p *Personalizer) Recipient(ctx context.Context, email string) (*Recipient, error) {
+	tokens, err := mdb.GetEmailTokens(ctx, p.db, email)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := mdb.GetEmailFields(ctx, p.db, email)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := mdb.GetEmailTags(ctx, p.db, email)
+	if err != nil {
+		return nil, err
+	}
+	return &Recipient{
+		Data: templates.Data{
+			Email:          email,
+			FirstName:      fields[mdb.FirstNameField],
+			Tags:           tags,
+			Fields:         fields,
+			UnsubscribeURL: p.UnsubscribeURL(tokens.UnsubscribeToken),
+			ConfirmURL:     p.ConfirmURL(tokens.ConfirmToken),
+		},
+		Headers: p.UnsubscribeHeaders(tokens.UnsubscribeToken),
+	}, nil
+}
+
+// UnsubscribeHeaders returns the List-Unsubscribe header of the messages
+// to the holder of the token, with the mailto: address and the link, and
+// the List-Unsubscribe-Post one of the one-click unsubscription of RFC
+// 8058 with the link, which is a POST to /unsubscribe/{token}.
+func (p *Personalizer) UnsubscribeHeaders(token string) map[string]string {
+	if token == "" {
+		return nil
+	}
+	var targets []string
+	if p.mailto != "" {
+		subject := url.PathEscape("unsubscribe " + p.signUnsubscribe(token))
+		targets = append(targets, "<mailto:"+p.mailto+"?subject="+subject+">")
+	}
+	link := p.UnsubscribeURL(token)
+	if link != "" {
+		targets = append(targets, "<"+link+">")
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	headers := map[string]string{"List-Unsubscribe": strings.Join(targets, ", ")}
+	if link != "" {
+		headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+	}
+	return headers
+}
+
+// UnsubscribeURL returns the link unsubscribing the holder of the token,
+// signed when the personalizer has a link secret.
+func (p *Personalizer) UnsubscribeURL(token string) string {
+	return p.link("unsubscribe", p.signUnsubscribe(token))
+}
+
+// UnsubscribeToken returns the unsubscribe token of the last element of
+// an unsubscribe link, checking its signature when it is signed. Unlike
+// the confirmation links, the bare tokens of the links sent without a
+// secret are still taken, as the unsubscribe links never expire. It fails
+// with mdb.ErrInvalidToken for a bad signature.
+func (p *Personalizer) UnsubscribeToken(signed string) (string, error) {
+	token, signature, ok := strings.Cut(signed, ".")
+	if !ok {
+		return signed, nil
+	}
+	if len(p.secret) == 0 || !hmac.Equal([]byte(p.sign(token, "unsubscribe")), []byte(signature)) {
+		return "", mdb.ErrInvalidToken
+	}
+	return token, nil
+}
+
+func (p *Personalizer) signUnsubscribe(token string) string {
+	if len(p.secret) == 0 || token == "" {
+		return token
+	}
+	return token + "." + p.sign(token, "unsubscribe")
+}
+
+// ConfirmURL returns the link confirming the holder of the token, empty for
+// the emails confirmed, which have none. The token is signed with its
+// expiry when the personalizer has a link secret.
+func (p *Personalizer) ConfirmURL(token string) string {
+	if len(p.secret) == 0 || token == "" {
+		return p.link("confirm", token)
+	}
+	expires := strconv.FormatInt(time.Now().Add(p.confirmExpiry).Unix(), 10)
+	return p.link("confirm", token+"."+expires+"."+p.sign(token, expires))
+}
+
+// ConfirmToken returns the confirm token of the last element of a
+// confirmation link, checking its signature and its expiry when the
+// personalizer has a link secret. It fails with mdb.ErrInvalidToken for
+// the links not signed with the secret, and ErrLinkExpired.
+func (p *Personalizer) ConfirmToken(signed string) (string, error) {
+	if len(p.secret) == 0 {
+		return signed, nil
+	}
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 || !hmac.Equal([]byte(p.sign(parts[0], parts[1])), []byte(parts[2])) {
+		return "", mdb.ErrInvalidToken
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", mdb.ErrInvalidToken
+	}
+	if time.Now().Unix() > expires {
+		return "", ErrLinkExpired
+	}
+	return parts[0], nil
+}
+
+// sign returns the truncated HMAC-SHA256 of the token and of its expiry,
+// or of the action of an unsubscribe token, or of the URL of a click.
+func (p *Personalizer) sign(token, suffix string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(token + "." + suffix))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func (p *Personalizer) link(action, token string) string {
+	if p.publicURL == "" || token == "" {
+		return ""
+	}
+	return p.publicURL + "/" + action + "/" + url.PathEscape(token)
+}
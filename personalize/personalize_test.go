@@ -0,0 +1,84 @@
+package personalize
+
+import (
+	"errors"
+	"mailinglist/mdb"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnsubscribeToken(t *testing.T) {
+	p := New(nil, Options{PublicURL: "https://lists.example.com/", LinkSecret: "secret"})
+	link := p.UnsubscribeURL("tok")
+	signed, ok := strings.CutPrefix(link, "https://lists.example.com/unsubscribe/")
+	if !ok || !strings.HasPrefix(signed, "tok.") {
+		t.Fatalf("UnsubscribeURL = %v", link)
+	}
+	if token, err := p.UnsubscribeToken(signed); err != nil || token != "tok" {
+		t.Errorf("UnsubscribeToken(%v) = %v, %v", signed, token, err)
+	}
+	// The bare tokens of the links sent without a secret are taken.
+	if token, err := p.UnsubscribeToken("tok"); err != nil || token != "tok" {
+		t.Errorf("UnsubscribeToken(tok) = %v, %v", token, err)
+	}
+
+	for _, forged := range []string{"other" + signed[3:], signed[:len(signed)-1] + "0", "tok."} {
+		if _, err := p.UnsubscribeToken(forged); !errors.Is(err, mdb.ErrInvalidToken) {
+			t.Errorf("UnsubscribeToken(%v) = %v, want %v", forged, err, mdb.ErrInvalidToken)
+		}
+	}
+	other := New(nil, Options{PublicURL: "https://lists.example.com", LinkSecret: "other"})
+	if _, err := other.UnsubscribeToken(signed); !errors.Is(err, mdb.ErrInvalidToken) {
+		t.Errorf("UnsubscribeToken with another secret = %v, want %v", err, mdb.ErrInvalidToken)
+	}
+}
+
+func TestUnsubscribeHeaders(t *testing.T) {
+	p := New(nil, Options{PublicURL: "https://lists.example.com", UnsubscribeMailto: "unsubscribe@example.com"})
+	headers := p.UnsubscribeHeaders("tok")
+	want := "<mailto:unsubscribe@example.com?subject=unsubscribe%20tok>, <https://lists.example.com/unsubscribe/tok>"
+	if headers["List-Unsubscribe"] != want {
+		t.Errorf("List-Unsubscribe = %v, want %v", headers["List-Unsubscribe"], want)
+	}
+	if headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" {
+		t.Errorf("List-Unsubscribe-Post = %v", headers["List-Unsubscribe-Post"])
+	}
+	if headers := New(nil, Options{}).UnsubscribeHeaders("tok"); headers != nil {
+		t.Errorf("headers without links = %v", headers)
+	}
+}
+
+func TestConfirmToken(t *testing.T) {
+	p := New(nil, Options{PublicURL: "https://lists.example.com", LinkSecret: "secret", ConfirmExpiry: time.Hour})
+	signed := strings.TrimPrefix(p.ConfirmURL("tok"), "https://lists.example.com/confirm/")
+	if token, err := p.ConfirmToken(signed); err != nil || token != "tok" {
+		t.Errorf("ConfirmToken(%v) = %v, %v", signed, token, err)
+	}
+
+	parts := strings.Split(signed, ".")
+	for _, forged := range []string{
+		"tok",
+		"other." + parts[1] + "." + parts[2],
+		parts[0] + ".9999999999." + parts[2],
+	} {
+		if _, err := p.ConfirmToken(forged); !errors.Is(err, mdb.ErrInvalidToken) {
+			t.Errorf("ConfirmToken(%v) = %v, want %v", forged, err, mdb.ErrInvalidToken)
+		}
+	}
+
+	expired := New(nil, Options{PublicURL: "https://lists.example.com", LinkSecret: "secret", ConfirmExpiry: -time.Minute})
+	signed = strings.TrimPrefix(expired.ConfirmURL("tok"), "https://lists.example.com/confirm/")
+	if _, err := p.ConfirmToken(signed); !errors.Is(err, ErrLinkExpired) {
+		t.Errorf("ConfirmToken of an expired link = %v, want %v", err, ErrLinkExpired)
+	}
+
+	// Without a secret the links hold the bare tokens.
+	bare := New(nil, Options{PublicURL: "https://lists.example.com"})
+	if link := bare.ConfirmURL("tok"); link != "https://lists.example.com/confirm/tok" {
+		t.Errorf("ConfirmURL = %v", link)
+	}
+	if token, err := bare.ConfirmToken("tok"); err != nil || token != "tok" {
+		t.Errorf("ConfirmToken(tok) = %v, %v", token, err)
+	}
+}
@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The scopes of the rules, by which the callers are told apart.
+const (
+	// ScopeCaller limits each API key, or each address without a key.
+	ScopeCaller = "caller"
+	// ScopeIP limits each address, whatever its keys.
+	ScopeIP = "ip"
+	// ScopeKey limits each API key, not the requests without one.
+	ScopeKey = "key"
+	// ScopeTenant limits each organization over all its API keys, not the
+	// requests of the keys without one.
+	ScopeTenant = "tenant"
+)
+
+// The classes of the endpoints, which rules can be restricted to.
+const (
+	ClassRead  = "read"
+	ClassWrite = "write"
+	// ClassBulk are the batches, streams, imports and exports.
+	ClassBulk = "bulk"
+)
+
+var (
+	scopes  = map[string]bool{ScopeCaller: true, ScopeIP: true, ScopeKey: true, ScopeTenant: true}
+	classes = map[string]bool{ClassRead: true, ClassWrite: true, ClassBulk: true}
+)
+
+// Rule limits the requests of each caller told apart by the scope to a
+// class of endpoints.
+type Rule struct {
+	Name  string
+	Scope string
+	// Class restricts the rule to a class of endpoints, all when empty.
+	Class string `json:",omitempty"`
+	// Rate is the sustained number of requests per second.
+	Rate float64
+	// Burst is how many requests can be made at once.
+	Burst int
+}
+
+// Request describes a request to the limits, once its API key is
+// verified: the limits are checked after the authentication, so that a
+// caller cannot pick its bucket by sending any key.
+type Request struct {
+	Addr string
+	// KeyId and Org are those of the verified API key of the request, 0
+	// and empty without one.
+	KeyId int64
+	Org   string
+	Class string
+}
+
+func (r Rule) Validate() error {
+	switch {
+	case r.Name == "":
+		return errors.New("missing rule name")
+	case !scopes[r.Scope]:
+		return fmt.Errorf("rule %v: scope must be caller, ip, key or tenant", r.Name)
+	case r.Class != "" && !classes[r.Class]:
+		return fmt.Errorf("rule %v: class must be read, write or bulk", r.Name)
+	case r.Rate <= 0 || math.IsInf(r.Rate, 0):
+		return fmt.Errorf("rule %v: rate must be positive", r.Name)
+	case r.Burst < 1:
+		return fmt.Errorf("rule %v: burst must be positive", r.Name)
+	}
+	return nil
+}
+
+// ValidateRules checks the rules and that their names are unique.
+func ValidateRules(rules []Rule) error {
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %v is defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return nil
+}
+
+// ParseRule parses a rule written scope[:class] rate[/burst], e.g.
+// "tenant:bulk 1/5". The burst defaults to the rate.
+func ParseRule(name, spec string) (Rule, error) {
+	target, limit, ok := strings.Cut(strings.TrimSpace(spec), " ")
+	if !ok {
+		return Rule{}, fmt.Errorf("rule %v: %q is not scope[:class] rate[/burst]", name, spec)
+	}
+	rule := Rule{Name: name}
+	rule.Scope, rule.Class, _ = strings.Cut(target, ":")
+
+	rate, burst, hasBurst := strings.Cut(strings.TrimSpace(limit), "/")
+	var err error
+	if rule.Rate, err = strconv.ParseFloat(rate, 64); err != nil {
+		return Rule{}, fmt.Errorf("rule %v: invalid rate %q", name, rate)
+	}
+	rule.Burst = int(math.Ceil(rule.Rate))
+	if hasBurst {
+		if rule.Burst, err = strconv.Atoi(burst); err != nil {
+			return Rule{}, fmt.Errorf("rule %v: invalid burst %q", name, burst)
+		}
+	}
+	return rule, rule.Validate()
+}
+
+// matches reports whether the rule applies to requests of the class.
+func (r Rule) matches(class string) bool {
+	return r.Class == "" || r.Class == class
+}
@@ -0,0 +1,36 @@
+package ratelimit
+
+import "testing"
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		spec string
+		rule Rule
+		err  bool
+	}{
+		{spec: "caller 10", rule: Rule{Name: "r", Scope: ScopeCaller, Rate: 10, Burst: 10}},
+		{spec: "tenant:bulk 1/5", rule: Rule{Name: "r", Scope: ScopeTenant, Class: ClassBulk, Rate: 1, Burst: 5}},
+		{spec: "ip 0.5", rule: Rule{Name: "r", Scope: ScopeIP, Rate: 0.5, Burst: 1}},
+		{spec: "ip", err: true},
+		{spec: "everyone 10", err: true},
+		{spec: "ip:admin 10", err: true},
+		{spec: "ip ten", err: true},
+		{spec: "ip 10/many", err: true},
+		{spec: "ip 0", err: true},
+		{spec: "ip 10/0", err: true},
+	}
+
+	for _, test := range tests {
+		rule, err := ParseRule("r", test.spec)
+		if (err != nil) != test.err || (err == nil && rule != test.rule) {
+			t.Errorf("ParseRule(%q) = %+v, %v", test.spec, rule, err)
+		}
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	rule := Rule{Name: "r", Scope: ScopeIP, Rate: 1, Burst: 1}
+	if err := ValidateRules([]Rule{rule, rule}); err == nil {
+		t.Error("ValidateRules of a rule defined twice succeeded")
+	}
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Proxies are the proxies trusted to tell the address of their clients in
+// X-Forwarded-For, such as the load balancers. The header of the other
+// callers is ignored, as anyone can send it.
+type Proxies []*net.IPNet
+
+// ParseProxies parses the addresses and CIDRs of the trusted proxies, e.g.
+// "10.0.0.0/8".
+func ParseProxies(specs []string) (Proxies, error) {
+	var proxies Proxies
+	for _, spec := range specs {
+		if !strings.Contains(spec, "/") {
+			ip := net.ParseIP(spec)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an address or a CIDR", spec)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(spec)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an address or a CIDR", spec)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// Trusted reports whether the address is one of a trusted proxy.
+func (p Proxies) Trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Forwarded returns the address of the client of a request forwarded by a
+// trusted proxy, from the X-Forwarded-For values: the last address of the
+// chain not of a trusted proxy, each proxy appending the address it got
+// the request from. It is the first address when all are trusted, and
+// remote without any.
+func (p Proxies) Forwarded(remote string, forwardedFor []string) string {
+	var chain []string
+	for _, value := range forwardedFor {
+		for _, addr := range strings.Split(value, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				chain = append(chain, addr)
+			}
+		}
+	}
+	if len(chain) == 0 {
+		return remote
+	}
+	for i := len(chain) - 1; i > 0; i-- {
+		if !p.Trusted(chain[i]) {
+			return chain[i]
+		}
+	}
+	return chain[0]
+}
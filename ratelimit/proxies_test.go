@@ -0,0 +1,65 @@
+package ratelimit
+
+import "testing"
+
+func TestParseProxies(t *testing.T) {
+	tests := []struct {
+		specs   []string
+		trusted []string
+		other   []string
+		err     bool
+	}{
+		{specs: []string{"10.0.0.0/8"}, trusted: []string{"10.1.2.3"}, other: []string{"192.0.2.1", "nope"}},
+		{specs: []string{"192.0.2.1"}, trusted: []string{"192.0.2.1"}, other: []string{"192.0.2.2"}},
+		{specs: []string{"2001:db8::1"}, trusted: []string{"2001:db8::1"}, other: []string{"2001:db8::2"}},
+		{specs: []string{"2001:db8::/32"}, trusted: []string{"2001:db8::2"}, other: []string{"2001:db9::1"}},
+		{specs: []string{"proxy.example.com"}, err: true},
+		{specs: []string{"10.0.0.0/33"}, err: true},
+	}
+
+	for _, test := range tests {
+		proxies, err := ParseProxies(test.specs)
+		if (err != nil) != test.err {
+			t.Errorf("ParseProxies(%v) = %v", test.specs, err)
+			continue
+		}
+		for _, addr := range test.trusted {
+			if !proxies.Trusted(addr) {
+				t.Errorf("%v: %v not trusted", test.specs, addr)
+			}
+		}
+		for _, addr := range test.other {
+			if proxies.Trusted(addr) {
+				t.Errorf("%v: %v trusted", test.specs, addr)
+			}
+		}
+	}
+}
+
+func TestForwarded(t *testing.T) {
+	proxies, err := ParseProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		forwardedFor []string
+		addr         string
+	}{
+		{name: "none", addr: "10.0.0.1"},
+		{name: "client", forwardedFor: []string{"192.0.2.1"}, addr: "192.0.2.1"},
+		{name: "through proxies", forwardedFor: []string{"192.0.2.1, 10.0.0.2", "10.0.0.3"}, addr: "192.0.2.1"},
+		// The addresses before the first untrusted one are the client's own.
+		{name: "spoofed", forwardedFor: []string{"198.51.100.1, 192.0.2.1, 10.0.0.2"}, addr: "192.0.2.1"},
+		{name: "all trusted", forwardedFor: []string{"10.0.0.5, 10.0.0.2"}, addr: "10.0.0.5"},
+		{name: "empty values", forwardedFor: []string{" , ", ""}, addr: "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if addr := proxies.Forwarded("10.0.0.1", test.forwardedFor); addr != test.addr {
+				t.Errorf("Forwarded = %v, want %v", addr, test.addr)
+			}
+		})
+	}
+}
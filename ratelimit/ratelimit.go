@@ -0,0 +1,176 @@
+// Package ratelimit is the rate limiting policy shared by the JSON and gRPC
+// servers: rules limiting each address, API key or organization, on all
+// the endpoints or on a class of them, which can be changed while the
+// servers run.
+package ratelimit
+
+import (
+	"context"
+	"mailinglist/logging"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// idleTimeout is how long the bucket of a caller is kept after its last
+// request.
+const idleTimeout = 10 * time.Minute
+
+// Store keeps the buckets shared by the servers behind a load balancer, so
+// that a caller is limited the same whichever server it reaches.
+type Store interface {
+	Allow(ctx context.Context, key string, rate float64, burst int) (bool, error)
+}
+
+type bucket struct {
+	rule     string
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter keeps a token bucket per rule and caller. A request is allowed
+// when all the rules applying to it allow it.
+type Limiter struct {
+	store Store
+
+	mu        sync.Mutex
+	rules     []Rule
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// New returns a limiter applying the rules, which must be valid.
+func New(rules []Rule) *Limiter {
+	return &Limiter{
+		rules:     rules,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Rules returns the rules applied.
+func (l *Limiter) Rules() []Rule {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Rule{}, l.rules...)
+}
+
+// SetRules changes the rules, also for the callers already seen. The
+// buckets of the rules removed are dropped.
+func (l *Limiter) SetRules(rules []Rule) error {
+	if err := ValidateRules(rules); err != nil {
+		return err
+	}
+	byName := make(map[string]Rule, len(rules))
+	for _, rule := range rules {
+		byName[rule.Name] = rule
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules = append([]Rule{}, rules...)
+	for k, b := range l.buckets {
+		rule, ok := byName[b.rule]
+		if !ok {
+			delete(l.buckets, k)
+			continue
+		}
+		b.limiter.SetLimit(rate.Limit(rule.Rate))
+		b.limiter.SetBurst(rule.Burst)
+	}
+	return nil
+}
+
+// SetStore shares the buckets through the store, before the limiter is
+// used. The limiter falls back to its own buckets when the store fails.
+func (l *Limiter) SetStore(store Store) {
+	l.store = store
+}
+
+// Allow reports whether the request may be made now. A nil limiter or one
+// without rules allows everything.
+func (l *Limiter) Allow(ctx context.Context, request Request) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	rules := l.rules
+	l.mu.Unlock()
+
+	for _, rule := range rules {
+		if !rule.matches(request.Class) {
+			continue
+		}
+		subject := subject(rule.Scope, request)
+		if subject == "" {
+			continue
+		}
+		if !l.allow(ctx, rule, rule.Name+"/"+subject) {
+			return false
+		}
+	}
+	return true
+}
+
+// subject identifies the caller of the request in the scope, "" when the
+// scope does not apply to it.
+func subject(scope string, request Request) string {
+	switch scope {
+	case ScopeCaller:
+		return Key(request.KeyId, request.Addr)
+	case ScopeIP:
+		return "addr:" + request.Addr
+	case ScopeKey:
+		if request.KeyId != 0 {
+			return Key(request.KeyId, request.Addr)
+		}
+	case ScopeTenant:
+		if request.Org != "" {
+			return "org:" + request.Org
+		}
+	}
+	return ""
+}
+
+func (l *Limiter) allow(ctx context.Context, rule Rule, key string) bool {
+	if l.store != nil {
+		allowed, err := l.store.Allow(ctx, key, rule.Rate, rule.Burst)
+		if err == nil {
+			return allowed
+		}
+		logging.FromContext(ctx).Warn("shared rate limit failed, limiting locally", "err", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > idleTimeout {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > idleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{rule: rule.Name, limiter: rate.NewLimiter(rate.Limit(rule.Rate), rule.Burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	return b.limiter.AllowN(now, 1)
+}
+
+// Key identifies a caller by the id of its API key when it has one, else
+// by address.
+func Key(keyId int64, addr string) string {
+	if keyId != 0 {
+		return "key:" + strconv.FormatInt(keyId, 10)
+	}
+	return "addr:" + addr
+}
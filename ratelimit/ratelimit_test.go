@@ -0,0 +1,172 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAllow(t *testing.T) {
+	jane := Request{Addr: "192.0.2.1", KeyId: 1, Org: "acme", Class: ClassRead}
+	tests := []struct {
+		name string
+		rule Rule
+		// other is a request of another caller, limited with the first or
+		// not.
+		other   Request
+		limited bool
+	}{
+		{
+			name:    "caller, same key from another address",
+			rule:    Rule{Name: "r", Scope: ScopeCaller, Rate: 1, Burst: 1},
+			other:   Request{Addr: "192.0.2.2", KeyId: 1, Class: ClassRead},
+			limited: true,
+		},
+		{
+			name:  "caller, other key from the same address",
+			rule:  Rule{Name: "r", Scope: ScopeCaller, Rate: 1, Burst: 1},
+			other: Request{Addr: "192.0.2.1", KeyId: 2, Class: ClassRead},
+		},
+		{
+			name:    "ip, other key from the same address",
+			rule:    Rule{Name: "r", Scope: ScopeIP, Rate: 1, Burst: 1},
+			other:   Request{Addr: "192.0.2.1", KeyId: 2, Class: ClassRead},
+			limited: true,
+		},
+		{
+			name:  "key, without key",
+			rule:  Rule{Name: "r", Scope: ScopeKey, Rate: 1, Burst: 1},
+			other: Request{Addr: "192.0.2.1", Class: ClassRead},
+		},
+		{
+			name:    "tenant, other key of the organization",
+			rule:    Rule{Name: "r", Scope: ScopeTenant, Rate: 1, Burst: 1},
+			other:   Request{Addr: "192.0.2.2", KeyId: 2, Org: "acme", Class: ClassRead},
+			limited: true,
+		},
+		{
+			name:  "tenant, key of another organization",
+			rule:  Rule{Name: "r", Scope: ScopeTenant, Rate: 1, Burst: 1},
+			other: Request{Addr: "192.0.2.1", KeyId: 2, Org: "other", Class: ClassRead},
+		},
+		{
+			name:  "other class",
+			rule:  Rule{Name: "r", Scope: ScopeIP, Class: ClassRead, Rate: 1, Burst: 1},
+			other: Request{Addr: "192.0.2.1", Class: ClassWrite},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := New([]Rule{test.rule})
+			if !l.Allow(context.Background(), jane) {
+				t.Fatal("first request limited")
+			}
+			if l.Allow(context.Background(), test.other) == test.limited {
+				t.Errorf("other request limited %v, want %v", !test.limited, test.limited)
+			}
+		})
+	}
+}
+
+func TestAllowBurst(t *testing.T) {
+	l := New([]Rule{{Name: "r", Scope: ScopeIP, Rate: 0.001, Burst: 3}})
+	request := Request{Addr: "192.0.2.1", Class: ClassRead}
+	for i := range 3 {
+		if !l.Allow(context.Background(), request) {
+			t.Fatalf("request %v of the burst limited", i)
+		}
+	}
+	if l.Allow(context.Background(), request) {
+		t.Error("request past the burst allowed")
+	}
+}
+
+// All the rules applying to a request must allow it.
+func TestAllowRules(t *testing.T) {
+	l := New([]Rule{
+		{Name: "ip", Scope: ScopeIP, Rate: 1000, Burst: 1000},
+		{Name: "writes", Scope: ScopeIP, Class: ClassWrite, Rate: 0.001, Burst: 1},
+	})
+	ctx := context.Background()
+	write := Request{Addr: "192.0.2.1", Class: ClassWrite}
+	if !l.Allow(ctx, write) || l.Allow(ctx, write) {
+		t.Error("second write allowed")
+	}
+	if !l.Allow(ctx, Request{Addr: "192.0.2.1", Class: ClassRead}) {
+		t.Error("read limited by the rule of the writes")
+	}
+}
+
+func TestSetRules(t *testing.T) {
+	ctx := context.Background()
+	request := Request{Addr: "192.0.2.1", Class: ClassRead}
+	l := New([]Rule{{Name: "r", Scope: ScopeIP, Rate: 0.001, Burst: 1}})
+	if !l.Allow(ctx, request) || l.Allow(ctx, request) {
+		t.Fatal("second request allowed")
+	}
+
+	if err := l.SetRules([]Rule{{Name: "r", Scope: "nope", Rate: 1, Burst: 1}}); err == nil {
+		t.Error("SetRules of an invalid rule succeeded")
+	}
+	if err := l.SetRules([]Rule{{Name: "r", Scope: ScopeIP, Class: ClassWrite, Rate: 0.001, Burst: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if !l.Allow(ctx, request) {
+		t.Error("read limited by a rule of the writes")
+	}
+	if err := l.SetRules([]Rule{{Name: "other", Scope: ScopeIP, Rate: 0.001, Burst: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.buckets["r/addr:192.0.2.1"]; ok {
+		t.Error("bucket of the rule removed kept")
+	}
+	if !l.Allow(ctx, request) || l.Allow(ctx, request) {
+		t.Error("second request allowed by the new rule")
+	}
+	if err := l.SetRules(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !l.Allow(ctx, request) {
+		t.Error("request limited without rules")
+	}
+}
+
+type storeFunc func(key string) (bool, error)
+
+func (f storeFunc) Allow(ctx context.Context, key string, rate float64, burst int) (bool, error) {
+	return f(key)
+}
+
+func TestAllowStore(t *testing.T) {
+	ctx := context.Background()
+	request := Request{Addr: "192.0.2.1", KeyId: 1, Class: ClassRead}
+	l := New([]Rule{{Name: "r", Scope: ScopeCaller, Rate: 0.001, Burst: 1}})
+
+	var keys []string
+	l.SetStore(storeFunc(func(key string) (bool, error) {
+		keys = append(keys, key)
+		return len(keys) < 3, nil
+	}))
+	for i, allowed := range []bool{true, true, false} {
+		if l.Allow(ctx, request) != allowed {
+			t.Errorf("request %v allowed %v, want %v", i, !allowed, allowed)
+		}
+	}
+	if keys[0] != "r/key:1" {
+		t.Errorf("store key %v, want r/key:1", keys[0])
+	}
+
+	// The limiter falls back to its own buckets when the store fails.
+	l.SetStore(storeFunc(func(key string) (bool, error) { return false, errors.New("down") }))
+	if !l.Allow(ctx, request) || l.Allow(ctx, request) {
+		t.Error("second request allowed by the local buckets")
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	if !l.Allow(context.Background(), Request{Addr: "192.0.2.1"}) {
+		t.Error("nil limiter limited the request")
+	}
+}
@@ -0,0 +1,127 @@
+package redisstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// invalidatePollInterval is how often the events are read to evict the
+	// emails that changed.
+	invalidatePollInterval = 500 * time.Millisecond
+	invalidateBatchSize    = 1000
+)
+
+// fillEmail caches an email read from the database unless it was evicted
+// since, its generation, KEYS[2], having changed from ARGV[1].
+var fillEmail = redis.NewScript(`
+	if (redis.call("GET", KEYS[2]) or "") ~= ARGV[1] then
+		return 0
+	end
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+	return 1
+`)
+
+// GetEmail returns the email from the cache, or else from the database,
+// caching it. A nil store reads the database.
+func (s *Store) GetEmail(ctx context.Context, db *sql.DB, email string) (*mdb.EmailEntry, error) {
+	if s == nil {
+		return mdb.GetEmail(ctx, db, email)
+	}
+
+	key, genKey := s.key("email", email), s.key("email-gen", email)
+	values, err := s.client.MGet(ctx, key, genKey).Result()
+	if err == nil {
+		if data, ok := values[0].(string); ok {
+			entry := &mdb.EmailEntry{}
+			if err := json.Unmarshal([]byte(data), entry); err == nil {
+				return entry, nil
+			}
+		}
+	} else {
+		logging.FromContext(ctx).Warn("reading the email cache", "email", email, "err", err)
+	}
+
+	// The emails not found are not cached, as they are usually created
+	// right after.
+	entry, err := mdb.GetEmail(ctx, db, email)
+	if err != nil || entry == nil || values == nil {
+		return entry, err
+	}
+	// The email is only cached if it was not evicted while being read, as
+	// it may then be older than the change evicting it.
+	gen, _ := values[1].(string)
+	if data, err := json.Marshal(entry); err == nil {
+		err := fillEmail.Run(ctx, s.client, []string{key, genKey}, gen, data, s.opts.EmailTTL.Milliseconds()).Err()
+		if err != nil {
+			logging.FromContext(ctx).Warn("writing the email cache", "email", email, "err", err)
+		}
+	}
+	return entry, nil
+}
+
+// InvalidateEmails evicts the emails from the cache, bumping their
+// generation so that the reads started before are not cached.
+func (s *Store) InvalidateEmails(ctx context.Context, emails ...string) error {
+	if s == nil || len(emails) == 0 {
+		return nil
+	}
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, email := range emails {
+			genKey := s.key("email-gen", email)
+			pipe.Del(ctx, s.key("email", email))
+			pipe.Incr(ctx, genKey)
+			pipe.Expire(ctx, genKey, s.opts.EmailTTL)
+		}
+		return nil
+	})
+	return err
+}
+
+// InvalidateOnEvents evicts the emails changed through any server or by
+// sync, following the events of the database until ctx is done. The emails
+// missed while Redis is unreachable expire with their TTL.
+func (s *Store) InvalidateOnEvents(ctx context.Context, db *sql.DB) {
+	logger := logging.FromContext(ctx)
+	seq, err := mdb.LastEventSeq(ctx, db)
+	for err != nil {
+		logger.Error("email cache failed to read events", "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(invalidatePollInterval):
+		}
+		seq, err = mdb.LastEventSeq(ctx, db)
+	}
+
+	for {
+		events, err := mdb.GetEventsSince(ctx, db, seq, invalidateBatchSize)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("email cache failed to read events", "err", err)
+		}
+		if len(events) > 0 {
+			emails := make([]string, len(events))
+			for i, event := range events {
+				emails[i] = event.Email
+			}
+			if err := s.InvalidateEmails(ctx, emails...); err != nil {
+				logger.Warn("invalidating the email cache", "err", err)
+			}
+			seq = events[len(events)-1].Seq
+		}
+
+		if len(events) < invalidateBatchSize {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(invalidatePollInterval):
+			}
+		}
+	}
+}
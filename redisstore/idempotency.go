@@ -0,0 +1,60 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// idempotencyPendingTTL is how long a request of an idempotency key may
+// run before a retry runs it again.
+const idempotencyPendingTTL = time.Minute
+
+// The values of the idempotency keys start with a mark of their state.
+const (
+	idempotencyPending = "p"
+	idempotencyDone    = "d"
+)
+
+// ErrInProgress is returned when the request of an idempotency key is still
+// running, on this server or on another.
+var ErrInProgress = errors.New("a request with this idempotency key is in progress")
+
+// ClaimIdempotencyKey starts the request of the key. It returns the saved
+// response when the request was already done, and nil when the request
+// should run, which must then end with SaveIdempotencyKey or
+// ReleaseIdempotencyKey.
+func (s *Store) ClaimIdempotencyKey(ctx context.Context, key string) ([]byte, error) {
+	key = s.hashedKey("idempotency", key)
+	claimed, err := s.client.SetNX(ctx, key, idempotencyPending, idempotencyPendingTTL).Result()
+	if err != nil || claimed {
+		return nil, err
+	}
+
+	value, err := s.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		// The other request just gave the key up, the retry should run.
+		return nil, ErrInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+	if value == idempotencyPending {
+		return nil, ErrInProgress
+	}
+	return []byte(value[len(idempotencyDone):]), nil
+}
+
+// SaveIdempotencyKey saves the response of the request of the key, returned
+// to the retries.
+func (s *Store) SaveIdempotencyKey(ctx context.Context, key string, response []byte) error {
+	return s.client.Set(ctx, s.hashedKey("idempotency", key), idempotencyDone+string(response), s.opts.IdempotencyTTL).Err()
+}
+
+// ReleaseIdempotencyKey gives up the key of a failed request, so that a
+// retry runs it again.
+func (s *Store) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.hashedKey("idempotency", key)).Err()
+}
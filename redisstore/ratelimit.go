@@ -0,0 +1,38 @@
+package redisstore
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// allowScript is the generic cell rate algorithm: the key holds the time,
+// in milliseconds of the Redis clock, at which the bucket of the caller is
+// full again. A request is allowed while that time is less than burst
+// intervals ahead, and pushes it one interval further.
+var allowScript = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local full = tonumber(redis.call("GET", KEYS[1])) or now
+if full < now then
+	full = now
+end
+if full + interval - burst * interval > now then
+	return 0
+end
+
+full = full + interval
+redis.call("SET", KEYS[1], string.format("%.3f", full), "PX", math.ceil(full - now))
+return 1
+`)
+
+// Allow reports whether the caller of the key may make a request now, the
+// callers making rate requests per second, and burst at once, across all
+// the servers.
+func (s *Store) Allow(ctx context.Context, key string, rate float64, burst int) (bool, error) {
+	allowed, err := allowScript.Run(ctx, s.client, []string{s.hashedKey("ratelimit", key)}, 1000/rate, burst).Int()
+	return allowed == 1, err
+}
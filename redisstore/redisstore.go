@@ -0,0 +1,73 @@
+// Package redisstore keeps in Redis the state that the servers behind a
+// load balancer share: the cache of the emails, the idempotency keys and
+// the rate limit buckets. The servers keep working when Redis is down,
+// reading the database, without idempotency keys, and limiting the rates
+// on their own.
+package redisstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// timeout bounds each command, so that an unreachable Redis only slows
+// down the requests a little.
+const timeout = 500 * time.Millisecond
+
+// Options sets the Redis server and the lifetime of the keys.
+type Options struct {
+	Addr     string
+	Password string
+	DB       int
+	// Prefix starts all the keys, separating the servers sharing Redis.
+	Prefix string
+	// EmailTTL is how long an email stays in the cache at most.
+	EmailTTL time.Duration
+	// IdempotencyTTL is how long the response to an idempotency key is
+	// kept for the retries.
+	IdempotencyTTL time.Duration
+}
+
+// Store is the shared state in Redis.
+type Store struct {
+	client *redis.Client
+	opts   Options
+}
+
+// New connects to Redis, failing if it cannot be reached.
+func New(ctx context.Context, opts Options) (*Store, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:         opts.Addr,
+		Password:     opts.Password,
+		DB:           opts.DB,
+		DialTimeout:  2 * time.Second,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	})
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connecting to redis at %v : %w", opts.Addr, err)
+	}
+	return &Store{client: client, opts: opts}, nil
+}
+
+// Close closes the connections to Redis.
+func (s *Store) Close() error {
+	return s.client.Close()
+}
+
+func (s *Store) key(kind, name string) string {
+	return s.opts.Prefix + kind + ":" + name
+}
+
+// hashedKey is the key of a name holding credentials, such as an API key,
+// which are not written to Redis in clear.
+func (s *Store) hashedKey(kind, name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return s.key(kind, hex.EncodeToString(sum[:]))
+}
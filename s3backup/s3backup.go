@@ -0,0 +1,181 @@
+// Package s3backup uploads the database snapshots to S3 or a compatible
+// object storage, compressed with gzip and encrypted with age, so that the
+// bucket never holds the emails in clear, and prunes the old uploads.
+package s3backup
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	// Suffix ends the names of the uploads, decrypted with
+	// age -d -i key.txt | gunzip.
+	Suffix = ".gz.age"
+	// partSize bounds the memory of an upload, buffered a part at a time.
+	partSize = 16 << 20
+)
+
+// Options sets the bucket and the retention of the uploads.
+type Options struct {
+	// Endpoint is the host[:port] of the object storage.
+	Endpoint string
+	// Insecure uses http rather than https.
+	Insecure bool
+	Region   string
+	Bucket   string
+	// Prefix starts the names of the uploads, e.g. backups/.
+	Prefix string
+	// AccessKey and SecretKey are taken from the AWS environment variables,
+	// credentials file or instance role when empty.
+	AccessKey string
+	SecretKey string
+	// Recipients are the age public keys the snapshots are encrypted to.
+	Recipients []string
+	// Keep is how many of the newest uploads are never pruned.
+	Keep int
+	// Retention is how long the older uploads are kept.
+	Retention time.Duration
+}
+
+// Uploader uploads the snapshots to the bucket.
+type Uploader struct {
+	client     *minio.Client
+	opts       Options
+	recipients []age.Recipient
+}
+
+// ParseRecipients parses age public keys, age1...
+func ParseRecipients(keys []string) ([]age.Recipient, error) {
+	recipients := make([]age.Recipient, 0, len(keys))
+	for _, key := range keys {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+func New(opts Options) (*Uploader, error) {
+	recipients, err := ParseRecipients(opts.Recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, "")
+	if opts.AccessKey == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		})
+	}
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !opts.Insecure,
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %v : %w", opts.Endpoint, err)
+	}
+	return &Uploader{client: client, opts: opts, recipients: recipients}, nil
+}
+
+// Upload compresses, encrypts and uploads the snapshot file, named after
+// it, returning the name of the upload.
+func (u *Uploader) Upload(ctx context.Context, snapshot string) (string, error) {
+	file, err := os.Open(snapshot)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	name := u.opts.Prefix + filepath.Base(snapshot) + Suffix
+	reader, writer := io.Pipe()
+	encoded := make(chan error, 1)
+	go func() {
+		err := encode(writer, file, u.recipients)
+		writer.CloseWithError(err)
+		encoded <- err
+	}()
+
+	_, err = u.client.PutObject(ctx, u.opts.Bucket, name, reader, -1, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+		PartSize:    partSize,
+	})
+	// Stops the encoding when the upload failed.
+	reader.CloseWithError(io.ErrClosedPipe)
+	if encodeErr := <-encoded; err == nil && encodeErr != nil {
+		err = encodeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("uploading %v to %v : %w", name, u.opts.Bucket, err)
+	}
+	return name, nil
+}
+
+func encode(w io.Writer, r io.Reader, recipients []age.Recipient) error {
+	encrypted, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return err
+	}
+	compressed := gzip.NewWriter(encrypted)
+	if _, err := io.Copy(compressed, r); err != nil {
+		return err
+	}
+	if err := compressed.Close(); err != nil {
+		return err
+	}
+	return encrypted.Close()
+}
+
+// Prune removes the uploads older than the retention, past the newest
+// Keep ones, and returns the names removed. The uploads are matched by
+// the pattern of their file names under the prefix.
+func (u *Uploader) Prune(ctx context.Context, pattern string) ([]string, error) {
+	// Stops the listing when returning early.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var uploads []minio.ObjectInfo
+	for object := range u.client.ListObjects(ctx, u.opts.Bucket, minio.ListObjectsOptions{Prefix: u.opts.Prefix}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("listing %v : %w", u.opts.Bucket, object.Err)
+		}
+		name := strings.TrimPrefix(object.Key, u.opts.Prefix)
+		if matched, _ := path.Match(pattern+Suffix, name); matched {
+			uploads = append(uploads, object)
+		}
+	}
+
+	// The names sort by time.
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].Key > uploads[j].Key
+	})
+	var removed []string
+	cutoff := time.Now().Add(-u.opts.Retention)
+	for i, upload := range uploads {
+		if i < u.opts.Keep || upload.LastModified.After(cutoff) {
+			continue
+		}
+		if err := u.client.RemoveObject(ctx, u.opts.Bucket, upload.Key, minio.RemoveObjectOptions{}); err != nil {
+			return removed, fmt.Errorf("removing %v : %w", upload.Key, err)
+		}
+		removed = append(removed, upload.Key)
+	}
+	return removed, nil
+}
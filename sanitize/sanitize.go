@@ -0,0 +1,38 @@
+package sanitize
+
+import (
+	"fmt"
+	"net/mail"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Check rejects values that would be unsafe once they end up in SMTP
+// headers: invalid UTF-8, CR/LF, null bytes and other non-printable
+// characters. The error names the field and the offending character.
+func Check(field, value string) error {
+	if !utf8.ValidString(value) {
+		return fmt.Errorf("%v is not valid UTF-8", field)
+	}
+
+	for i, r := range value {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("%v contains forbidden character %q at position %d", field, r, i)
+		}
+	}
+	return nil
+}
+
+// Email checks the value with Check and that it is a bare email address,
+// without display name or angle brackets.
+func Email(field, value string) error {
+	if err := Check(field, value); err != nil {
+		return err
+	}
+
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value {
+		return fmt.Errorf("%v is not a valid email address", field)
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+// Package scheduler runs the recurring jobs of the server, such as purging
+// the trash or snapshotting the database, and keeps their last status.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mailinglist/crash"
+	"mailinglist/logging"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrUnknownJob is returned for a job name that was not added.
+var ErrUnknownJob = errors.New("unknown job")
+
+// Job is a recurring task.
+type Job struct {
+	Name string
+	// Interval is the time between the start of two runs.
+	Interval time.Duration
+	// Run does the work, logging with the logger of the context. The
+	// context is canceled when the scheduler stops.
+	Run func(ctx context.Context) error
+}
+
+// Elector tells whether this server is the one running the jobs, when
+// several servers share the database.
+type Elector interface {
+	IsLeader() bool
+}
+
+// Status is the state of a job.
+type Status struct {
+	Name     string
+	Interval string
+	Enabled  bool
+	Running  bool
+	Runs     int64
+	Failures int64
+	// The last run, once the job has run.
+	LastRun      *time.Time `json:",omitempty"`
+	LastDuration string     `json:",omitempty"`
+	LastError    string     `json:",omitempty"`
+	// NextRun is when the enabled jobs run next.
+	NextRun *time.Time `json:",omitempty"`
+}
+
+type entry struct {
+	job    Job
+	status Status
+	// trigger runs the job now, out of its schedule.
+	trigger chan struct{}
+	// nextTick is when the ticker of the job fires next.
+	nextTick time.Time
+}
+
+// setNextRun shows the next tick as the next run of the enabled jobs.
+func (e *entry) setNextRun() {
+	e.status.NextRun = nil
+	if e.status.Enabled && !e.nextTick.IsZero() {
+		next := e.nextTick
+		e.status.NextRun = &next
+	}
+}
+
+// Scheduler runs each enabled job at its interval, a job never running
+// twice at once.
+type Scheduler struct {
+	logger  *slog.Logger
+	elector Elector
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	started bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// New returns a scheduler logging to the logger, the default logger when
+// nil.
+func New(logger *slog.Logger) *Scheduler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		logger:  logger.With("component", "scheduler"),
+		entries: make(map[string]*entry),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Add registers a job, enabled or not, before Start.
+func (s *Scheduler) Add(job Job, enabled bool) error {
+	if job.Interval <= 0 {
+		return fmt.Errorf("job %v : the interval must be positive", job.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[job.Name]; ok {
+		return fmt.Errorf("job %v : added twice", job.Name)
+	}
+	s.entries[job.Name] = &entry{
+		job:     job,
+		status:  Status{Name: job.Name, Interval: job.Interval.String(), Enabled: enabled},
+		trigger: make(chan struct{}, 1),
+	}
+	return nil
+}
+
+// SetElector makes the jobs run on schedule only while the elector tells
+// that this server is the leader, before Start. The jobs run on demand
+// wherever they are asked to.
+func (s *Scheduler) SetElector(elector Elector) {
+	s.elector = elector
+}
+
+// Start runs the jobs in the background, the first time after their
+// interval.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	for _, e := range s.entries {
+		e.nextTick = time.Now().Add(e.job.Interval)
+		e.setNextRun()
+		s.wg.Add(1)
+		go s.loop(e)
+	}
+}
+
+// Stop cancels the running jobs and waits for them to return.
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(e *entry) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(e.job.Interval)
+	defer ticker.Stop()
+
+	for {
+		manual := false
+		select {
+		case <-s.ctx.Done():
+			return
+		case tick := <-ticker.C:
+			s.mu.Lock()
+			enabled := e.status.Enabled
+			e.nextTick = tick.Add(e.job.Interval)
+			e.setNextRun()
+			s.mu.Unlock()
+			if !enabled {
+				continue
+			}
+			if s.elector != nil && !s.elector.IsLeader() {
+				s.logger.Debug("job skipped, another server is the leader", "job", e.job.Name)
+				continue
+			}
+		case <-e.trigger:
+			manual = true
+		}
+		s.run(e, manual)
+	}
+}
+
+type manualKey struct{}
+
+// Manual reports whether the run of the job was asked for through RunNow,
+// rather than due on schedule.
+func Manual(ctx context.Context) bool {
+	manual, _ := ctx.Value(manualKey{}).(bool)
+	return manual
+}
+
+func (s *Scheduler) run(e *entry, manual bool) {
+	s.mu.Lock()
+	e.status.Running = true
+	s.mu.Unlock()
+
+	logger := s.logger.With("job", e.job.Name)
+	start := time.Now()
+	ctx := context.WithValue(logging.NewContext(s.ctx, logger), manualKey{}, manual)
+	err := runJob(ctx, e.job)
+	elapsed := time.Since(start)
+	if err != nil {
+		logger.Error("job failed", "elapsed", elapsed, "err", err)
+	} else {
+		logger.Info("job done", "elapsed", elapsed)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.status.Running = false
+	e.status.Runs++
+	e.status.LastRun = &start
+	e.status.LastDuration = elapsed.Round(time.Millisecond).String()
+	e.status.LastError = ""
+	if err != nil {
+		e.status.Failures++
+		e.status.LastError = err.Error()
+	}
+}
+
+// runJob runs the job, a panic failing the run rather than the server.
+func runJob(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			crash.Handle(ctx, r, "job", job.Name)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+// Jobs returns the status of the jobs, sorted by name.
+func (s *Scheduler) Jobs() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Status, 0, len(s.entries))
+	for _, e := range s.entries {
+		jobs = append(jobs, e.status)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Name < jobs[j].Name
+	})
+	return jobs
+}
+
+// Job returns the status of a job.
+func (s *Scheduler) Job(name string) (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[name]
+	if !ok {
+		return Status{}, ErrUnknownJob
+	}
+	return e.status, nil
+}
+
+// SetEnabled enables or disables a job. A disabled job still finishes the
+// run in progress.
+func (s *Scheduler) SetEnabled(name string, enabled bool) (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[name]
+	if !ok {
+		return Status{}, ErrUnknownJob
+	}
+	if enabled != e.status.Enabled {
+		s.logger.Info("job enabled changed", "job", name, "enabled", enabled)
+	}
+	e.status.Enabled = enabled
+	e.setNextRun()
+	return e.status, nil
+}
+
+// RunNow runs a job as soon as it is not running, enabled or not.
+func (s *Scheduler) RunNow(name string) error {
+	s.mu.Lock()
+	e, ok := s.entries[name]
+	s.mu.Unlock()
+	if !ok {
+		return ErrUnknownJob
+	}
+	select {
+	case e.trigger <- struct{}{}:
+	default:
+		// A run is already pending.
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a daily time range, such as 02:00-05:00, which may span
+// midnight, as 23:00-01:00. The zero window is the whole day.
+type Window struct {
+	// Start and End are offsets from midnight.
+	Start, End time.Duration
+}
+
+// ParseWindow parses a window written HH:MM-HH:MM, the empty string being
+// the whole day.
+func ParseWindow(s string) (Window, error) {
+	if s == "" {
+		return Window{}, nil
+	}
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("window %q is not HH:MM-HH:MM", s)
+	}
+	var w Window
+	var err error
+	if w.Start, err = parseClock(start); err != nil {
+		return Window{}, fmt.Errorf("window %q : %w", s, err)
+	}
+	if w.End, err = parseClock(end); err != nil {
+		return Window{}, fmt.Errorf("window %q : %w", s, err)
+	}
+	if w.Start == w.End {
+		return Window{}, fmt.Errorf("window %q is empty", s)
+	}
+	return w, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Opened returns when the window containing t opened, in the location of
+// t, and false when t is out of the window.
+func (w Window) Opened(t time.Time) (time.Time, bool) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if w == (Window{}) {
+		return midnight, true
+	}
+
+	clock := t.Sub(midnight)
+	switch {
+	case w.Start < w.End:
+		return midnight.Add(w.Start), clock >= w.Start && clock < w.End
+	case clock >= w.Start:
+		return midnight.Add(w.Start), true
+	case clock < w.End:
+		// Opened the day before.
+		return midnight.AddDate(0, 0, -1).Add(w.Start), true
+	}
+	return time.Time{}, false
+}
+
+// Length is how long the window stays open each day.
+func (w Window) Length() time.Duration {
+	if w == (Window{}) {
+		return 24 * time.Hour
+	}
+	if w.Start < w.End {
+		return w.End - w.Start
+	}
+	return 24*time.Hour - w.Start + w.End
+}
+
+func (w Window) String() string {
+	if w == (Window{}) {
+		return "always"
+	}
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return clock(w.Start) + "-" + clock(w.End)
+}
@@ -0,0 +1,204 @@
+// Package sendqueue sends the emails queued in the database through the
+// mail provider. A pool of workers, on every server sharing the database,
+// claims the due messages, retrying the temporary failures with an
+// exponential backoff and marking failed the messages the provider
+// rejects or which failed too many times. The queue being in the
+// database, the messages are sent after a restart. The messages to the
+// emails which complained since they were queued are failed unsent, as are
+// those of the campaigns to the emails which opted out or were deleted. The
+// messages to each recipient domain can be throttled, so that a large
+// campaign does not flood the mailbox providers, e.g. gmail.com.
+package sendqueue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mailinglist/bounces"
+	"mailinglist/crash"
+	"mailinglist/logging"
+	"mailinglist/mailer"
+	"mailinglist/mdb"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// pollInterval is how often the idle workers look for due messages.
+	pollInterval = time.Second
+	// visibility is how long a claimed message is hidden from the other
+	// workers, after which it is sent again if the server sending it died.
+	visibility = 2 * time.Minute
+
+	baseBackoff = 30 * time.Second
+	maxBackoff  = time.Hour
+)
+
+// errSuppressed fails the messages to the emails which complained, or
+// which opted out of the campaigns.
+var errSuppressed = errors.New("recipient complained or opted out")
+
+// Options sets how many messages are sent at once and how hard.
+type Options struct {
+	// Workers is the number of messages sent at once.
+	Workers int
+	// MaxAttempts is how many times a message is tried before it fails.
+	MaxAttempts int
+	// Paused reports whether the messages wait, e.g. in read-only mode.
+	// They are sent once it returns false.
+	Paused func() bool
+	// VERP, when set, is the address of the bounces mailbox, the envelope
+	// sender of each message being its VERP address for the recipient,
+	// signed with VERPSecret.
+	VERP       string
+	VERPSecret string
+	// DomainLimits limits the messages to the recipient domains, by
+	// domain, the one of DefaultDomain applying to the others.
+	DomainLimits map[string]DomainLimit
+}
+
+// Queue runs the workers sending the messages.
+type Queue struct {
+	db       *sql.DB
+	sender   mailer.Sender
+	opts     Options
+	throttle *throttle
+	logger   *slog.Logger
+}
+
+func New(db *sql.DB, sender mailer.Sender, opts Options, logger *slog.Logger) *Queue {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Queue{
+		db:       db,
+		sender:   sender,
+		opts:     opts,
+		throttle: newThrottle(opts.DomainLimits),
+		logger:   logger.With("component", "send-queue"),
+	}
+}
+
+// Run sends the messages until ctx is done, then waits for the ones in
+// flight.
+func (q *Queue) Run(ctx context.Context) {
+	ctx = logging.NewContext(ctx, q.logger)
+	var wg sync.WaitGroup
+	for i := 0; i < q.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		if q.opts.Paused == nil || !q.opts.Paused() {
+			sent, err := q.safeNext(ctx)
+			if err != nil && ctx.Err() == nil {
+				q.logger.Error("sending message failed", "err", err)
+			}
+			if sent && err == nil {
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(q.throttle.idle(time.Now())):
+		case <-q.throttle.released:
+		}
+	}
+}
+
+// safeNext is next, a panic failing the attempt rather than the worker.
+// The message is sent again once its claim expires.
+func (q *Queue) safeNext(ctx context.Context) (sent bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			crash.Handle(ctx, r, "component", "send-queue")
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return q.next(ctx)
+}
+
+// next sends the next due message, reporting whether there was one.
+func (q *Queue) next(ctx context.Context) (bool, error) {
+	m, err := mdb.ClaimMessage(ctx, q.db, q.throttle.busy(time.Now()), visibility)
+	if err != nil || m == nil {
+		return false, err
+	}
+
+	logger := q.logger.With("message", m.Id, "campaign", m.CampaignId)
+	suppressed, err := mdb.Suppressed(ctx, q.db, m)
+	if err != nil {
+		// Sent once the claim expires.
+		return true, err
+	}
+	if suppressed {
+		logger.Info("message to a suppressed email dropped")
+		return true, mdb.SettleMessage(ctx, q.db, m, m.Attempts, errSuppressed)
+	}
+	domain := domainOf(m.To)
+	wait, ok := q.throttle.acquire(domain, time.Now())
+	if !ok {
+		// Another worker took the last turn of the domain.
+		return true, mdb.RetryMessage(ctx, q.db, m.Id, m.Attempts, time.Now().Add(wait), m.LastError)
+	}
+	defer q.throttle.release(domain)
+
+	msg := &mailer.Message{
+		From:    m.From,
+		To:      m.To,
+		Subject: m.Subject,
+		Text:    m.Text,
+		HTML:    m.HTML,
+		Headers: m.Headers,
+	}
+	if q.opts.VERP != "" {
+		msg.ReturnPath = bounces.VERP(q.opts.VERP, m.To, q.opts.VERPSecret)
+	}
+	err = q.sender.Send(ctx, msg)
+	// The settling outlives a canceled ctx, a message sent not to be sent
+	// twice.
+	settle := context.WithoutCancel(ctx)
+	attempts := m.Attempts + 1
+	if err == nil {
+		logger.Debug("message sent", "attempts", attempts)
+		return true, mdb.SettleMessage(settle, q.db, m, attempts, nil)
+	}
+	if ctx.Err() != nil {
+		// Sent again once the claim expires, by this server or another.
+		return true, nil
+	}
+
+	if mailer.IsPermanent(err) || attempts >= q.opts.MaxAttempts {
+		logger.Warn("message failed", "attempts", attempts, "err", err)
+		return true, mdb.SettleMessage(settle, q.db, m, attempts, err)
+	}
+	retryAt := time.Now().Add(backoff(attempts))
+	logger.Info("sending message failed, retrying", "attempts", attempts, "retry_at", retryAt, "err", err)
+	return true, mdb.RetryMessage(settle, q.db, m.Id, attempts, retryAt, err.Error())
+}
+
+// backoff is the wait before the next attempt, doubling with each attempt
+// up to maxBackoff, with some jitter so that the messages held back by an
+// outage of the provider are not all retried at once.
+func backoff(attempts int) time.Duration {
+	wait := maxBackoff
+	if attempts < 20 {
+		wait = baseBackoff << (attempts - 1)
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait - time.Duration(rand.Int63n(int64(wait/5)))
+}
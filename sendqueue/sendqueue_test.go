@@ -0,0 +1,214 @@
+package sendqueue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mailinglist/mailer"
+	"mailinglist/mdb"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// senderFunc sends the messages with a function.
+type senderFunc func(ctx context.Context, msg *mailer.Message) error
+
+func (f senderFunc) Send(ctx context.Context, msg *mailer.Message) error {
+	return f(ctx, msg)
+}
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", t.TempDir()+"/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := mdb.Migrate(context.Background(), db, mdb.SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// message returns the status, the attempts and the last error of the
+// message.
+func message(t *testing.T, db *sql.DB, id int64) (status string, attempts int, lastError string) {
+	t.Helper()
+	err := db.QueryRow(`SELECT status, attempts, last_error FROM send_queue WHERE id = ?`, id).
+		Scan(&status, &attempts, &lastError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status, attempts, lastError
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name string
+		// campaign queues the message for a campaign rather than as a
+		// confirmation.
+		campaign bool
+		// setup changes the email of the recipient once the message is
+		// queued.
+		setup    func(ctx context.Context, db *sql.DB) error
+		sendErr  error
+		sent     bool
+		status   string
+		attempts int
+	}{
+		{
+			name:     "sent",
+			sent:     true,
+			status:   mdb.MessageSent,
+			attempts: 1,
+		},
+		{
+			name:     "temporary failure",
+			sendErr:  errors.New("connection reset"),
+			sent:     true,
+			status:   mdb.MessagePending,
+			attempts: 1,
+		},
+		{
+			name:     "permanent failure",
+			sendErr:  &mailer.PermanentError{Err: errors.New("550 no such user")},
+			sent:     true,
+			status:   mdb.MessageFailed,
+			attempts: 1,
+		},
+		{
+			name: "complained",
+			setup: func(ctx context.Context, db *sql.DB) error {
+				_, err := mdb.RecordComplaint(ctx, db, "jane@example.com")
+				return err
+			},
+			status: mdb.MessageFailed,
+		},
+		{
+			name:     "confirmation to an email opted out",
+			setup:    optOut,
+			sent:     true,
+			status:   mdb.MessageSent,
+			attempts: 1,
+		},
+		{
+			name:     "campaign to an email opted out",
+			campaign: true,
+			setup:    optOut,
+			status:   mdb.MessageFailed,
+		},
+		{
+			name:     "campaign to an email deleted",
+			campaign: true,
+			setup: func(ctx context.Context, db *sql.DB) error {
+				return mdb.DeleteEmailByEmail(ctx, db, "jane@example.com")
+			},
+			status: mdb.MessageFailed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := openDB(t)
+			if err := mdb.CreateEmail(ctx, db, "jane@example.com"); err != nil {
+				t.Fatal(err)
+			}
+			m := &mdb.QueuedMessage{From: "news@example.com", To: "jane@example.com", Subject: "Hello", Text: "Hi"}
+			if test.campaign {
+				m.CampaignId = 1
+			}
+			id, err := mdb.EnqueueMessage(ctx, db, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.setup != nil {
+				if err := test.setup(ctx, db); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			sent := false
+			q := New(db, senderFunc(func(ctx context.Context, msg *mailer.Message) error {
+				sent = true
+				return test.sendErr
+			}), Options{Workers: 1, MaxAttempts: 3}, nil)
+			found, err := q.next(ctx)
+			if err != nil || !found {
+				t.Fatalf("next = %v, %v", found, err)
+			}
+
+			if sent != test.sent {
+				t.Errorf("sent = %v, want %v", sent, test.sent)
+			}
+			status, attempts, _ := message(t, db, id)
+			if status != test.status || attempts != test.attempts {
+				t.Errorf("message %v after %v attempts, want %v after %v", status, attempts, test.status, test.attempts)
+			}
+		})
+	}
+}
+
+func optOut(ctx context.Context, db *sql.DB) error {
+	return mdb.OptOutEmail(ctx, db, "jane@example.com", "unsubscribed")
+}
+
+// A message sent as the server shuts down is settled, not to be sent again
+// once its claim expires.
+func TestNextCanceledAfterSend(t *testing.T) {
+	db := openDB(t)
+	id, err := mdb.EnqueueMessage(context.Background(), db, &mdb.QueuedMessage{From: "news@example.com", To: "jane@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := New(db, senderFunc(func(context.Context, *mailer.Message) error {
+		cancel()
+		return nil
+	}), Options{Workers: 1, MaxAttempts: 3}, nil)
+	if _, err := q.next(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _, _ := message(t, db, id); status != mdb.MessageSent {
+		t.Errorf("message %v, want %v", status, mdb.MessageSent)
+	}
+}
+
+// A message failing as the server shuts down is left to its claim, the
+// failure being the shutdown's.
+func TestNextCanceledFailing(t *testing.T) {
+	db := openDB(t)
+	id, err := mdb.EnqueueMessage(context.Background(), db, &mdb.QueuedMessage{From: "news@example.com", To: "jane@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := New(db, senderFunc(func(ctx context.Context, _ *mailer.Message) error {
+		cancel()
+		return ctx.Err()
+	}), Options{Workers: 1, MaxAttempts: 1}, nil)
+	if _, err := q.next(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, attempts, _ := message(t, db, id); status != mdb.MessagePending || attempts != 0 {
+		t.Errorf("message %v after %v attempts, want %v after 0", status, attempts, mdb.MessagePending)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts := 1; attempts <= 30; attempts++ {
+		wait := backoff(attempts)
+		want := maxBackoff
+		if attempts < 20 && baseBackoff<<(attempts-1) < maxBackoff {
+			want = baseBackoff << (attempts - 1)
+		}
+		if wait > want || wait <= want-want/5 {
+			t.Errorf("backoff(%v) = %v, want within 20%% below %v", attempts, wait, want)
+		}
+	}
+}
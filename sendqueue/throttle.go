@@ -0,0 +1,155 @@
+package sendqueue
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDomain is the domain of the limit applying to each recipient
+// domain without one of its own.
+const DefaultDomain = "*"
+
+// DomainLimit limits the messages sent to the recipients of a domain, 0
+// being no limit.
+type DomainLimit struct {
+	// Concurrency is how many messages are sent at once.
+	Concurrency int
+	// PerMinute is how many messages are sent each minute, spread evenly
+	// over it.
+	PerMinute int
+}
+
+// ParseDomainLimit parses the limit of a domain written
+// concurrency/per_minute, e.g. "4/600", either of them 0 for no limit.
+func ParseDomainLimit(domain, spec string) (DomainLimit, error) {
+	concurrency, perMinute, ok := strings.Cut(strings.TrimSpace(spec), "/")
+	if !ok {
+		return DomainLimit{}, fmt.Errorf("domain %v: %q is not concurrency/per_minute", domain, spec)
+	}
+	var (
+		limit DomainLimit
+		err   error
+	)
+	if limit.Concurrency, err = strconv.Atoi(concurrency); err != nil || limit.Concurrency < 0 {
+		return DomainLimit{}, fmt.Errorf("domain %v: invalid concurrency %q", domain, concurrency)
+	}
+	if limit.PerMinute, err = strconv.Atoi(perMinute); err != nil || limit.PerMinute < 0 {
+		return DomainLimit{}, fmt.Errorf("domain %v: invalid messages per minute %q", domain, perMinute)
+	}
+	return limit, nil
+}
+
+// domainOf returns the domain of the address, lowercased like the claims
+// of the messages compare it.
+func domainOf(addr string) string {
+	_, domain, _ := strings.Cut(addr, "@")
+	return strings.ToLower(domain)
+}
+
+// throttle holds back the messages to the domains sending as many messages
+// at once as their limit allows, or having sent their last one less than a
+// minute divided by their messages per minute ago. The limits are those of
+// a server, each server sharing the database applying them apart.
+type throttle struct {
+	limits map[string]DomainLimit
+
+	mu      sync.Mutex
+	domains map[string]*domainState
+	// released wakes a worker waiting when a message is sent, which may
+	// free a turn of its domain.
+	released chan struct{}
+}
+
+type domainState struct {
+	inFlight int
+	// next is when the domain can be sent its next message.
+	next time.Time
+}
+
+func newThrottle(limits map[string]DomainLimit) *throttle {
+	return &throttle{limits: limits, domains: make(map[string]*domainState), released: make(chan struct{}, 1)}
+}
+
+func (t *throttle) limit(domain string) (DomainLimit, bool) {
+	limit, ok := t.limits[domain]
+	if !ok {
+		limit, ok = t.limits[DefaultDomain]
+	}
+	return limit, ok
+}
+
+// busy returns the domains whose messages cannot be sent now, not claimed.
+// The domains idle are forgotten.
+func (t *throttle) busy(now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var busy []string
+	for domain, state := range t.domains {
+		if state.inFlight == 0 && !now.Before(state.next) {
+			delete(t.domains, domain)
+			continue
+		}
+		limit, _ := t.limit(domain)
+		if now.Before(state.next) || (limit.Concurrency > 0 && state.inFlight >= limit.Concurrency) {
+			busy = append(busy, domain)
+		}
+	}
+	return busy
+}
+
+// idle returns how long the workers without a message wait before looking
+// again: until the next turn of a domain, at most pollInterval.
+func (t *throttle) idle(now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	wait := pollInterval
+	for _, state := range t.domains {
+		if d := state.next.Sub(now); d > 0 && d < wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// acquire reserves the sending of a message to the domain, to be released
+// once sent, else returns how long to wait before trying again.
+func (t *throttle) acquire(domain string, now time.Time) (time.Duration, bool) {
+	limit, ok := t.limit(domain)
+	if !ok {
+		return 0, true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.domains[domain]
+	if state == nil {
+		state = &domainState{}
+		t.domains[domain] = state
+	}
+	if limit.Concurrency > 0 && state.inFlight >= limit.Concurrency {
+		return pollInterval, false
+	}
+	if now.Before(state.next) {
+		return state.next.Sub(now), false
+	}
+	state.inFlight++
+	if limit.PerMinute > 0 {
+		state.next = now.Add(time.Minute / time.Duration(limit.PerMinute))
+	}
+	return 0, true
+}
+
+// release ends the sending of a message acquired.
+func (t *throttle) release(domain string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state := t.domains[domain]; state != nil {
+		state.inFlight--
+		select {
+		case t.released <- struct{}{}:
+		default:
+		}
+	}
+}
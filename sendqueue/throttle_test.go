@@ -0,0 +1,108 @@
+package sendqueue
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseDomainLimit(t *testing.T) {
+	tests := []struct {
+		spec  string
+		limit DomainLimit
+		err   bool
+	}{
+		{spec: "4/600", limit: DomainLimit{Concurrency: 4, PerMinute: 600}},
+		{spec: " 0/60 ", limit: DomainLimit{PerMinute: 60}},
+		{spec: "2/0", limit: DomainLimit{Concurrency: 2}},
+		{spec: "4", err: true},
+		{spec: "-1/60", err: true},
+		{spec: "4/many", err: true},
+	}
+	for _, test := range tests {
+		limit, err := ParseDomainLimit("gmail.com", test.spec)
+		if (err != nil) != test.err || limit != test.limit {
+			t.Errorf("ParseDomainLimit(%q) = %+v, %v", test.spec, limit, err)
+		}
+	}
+}
+
+func TestDomainOf(t *testing.T) {
+	if got := domainOf("Jane@GMail.com"); got != "gmail.com" {
+		t.Errorf("domainOf = %q, want gmail.com", got)
+	}
+	if got := domainOf("jane"); got != "" {
+		t.Errorf("domainOf = %q, want none", got)
+	}
+}
+
+func TestThrottleConcurrency(t *testing.T) {
+	th := newThrottle(map[string]DomainLimit{"gmail.com": {Concurrency: 2}})
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if _, ok := th.acquire("gmail.com", now); !ok {
+			t.Fatalf("acquire %v refused", i)
+		}
+	}
+	if _, ok := th.acquire("gmail.com", now); ok {
+		t.Error("acquire beyond the concurrency granted")
+	}
+	if busy := th.busy(now); !slices.Equal(busy, []string{"gmail.com"}) {
+		t.Errorf("busy = %v, want gmail.com", busy)
+	}
+	// The domains without a limit are not throttled.
+	for i := 0; i < 5; i++ {
+		if _, ok := th.acquire("example.com", now); !ok {
+			t.Fatal("acquire of a domain without limit refused")
+		}
+	}
+
+	th.release("gmail.com")
+	select {
+	case <-th.released:
+	default:
+		t.Error("release woke no worker")
+	}
+	if busy := th.busy(now); len(busy) != 0 {
+		t.Errorf("busy = %v, want none", busy)
+	}
+	if _, ok := th.acquire("gmail.com", now); !ok {
+		t.Error("acquire after a release refused")
+	}
+}
+
+func TestThrottlePerMinute(t *testing.T) {
+	th := newThrottle(map[string]DomainLimit{DefaultDomain: {PerMinute: 60}})
+	now := time.Now()
+
+	if _, ok := th.acquire("gmail.com", now); !ok {
+		t.Fatal("first acquire refused")
+	}
+	th.release("gmail.com")
+	wait, ok := th.acquire("gmail.com", now.Add(400*time.Millisecond))
+	if ok || wait != 600*time.Millisecond {
+		t.Errorf("acquire within the turn = %v, %v, want a wait of 600ms", wait, ok)
+	}
+	if busy := th.busy(now.Add(400 * time.Millisecond)); !slices.Equal(busy, []string{"gmail.com"}) {
+		t.Errorf("busy = %v, want gmail.com", busy)
+	}
+	if idle := th.idle(now.Add(400 * time.Millisecond)); idle != 600*time.Millisecond {
+		t.Errorf("idle = %v, want 600ms", idle)
+	}
+	// Another domain has its own turns under the default limit.
+	if _, ok := th.acquire("example.com", now); !ok {
+		t.Error("acquire of another domain refused")
+	}
+	th.release("example.com")
+
+	if _, ok := th.acquire("gmail.com", now.Add(time.Second)); !ok {
+		t.Error("acquire at the next turn refused")
+	}
+	th.release("gmail.com")
+	// The domains idle are forgotten.
+	th.busy(now.Add(time.Hour))
+	if len(th.domains) != 0 {
+		t.Errorf("domains %v remembered, want none", th.domains)
+	}
+}
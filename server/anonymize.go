@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"mailinglist/config"
+	"mailinglist/mdb"
+)
+
+// runAnonymize writes the anonymized copy of the database of the settings.
+func runAnonymize(cmd *config.AnonymizeCommand) error {
+	key := []byte(cmd.Key)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+	}
+
+	db, err := sql.Open("sqlite3", args.DbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	// The tables rewritten are the ones of the last schema.
+	version, err := mdb.GetSchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if version != mdb.SchemaVersion {
+		return fmt.Errorf("schema version %v, run migrate up first, the last is %v", version, mdb.SchemaVersion)
+	}
+	if err := mdb.Anonymize(ctx, db, cmd.Out, key); err != nil {
+		return err
+	}
+	fmt.Printf("anonymized copy written to %v\n", cmd.Out)
+	return nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"mailinglist/bounces"
+	"mailinglist/campaigns"
+	"mailinglist/config"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"mailinglist/personalize"
+	"mailinglist/s3backup"
+	"mailinglist/scheduler"
+	"mailinglist/state"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// backupPattern matches the snapshots written by the backup job.
+const backupPattern = "mailinglist-*.db"
+
+// jobNames are all the jobs, trash-unconfirmed, backup, campaigns and
+// bounces only running when configured.
+var jobNames = map[string]bool{"purge-trash": true, "stats-rollup": true, "prune-outbox": true, "trash-unconfirmed": true, "backup": true, "maintenance": true, "campaigns": true, "bounces": true}
+
+// writeJob skips the job while the server is in read-only mode, as it
+// changes the emails or the database file.
+func writeJob(st *state.State, run func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if st.ReadOnly() {
+			logging.FromContext(ctx).Info("skipped in read-only mode")
+			return nil
+		}
+		return run(ctx)
+	}
+}
+
+// backup snapshots the database into dir, then removes the oldest
+// snapshots beyond keep. With an uploader, the snapshot is also uploaded,
+// and taken in a temporary directory when dir is empty.
+func backup(ctx context.Context, db *sql.DB, dir string, keep int, uploader *s3backup.Uploader) error {
+	snapshotDir := dir
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "mailinglist-backup-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+		snapshotDir = tmp
+	}
+
+	path := filepath.Join(snapshotDir, "mailinglist-"+time.Now().UTC().Format("20060102T150405Z")+".db")
+	if err := mdb.Backup(ctx, db, path); err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Info("database snapshot written", "path", path)
+
+	if uploader != nil {
+		name, err := uploader.Upload(ctx, path)
+		if err != nil {
+			return err
+		}
+		logging.FromContext(ctx).Info("database snapshot uploaded", "name", name)
+
+		removed, err := uploader.Prune(ctx, backupPattern)
+		for _, name := range removed {
+			logging.FromContext(ctx).Info("old database snapshot upload removed", "name", name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if dir == "" {
+		return nil
+	}
+
+	// The names sort by time.
+	snapshots, err := filepath.Glob(filepath.Join(dir, backupPattern))
+	if err != nil {
+		return err
+	}
+	sort.Strings(snapshots)
+	for len(snapshots) > keep {
+		if err := os.Remove(snapshots[0]); err != nil {
+			return err
+		}
+		logging.FromContext(ctx).Info("old database snapshot removed", "path", snapshots[0])
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
+
+// maintenance returns the maintenance job, done once in each opening of
+// the window, the runs out of it skipped. The job ticks every minute, for
+// the short windows not to fall between two ticks. The runs asked for at
+// /admin/jobs are done at once.
+func maintenance(db *sql.DB, window scheduler.Window) func(ctx context.Context) error {
+	var last time.Time
+	return func(ctx context.Context) error {
+		now := time.Now()
+		opened, open := window.Opened(now)
+		if !scheduler.Manual(ctx) && (!open || last.After(opened)) {
+			logging.FromContext(ctx).Debug("skipped out of the maintenance window", "window", window)
+			return nil
+		}
+
+		report, err := mdb.Maintain(ctx, db)
+		if err != nil {
+			return err
+		}
+		last = now
+		logging.FromContext(ctx).Info("database maintenance done", "vacuumed", report.Vacuumed,
+			"size_before", report.SizeBefore, "size_after", report.SizeAfter, "reclaimed", report.Reclaimed())
+		return nil
+	}
+}
+
+// newScheduler returns the scheduler of the recurring jobs of the
+// configuration, not started yet.
+func newScheduler(db *sql.DB, st *state.State, cfg *config.Config, links *personalize.Personalizer, logger *slog.Logger) (*scheduler.Scheduler, error) {
+	// Typos would silently leave a job running.
+	for _, name := range cfg.DisabledJobs {
+		if !jobNames[name] {
+			return nil, fmt.Errorf("jobs.disabled : %w %v", scheduler.ErrUnknownJob, name)
+		}
+	}
+	for name := range cfg.JobIntervals {
+		if !jobNames[name] {
+			return nil, fmt.Errorf("jobs.intervals : %w %v", scheduler.ErrUnknownJob, name)
+		}
+	}
+
+	window, err := scheduler.ParseWindow(cfg.MaintenanceWindow)
+	if err != nil {
+		return nil, fmt.Errorf("jobs.maintenance_window : %w", err)
+	}
+
+	jobs := []scheduler.Job{
+		{
+			Name:     "purge-trash",
+			Interval: time.Hour,
+			Run: writeJob(st, func(ctx context.Context) error {
+				purged, err := mdb.PurgeTrash(ctx, db, time.Now().Add(-cfg.TrashRetention))
+				if err == nil && purged > 0 {
+					logging.FromContext(ctx).Info("purged emails past the trash retention window", "count", purged)
+				}
+				return err
+			}),
+		},
+		{
+			Name:     "stats-rollup",
+			Interval: time.Hour,
+			Run: writeJob(st, func(ctx context.Context) error {
+				return mdb.RollupStats(ctx, db)
+			}),
+		},
+		{
+			Name:     "prune-outbox",
+			Interval: time.Hour,
+			Run: writeJob(st, func(ctx context.Context) error {
+				pruned, err := mdb.PruneOutbox(ctx, db, time.Now().Add(-cfg.OutboxRetention))
+				if err == nil && pruned > 0 {
+					logging.FromContext(ctx).Warn("pruned events no server published from the outbox", "count", pruned)
+				}
+				return err
+			}),
+		},
+		{
+			Name:     "maintenance",
+			Interval: time.Minute,
+			Run:      writeJob(st, maintenance(db, window)),
+		},
+	}
+	if cfg.PendingRetention > 0 {
+		jobs = append(jobs, scheduler.Job{
+			Name:     "trash-unconfirmed",
+			Interval: time.Hour,
+			Run: writeJob(st, func(ctx context.Context) error {
+				trashed, err := mdb.TrashUnconfirmed(ctx, db, time.Now().Add(-cfg.PendingRetention))
+				if err == nil && trashed > 0 {
+					logging.FromContext(ctx).Info("moved stale unconfirmed emails to the trash", "count", trashed)
+				}
+				return err
+			}),
+		})
+	}
+	if cfg.MailProvider != "" {
+		jobs = append(jobs, scheduler.Job{
+			Name:     "campaigns",
+			Interval: time.Minute,
+			Run: writeJob(st, func(ctx context.Context) error {
+				return campaigns.Run(ctx, db, links, cfg.MailConfirm)
+			}),
+		})
+	}
+	if cfg.BounceIMAP != "" {
+		poller := bounces.NewPoller(db, bounces.IMAPOptions{
+			Addr:       cfg.BounceIMAP,
+			Plaintext:  cfg.BounceIMAPPlaintext,
+			Username:   cfg.BounceIMAPUsername,
+			Password:   cfg.BounceIMAPPassword,
+			Mailbox:    cfg.BounceIMAPMailbox,
+			VERP:       cfg.BounceVERP,
+			VERPSecret: cfg.MailLinkSecret,
+		})
+		jobs = append(jobs, scheduler.Job{
+			Name:     "bounces",
+			Interval: time.Minute,
+			Run:      writeJob(st, poller.Poll),
+		})
+	}
+	var uploader *s3backup.Uploader
+	if cfg.S3Bucket != "" {
+		var err error
+		uploader, err = s3backup.New(s3backup.Options{
+			Endpoint:   cfg.S3Endpoint,
+			Insecure:   cfg.S3Insecure,
+			Region:     cfg.S3Region,
+			Bucket:     cfg.S3Bucket,
+			Prefix:     cfg.S3Prefix,
+			AccessKey:  cfg.S3AccessKey,
+			SecretKey:  cfg.S3SecretKey,
+			Recipients: cfg.S3Recipients,
+			Keep:       cfg.S3Keep,
+			Retention:  cfg.S3Retention,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("s3 : %w", err)
+		}
+	}
+	if cfg.BackupDir != "" || uploader != nil {
+		jobs = append(jobs, scheduler.Job{
+			Name:     "backup",
+			Interval: 24 * time.Hour,
+			Run: func(ctx context.Context) error {
+				return backup(ctx, db, cfg.BackupDir, cfg.BackupKeep, uploader)
+			},
+		})
+	}
+
+	disabled := make(map[string]bool)
+	for _, name := range cfg.DisabledJobs {
+		disabled[name] = true
+	}
+
+	sched := scheduler.New(logger)
+	for _, job := range jobs {
+		if interval, ok := cfg.JobIntervals[job.Name]; ok {
+			job.Interval = interval
+		}
+		if job.Name == "maintenance" && job.Interval > window.Length() {
+			return nil, fmt.Errorf("jobs.intervals : maintenance every %v could miss the window %v", job.Interval, window)
+		}
+		if err := sched.Add(job, !disabled[job.Name]); err != nil {
+			return nil, err
+		}
+	}
+	return sched, nil
+}
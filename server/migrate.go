@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"mailinglist/config"
+	"mailinglist/mdb"
+	"os"
+	"text/tabwriter"
+)
+
+// runMigrate runs the migrate command on the database of the settings,
+// showing the status of the schema once done.
+func runMigrate(cmd *config.MigrateCommand) error {
+	db, err := sql.Open("sqlite3", args.DbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	version, err := mdb.GetSchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	switch {
+	case cmd.Up != nil:
+		err = mdb.Migrate(ctx, db, mdb.SchemaVersion)
+	case cmd.Down != nil:
+		if version == 0 {
+			return errors.New("no migration to revert")
+		}
+		err = mdb.Migrate(ctx, db, version-1)
+	case cmd.To != nil:
+		err = mdb.Migrate(ctx, db, cmd.To.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return writeMigrateStatus(ctx, os.Stdout, db)
+}
+
+// writeMigrateStatus writes the version of the schema and whether each
+// migration is applied.
+func writeMigrateStatus(ctx context.Context, w io.Writer, db *sql.DB) error {
+	version, err := mdb.GetSchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "schema version %v, the last is %v\n\n", version, mdb.SchemaVersion)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tMIGRATION\tSTATUS")
+	for _, m := range mdb.Migrations {
+		status := "pending"
+		if m.Version <= version {
+			status = "applied"
+		}
+		if m.Down == nil {
+			status += ", irreversible"
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", m.Version, m.Name, status)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mailinglist/config"
+	"mailinglist/logging"
+	"mailinglist/mdb"
+	"mailinglist/tlsutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// certificateWarning is how long before its expiry a certificate is
+// warned about.
+const certificateWarning = 14 * 24 * time.Hour
+
+type selfCheck struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// dbChecks are the checks of the database, each one skipped once one
+// fails, as they would fail for the same reason.
+var dbChecks = map[string]bool{"database path": true, "database connection": true, "database schema": true, "database writable": true}
+
+// runSelfChecks verifies that the database and the TLS material are usable
+// before the ports are bound, logging what is wrong with each failed
+// check, so that the server exits at startup rather than failing the first
+// requests.
+func runSelfChecks(ctx context.Context, db *sql.DB, cfg *config.Config) error {
+	checks := []selfCheck{
+		{"database path", func(ctx context.Context) error {
+			return checkDbPath(cfg.DbPath)
+		}},
+		{"database connection", func(ctx context.Context) error {
+			return db.PingContext(ctx)
+		}},
+		{"database schema", func(ctx context.Context) error {
+			version, err := mdb.GetSchemaVersion(ctx, db)
+			if err == nil && version > mdb.SchemaVersion {
+				err = fmt.Errorf("schema version %v is newer than %v, the database was upgraded by a newer release", version, mdb.SchemaVersion)
+			}
+			return err
+		}},
+		{"database writable", func(ctx context.Context) error {
+			return mdb.CheckWritable(ctx, db)
+		}},
+	}
+	if cfg.GrpcTLSCert != "" {
+		checks = append(checks, selfCheck{"tls", func(ctx context.Context) error {
+			tlsConfig, err := tlsutil.ServerConfig(cfg.GrpcTLSCert, cfg.GrpcTLSKey, cfg.GrpcTLSClientCA)
+			if err != nil {
+				return err
+			}
+			return checkCertificate(ctx, tlsConfig.Certificates[0])
+		}})
+	}
+	if cfg.SyncPeerCaCert != "" {
+		checks = append(checks, selfCheck{"sync peer tls", func(ctx context.Context) error {
+			_, err := tlsutil.ClientConfig(cfg.SyncPeerCaCert, cfg.GrpcTLSCert, cfg.GrpcTLSKey)
+			return err
+		}})
+	}
+
+	logger := logging.FromContext(ctx)
+	var failed []string
+	dbFailed := false
+	for _, check := range checks {
+		if dbFailed && dbChecks[check.name] {
+			logger.Warn("self-check skipped", "check", check.name)
+			continue
+		}
+		if err := check.run(ctx); err != nil {
+			dbFailed = dbFailed || dbChecks[check.name]
+			logger.Error("self-check failed", "check", check.name, "err", err)
+			failed = append(failed, check.name)
+			continue
+		}
+		logger.Debug("self-check passed", "check", check.name)
+	}
+	if len(failed) > 0 {
+		return errors.New("failed " + strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// checkDbPath verifies that the database file, when it exists, and its
+// directory, where SQLite writes its journal, can be written.
+func checkDbPath(path string) error {
+	if path == ":memory:" || strings.HasPrefix(path, "file:") {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && !info.Mode().IsRegular():
+		return fmt.Errorf("%v is not a file", path)
+	case err == nil:
+		file, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		file.Close()
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	probe, err := os.CreateTemp(filepath.Dir(path), ".mailinglist-check-*")
+	if err != nil {
+		return fmt.Errorf("the directory of the database cannot be written: %w", err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// checkCertificate fails on a certificate not valid yet or expired, and
+// warns about one expiring soon.
+func checkCertificate(ctx context.Context, cert tls.Certificate) error {
+	leaf := cert.Leaf
+	now := time.Now()
+	switch {
+	case now.Before(leaf.NotBefore):
+		return fmt.Errorf("the certificate of %v is not valid before %v", leaf.Subject, leaf.NotBefore)
+	case now.After(leaf.NotAfter):
+		return fmt.Errorf("the certificate of %v expired on %v", leaf.Subject, leaf.NotAfter)
+	case now.Add(certificateWarning).After(leaf.NotAfter):
+		logging.FromContext(ctx).Warn("the certificate expires soon", "subject", leaf.Subject.String(), "not_after", leaf.NotAfter)
+	}
+	return nil
+}
@@ -1,64 +1,595 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"fmt"
 	"log"
+	"log/slog"
+	"mailinglist/config"
+	"mailinglist/confirmation"
+	"mailinglist/crash"
+	"mailinglist/delivery"
+	"mailinglist/diagnostics"
+	"mailinglist/flags"
+	"mailinglist/gateway"
 	"mailinglist/grpcapi"
+	"mailinglist/handoff"
 	"mailinglist/jsonapi"
+	"mailinglist/leader"
+	"mailinglist/logging"
+	"mailinglist/mailer"
 	"mailinglist/mdb"
+	"mailinglist/outbox"
+	"mailinglist/personalize"
+	"mailinglist/ratelimit"
+	"mailinglist/redisstore"
+	"mailinglist/sendqueue"
+	"mailinglist/state"
+	"mailinglist/tlsutil"
+	"mailinglist/tracing"
+	"mailinglist/webhooks"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/alexflint/go-arg"
+	"github.com/coreos/go-systemd/v22/daemon"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
-var args struct {
-	DbPath   string `arg:"env:MAILING_LIST_DB"`
-	BindJson string `arg:"env:MAILING_LIST_BIND_PORT"`
-	BindGrpc string `arg:"env:MAILING_LIST_GRPC_BIND_PORT"`
+var args *config.Config
+
+// upgradeTimeout bounds the start of the new process on SIGUSR2, killed
+// past it.
+const upgradeTimeout = time.Minute
+
+// fatal logs the error and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
 
-func main() {
-	arg.MustParse(&args)
+func webhookProviders(cfg *config.Config) (map[string]webhooks.Provider, error) {
+	providers := make(map[string]webhooks.Provider)
+
+	if cfg.SesTopicArn != "" {
+		providers["ses"] = webhooks.NewSES(cfg.SesTopicArn)
+	}
+	if cfg.SendGridWebhookKey != "" {
+		sendGrid, err := webhooks.NewSendGrid(cfg.SendGridWebhookKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SendGrid webhook key : %w", err)
+		}
+		providers["sendgrid"] = sendGrid
+	}
+	if cfg.MailgunWebhookKey != "" {
+		providers["mailgun"] = webhooks.NewMailgun(cfg.MailgunWebhookKey)
+	}
+	if cfg.PostmarkWebhookAuth != "" {
+		username, password, _ := strings.Cut(cfg.PostmarkWebhookAuth, ":")
+		providers["postmark"] = webhooks.NewPostmark(username, password)
+	}
+
+	return providers, nil
+}
+
+// eventSink returns the sink the email events are published to, or nil
+// when neither Kafka nor NATS is set.
+func eventSink(cfg *config.Config) (outbox.Sink, error) {
+	if len(cfg.KafkaBrokers) > 0 {
+		return outbox.NewKafka(cfg.KafkaBrokers, cfg.KafkaTopic), nil
+	}
+	if cfg.NatsURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return outbox.NewNats(ctx, cfg.NatsURL, cfg.NatsSubject, cfg.NatsStream)
+	}
+	return nil, nil
+}
+
+// reloader applies the settings that can change while the server runs.
+type reloader struct {
+	logger    *slog.Logger
+	logLevel  *slog.LevelVar
+	limiter   *ratelimit.Limiter
+	providers *webhooks.Providers
+	flags     *flags.Set
+}
+
+// reload reads the configuration again and applies the log level, the rate
+// limits, the webhook providers and the flags, over their changes made with
+// the admin API. The other changes are logged as
+// waiting for a restart. Invalid settings are rejected as a whole.
+func (r *reloader) reload() {
+	next, err := config.Reload()
+	if err != nil {
+		r.logger.Error("config reload failed, keeping the current settings", "err", err)
+		return
+	}
+	providers, err := webhookProviders(next)
+	if err != nil {
+		r.logger.Error("config reload failed, keeping the current settings", "err", err)
+		return
+	}
+	// Both were validated with the configuration.
+	level, _ := logging.ParseLevel(next.LogLevel)
+	rules, _ := next.RateLimitPolicy()
+
+	r.logLevel.Set(level)
+	r.limiter.SetRules(rules)
+	r.providers.Set(providers)
+	r.flags.Set(next.FlagValues())
+
+	current := args.Lines()
+	args.LogLevel = next.LogLevel
+	args.RateLimit, args.RateLimitBurst, args.RateLimitRules = next.RateLimit, next.RateLimitBurst, next.RateLimitRules
+	// The delivery of the events to the webhook endpoints needs a restart.
+	args.SesTopicArn, args.SendGridWebhookKey = next.SesTopicArn, next.SendGridWebhookKey
+	args.MailgunWebhookKey, args.PostmarkWebhookAuth = next.MailgunWebhookKey, next.PostmarkWebhookAuth
+	args.ReadOnly, args.Flags = next.ReadOnly, next.Flags
+	applied := args.Lines()
+	for i, line := range next.Lines() {
+		if line != applied[i] {
+			r.logger.Warn("config change needs a restart", "setting", line)
+		} else if line != current[i] {
+			r.logger.Info("config setting changed", "setting", line)
+		}
+	}
+	r.logger.Info("config reloaded")
+}
+
+// syncPeerCredentials uses TLS towards the sync peer when its CA is set,
+// presenting the gRPC server certificate as client certificate.
+func syncPeerCredentials() credentials.TransportCredentials {
+	if args.SyncPeerCaCert == "" {
+		return insecure.NewCredentials()
+	}
 
-	// Default values
-	if args.DbPath == "" {
-		args.DbPath = "list.db"
+	tlsConfig, err := tlsutil.ClientConfig(args.SyncPeerCaCert, args.GrpcTLSCert, args.GrpcTLSKey)
+	if err != nil {
+		fatal("invalid sync peer TLS configuration", err)
 	}
-	if args.BindJson == "" {
-		args.BindJson = ":9091"
+	return credentials.NewTLS(tlsConfig)
+}
+
+// gatewayCredentials dials the local gRPC server over TLS when it is
+// enabled, trusting its own certificate and presenting it as client
+// certificate. The certificate must then be valid for localhost.
+func gatewayCredentials() credentials.TransportCredentials {
+	if args.GrpcTLSCert == "" {
+		return insecure.NewCredentials()
 	}
-	if args.BindGrpc == "" {
-		args.BindGrpc = ":9092"
+
+	tlsConfig, err := tlsutil.ClientConfig(args.GrpcTLSCert, args.GrpcTLSCert, args.GrpcTLSKey)
+	if err != nil {
+		fatal("invalid gateway TLS configuration", err)
+	}
+	return credentials.NewTLS(tlsConfig)
+}
+
+// newGatewayToken returns the secret the REST gateway tells itself to the
+// gRPC server with, new on each start.
+func newGatewayToken() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		fatal("error generating the gateway token", err)
 	}
+	return hex.EncodeToString(buf)
+}
 
-	log.Printf("using db path %v and bind address %v\n", args.DbPath, args.BindJson)
+// drain makes the server a lame duck for the lame duck period, or until
+// another terminal signal, for the load balancers to stop sending it
+// requests before it shuts down.
+func drain(sigChan <-chan os.Signal, st *state.State, logger *slog.Logger) {
+	st.SetLameDuck(true)
+	logger.Info("lame duck, draining before the shutdown", "period", args.LameDuckPeriod)
+	timer := time.NewTimer(args.LameDuckPeriod)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return
+		case sig := <-sigChan:
+			if sig == os.Interrupt || sig == syscall.SIGTERM {
+				logger.Info("received terminal signal, draining cut short", "signal", sig)
+				return
+			}
+		}
+	}
+}
+
+func main() {
+	var err error
+	if args, err = config.MustLoad(); err != nil {
+		log.Fatal(err)
+	}
+	if err := args.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if args.SystemdUnit != "" {
+		if err := writeSystemdUnit(os.Stdout, args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if args.Migrate != nil {
+		if err := runMigrate(args.Migrate); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if args.Anonymize != nil {
+		if err := runAnonymize(args.Anonymize); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// The standard log package, still used by the dependencies, also
+	// writes to the logger.
+	logLevel := &slog.LevelVar{}
+	level, _ := logging.ParseLevel(args.LogLevel)
+	logLevel.Set(level)
+	logger, err := logging.Open(logging.Files{
+		Path:           args.LogFile,
+		Components:     args.LogFiles,
+		MaxSize:        args.LogMaxSize,
+		RotateInterval: args.LogRotateInterval,
+		MaxAge:         args.LogMaxAge,
+		MaxBackups:     args.LogMaxBackups,
+		Compress:       args.LogCompress,
+	}, logLevel, args.LogFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+	for _, line := range args.Lines() {
+		logger.Info("config " + line)
+	}
+
+	if args.CrashDSN != "" {
+		reporter, err := crash.New(crash.Options{DSN: args.CrashDSN, Environment: args.CrashEnvironment})
+		if err != nil {
+			fatal("error setting up crash reporting", err)
+		}
+		crash.SetDefault(reporter)
+	}
+	if args.CrashFile != "" {
+		if err := crash.CaptureFatal(logging.NewContext(context.Background(), logger), args.CrashFile); err != nil {
+			fatal("error setting up the crash file", err)
+		}
+	}
 
 	db, err := sql.Open("sqlite3", args.DbPath)
 	if err != nil {
-		log.Fatalf("Error opening sqlite db : %v\n", err)
+		fatal("error opening sqlite db", err)
 	}
 	defer db.Close()
 
-	mdb.TryCreate(db)
+	checkCtx, cancelCheck := context.WithTimeout(logging.NewContext(context.Background(), logger), 30*time.Second)
+	err = runSelfChecks(checkCtx, db, args)
+	cancelCheck()
+	if err != nil {
+		fatal("startup self-check failed", err)
+	}
+
+	if args.ManualMigrations {
+		version, err := mdb.GetSchemaVersion(context.Background(), db)
+		if err == nil && version != mdb.SchemaVersion {
+			err = fmt.Errorf("schema version %v is not %v, run migrate up", version, mdb.SchemaVersion)
+		}
+		if err != nil {
+			fatal("the database is not migrated", err)
+		}
+	} else if err := mdb.Migrate(logging.NewContext(context.Background(), logger), db, mdb.SchemaVersion); err != nil {
+		fatal("error migrating the database", err)
+	}
 
-	jsonServer := jsonapi.Serve(db, args.BindJson)
-	defer func() {
-		log.Println("HTTP Server graceful stop...")
-		jsonapi.Shutdown(jsonServer)
-	}()
+	purged, err := mdb.PurgeTrash(logging.NewContext(context.Background(), logger), db, time.Now().Add(-args.TrashRetention))
+	if err != nil {
+		fatal("error purging trash", err)
+	}
+	logger.Info("purged emails past the trash retention window", "count", purged)
 
-	grpcServer := grpcapi.Serve(db, args.BindGrpc)
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    args.OtlpEndpoint,
+		Insecure:    args.OtlpInsecure,
+		ServiceName: "mailinglist",
+	})
+	if err != nil {
+		fatal("error setting up tracing", err)
+	}
 	defer func() {
-		log.Println("gRPC Server graceful stop...")
-		grpcServer.GracefulStop()
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Error("error flushing traces", "err", err)
+		}
 	}()
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Kill)
-	signal.Notify(sigChan, os.Interrupt)
+	// Validated with the configuration.
+	featureFlags, _ := flags.New(args.FlagValues())
+	st := state.New(featureFlags, args.DebugBodies)
+	rules, _ := args.RateLimitPolicy()
+	limiter := ratelimit.New(rules)
+
+	var store *redisstore.Store
+	if args.RedisAddr != "" {
+		store, err = redisstore.New(context.Background(), redisstore.Options{
+			Addr:           args.RedisAddr,
+			Password:       args.RedisPassword,
+			DB:             args.RedisDB,
+			Prefix:         args.RedisPrefix,
+			EmailTTL:       args.EmailCacheTTL,
+			IdempotencyTTL: args.IdempotencyTTL,
+		})
+		if err != nil {
+			fatal("error connecting to redis", err)
+		}
+		defer store.Close()
+		limiter.SetStore(store)
+
+		cacheCtx, stopCache := context.WithCancel(logging.NewContext(context.Background(), logger.With("component", "email-cache")))
+		defer stopCache()
+		go store.InvalidateOnEvents(cacheCtx, db)
+	}
+
+	providers, err := webhookProviders(args)
+	if err != nil {
+		fatal("error setting up the webhooks", err)
+	}
+	hooks := webhooks.NewProviders(providers)
+
+	links := personalize.New(db, personalize.Options{
+		PublicURL:     args.MailPublicURL,
+		LinkSecret:    args.MailLinkSecret,
+		ConfirmExpiry: args.MailConfirmExpiry,
+
+		UnsubscribeMailto: args.MailUnsubscribeMailto,
+		TrackClicks:       args.MailTrackClicks,
+		TrackOpens:        args.MailTrackOpens,
+	})
+
+	sched, err := newScheduler(db, st, args, links, logger)
+	if err != nil {
+		fatal("error setting up the jobs", err)
+	}
+	var lease *leader.Lease
+	if args.LeaderElection {
+		lease = leader.NewLease(db, "scheduler", leader.Holder(), args.LeaseTTL, logger)
+		leaseCtx, stopLease := context.WithCancel(context.Background())
+		leaseDone := make(chan struct{})
+		go func() {
+			lease.Run(leaseCtx)
+			close(leaseDone)
+		}()
+		// Released once the jobs and the relay have stopped.
+		defer func() {
+			stopLease()
+			<-leaseDone
+		}()
+		sched.SetElector(lease)
+	}
+	sched.Start()
+	defer sched.Stop()
+
+	sink, err := eventSink(args)
+	if err != nil {
+		fatal("error setting up the events", err)
+	}
+	if sink != nil {
+		relay := outbox.NewRelay(db, sink, logger)
+		if lease != nil {
+			relay.SetElector(lease)
+		}
+		relayCtx, stopRelay := context.WithCancel(context.Background())
+		relayDone := make(chan struct{})
+		go func() {
+			relay.Run(relayCtx)
+			close(relayDone)
+		}()
+		defer func() {
+			stopRelay()
+			<-relayDone
+			relay.Close()
+		}()
+	}
+
+	endpoints := make([]string, 0, len(args.WebhookEndpoints))
+	for name := range args.WebhookEndpoints {
+		endpoints = append(endpoints, name)
+	}
+	if err := mdb.AddWebhookEndpoints(logging.NewContext(context.Background(), logger), db, endpoints); err != nil {
+		fatal("error setting up the webhook endpoints", err)
+	}
+	var dispatcher *delivery.Dispatcher
+	if len(endpoints) > 0 {
+		dispatcher = delivery.New(db, delivery.Options{
+			Endpoints:   args.WebhookEndpoints,
+			Secret:      args.WebhookSecret,
+			Workers:     args.WebhookWorkers,
+			MaxAttempts: args.WebhookMaxAttempts,
+			Paused: func() bool {
+				return !featureFlags.Enabled(flags.Webhooks)
+			},
+		}, logger)
+		deliveryCtx, stopDelivery := context.WithCancel(context.Background())
+		deliveryDone := make(chan struct{})
+		go func() {
+			dispatcher.Run(deliveryCtx)
+			close(deliveryDone)
+		}()
+		defer func() {
+			stopDelivery()
+			<-deliveryDone
+		}()
+	}
+
+	var sender mailer.Sender
+	if args.MailProvider != "" {
+		// Validated with the configuration.
+		sender, _ = mailer.New(args.MailOptions())
+	}
+
+	if sender != nil {
+		// Validated with the configuration.
+		domainLimits, _ := args.DomainLimits()
+		queue := sendqueue.New(db, sender, sendqueue.Options{
+			Workers:      args.MailWorkers,
+			MaxAttempts:  args.MailMaxAttempts,
+			Paused:       st.ReadOnly,
+			VERP:         args.BounceVERP,
+			VERPSecret:   args.MailLinkSecret,
+			DomainLimits: domainLimits,
+		}, logger)
+		queueCtx, stopQueue := context.WithCancel(context.Background())
+		queueDone := make(chan struct{})
+		go func() {
+			queue.Run(queueCtx)
+			close(queueDone)
+		}()
+		defer func() {
+			stopQueue()
+			<-queueDone
+		}()
+	}
+
+	var confirmations *confirmation.Sender
+	if args.MailConfirm {
+		confirmations = confirmation.New(db, links, args.MailConfirmTemplate, args.MailConfirmCooldown)
+	}
+
+	// Validated with the configuration.
+	proxies, _ := ratelimit.ParseProxies(args.TrustedProxies)
+
+	// The gRPC server is started first as the REST gateway dials it.
+	var gatewayHandler http.Handler
+	if !args.DisableGrpc {
+		gatewayToken := newGatewayToken()
+		grpcServer := grpcapi.Serve(db, args.BindGrpc, grpcapi.Options{
+			State:           st,
+			RequireApiKey:   args.RequireApiKey,
+			TLSCertFile:     args.GrpcTLSCert,
+			TLSKeyFile:      args.GrpcTLSKey,
+			TLSClientCAFile: args.GrpcTLSClientCA,
+
+			MaxRecvMsgSize:       args.GrpcMaxRecvMsgSize,
+			MaxSendMsgSize:       args.GrpcMaxSendMsgSize,
+			MaxConcurrentStreams: args.GrpcMaxConcurrentStreams,
+			KeepaliveTime:        args.GrpcKeepaliveTime,
+			KeepaliveTimeout:     args.GrpcKeepaliveTimeout,
+			KeepaliveMinTime:     args.GrpcKeepaliveMinTime,
+
+			RateLimiter:    limiter,
+			TrustedProxies: proxies,
+			GatewayToken:   gatewayToken,
+			UnixSocket:     args.GrpcUnix,
+			Redis:          store,
+			Links:          links,
+			Confirmations:  confirmations,
+			Logger:         logger,
+		})
+		defer func() {
+			logger.Info("gRPC server graceful stop")
+			grpcServer.Shutdown(args.GrpcShutdownTimeout)
+		}()
+
+		gatewayHandler, err = gateway.New(context.Background(), args.BindGrpc, gatewayCredentials(), gatewayToken)
+		if err != nil {
+			fatal("error creating REST gateway", err)
+		}
+	}
 
-	sig := <-sigChan
-	log.Printf("Received terminal signal %v, Graceful shutdown\n", sig)
+	if !args.DisableJson {
+		jsonOpts := jsonapi.Options{
+			RequireApiKey:  args.RequireApiKey,
+			AdminToken:     args.AdminToken,
+			TrashRetention: args.TrashRetention,
+			Webhooks:       hooks,
+			State:          st,
+			Gateway:        gatewayHandler,
+			RateLimiter:    limiter,
+			TrustedProxies: proxies,
+			Scheduler:      sched,
+			Redis:          store,
+			Deliveries:     dispatcher,
+			Mailer:         sender,
+			Links:          links,
+			Confirmations:  confirmations,
+			Logger:         logger,
+
+			GrpcServiceConfig: grpcapi.ServiceConfig,
+			AdminBind:         args.BindAdmin,
+		}
+		if args.BindDebug != "" && args.BindDebug == args.BindAdmin {
+			jsonOpts.Diagnostics = diagnostics.Handler(db)
+		}
+		jsonServer := jsonapi.Serve(db, args.BindJson, jsonOpts)
+		defer func() {
+			logger.Info("HTTP server graceful stop")
+			jsonapi.Shutdown(jsonServer)
+		}()
+
+		if args.BindAdmin != "" {
+			adminServer := jsonapi.ServeAdmin(db, jsonOpts)
+			defer jsonapi.Shutdown(adminServer)
+		}
+	}
+
+	if args.BindDebug != "" && args.BindDebug != args.BindAdmin {
+		debugServer := diagnostics.Serve(db, args.BindDebug, logger)
+		defer diagnostics.Shutdown(debugServer)
+	}
+
+	if args.SyncPeer != "" {
+		stopSync := grpcapi.SyncWithPeer(db, args.SyncPeer, args.SyncPeerApiKey, syncPeerCredentials(), st, logger)
+		defer stopSync()
+	}
+
+	reloader := &reloader{logger: logger, logLevel: logLevel, limiter: limiter, providers: hooks, flags: featureFlags}
+
+	// The process which handed over the sockets shuts down now.
+	if err := handoff.Ready(); err != nil {
+		logger.Error("error signaling the previous process", "err", err)
+	}
+	notify(logger, daemon.SdNotifyReady)
+	watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+	defer stopWatchdog()
+	go watchdog(watchdogCtx, db, logger)
+
+	// SIGKILL cannot be caught, SIGTERM is what service managers send.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
+	for sig := range sigChan {
+		if sig == syscall.SIGHUP {
+			notify(logger, daemon.SdNotifyReloading)
+			reloader.reload()
+			notify(logger, daemon.SdNotifyReady)
+			continue
+		}
+		if sig == syscall.SIGUSR2 {
+			// Starts the executable, e.g. a new release, on the same sockets.
+			ctx, cancel := context.WithTimeout(context.Background(), upgradeTimeout)
+			pid, err := handoff.Upgrade(ctx)
+			cancel()
+			if err != nil {
+				logger.Error("upgrade failed, still serving", "err", err)
+				continue
+			}
+			logger.Info("upgraded, graceful shutdown", "pid", pid)
+			notify(logger, fmt.Sprintf("MAINPID=%v", pid))
+			break
+		}
+		notify(logger, daemon.SdNotifyStopping)
+		if args.LameDuckPeriod > 0 && !st.LameDuck() {
+			drain(sigChan, st, logger)
+		}
+		logger.Info("received terminal signal, graceful shutdown", "signal", sig)
+		break
+	}
 
 }
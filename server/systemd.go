@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"mailinglist/config"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+)
+
+// notify sends the state to systemd, when it runs the server with
+// Type=notify. NOTIFY_SOCKET is kept for the processes started on upgrade.
+func notify(logger *slog.Logger, state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		logger.Warn("notifying systemd failed", "state", state, "err", err)
+	}
+}
+
+// watchdog pings the systemd watchdog while the database answers, when
+// WatchdogSec is set, so that systemd restarts a server which hangs.
+func watchdog(ctx context.Context, db *sql.DB, logger *slog.Logger) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil || interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, interval/2)
+		err := db.PingContext(pingCtx)
+		cancel()
+		if err != nil {
+			logger.Warn("database unreachable, the systemd watchdog is not pinged", "err", err)
+			continue
+		}
+		notify(logger, daemon.SdNotifyWatchdog)
+	}
+}
+
+// writeSystemdUnit writes the service or the socket unit running the server
+// with the settings of cfg, from the file given by --config and from the
+// current directory, where the relative paths resolve.
+func writeSystemdUnit(w io.Writer, cfg *config.Config) error {
+	if cfg.SystemdUnit == "socket" {
+		return writeSocketUnit(w, cfg)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	start := systemdQuote(executable)
+	if cfg.File != "" {
+		file, err := filepath.Abs(cfg.File)
+		if err != nil {
+			return err
+		}
+		start += " --config " + systemdQuote(file)
+	}
+	// The graceful stops of the gRPC server, then of the HTTP one, in 30s,
+	// then of the workers.
+	stopTimeout := cfg.GrpcShutdownTimeout + time.Minute
+
+	_, err = fmt.Fprintf(w, `[Unit]
+Description=Mailing list server
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+# The process started on SIGUSR2 notifies systemd, then becomes the main one.
+NotifyAccess=all
+ExecStart=%v
+ExecReload=/bin/kill -HUP $MAINPID
+WorkingDirectory=%v
+Restart=on-failure
+WatchdogSec=30s
+TimeoutStopSec=%v
+NoNewPrivileges=yes
+PrivateTmp=yes
+
+[Install]
+WantedBy=multi-user.target
+`, start, systemdQuote(dir), int(stopTimeout.Seconds()))
+	return err
+}
+
+// writeSocketUnit writes the socket unit listening on the addresses of the
+// servers, which are passed to the server on its start.
+func writeSocketUnit(w io.Writer, cfg *config.Config) error {
+	var listen []string
+	if !cfg.DisableJson {
+		listen = append(listen, cfg.BindJson)
+	}
+	if !cfg.DisableGrpc {
+		listen = append(listen, cfg.BindGrpc)
+	}
+	if !cfg.DisableJson && cfg.BindAdmin != "" {
+		listen = append(listen, cfg.BindAdmin)
+	}
+	if cfg.BindDebug != "" && cfg.BindDebug != cfg.BindAdmin {
+		listen = append(listen, cfg.BindDebug)
+	}
+
+	var lines strings.Builder
+	for _, addr := range listen {
+		stream, err := listenStream(addr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&lines, "ListenStream=%v\n", stream)
+	}
+	if !cfg.DisableGrpc && cfg.GrpcUnix != "" {
+		path, err := filepath.Abs(cfg.GrpcUnix)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&lines, "ListenStream=%v\nSocketMode=0660\n", path)
+	}
+
+	_, err := fmt.Fprintf(w, `[Unit]
+Description=Mailing list server sockets
+
+[Socket]
+%v
+[Install]
+WantedBy=sockets.target
+`, lines.String())
+	return err
+}
+
+// listenStream writes an address of the settings as systemd expects it:
+// the port alone for all the interfaces, else the IP address and port.
+func listenStream(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return port, nil
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(tcpAddr.IP.String(), strconv.Itoa(tcpAddr.Port)), nil
+}
+
+// systemdQuote quotes a path with spaces for the unit files, escaping the
+// specifiers.
+func systemdQuote(path string) string {
+	path = strings.ReplaceAll(path, "%", "%%")
+	if strings.ContainsAny(path, " \t\"\\") {
+		return strconv.Quote(path)
+	}
+	return path
+}
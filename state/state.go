@@ -0,0 +1,76 @@
+package state
+
+import (
+	"mailinglist/flags"
+	"sync"
+	"sync/atomic"
+)
+
+// State is the runtime state shared by the JSON and gRPC servers, which
+// can be changed while the servers are running.
+type State struct {
+	flags       *flags.Set
+	debugBodies atomic.Bool
+
+	mu       sync.Mutex
+	lameDuck bool
+	// lameDuckChanged is closed when lameDuck changes.
+	lameDuckChanged chan struct{}
+}
+
+func New(flags *flags.Set, debugBodies bool) *State {
+	s := &State{flags: flags, lameDuckChanged: make(chan struct{})}
+	s.debugBodies.Store(debugBodies)
+	return s
+}
+
+// ReadOnly reports whether writes are currently rejected, e.g. during
+// migrations or backups.
+func (s *State) ReadOnly() bool {
+	return s.flags.Enabled(flags.ReadOnly)
+}
+
+func (s *State) SetReadOnly(readOnly bool) {
+	s.flags.Set(map[string]bool{flags.ReadOnly: readOnly})
+}
+
+// Flags are the optional behaviors turned on or off, read only included.
+func (s *State) Flags() *flags.Set {
+	return s.flags
+}
+
+// DebugBodies reports whether request and response bodies are logged.
+func (s *State) DebugBodies() bool {
+	return s.debugBodies.Load()
+}
+
+func (s *State) SetDebugBodies(debugBodies bool) {
+	s.debugBodies.Store(debugBodies)
+}
+
+// LameDuck reports whether the server is draining before a shutdown: it
+// reports itself as not ready, for the load balancers to stop sending it
+// requests, but still serves the ones it receives.
+func (s *State) LameDuck() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lameDuck
+}
+
+func (s *State) SetLameDuck(lameDuck bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lameDuck != lameDuck {
+		s.lameDuck = lameDuck
+		close(s.lameDuckChanged)
+		s.lameDuckChanged = make(chan struct{})
+	}
+}
+
+// LameDuckChanged returns a channel closed on the next change of the lame
+// duck mode.
+func (s *State) LameDuckChanged() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lameDuckChanged
+}
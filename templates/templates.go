@@ -0,0 +1,141 @@
+// Package templates renders the emails from templates of their subject and
+// bodies, with text/template, and html/template for the HTML body, whose
+// merge tags are the fields of Data, e.g. {{.Email}}.
+package templates
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mailinglist/sanitize"
+	"text/template"
+)
+
+// Data are the merge tags of the templates.
+type Data struct {
+	Email string
+	// FirstName is empty for the subscribers without one, which
+	// {{.FirstName | default "there"}} replaces.
+	FirstName string
+	Tags      []string
+	// Fields are all the fields of the subscriber, e.g.
+	// {{index .Fields "company"}}.
+	Fields         map[string]string
+	UnsubscribeURL string
+	ConfirmURL     string
+}
+
+// Sample is the data the templates are checked with, and previewed with
+// by default.
+var Sample = Data{
+	Email:          "jane@example.com",
+	FirstName:      "Jane",
+	Tags:           []string{"vip"},
+	Fields:         map[string]string{"first_name": "Jane", "company": "Example"},
+	UnsubscribeURL: "https://example.com/unsubscribe/sample",
+	ConfirmURL:     "https://example.com/confirm/sample",
+}
+
+var funcs = map[string]any{
+	// default returns value, or def when value is empty.
+	"default": func(def, value string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	},
+}
+
+// Template is the source of the subject and the bodies of an email.
+type Template struct {
+	Subject string
+	Text    string
+	// HTML is the alternative to Text, optional.
+	HTML string
+}
+
+// Parsed is a template ready to be rendered.
+type Parsed struct {
+	subject, text *template.Template
+	html          *htmltemplate.Template
+}
+
+// Rendered is an email rendered for a recipient.
+type Rendered struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Parse parses the template, and renders it with Sample to catch the
+// unknown merge tags.
+func Parse(t Template) (*Parsed, error) {
+	if t.Subject == "" {
+		return nil, errors.New("missing subject")
+	}
+	if t.Text == "" {
+		return nil, errors.New("missing text body")
+	}
+
+	var (
+		p   Parsed
+		err error
+	)
+	if p.subject, err = template.New("Subject").Funcs(funcs).Parse(t.Subject); err != nil {
+		return nil, err
+	}
+	if p.text, err = template.New("Text").Funcs(funcs).Parse(t.Text); err != nil {
+		return nil, err
+	}
+	if t.HTML != "" {
+		if p.html, err = htmltemplate.New("HTML").Funcs(funcs).Parse(t.HTML); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.Render(Sample); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Render renders the email for the data. The subject must stay safe in a
+// header once rendered.
+func (p *Parsed) Render(data Data) (*Rendered, error) {
+	var r Rendered
+	var buf bytes.Buffer
+	if err := p.subject.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	r.Subject = buf.String()
+	if err := sanitize.Check("Subject", r.Subject); err != nil {
+		return nil, err
+	}
+
+	buf.Reset()
+	if err := p.text.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	r.Text = buf.String()
+
+	if p.html != nil {
+		buf.Reset()
+		if err := p.html.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		r.HTML = buf.String()
+	}
+	return &r, nil
+}
+
+// Hash returns the hex SHA-256 of the subject and the bodies, which tells
+// what a recipient was sent.
+func (r *Rendered) Hash() string {
+	h := sha256.New()
+	for _, part := range []string{r.Subject, r.Text, r.HTML} {
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
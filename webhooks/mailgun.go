@@ -0,0 +1,70 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxSignatureAge bounds how old a signed timestamp may be to limit replays.
+const maxSignatureAge = 15 * time.Minute
+
+type mailgunPayload struct {
+	Signature struct {
+		Timestamp string `json:"timestamp"`
+		Token     string `json:"token"`
+		Signature string `json:"signature"`
+	} `json:"signature"`
+	EventData struct {
+		Event     string `json:"event"`
+		Severity  string `json:"severity"`
+		Recipient string `json:"recipient"`
+	} `json:"event-data"`
+}
+
+// Mailgun handles Mailgun webhooks signed with the HTTP webhook signing key.
+type Mailgun struct {
+	signingKey []byte
+}
+
+func NewMailgun(signingKey string) *Mailgun {
+	return &Mailgun{signingKey: []byte(signingKey)}
+}
+
+func (m *Mailgun) Events(header http.Header, body []byte) ([]Event, error) {
+	payload := mailgunPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	sig := payload.Signature
+	timestamp, err := strconv.ParseInt(sig.Timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)) > maxSignatureAge {
+		return nil, ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, m.signingKey)
+	mac.Write([]byte(sig.Timestamp + sig.Token))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(sig.Signature)) {
+		return nil, ErrInvalidSignature
+	}
+
+	data := payload.EventData
+	switch data.Event {
+	case "failed":
+		if data.Severity == "permanent" {
+			return []Event{{Email: data.Recipient, Kind: Bounce}}, nil
+		}
+		return []Event{{Email: data.Recipient, Kind: SoftBounce}}, nil
+	case "complained":
+		return []Event{{Email: data.Recipient, Kind: Complaint}}, nil
+	case "unsubscribed":
+		return []Event{{Email: data.Recipient, Kind: Unsubscribe}}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,57 @@
+package webhooks
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+)
+
+type postmarkPayload struct {
+	RecordType      string
+	Type            string
+	Email           string
+	Recipient       string
+	SuppressSending bool
+}
+
+// Postmark handles Postmark webhooks. Postmark does not sign its requests,
+// so the webhook URL is expected to be configured with basic auth credentials.
+type Postmark struct {
+	username, password string
+}
+
+func NewPostmark(username, password string) *Postmark {
+	return &Postmark{username: username, password: password}
+}
+
+func (p *Postmark) Events(header http.Header, body []byte) ([]Event, error) {
+	request := http.Request{Header: header}
+	username, password, ok := request.BasicAuth()
+	if !ok ||
+		subtle.ConstantTimeCompare([]byte(username), []byte(p.username)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(password), []byte(p.password)) != 1 {
+		return nil, ErrInvalidSignature
+	}
+
+	payload := postmarkPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	switch payload.RecordType {
+	case "Bounce":
+		switch payload.Type {
+		case "HardBounce":
+			return []Event{{Email: payload.Email, Kind: Bounce}}, nil
+		case "SoftBounce", "Transient", "DnsError":
+			return []Event{{Email: payload.Email, Kind: SoftBounce}}, nil
+		}
+	case "SpamComplaint":
+		return []Event{{Email: payload.Email, Kind: Complaint}}, nil
+	case "SubscriptionChange":
+		if payload.SuppressSending {
+			return []Event{{Email: payload.Recipient, Kind: Unsubscribe}}, nil
+		}
+	}
+	return nil, nil
+}
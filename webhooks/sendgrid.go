@@ -0,0 +1,83 @@
+package webhooks
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type sendGridEvent struct {
+	Email string `json:"email"`
+	Event string `json:"event"`
+	Type  string `json:"type"`
+}
+
+// SendGrid handles SendGrid Event Webhook notifications signed with the
+// account's ECDSA verification key.
+type SendGrid struct {
+	publicKey *ecdsa.PublicKey
+}
+
+// NewSendGrid takes the base64 encoded verification key shown in the
+// SendGrid mail settings.
+func NewSendGrid(publicKey string) (*SendGrid, error) {
+	der, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("SendGrid verification key is not an ECDSA key")
+	}
+	return &SendGrid{publicKey: ecKey}, nil
+}
+
+func (s *SendGrid) Events(header http.Header, body []byte) ([]Event, error) {
+	timestamp := header.Get("X-Twilio-Email-Event-Webhook-Timestamp")
+	signature, err := base64.StdEncoding.DecodeString(header.Get("X-Twilio-Email-Event-Webhook-Signature"))
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	sentAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sentAt, 0)) > maxSignatureAge {
+		return nil, ErrInvalidSignature
+	}
+
+	digest := sha256.Sum256(append([]byte(timestamp), body...))
+	if !ecdsa.VerifyASN1(s.publicKey, digest[:], signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	var sgEvents []sendGridEvent
+	if err := json.Unmarshal(body, &sgEvents); err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for _, e := range sgEvents {
+		switch e.Event {
+		case "bounce":
+			// "blocked" bounces are temporary
+			if e.Type == "blocked" {
+				events = append(events, Event{Email: e.Email, Kind: SoftBounce})
+			} else {
+				events = append(events, Event{Email: e.Email, Kind: Bounce})
+			}
+		case "spamreport":
+			events = append(events, Event{Email: e.Email, Kind: Complaint})
+		case "unsubscribe", "group_unsubscribe":
+			events = append(events, Event{Email: e.Email, Kind: Unsubscribe})
+		}
+	}
+	return events, nil
+}
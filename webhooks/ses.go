@@ -0,0 +1,256 @@
+package webhooks
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var snsHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+type snsMessage struct {
+	Type             string
+	MessageId        string
+	Token            string
+	TopicArn         string
+	Subject          string
+	Message          string
+	SubscribeURL     string
+	Timestamp        string
+	SignatureVersion string
+	Signature        string
+	SigningCertURL   string
+}
+
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"`
+	Bounce           struct {
+		BounceType        string `json:"bounceType"`
+		BouncedRecipients []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint struct {
+		ComplainedRecipients []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+}
+
+// SES handles Amazon SES notifications delivered through an SNS topic.
+// Messages are verified against the SNS signing certificate and subscription
+// confirmations for the configured topic are accepted automatically.
+type SES struct {
+	topicArn string
+	client   *http.Client
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+func NewSES(topicArn string) *SES {
+	return &SES{
+		topicArn: topicArn,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		certs:    make(map[string]*x509.Certificate),
+	}
+}
+
+func (s *SES) Events(header http.Header, body []byte) ([]Event, error) {
+	msg := snsMessage{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+
+	if msg.TopicArn != s.topicArn {
+		return nil, fmt.Errorf("unexpected SNS topic %v", msg.TopicArn)
+	}
+	if err := s.verify(msg); err != nil {
+		return nil, err
+	}
+
+	switch msg.Type {
+	case "SubscriptionConfirmation":
+		return nil, s.confirm(msg)
+	case "Notification":
+		return sesEvents(msg.Message)
+	}
+	return nil, nil
+}
+
+func (s *SES) confirm(msg snsMessage) error {
+	if err := checkSnsURL(msg.SubscribeURL); err != nil {
+		return err
+	}
+
+	res, err := s.client.Get(msg.SubscribeURL)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("confirming SNS subscription: %v", res.Status)
+	}
+	slog.Info("confirmed SNS subscription", "topic_arn", msg.TopicArn)
+	return nil
+}
+
+func sesEvents(message string) ([]Event, error) {
+	notification := sesNotification{}
+	if err := json.Unmarshal([]byte(message), &notification); err != nil {
+		return nil, err
+	}
+
+	notificationType := notification.NotificationType
+	if notificationType == "" {
+		notificationType = notification.EventType
+	}
+
+	var events []Event
+	switch notificationType {
+	case "Bounce":
+		kind := Bounce
+		switch notification.Bounce.BounceType {
+		case "Permanent":
+		case "Transient":
+			kind = SoftBounce
+		default:
+			// Undetermined, SES could not tell.
+			return nil, nil
+		}
+		for _, r := range notification.Bounce.BouncedRecipients {
+			events = append(events, Event{Email: r.EmailAddress, Kind: kind})
+		}
+	case "Complaint":
+		for _, r := range notification.Complaint.ComplainedRecipients {
+			events = append(events, Event{Email: r.EmailAddress, Kind: Complaint})
+		}
+	}
+	return events, nil
+}
+
+func checkSnsURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || !snsHost.MatchString(u.Host) {
+		return fmt.Errorf("untrusted SNS URL %v", rawURL)
+	}
+	return nil
+}
+
+func (s *SES) verify(msg snsMessage) error {
+	var (
+		h        hash.Hash
+		hashType crypto.Hash
+	)
+	switch msg.SignatureVersion {
+	case "1":
+		h, hashType = sha1.New(), crypto.SHA1
+	case "2":
+		h, hashType = sha256.New(), crypto.SHA256
+	default:
+		return ErrInvalidSignature
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	cert, err := s.cert(msg.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return ErrInvalidSignature
+	}
+
+	h.Write([]byte(snsStringToSign(msg)))
+	if err := rsa.VerifyPKCS1v15(pub, hashType, h.Sum(nil), signature); err != nil {
+		return ErrInvalidSignature
+	}
+
+	// The timestamp is signed, an old one is a message replayed.
+	sentAt, err := time.Parse(time.RFC3339, msg.Timestamp)
+	if err != nil || time.Since(sentAt) > maxSignatureAge {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func snsStringToSign(msg snsMessage) string {
+	fields := [][2]string{{"Message", msg.Message}, {"MessageId", msg.MessageId}}
+	if msg.Type == "Notification" {
+		if msg.Subject != "" {
+			fields = append(fields, [2]string{"Subject", msg.Subject})
+		}
+	} else {
+		fields = append(fields, [2]string{"SubscribeURL", msg.SubscribeURL})
+	}
+	fields = append(fields, [2]string{"Timestamp", msg.Timestamp})
+	if msg.Type != "Notification" {
+		fields = append(fields, [2]string{"Token", msg.Token})
+	}
+	fields = append(fields, [2]string{"TopicArn", msg.TopicArn}, [2]string{"Type", msg.Type})
+
+	str := ""
+	for _, field := range fields {
+		str += field[0] + "\n" + field[1] + "\n"
+	}
+	return str
+}
+
+func (s *SES) cert(certURL string) (*x509.Certificate, error) {
+	if err := checkSnsURL(certURL); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cert, ok := s.certs[certURL]; ok {
+		return cert, nil
+	}
+
+	res, err := s.client.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid SNS signing certificate at %v", certURL)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	s.certs[certURL] = cert
+	return cert, nil
+}
@@ -0,0 +1,110 @@
+package webhooks
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+const (
+	testTopicArn = "arn:aws:sns:us-east-1:123456789012:bounces"
+	testCertURL  = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-test.pem"
+)
+
+// testSES returns an SES trusting the signing certificate of the key.
+func testSES(t *testing.T, key *rsa.PrivateKey) *SES {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSES(testTopicArn)
+	s.certs[testCertURL] = cert
+	return s
+}
+
+// signedNotification returns the SNS notification of the SES message,
+// sent at the time and signed with the key.
+func signedNotification(t *testing.T, key *rsa.PrivateKey, message string, sentAt time.Time) []byte {
+	t.Helper()
+	msg := snsMessage{
+		Type:             "Notification",
+		MessageId:        "1",
+		TopicArn:         testTopicArn,
+		Message:          message,
+		Timestamp:        sentAt.UTC().Format(time.RFC3339),
+		SignatureVersion: "2",
+		SigningCertURL:   testCertURL,
+	}
+	digest := sha256.Sum256([]byte(snsStringToSign(msg)))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Signature = base64.StdEncoding.EncodeToString(signature)
+	body, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestSESEvents(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testSES(t, key)
+	const bounce = `{"notificationType":"Bounce","bounce":{"bounceType":"Permanent","bouncedRecipients":[{"emailAddress":"jane@example.com"}]}}`
+
+	tests := []struct {
+		name string
+		body []byte
+		err  error
+	}{
+		{name: "fresh", body: signedNotification(t, key, bounce, time.Now())},
+		{name: "replayed", body: signedNotification(t, key, bounce, time.Now().Add(-maxSignatureAge-time.Minute)), err: ErrInvalidSignature},
+		{
+			name: "tampered",
+			body: func() []byte {
+				msg := snsMessage{}
+				json.Unmarshal(signedNotification(t, key, bounce, time.Now()), &msg)
+				msg.Message = `{"notificationType":"Bounce","bounce":{"bounceType":"Permanent","bouncedRecipients":[{"emailAddress":"john@example.com"}]}}`
+				body, _ := json.Marshal(msg)
+				return body
+			}(),
+			err: ErrInvalidSignature,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events, err := s.Events(nil, test.body)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Events = %v, want %v", err, test.err)
+			}
+			if test.err == nil && (len(events) != 1 || events[0] != (Event{Email: "jane@example.com", Kind: Bounce})) {
+				t.Errorf("Events = %+v, want the bounce of jane@example.com", events)
+			}
+		})
+	}
+}
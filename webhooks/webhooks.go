@@ -0,0 +1,60 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrInvalidSignature is returned when a notification cannot be
+// authenticated as coming from the provider.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+type Kind string
+
+const (
+	// Bounce is a hard bounce, the address failing for good.
+	Bounce Kind = "bounce"
+	// SoftBounce is a temporary failure, e.g. a full mailbox.
+	SoftBounce  Kind = "soft_bounce"
+	Complaint   Kind = "complaint"
+	Unsubscribe Kind = "unsubscribe"
+)
+
+// Event is a provider notification that affects the status of a subscriber.
+type Event struct {
+	Email string
+	Kind  Kind
+}
+
+// Provider verifies and parses the webhook notifications of an email provider.
+type Provider interface {
+	// Events authenticates the request and returns the subscriber events it
+	// carries. Notifications that don't affect subscribers are skipped.
+	Events(header http.Header, body []byte) ([]Event, error)
+}
+
+// Providers are the providers accepted by name. They can be replaced while
+// the server runs, e.g. when its configuration is reloaded.
+type Providers struct {
+	providers atomic.Pointer[map[string]Provider]
+}
+
+func NewProviders(providers map[string]Provider) *Providers {
+	p := &Providers{}
+	p.Set(providers)
+	return p
+}
+
+// Get returns the provider of the name. A nil Providers has none.
+func (p *Providers) Get(name string) (Provider, bool) {
+	if p == nil {
+		return nil, false
+	}
+	provider, ok := (*p.providers.Load())[name]
+	return provider, ok
+}
+
+func (p *Providers) Set(providers map[string]Provider) {
+	p.providers.Store(&providers)
+}